@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yutat23/trail/pkg/trail"
+)
+
+// ---------- サブコマンド: agent / connect ----------
+
+// agent は TCP か Unix ソケットで待ち受け、接続してきた connect にファイルの行を送る。
+// 色付けや絞り込みは connect 側で行うので、agent は行をそのまま送るだけ。
+//
+// やり取りは 1 行 1 つの JSON。connect が agentRequest を送り、agent は ready を返してから
+// line / event を送り続ける。追従が終わると end を送って切断する。
+// 断られた場合は error を 1 つ送って切断する。
+
+const agentProtocolVersion = 1
+
+// 要求の 1 行の上限
+const agentMaxRequest = 64 << 10
+
+// 受け取る 1 行の上限
+const agentMaxMessage = 16 << 20
+
+// 接続してから要求が届くまでの待ち時間
+const agentRequestTimeout = 10 * time.Second
+
+// クライアントへの書き込みの待ち時間。これを超えると切断する。
+const agentWriteTimeout = 30 * time.Second
+
+// -token を指定しなかったときに使う環境変数 (ps に出さないため)
+const agentTokenEnv = "TRAIL_TOKEN"
+
+type agentPosition struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+}
+
+type agentRequest struct {
+	Version int            `json:"version"`
+	Token   string         `json:"token,omitempty"`
+	Mode    string         `json:"mode"` // file / dir
+	Path    string         `json:"path"`
+	Pattern string         `json:"pattern,omitempty"`
+	Lines   int            `json:"lines"`
+	Resume  *agentPosition `json:"resume,omitempty"`
+}
+
+type agentMessage struct {
+	Type       string `json:"type"` // ready / line / event / error / end
+	File       string `json:"file,omitempty"`
+	Offset     *int64 `json:"offset,omitempty"`
+	Next       int64  `json:"next,omitempty"` // 続きを読み始める位置 (再接続に使う)
+	LineNumber int    `json:"line_number,omitempty"`
+	ReceivedAt string `json:"received_at,omitempty"`
+	Text       string `json:"text,omitempty"`
+	Event      string `json:"event,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// "tcp://host:port"、"unix:///path"、"host:port" を net.Listen / net.Dial の引数にする
+func parseAgentAddress(addr string) (network, address string, err error) {
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "tcp://"):
+		network, address = "tcp", strings.TrimPrefix(addr, "tcp://")
+	case strings.Contains(addr, "://"):
+		return "", "", fmt.Errorf("unsupported address %q (expected host:port, tcp://host:port or unix:///path)", addr)
+	default:
+		network, address = "tcp", addr
+	}
+	if address == "" {
+		return "", "", fmt.Errorf("missing address in %q", addr)
+	}
+	if network == "tcp" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("invalid address %q: %v", addr, err)
+		}
+	}
+	return network, address, nil
+}
+
+// ---------- agent ----------
+
+type agentServer struct {
+	token string
+	roots []string // 追従してよいディレクトリ (シンボリックリンクを解決した絶対パス)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+func newAgentServer(token string, roots []string) (*agentServer, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("no directories allowed")
+	}
+	resolved := make([]string, 0, len(roots))
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, real)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &agentServer{
+		token:  token,
+		roots:  resolved,
+		ctx:    ctx,
+		cancel: cancel,
+		conns:  make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// ln で接続を受け付ける。close するまで戻らない。
+func (a *agentServer) serve(ln net.Listener) error {
+	a.mu.Lock()
+	if a.ctx.Err() != nil {
+		a.mu.Unlock()
+		return net.ErrClosed
+	}
+	a.listeners = append(a.listeners, ln)
+	a.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if a.ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !a.track(conn) {
+			conn.Close()
+			return nil
+		}
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			defer a.untrack(conn)
+			a.handle(conn)
+		}()
+	}
+}
+
+func (a *agentServer) track(conn net.Conn) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ctx.Err() != nil {
+		return false
+	}
+	a.conns[conn] = struct{}{}
+	return true
+}
+
+func (a *agentServer) untrack(conn net.Conn) {
+	a.mu.Lock()
+	delete(a.conns, conn)
+	a.mu.Unlock()
+	conn.Close()
+}
+
+// 待ち受けと全ての接続を閉じ、追従が終わるのを待つ
+func (a *agentServer) close() {
+	a.mu.Lock()
+	a.cancel()
+	for _, ln := range a.listeners {
+		ln.Close()
+	}
+	for conn := range a.conns {
+		conn.Close()
+	}
+	a.mu.Unlock()
+	a.wg.Wait()
+}
+
+// 1 つの接続の要求を受け取り、行を送り続ける
+func (a *agentServer) handle(conn net.Conn) {
+	remote := "unix socket"
+	if addr := conn.RemoteAddr(); addr != nil && addr.String() != "" && addr.String() != "@" {
+		remote = addr.String()
+	}
+	// バッファが溢れて send の途中で書き出す場合も、読まないクライアントで止まらないよう書くたびに期限を設定する
+	w := bufio.NewWriter(deadlineWriter{conn: conn, timeout: agentWriteTimeout})
+	send := func(msg agentMessage) {
+		w.WriteString(marshalRecord(msg))
+		w.WriteByte('\n')
+	}
+	flush := w.Flush
+	refuse := func(format string, args ...any) {
+		message := fmt.Sprintf(format, args...)
+		log.Printf("refused %s: %s", remote, message)
+		send(agentMessage{Type: "error", Message: message})
+		flush()
+	}
+
+	conn.SetReadDeadline(time.Now().Add(agentRequestTimeout))
+	r := bufio.NewReader(io.LimitReader(conn, agentMaxRequest))
+	data, err := r.ReadBytes('\n')
+	if err != nil {
+		refuse("failed to read request: %v", err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	var req agentRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		refuse("invalid request: %v", err)
+		return
+	}
+	if req.Version != agentProtocolVersion {
+		refuse("unsupported protocol version %d (this agent speaks %d)", req.Version, agentProtocolVersion)
+		return
+	}
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(req.Token), []byte(a.token)) != 1 {
+		refuse("invalid token")
+		return
+	}
+	cfg, err := a.followerConfig(req)
+	if err != nil {
+		refuse("%v", err)
+		return
+	}
+	// 追従を始められないことは ready の前に知らせる (connect は再接続せずに終わる)
+	if _, err := cfg.Selector.Select(); err != nil {
+		refuse("%v", err)
+		return
+	}
+	follower, err := trail.New(cfg)
+	if err != nil {
+		refuse("%v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	defer cancel()
+	// クライアントが切断したら追従をやめる
+	go func() {
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- follower.Run(ctx)
+	}()
+
+	log.Printf("%s is following %s", remote, req.Path)
+	send(agentMessage{Type: "ready"})
+	broken := false
+	events := follower.Events()
+	for ev := range events {
+		if broken {
+			continue
+		}
+		send(agentEventMessage(ev))
+		if len(events) == 0 && flush() != nil {
+			// 書けなくなったら追従をやめ、残りは読み捨てる
+			broken = true
+			cancel()
+		}
+	}
+	if err := <-runErr; err != nil {
+		send(agentMessage{Type: "error", Message: err.Error()})
+	} else if ctx.Err() == nil {
+		send(agentMessage{Type: "end"})
+	}
+	if !broken {
+		flush()
+	}
+	log.Printf("%s disconnected", remote)
+}
+
+// 書き込むたびに conn の書き込みの期限を設定する
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.conn.Write(p)
+}
+
+// 要求から Follower の設定を作る。-allow の外のパスは断る。
+func (a *agentServer) followerConfig(req agentRequest) (trail.Config, error) {
+	if req.Lines < 0 {
+		return trail.Config{}, errors.New("lines must be >= 0")
+	}
+	path, err := a.allowedPath(req.Path)
+	if err != nil {
+		return trail.Config{}, err
+	}
+	cfg := trail.Config{
+		Lines:       req.Lines,
+		Poll:        runtime.GOOS == "windows",
+		LineNumbers: true,
+	}
+	switch req.Mode {
+	case "file":
+		cfg.Selector = trail.File(path)
+	case "dir":
+		cfg.Selector = trail.Newest(path, req.Pattern)
+	default:
+		return trail.Config{}, fmt.Errorf("unknown mode %q (expected file or dir)", req.Mode)
+	}
+	if req.Resume != nil {
+		cfg.Resume = &trail.Position{File: req.Resume.File, Offset: req.Resume.Offset}
+	}
+	return cfg, nil
+}
+
+func (a *agentServer) allowedPath(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("missing path")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range a.roots {
+		if real == root || strings.HasPrefix(real, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return real, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not under a directory allowed by the agent (-allow)", path)
+}
+
+func agentEventMessage(ev trail.Event) agentMessage {
+	if ev.Type == trail.EventLine {
+		line := ev.Line
+		msg := agentMessage{
+			Type:       "line",
+			File:       line.File,
+			Next:       line.Next,
+			LineNumber: line.Number,
+			Text:       line.Text,
+		}
+		if line.Offset >= 0 {
+			offset := line.Offset
+			msg.Offset = &offset
+		}
+		if !line.Received.IsZero() {
+			msg.ReceivedAt = line.Received.Format(time.RFC3339Nano)
+		}
+		return msg
+	}
+	return agentMessage{Type: "event", Event: string(ev.Type), File: ev.File, Message: ev.Message}
+}
+
+// 証明書と鍵から TLS の設定を作る
+func agentServerTLS(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("-tls-cert and -tls-key must be used together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+func cmdAgent(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	listen := fs.String("listen", "tcp://127.0.0.1:7070", "address to listen on: host:port, tcp://host:port or unix:///path")
+	var allow repeatedStrings
+	fs.Var(&allow, "allow", "directory clients may tail files under (can be used multiple times; default: the current directory)")
+	token := fs.String("token", os.Getenv(agentTokenEnv), "token clients must send (default $"+agentTokenEnv+")")
+	certFile := fs.String("tls-cert", "", "TLS certificate file")
+	keyFile := fs.String("tls-key", "", "TLS private key file")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		log.Fatalf("usage: trail agent [options]")
+	}
+
+	if len(allow) == 0 {
+		allow = repeatedStrings{"."}
+	}
+	agent, err := newAgentServer(*token, allow)
+	if err != nil {
+		log.Fatalf("-allow: %v", err)
+	}
+	tlsConfig, err := agentServerTLS(*certFile, *keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	network, address, err := parseAgentAddress(*listen)
+	if err != nil {
+		log.Fatalf("-listen: %v", err)
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	if *token == "" && network == "tcp" {
+		log.Printf("warning: no -token set; anyone who can connect can read files under %s", strings.Join(agent.roots, ", "))
+	}
+	log.Printf("agent listening on %s://%s (allowed: %s)", network, ln.Addr(), strings.Join(agent.roots, ", "))
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- agent.serve(ln)
+	}()
+	select {
+	case sig := <-notifyShutdown():
+		agent.close()
+		os.Exit(signalExitCode(sig))
+	case err := <-serveErr:
+		agent.close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// ---------- connect ----------
+
+// agent が要求を断った。再接続しても同じなので諦める。
+type agentRefusedError struct {
+	message string
+}
+
+func (e *agentRefusedError) Error() string {
+	return "agent refused: " + e.message
+}
+
+type agentClient struct {
+	network, address string
+	tlsConfig        *tls.Config
+	req              agentRequest
+	// 切断されてから接続し直すまでの間隔。0 なら接続し直さない
+	retry time.Duration
+
+	connected bool // 1 度でも ready を受け取った
+}
+
+// ctx が終わるか、追従が終わるまで行を受け取って出力する。切断されたら続きから受け取り直す。
+func (c *agentClient) run(ctx context.Context, h *Highlighter) error {
+	return runReconnecting(ctx, c.retry,
+		func(ctx context.Context) error { return c.stream(ctx, h) },
+		func(err error) bool {
+			var refused *agentRefusedError
+			return !errors.As(err, &refused) && !isCertificateError(err)
+		},
+		func(err error) {
+			log.Printf("connection to %s lost: %v; reconnecting in %s", c.address, err, c.retry)
+		})
+}
+
+// 相手の証明書を確かめられなかった。トークンの誤りと同じく、接続し直しても変わらない。
+func isCertificateError(err error) bool {
+	var unknown x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var verification *tls.CertificateVerificationError
+	return errors.As(err, &unknown) || errors.As(err, &hostname) || errors.As(err, &verification)
+}
+
+// ctx が終わるまで stream を繰り返し、切断されるたびに retry だけ待って接続し直す。ctx が終わった場合は nil を返す。
+// stream が nil を返すか、retryable が偽を返すエラーか、retry が 0 の場合はそのエラーで終わる。
+// lost は接続し直す前に呼ぶ。
+func runReconnecting(ctx context.Context, retry time.Duration, stream func(ctx context.Context) error, retryable func(err error) bool, lost func(err error)) error {
+	for {
+		err := stream(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil || retry == 0 || !retryable(err) {
+			return err
+		}
+		lost(err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retry):
+		}
+	}
+}
+
+func (c *agentClient) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	if c.tlsConfig != nil {
+		return (&tls.Dialer{NetDialer: dialer, Config: c.tlsConfig}).DialContext(ctx, c.network, c.address)
+	}
+	return dialer.DialContext(ctx, c.network, c.address)
+}
+
+// 1 回の接続で受け取れるだけ受け取る。追従が終わった (end を受け取った) 場合は nil。
+func (c *agentClient) stream(ctx context.Context, h *Highlighter) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	data, _ := json.Marshal(c.req)
+	conn.SetWriteDeadline(time.Now().Add(agentWriteTimeout))
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	ready := false
+	for {
+		line, err := readAgentLine(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		var msg agentMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return fmt.Errorf("invalid message from agent: %v", err)
+		}
+		switch msg.Type {
+		case "ready":
+			ready = true
+			if c.connected {
+				log.Printf("reconnected to %s", c.address)
+			}
+			c.connected = true
+			// 次の接続では続きから受け取る
+			c.req.Lines = 0
+		case "line":
+			info := lineInfo{source: msg.File, offset: -1, number: msg.LineNumber}
+			if msg.Offset != nil {
+				info.offset = *msg.Offset
+			}
+			if received, err := time.Parse(time.RFC3339Nano, msg.ReceivedAt); err == nil {
+				info.received = received
+			}
+			writeLineFrom(h, info, msg.Text)
+			if msg.Next > 0 {
+				c.req.Resume = &agentPosition{File: msg.File, Offset: msg.Next}
+			}
+		case "event":
+			handleFollowEvent(h, trail.Event{Type: trail.EventType(msg.Event), File: msg.File, Message: msg.Message})
+		case "error":
+			if !ready {
+				return &agentRefusedError{message: msg.Message}
+			}
+			log.Printf("agent: %s", msg.Message)
+		case "end":
+			output.flush()
+			return nil
+		}
+		if r.Buffered() == 0 {
+			output.flush()
+		}
+	}
+}
+
+// 1 行を読む。長すぎる行はエラーにする。
+func readAgentLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > agentMaxMessage {
+			return nil, errors.New("message from agent too large")
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return line, err
+	}
+}
+
+// -tls と -tls-ca から TLS の設定を作る。どちらも無ければ nil。
+func agentClientTLS(useTLS bool, caFile, serverName, address string) (*tls.Config, error) {
+	if !useTLS && caFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+	if cfg.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func cmdConnect(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	nLines := fs.Int("n", 10, "show last N lines then follow")
+	pattern := fs.String("pattern", "*", "file pattern to match in dir mode")
+	token := fs.String("token", os.Getenv(agentTokenEnv), "token to send to the agent (default $"+agentTokenEnv+")")
+	useTLS := fs.Bool("tls", false, "connect with TLS")
+	caFile := fs.String("tls-ca", "", "CA certificate to verify the agent with (implies -tls)")
+	serverName := fs.String("tls-server-name", "", "server name to verify the agent's certificate against")
+	retry := fs.Duration("retry", 2*time.Second, "wait before reconnecting after the connection drops (0 to exit instead)")
+	pipeline := registerPipelineFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 3 {
+		log.Fatalf("usage: trail connect [options] <address> file|dir <path>")
+	}
+	validateLineCount(*nLines)
+	if *retry < 0 {
+		log.Fatalf("-retry must be >= 0")
+	}
+	network, address, err := parseAgentAddress(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	mode := fs.Arg(1)
+	switch mode {
+	case "-f":
+		mode = "file"
+	case "-d":
+		mode = "dir"
+	}
+	if mode != "file" && mode != "dir" {
+		log.Fatalf("unknown connect mode %q (expected file or dir)", fs.Arg(1))
+	}
+	tlsConfig, err := agentClientTLS(*useTLS, *caFile, *serverName, address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := &agentClient{
+		network:   network,
+		address:   address,
+		tlsConfig: tlsConfig,
+		retry:     *retry,
+		req: agentRequest{
+			Version: agentProtocolVersion,
+			Token:   *token,
+			Mode:    mode,
+			Path:    fs.Arg(2),
+			Pattern: *pattern,
+			Lines:   *nLines,
+		},
+	}
+
+	highlighter := pipeline.start(opts, fs.Arg(2))
+	runSource(highlighter, fs.Arg(0), *pipeline.showSummary, func(ctx context.Context) error {
+		return client.run(ctx, highlighter)
+	})
+}
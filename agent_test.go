@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAgentAddress(t *testing.T) {
+	tests := []struct {
+		in, network, address string
+	}{
+		{"127.0.0.1:7070", "tcp", "127.0.0.1:7070"},
+		{"tcp://:7070", "tcp", ":7070"},
+		{"unix:///run/trail.sock", "unix", "/run/trail.sock"},
+	}
+	for _, tt := range tests {
+		network, address, err := parseAgentAddress(tt.in)
+		if err != nil || network != tt.network || address != tt.address {
+			t.Errorf("parseAgentAddress(%q) = %q, %q, %v", tt.in, network, address, err)
+		}
+	}
+	for _, in := range []string{"", "localhost", "udp://:514", "unix://", "tcp://host"} {
+		if _, _, err := parseAgentAddress(in); err == nil {
+			t.Errorf("parseAgentAddress(%q) error = nil", in)
+		}
+	}
+}
+
+// テスト用の agent を動かし、待ち受けのアドレスを返す
+func startTestAgent(t *testing.T, network, address, token string, tlsConfig *tls.Config, roots ...string) (*agentServer, string) {
+	t.Helper()
+	agent, err := newAgentServer(token, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := agent.serve(ln); err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		agent.close()
+		<-done
+	})
+	return agent, ln.Addr().String()
+}
+
+// 要求を送り、agent から最初に届くメッセージを返す
+func requestAgent(t *testing.T, addr string, req agentRequest) agentMessage {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, _ := json.Marshal(req)
+	conn.Write(append(data, '\n'))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg agentMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestAgentRefusesBadRequests(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	allowed := t.TempDir()
+	path := filepath.Join(allowed, "app.log")
+	if err := os.WriteFile(path, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.log")
+	if err := os.WriteFile(outside, []byte("secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 許可したディレクトリからのシンボリックリンクでも外は読ませない
+	link := filepath.Join(allowed, "link.log")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startTestAgent(t, "tcp", "127.0.0.1:0", "s3cret", nil, allowed)
+
+	valid := agentRequest{Version: agentProtocolVersion, Token: "s3cret", Mode: "file", Path: path, Lines: 1}
+	tests := []struct {
+		name   string
+		modify func(*agentRequest)
+		want   string
+	}{
+		{"token", func(r *agentRequest) { r.Token = "guess" }, "invalid token"},
+		{"version", func(r *agentRequest) { r.Version = 99 }, "unsupported protocol version 99"},
+		{"outside", func(r *agentRequest) { r.Path = outside }, "not under a directory allowed by the agent"},
+		{"symlink", func(r *agentRequest) { r.Path = link }, "not under a directory allowed by the agent"},
+		{"traversal", func(r *agentRequest) {
+			r.Path = filepath.Join(allowed, "..", filepath.Base(filepath.Dir(outside)), "secret.log")
+		}, "not under"},
+		{"mode", func(r *agentRequest) { r.Mode = "glob" }, `unknown mode "glob"`},
+		{"missing", func(r *agentRequest) { r.Path = filepath.Join(allowed, "missing.log") }, "no such file"},
+		{"no files", func(r *agentRequest) { r.Mode, r.Path, r.Pattern = "dir", allowed, "*.txt" }, "no files matching"},
+	}
+	for _, tt := range tests {
+		req := valid
+		tt.modify(&req)
+		if msg := requestAgent(t, addr, req); msg.Type != "error" || !strings.Contains(msg.Message, tt.want) {
+			t.Errorf("%s: message = %+v, want error containing %q", tt.name, msg, tt.want)
+		}
+	}
+	if msg := requestAgent(t, addr, valid); msg.Type != "ready" {
+		t.Fatalf("valid request: message = %+v, want ready", msg)
+	}
+}
+
+// output をメモリに差し替え、want が書き込まれるまで待つ関数を返す
+func memoryOutput(t *testing.T) func(want string) string {
+	t.Helper()
+	mem := &memorySink{}
+	saved := output
+	output = newOutputWriter(namedSink{name: "memory", sink: mem})
+	t.Cleanup(func() { output = saved })
+	return func(want string) string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !strings.Contains(mem.String(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("output = %q, want it to contain %q", mem.String(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return mem.String()
+	}
+}
+
+// 読み出し元の run をテストの間だけ動かし、戻り値を返すチャネルを返す
+func runTestSource(t *testing.T, run func(ctx context.Context) error) <-chan error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		done <- run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-finished
+	})
+	return done
+}
+
+func TestDeadlineWriterSetsDeadlineOnEveryWrite(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	// バッファより長い行は Flush を待たずに書き出されるので、そこでも期限が効く
+	w := bufio.NewWriterSize(deadlineWriter{conn: server, timeout: 50 * time.Millisecond}, 16)
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.WriteString(strings.Repeat("x", 64))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("write error = %v, want deadline exceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write to a client that does not read did not time out")
+	}
+}
+
+func TestConnectColorsLinesAndResumesAfterReconnect(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("line 1\nline 2\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitOutput := memoryOutput(t)
+	agent, addr := startTestAgent(t, "tcp", "127.0.0.1:0", "s3cret", nil, dir)
+
+	client := &agentClient{
+		network: "tcp",
+		address: addr,
+		retry:   20 * time.Millisecond,
+		req:     agentRequest{Version: agentProtocolVersion, Token: "s3cret", Mode: "file", Path: path, Lines: 1},
+	}
+	h := userHighlighter(colorAlways, "red:ERROR")
+	runTestSource(t, func(ctx context.Context) error { return client.run(ctx, h) })
+	waitOutput("line 2\n")
+	appendToFile(t, path, "ERROR live\n")
+	waitOutput(ansi("31", "ERROR") + " live\n")
+
+	// agent が止まっている間に書かれた行も、続きから 1 度だけ受け取る
+	agent.close()
+	appendToFile(t, path, "while away\n")
+	startTestAgent(t, "tcp", addr, "s3cret", nil, dir)
+	waitOutput("while away\n")
+	appendToFile(t, path, "after\n")
+	got := waitOutput("after\n")
+	if want := "line 2\n" + ansi("31", "ERROR") + " live\nwhile away\nafter\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestConnectStopsWhenRefused(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	_, addr := startTestAgent(t, "tcp", "127.0.0.1:0", "s3cret", nil, dir)
+	client := &agentClient{
+		network: "tcp",
+		address: addr,
+		retry:   20 * time.Millisecond,
+		req:     agentRequest{Version: agentProtocolVersion, Token: "wrong", Mode: "dir", Path: dir},
+	}
+	select {
+	case err := <-runTestSource(t, func(ctx context.Context) error { return client.run(ctx, nil) }):
+		if err == nil || err.Error() != "agent refused: invalid token" {
+			t.Fatalf("run = %v, want refused", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client kept retrying after being refused")
+	}
+}
+
+// 127.0.0.1 用の自己署名証明書
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "trail test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestConnectOverTLS(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("secure\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cert, pool := testCertificate(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startTestAgent(t, "tcp", "127.0.0.1:0", "", &tls.Config{Certificates: []tls.Certificate{cert}}, dir)
+
+	tlsConfig, err := agentClientTLS(false, caFile, "", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tlsConfig.RootCAs.Equal(pool) || tlsConfig.ServerName != "127.0.0.1" {
+		t.Fatalf("tls config = %+v", tlsConfig)
+	}
+	waitOutput := memoryOutput(t)
+	req := agentRequest{Version: agentProtocolVersion, Mode: "file", Path: path, Lines: 1}
+	client := &agentClient{network: "tcp", address: addr, tlsConfig: tlsConfig, req: req}
+	runTestSource(t, func(ctx context.Context) error { return client.run(ctx, nil) })
+	waitOutput("secure\n")
+
+	// CA を知らないクライアントは接続できず、接続し直さずに終わる
+	untrusted := &agentClient{
+		network:   "tcp",
+		address:   addr,
+		tlsConfig: &tls.Config{ServerName: "127.0.0.1"},
+		req:       req,
+		retry:     10 * time.Millisecond,
+	}
+	select {
+	case err := <-runTestSource(t, func(ctx context.Context) error { return untrusted.run(ctx, nil) }):
+		if err == nil || !strings.Contains(err.Error(), "certificate") {
+			t.Fatalf("untrusted run = %v, want certificate error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("untrusted run kept reconnecting")
+	}
+
+	// 名前の合わない証明書も同じ
+	mismatched := &agentClient{
+		network:   "tcp",
+		address:   addr,
+		tlsConfig: &tls.Config{RootCAs: pool, ServerName: "other.example"},
+		req:       req,
+		retry:     10 * time.Millisecond,
+	}
+	select {
+	case err := <-runTestSource(t, func(ctx context.Context) error { return mismatched.run(ctx, nil) }):
+		if err == nil || !strings.Contains(err.Error(), "certificate") {
+			t.Fatalf("mismatched run = %v, want certificate error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mismatched run kept reconnecting")
+	}
+}
+
+func TestConnectOverUnixSocketFollowsNewestFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not used on windows")
+	}
+	withReset(t)
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	writeFileAt(t, filepath.Join(dir, "app-1.log"), "old\n", time.Now().Add(-time.Hour))
+	writeFileAt(t, filepath.Join(dir, "app-2.log"), "new\n", time.Now())
+	socket := filepath.Join(t.TempDir(), "trail.sock")
+	startTestAgent(t, "unix", socket, "", nil, dir)
+
+	waitOutput := memoryOutput(t)
+	client := &agentClient{
+		network: "unix",
+		address: socket,
+		req:     agentRequest{Version: agentProtocolVersion, Mode: "dir", Path: dir, Pattern: "*.log", Lines: 5},
+	}
+	runTestSource(t, func(ctx context.Context) error { return client.run(ctx, nil) })
+	if got := waitOutput("new\n"); got != "new\n" {
+		t.Fatalf("output = %q, want only the newest file", got)
+	}
+}
@@ -34,7 +34,7 @@ if (!(Test-Path $releaseDir)) {
     New-Item -ItemType Directory -Path $buildDir -Force | Out-Null
 
     # Goアプリケーションをビルド
-    go build -o $outputFile -ldflags "-s -w" main.go
+    go build -o $outputFile -ldflags "-s -w" .
 
     # インストーラースクリプトをコピー
     Copy-Item "installer.ps1" "$buildDir/"
@@ -70,7 +70,7 @@ if (!(Test-Path $releaseDir)) {
     New-Item -ItemType Directory -Path $buildDir -Force | Out-Null
 
     # Goアプリケーションをビルド
-    go build -o $outputFile -ldflags "-s -w" main.go
+    go build -o $outputFile -ldflags "-s -w" .
 
     # ZIP化
     $zipPath = "$releaseDir/$appname" + "_$version" + "_$target.zip"
@@ -103,7 +103,7 @@ if (!(Test-Path $releaseDir)) {
     New-Item -ItemType Directory -Path $buildDir -Force | Out-Null
 
     # Goアプリケーションをビルド
-    go build -o $outputFile -ldflags "-s -w" main.go
+    go build -o $outputFile -ldflags "-s -w" .
 
     # ZIP化
     $zipPath = "$releaseDir/$appname" + "_$version" + "_$target.zip"
@@ -41,7 +41,7 @@ do
 	rm -rf "$build_dir"
 	mkdir -p "$build_dir"
 
-	GOOS="$goos" GOARCH="$goarch" go build -o "$output_file" -ldflags "-s -w" main.go
+	GOOS="$goos" GOARCH="$goarch" go build -o "$output_file" -ldflags "-s -w" .
 
 	if [ "$goos" = "windows" ]; then
 		cp installer.ps1 "$build_dir/"
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- サブコマンド: docker ----------
+
+// Docker Engine API からコンテナのログを受け取る。docker コマンドは使わない。
+// コンテナが止まっても同じ名前のコンテナが動き出すのを待って続きを読む。
+
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// Engine API のクライアント
+type dockerClient struct {
+	host string // エラーメッセージ用 (unix:///var/run/docker.sock など)
+	base string // リクエスト先の URL の先頭
+	http *http.Client
+}
+
+// DOCKER_HOST と同じ書式 (unix://path, tcp://host:port) を受け付ける
+func newDockerClient(host string) (*dockerClient, error) {
+	scheme, rest, ok := strings.Cut(host, "://")
+	if !ok || rest == "" {
+		return nil, fmt.Errorf("invalid docker host %q (use unix:///path or tcp://host:port)", host)
+	}
+	transport := &http.Transport{}
+	c := &dockerClient{host: host, http: &http.Client{Transport: transport}}
+	switch scheme {
+	case "unix":
+		// ホスト名は使われないので何でもよい
+		c.base = "http://docker"
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", rest)
+		}
+	case "tcp", "http":
+		c.base = "http://" + strings.TrimSuffix(rest, "/")
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q (use unix:///path or tcp://host:port)", host)
+	}
+	return c, nil
+}
+
+// Engine API が返したエラー
+type dockerAPIError struct {
+	status  int
+	message string
+}
+
+func (e *dockerAPIError) Error() string {
+	return "docker: " + e.message
+}
+
+func (c *dockerClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("cannot connect to the Docker daemon at %s: %w", c.host, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var body struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &body) != nil || body.Message == "" {
+			body.Message = strings.TrimSpace(string(data))
+			if body.Message == "" {
+				body.Message = resp.Status
+			}
+		}
+		return nil, &dockerAPIError{status: resp.StatusCode, message: body.Message}
+	}
+	return resp, nil
+}
+
+// GET /containers/{name}/json のうち使う項目
+type dockerContainer struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Config struct {
+		Tty bool `json:"Tty"`
+	} `json:"Config"`
+	State struct {
+		Running   bool   `json:"Running"`
+		StartedAt string `json:"StartedAt"`
+	} `json:"State"`
+}
+
+func (c *dockerClient) inspect(ctx context.Context, name string) (*dockerContainer, error) {
+	resp, err := c.get(ctx, "/containers/"+url.PathEscape(name)+"/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var container dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&container); err != nil {
+		return nil, fmt.Errorf("docker: invalid container info: %v", err)
+	}
+	return &container, nil
+}
+
+// デーモンの時刻 (GET /_ping の Date ヘッダ)。Date が無ければ手元の時刻を返す
+func (c *dockerClient) now(ctx context.Context) (time.Time, error) {
+	resp, err := c.get(ctx, "/_ping", nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+	if now, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		return now, nil
+	}
+	return time.Now(), nil
+}
+
+// ログのストリームと、応答した時点のデーモンの時刻 (Date ヘッダ。分からなければゼロ値) を返す
+func (c *dockerClient) logs(ctx context.Context, id string, query url.Values) (io.ReadCloser, time.Time, error) {
+	resp, err := c.get(ctx, "/containers/"+url.PathEscape(id)+"/logs", query)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	now, _ := http.ParseTime(resp.Header.Get("Date"))
+	return resp.Body, now, nil
+}
+
+// ログストリームの種類 (多重化フレームの先頭バイト)
+const (
+	dockerStdout    = 1
+	dockerStderr    = 2
+	dockerSystemErr = 3
+)
+
+// タイムスタンプを外したログの断片。長い行は複数に分かれて届く
+type dockerMessage struct {
+	stream int
+	at     time.Time // 分からなければゼロ値
+	text   string
+}
+
+// ログストリームから 1 メッセージずつ読む。
+// TTY のないコンテナは 8 バイトのヘッダー付きフレームに stdout と stderr が混ざって届く。
+type dockerLogReader struct {
+	r   *bufio.Reader
+	tty bool
+}
+
+func (d *dockerLogReader) next() (dockerMessage, error) {
+	if d.tty {
+		text, err := d.r.ReadString('\n')
+		if text == "" {
+			return dockerMessage{}, err
+		}
+		return splitDockerTimestamp(dockerStdout, text), nil
+	}
+	var header [8]byte
+	if _, err := io.ReadFull(d.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return dockerMessage{}, fmt.Errorf("docker: truncated log frame")
+		}
+		return dockerMessage{}, err
+	}
+	size := binary.BigEndian.Uint32(header[4:])
+	if size > 16<<20 {
+		return dockerMessage{}, fmt.Errorf("docker: log frame too large (%d bytes)", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(d.r, payload); err != nil {
+		return dockerMessage{}, fmt.Errorf("docker: truncated log frame")
+	}
+	return splitDockerTimestamp(int(header[0]), string(payload)), nil
+}
+
+// timestamps=1 で付く "2006-01-02T15:04:05.999999999Z " を取り外す
+func splitDockerTimestamp(stream int, text string) dockerMessage {
+	at, rest := cutTimestampPrefix(text)
+	return dockerMessage{stream: stream, at: at, text: rest}
+}
+
+// 行頭の RFC 3339 の時刻と空白を取り外す。なければゼロ値とそのままの行を返す
+func cutTimestampPrefix(text string) (time.Time, string) {
+	if value, rest, ok := strings.Cut(text, " "); ok {
+		if at, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return at, rest
+		}
+	}
+	return time.Time{}, text
+}
+
+// -since の値。期間 (10m)、RFC 3339 の時刻、日付、Unix 時刻を受け付ける
+func parseDockerSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("invalid -since %q (must not be negative)", value)
+		}
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := parseUnixTimestamp(value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -since %q (use a duration like 10m, an RFC 3339 time or a Unix timestamp)", value)
+}
+
+// Engine API の since/until に渡す書式
+func formatDockerTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// 1 つのコンテナ名を追いかける
+type dockerLogs struct {
+	client *dockerClient
+	name   string
+	lines  int       // 最初に表示する行数。負なら全部
+	since  time.Time // ゼロ値でなければこれより前の行は表示しない
+	stdout bool
+	stderr bool
+	// コンテナが止まっている間、状態を確かめる間隔
+	poll time.Duration
+
+	streamed bool      // 1 度でもログを読み始めた
+	cursor   time.Time // 最後に受け取った行の時刻 (デーモンの時計)。読み直すときはこれより後だけを表示する
+}
+
+// ctx が終わるまでログを読む。コンテナが止まったり作り直されたりしたら、また動き出すのを待つ。
+func (d *dockerLogs) run(ctx context.Context, h *Highlighter) error {
+	var last *dockerContainer
+	waiting := false
+	for {
+		container, err := d.client.inspect(ctx, d.name)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			if !d.streamed {
+				return err
+			}
+			if !waiting {
+				log.Printf("waiting for container %s: %v", d.name, err)
+				waiting = true
+			}
+		case last == nil || container.State.Running || container.ID != last.ID || container.State.StartedAt != last.State.StartedAt:
+			if last != nil && (container.ID != last.ID || container.State.StartedAt != last.State.StartedAt) {
+				log.Printf("container %s restarted", d.name)
+			}
+			waiting = false
+			err := d.stream(ctx, h, container)
+			if ctx.Err() != nil {
+				return nil
+			}
+			var apiErr *dockerAPIError
+			if err != nil && (!d.streamed || errors.As(err, &apiErr) && apiErr.status != http.StatusNotFound) {
+				return err
+			}
+			if err != nil {
+				log.Printf("logs of container %s: %v", d.name, err)
+			}
+			last = container
+		default:
+			if !waiting {
+				log.Printf("container %s stopped; waiting for it to restart", d.name)
+				waiting = true
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(d.poll):
+		}
+	}
+}
+
+func (d *dockerLogs) stream(ctx context.Context, h *Highlighter, container *dockerContainer) error {
+	query := url.Values{
+		"follow":     {"1"},
+		"stdout":     {strconv.FormatBool(d.stdout)},
+		"stderr":     {strconv.FormatBool(d.stderr)},
+		"timestamps": {"1"},
+	}
+	resume := d.streamed
+	if resume {
+		// 前回の続きから。止まっていた間に出た行も含める
+		query.Set("tail", "all")
+		query.Set("since", formatDockerTime(d.cursor))
+	} else {
+		query.Set("tail", "all")
+		if d.lines >= 0 {
+			query.Set("tail", strconv.Itoa(d.lines))
+		}
+		if !d.since.IsZero() {
+			query.Set("since", formatDockerTime(d.since))
+		}
+	}
+	body, daemonNow, err := d.client.logs(ctx, container.ID, query)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if !d.streamed {
+		d.streamed = true
+		// 行を受け取るまでは読み始めた時刻を続きの位置にする。行の時刻と比べるので手元の時計ではなくデーモンの時計を使う
+		d.cursor = daemonNow
+		if started, err := time.Parse(time.RFC3339Nano, container.State.StartedAt); err == nil && started.After(d.cursor) {
+			d.cursor = started
+		}
+	}
+
+	r := bufio.NewReader(body)
+	reader := &dockerLogReader{r: r, tty: container.Config.Tty}
+	// stdout と stderr の書きかけの行
+	partial := map[int]string{}
+	for {
+		msg, err := reader.next()
+		if err != nil {
+			for _, stream := range []int{dockerStdout, dockerStderr} {
+				if partial[stream] != "" {
+					writeLine(h, d.name, partial[stream])
+				}
+			}
+			output.flush()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		// since は秒未満を含めて指定するが、同じ時刻の行が重ならないよう手元でも除く
+		if resume && !msg.at.IsZero() && !msg.at.After(d.cursor) {
+			continue
+		}
+		if msg.at.After(d.cursor) {
+			d.cursor = msg.at
+		}
+		if msg.stream == dockerSystemErr {
+			log.Printf("docker: %s", strings.TrimRight(msg.text, "\n"))
+			continue
+		}
+		text := partial[msg.stream] + msg.text
+		for {
+			line, rest, ok := strings.Cut(text, "\n")
+			if !ok {
+				break
+			}
+			writeLine(h, d.name, line)
+			text = rest
+		}
+		partial[msg.stream] = text
+		if r.Buffered() == 0 {
+			output.flush()
+		}
+	}
+}
+
+func dockerHostDefault() string {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return host
+	}
+	return defaultDockerHost
+}
+
+func cmdDocker(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("docker", flag.ExitOnError)
+	nLines := fs.Int("n", 10, "show last N lines then follow (default all lines with -since)")
+	host := fs.String("host", dockerHostDefault(), "Docker daemon address (default $DOCKER_HOST or "+defaultDockerHost+")")
+	since := fs.String("since", "", "show lines since a time (10m, 2006-01-02T15:04:05Z or a Unix timestamp)")
+	stdout := fs.Bool("stdout", true, "show the container's stdout")
+	stderr := fs.Bool("stderr", true, "show the container's stderr")
+	poll := fs.Duration("poll", time.Second, "interval to check for a restart while the container is stopped")
+	pipeline := registerPipelineFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: trail docker [options] <container>")
+	}
+	validateLineCount(*nLines)
+	if *poll <= 0 {
+		log.Fatalf("-poll must be > 0")
+	}
+	if !*stdout && !*stderr {
+		log.Fatalf("-stdout=false and -stderr=false leave nothing to show")
+	}
+	client, err := newDockerClient(*host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logs := &dockerLogs{client: client, name: fs.Arg(0), lines: linesSince(fs, *nLines, *since != ""), stdout: *stdout, stderr: *stderr, poll: *poll}
+	if *since != "" {
+		// ログの時刻はデーモンが付けるので、期間もデーモンの時計で数える
+		now, err := client.now(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+		logs.since, err = parseDockerSince(*since, now)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	highlighter := pipeline.start(opts, logs.name)
+	runSource(highlighter, logs.name, *pipeline.showSummary, func(ctx context.Context) error {
+		return logs.run(ctx, highlighter)
+	})
+}
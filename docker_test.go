@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Engine API のうち /_ping と /containers/{name}/json と /containers/{id}/logs だけを真似る
+type fakeDocker struct {
+	mu         sync.Mutex
+	changed    chan struct{} // 状態が変わるたびに閉じて作り直す
+	containers map[string]*fakeContainer
+	queries    []url.Values
+	last       time.Time
+	skew       time.Duration // 手元の時計とのずれ
+}
+
+type fakeContainer struct {
+	id      string
+	tty     bool
+	running bool
+	started time.Time
+	logs    []fakeLogEntry
+}
+
+type fakeLogEntry struct {
+	at     time.Time
+	stream byte
+	text   string // 改行を含まなければ行の途中までの断片
+}
+
+func startFakeDocker(t *testing.T) (*fakeDocker, *dockerClient) {
+	t.Helper()
+	d := &fakeDocker{changed: make(chan struct{}), containers: map[string]*fakeContainer{}}
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_ping", d.ping)
+	mux.HandleFunc("GET /containers/{name}/json", d.inspect)
+	mux.HandleFunc("GET /containers/{id}/logs", d.logs)
+	server := &http.Server{Handler: mux}
+	go server.Serve(ln)
+	t.Cleanup(func() {
+		d.mu.Lock()
+		close(d.changed)
+		d.changed = make(chan struct{})
+		d.mu.Unlock()
+		server.Close()
+	})
+	client, err := newDockerClient("unix://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, client
+}
+
+// 呼び出し側でロックしておく
+func (d *fakeDocker) notify() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+func (d *fakeDocker) now() time.Time {
+	now := time.Now().Add(d.skew)
+	if !now.After(d.last) {
+		now = d.last.Add(time.Nanosecond)
+	}
+	d.last = now
+	return now
+}
+
+func (d *fakeDocker) run(name, id string, tty bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.containers[name]
+	if c == nil || c.id != id {
+		c = &fakeContainer{id: id, tty: tty}
+		d.containers[name] = c
+	}
+	c.running = true
+	c.started = d.now()
+	d.notify()
+}
+
+func (d *fakeDocker) stop(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.containers[name].running = false
+	d.notify()
+}
+
+func (d *fakeDocker) write(name string, stream byte, texts ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.containers[name]
+	for _, text := range texts {
+		c.logs = append(c.logs, fakeLogEntry{at: d.now(), stream: stream, text: text})
+	}
+	d.notify()
+}
+
+func (d *fakeDocker) requests() []url.Values {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]url.Values(nil), d.queries...)
+}
+
+func (d *fakeDocker) waitRequests(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.requests()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("requested logs %d times, want %d", len(d.requests()), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (d *fakeDocker) apiError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func (d *fakeDocker) inspect(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	name := strings.TrimPrefix(r.PathValue("name"), "/")
+	c := d.containers[name]
+	if c == nil {
+		d.apiError(w, http.StatusNotFound, "No such container: "+name)
+		return
+	}
+	var info dockerContainer
+	info.ID = c.id
+	info.Name = "/" + name
+	info.Config.Tty = c.tty
+	info.State.Running = c.running
+	info.State.StartedAt = c.started.Format(time.RFC3339Nano)
+	json.NewEncoder(w).Encode(info)
+}
+
+func (d *fakeDocker) ping(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	now := d.now()
+	d.mu.Unlock()
+	w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+	io.WriteString(w, "OK")
+}
+
+func (d *fakeDocker) logs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	d.mu.Lock()
+	d.queries = append(d.queries, query)
+	var c *fakeContainer
+	for _, container := range d.containers {
+		if container.id == r.PathValue("id") {
+			c = container
+		}
+	}
+	if c == nil {
+		d.mu.Unlock()
+		d.apiError(w, http.StatusNotFound, "No such container: "+r.PathValue("id"))
+		return
+	}
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		since, _ = parseUnixTimestamp(value)
+	}
+	var entries []fakeLogEntry
+	for _, e := range c.logs {
+		if !e.at.Before(since) && query.Get(map[byte]string{1: "stdout", 2: "stderr"}[e.stream]) == "true" {
+			entries = append(entries, e)
+		}
+	}
+	if n, err := strconv.Atoi(query.Get("tail")); err == nil && n < len(entries) {
+		entries = entries[len(entries)-n:]
+	}
+	next := len(c.logs)
+	started := c.started
+	now := d.now()
+	d.mu.Unlock()
+
+	w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Type", "application/vnd.docker.multiplexed-stream")
+	w.WriteHeader(http.StatusOK)
+	send := func(entries []fakeLogEntry) {
+		for _, e := range entries {
+			payload := e.at.UTC().Format(time.RFC3339Nano) + " " + e.text
+			if !c.tty {
+				var header [8]byte
+				header[0] = e.stream
+				binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+				w.Write(header[:])
+			}
+			io.WriteString(w, payload)
+		}
+		w.(http.Flusher).Flush()
+	}
+	send(entries)
+	for query.Get("follow") == "1" {
+		d.mu.Lock()
+		changed := d.changed
+		// 止まったら (すぐ再起動しても) ストリームは終わる
+		running := c.running && c.started.Equal(started)
+		entries := c.logs[next:]
+		next = len(c.logs)
+		d.mu.Unlock()
+		send(entries)
+		if !running {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func TestParseDockerSince(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"10m", now.Add(-10 * time.Minute)},
+		{"2025-02-28T10:00:00Z", time.Date(2025, 2, 28, 10, 0, 0, 0, time.UTC)},
+		{"2025-02-28T10:00:00", time.Date(2025, 2, 28, 10, 0, 0, 0, time.Local)},
+		{"1740830400", time.Unix(1740830400, 0)},
+		{"1740830400.5", time.Unix(1740830400, 500000000)},
+	}
+	for _, tt := range tests {
+		got, err := parseDockerSince(tt.value, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseDockerSince(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"", "-5m", "yesterday"} {
+		if _, err := parseDockerSince(value, now); err == nil {
+			t.Errorf("parseDockerSince(%q) succeeded", value)
+		}
+	}
+	if got := formatDockerTime(time.Unix(1740830400, 5)); got != "1740830400.000000005" {
+		t.Errorf("formatDockerTime = %q", got)
+	}
+}
+
+func TestNewDockerClient(t *testing.T) {
+	c, err := newDockerClient("tcp://10.0.0.5:2375")
+	if err != nil || c.base != "http://10.0.0.5:2375" {
+		t.Errorf("tcp host: %+v, %v", c, err)
+	}
+	for _, host := range []string{"/var/run/docker.sock", "ssh://user@host", "unix://"} {
+		if _, err := newDockerClient(host); err == nil {
+			t.Errorf("newDockerClient(%q) succeeded", host)
+		}
+	}
+}
+
+func TestDockerLogsDemuxesStreamsAndColors(t *testing.T) {
+	withReset(t)
+	wait := memoryOutput(t)
+	docker, client := startFakeDocker(t)
+	docker.run("api", "c1", false)
+	docker.write("api", dockerStdout, "old line\n", "starting\n")
+	docker.write("api", dockerStderr, "WARN disk almost full\n")
+
+	h := userHighlighter(colorAlways, "red:ERROR")
+	logs := &dockerLogs{client: client, name: "api", lines: 2, stdout: true, stderr: true, poll: 10 * time.Millisecond}
+	runTestSource(t, func(ctx context.Context) error { return logs.run(ctx, h) })
+	wait("WARN disk almost full\n")
+
+	// 1 行が stdout のフレーム 2 つに分かれ、間に stderr のフレームが挟まる
+	docker.write("api", dockerStdout, "ERROR request ")
+	docker.write("api", dockerStderr, "from stderr\n")
+	docker.write("api", dockerStdout, "failed\n")
+	got := wait("failed")
+	want := "starting\nWARN disk almost full\nfrom stderr\n" + h.Highlight("ERROR request failed") + "\n"
+	if got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	query := docker.requests()[0]
+	for key, value := range map[string]string{"follow": "1", "stdout": "true", "stderr": "true", "timestamps": "1", "tail": "2", "since": ""} {
+		if query.Get(key) != value {
+			t.Errorf("query %s = %q, want %q", key, query.Get(key), value)
+		}
+	}
+}
+
+func TestDockerLogsFollowsRestartsWithoutRepeats(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	wait := memoryOutput(t)
+	docker, client := startFakeDocker(t)
+	docker.run("api", "c1", false)
+	docker.write("api", dockerStdout, "first run\n")
+
+	logs := &dockerLogs{client: client, name: "api", lines: 10, stdout: true, stderr: true, poll: 10 * time.Millisecond}
+	done := runTestSource(t, func(ctx context.Context) error { return logs.run(ctx, nil) })
+	wait("first run\n")
+
+	// 同じコンテナが再起動する。止まっている間の行も表示する
+	docker.stop("api")
+	docker.write("api", dockerStdout, "written while stopped\n")
+	docker.run("api", "c1", false)
+	docker.waitRequests(t, 2)
+	docker.write("api", dockerStdout, "second run\n")
+	wait("second run\n")
+
+	// 作り直されて ID が変わっても同じ名前なら追いかける
+	docker.stop("api")
+	docker.mu.Lock()
+	delete(docker.containers, "api")
+	docker.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	docker.run("api", "c2", true)
+	docker.write("api", dockerStdout, "recreated with a tty\r\n")
+	got := wait("recreated with a tty\n")
+
+	want := "first run\nwritten while stopped\nsecond run\nrecreated with a tty\n"
+	if got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	queries := docker.requests()
+	if len(queries) != 3 {
+		t.Fatalf("requested logs %d times, want 3", len(queries))
+	}
+	for _, query := range queries[1:] {
+		if query.Get("tail") != "all" || query.Get("since") == "" {
+			t.Errorf("resume query = %v, want tail=all and since", query)
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("run returned %v while following", err)
+	default:
+	}
+}
+
+func TestDockerLogsResumesByDaemonClock(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	wait := memoryOutput(t)
+	docker, client := startFakeDocker(t)
+	// デーモンの時計が 1 時間遅れていても、最初に行を受け取る前に止まった間の行を落とさない
+	docker.skew = -time.Hour
+	docker.run("api", "c1", false)
+
+	logs := &dockerLogs{client: client, name: "api", lines: 0, stdout: true, stderr: true, poll: 10 * time.Millisecond}
+	runTestSource(t, func(ctx context.Context) error { return logs.run(ctx, nil) })
+	docker.waitRequests(t, 1)
+	docker.stop("api")
+	// 最初のストリームが終わってから書く
+	time.Sleep(50 * time.Millisecond)
+	docker.write("api", dockerStdout, "written while stopped\n")
+	docker.run("api", "c1", false)
+	docker.waitRequests(t, 2)
+	docker.write("api", dockerStdout, "second run\n")
+	if got := wait("second run\n"); got != "written while stopped\nsecond run\n" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestDockerClientNowUsesDaemonClock(t *testing.T) {
+	docker, client := startFakeDocker(t)
+	docker.skew = -time.Hour
+	now, err := client.now(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := time.Until(now) + time.Hour; diff < -2*time.Second || diff > 2*time.Second {
+		t.Fatalf("daemon now = %v, want about an hour behind", now)
+	}
+}
+
+func TestDockerLogsSinceAndStreamSelection(t *testing.T) {
+	withReset(t)
+	wait := memoryOutput(t)
+	docker, client := startFakeDocker(t)
+	docker.run("api", "c1", false)
+	docker.write("api", dockerStdout, "before\n")
+	docker.write("api", dockerStderr, "hidden stderr\n")
+	time.Sleep(20 * time.Millisecond)
+	since := time.Now()
+	docker.write("api", dockerStdout, "after 1\n", "after 2\n")
+	docker.write("api", dockerStderr, "hidden again\n")
+	docker.stop("api")
+
+	logs := &dockerLogs{client: client, name: "api", lines: -1, since: since, stdout: true, poll: 10 * time.Millisecond}
+	runTestSource(t, func(ctx context.Context) error { return logs.run(ctx, nil) })
+	if got := wait("after 2\n"); got != "after 1\nafter 2\n" {
+		t.Errorf("output = %q", got)
+	}
+	query := docker.requests()[0]
+	if query.Get("tail") != "all" || query.Get("since") != formatDockerTime(since) || query.Get("stderr") != "false" {
+		t.Errorf("query = %v", query)
+	}
+}
+
+func TestDockerLogsReportsMissingContainer(t *testing.T) {
+	withReset(t)
+	_, client := startFakeDocker(t)
+	logs := &dockerLogs{client: client, name: "nope", lines: 10, stdout: true, stderr: true, poll: 10 * time.Millisecond}
+	select {
+	case err := <-runTestSource(t, func(ctx context.Context) error { return logs.run(ctx, nil) }):
+		var apiErr *dockerAPIError
+		if !errors.As(err, &apiErr) || err.Error() != "docker: No such container: nope" {
+			t.Fatalf("run = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run kept waiting for a container that never existed")
+	}
+
+	unreachable, err := newDockerClient("unix://" + filepath.Join(t.TempDir(), "missing.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs.client = unreachable
+	err = logs.run(context.Background(), nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "cannot connect to the Docker daemon at unix://") {
+		t.Fatalf("run = %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ---------- 終了条件 (スクリプト向け) ----------
+
+const (
+	exitMatched = 0
+	exitFailed  = 1
+	exitTimeout = 124 // timeout(1) と同じ
+)
+
+type exitFlags struct {
+	untilMatch *string
+	failOn     *string
+	timeout    *time.Duration
+}
+
+func registerExitFlags(fs *flag.FlagSet) exitFlags {
+	return exitFlags{
+		untilMatch: fs.String("until-match", "", "exit 0 when a line matches this regex"),
+		failOn:     fs.String("fail-on", "", "exit 1 when a line matches this regex"),
+		timeout:    fs.Duration("timeout", 0, "stop after this duration (exit 124 if -until-match was not seen)"),
+	}
+}
+
+// 行ごとに評価される終了条件
+type exitConditions struct {
+	untilMatch *regexp.Regexp
+	failOn     *regexp.Regexp
+	timeout    time.Duration
+
+	once sync.Once
+	done chan int
+	hit  bool
+	mu   sync.Mutex
+}
+
+var exitRules *exitConditions
+
+func (f exitFlags) build() (*exitConditions, error) {
+	if *f.timeout < 0 {
+		return nil, fmt.Errorf("-timeout must be >= 0")
+	}
+	if *f.untilMatch == "" && *f.failOn == "" && *f.timeout == 0 {
+		return nil, nil
+	}
+
+	rules := &exitConditions{timeout: *f.timeout, done: make(chan int, 1)}
+	if *f.untilMatch != "" {
+		re, err := regexp.Compile(*f.untilMatch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -until-match regex '%s': %v", *f.untilMatch, err)
+		}
+		rules.untilMatch = re
+	}
+	if *f.failOn != "" {
+		re, err := regexp.Compile(*f.failOn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -fail-on regex '%s': %v", *f.failOn, err)
+		}
+		rules.failOn = re
+	}
+	return rules, nil
+}
+
+// 行を評価する。終了条件が成立済みなら false を返し、その行は表示しない。
+func (e *exitConditions) check(text string) bool {
+	if e == nil {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.hit {
+		return false
+	}
+	// fail-on を優先し、同じ行が両方にマッチした場合は失敗として扱う
+	switch {
+	case e.failOn != nil && e.failOn.MatchString(text):
+		e.trigger(exitFailed)
+	case e.untilMatch != nil && e.untilMatch.MatchString(text):
+		e.trigger(exitMatched)
+	}
+	return true
+}
+
+func (e *exitConditions) trigger(code int) {
+	e.hit = true
+	e.once.Do(func() {
+		e.done <- code
+	})
+}
+
+// 終了コードが届くチャネル。条件が無い場合は nil (select で永遠に待つ)。
+func (e *exitConditions) doneCh() <-chan int {
+	if e == nil {
+		return nil
+	}
+	return e.done
+}
+
+func (e *exitConditions) timeoutCh() <-chan time.Time {
+	if e == nil || e.timeout == 0 {
+		return nil
+	}
+	return time.After(e.timeout)
+}
+
+// タイムアウト時の終了コード。待っているパターンがあれば失敗扱い。
+func (e *exitConditions) timeoutCode() int {
+	if e.untilMatch != nil {
+		return exitTimeout
+	}
+	return exitMatched
+}
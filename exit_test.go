@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func buildExitRules(t *testing.T, args ...string) *exitConditions {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts := registerExitFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := opts.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestExitConditionsWithoutFlagsAreDisabled(t *testing.T) {
+	rules := buildExitRules(t)
+	if rules != nil {
+		t.Fatalf("rules = %+v, want nil", rules)
+	}
+	if !rules.check("anything") {
+		t.Fatal("nil rules should let every line through")
+	}
+	if rules.doneCh() != nil || rules.timeoutCh() != nil {
+		t.Fatal("nil rules should return nil channels")
+	}
+}
+
+func TestExitConditionsUntilMatchStopsAfterMatchingLine(t *testing.T) {
+	rules := buildExitRules(t, "-until-match", `Server started on :\d+`)
+
+	if !rules.check("booting") {
+		t.Fatal("line before match should be shown")
+	}
+	if !rules.check("Server started on :8080") {
+		t.Fatal("matching line should be shown")
+	}
+	if rules.check("after") {
+		t.Fatal("lines after the match should be suppressed")
+	}
+
+	select {
+	case code := <-rules.doneCh():
+		if code != exitMatched {
+			t.Fatalf("exit code = %d, want %d", code, exitMatched)
+		}
+	default:
+		t.Fatal("done channel did not receive exit code")
+	}
+}
+
+func TestExitConditionsFailOnTakesPrecedence(t *testing.T) {
+	rules := buildExitRules(t, "-until-match", "ready", "-fail-on", "FATAL")
+
+	rules.check("FATAL not ready")
+
+	if code := <-rules.doneCh(); code != exitFailed {
+		t.Fatalf("exit code = %d, want %d", code, exitFailed)
+	}
+}
+
+func TestExitConditionsTimeoutCode(t *testing.T) {
+	if got := buildExitRules(t, "-timeout", "1s", "-until-match", "ready").timeoutCode(); got != exitTimeout {
+		t.Fatalf("timeout code with -until-match = %d, want %d", got, exitTimeout)
+	}
+	if got := buildExitRules(t, "-timeout", "1s", "-fail-on", "FATAL").timeoutCode(); got != exitMatched {
+		t.Fatalf("timeout code with only -fail-on = %d, want %d", got, exitMatched)
+	}
+}
+
+func TestExitFlagsRejectInvalidValues(t *testing.T) {
+	for _, args := range [][]string{
+		{"-until-match", "["},
+		{"-fail-on", "("},
+		{"-timeout", "-1s"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		opts := registerExitFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := opts.build(); err == nil {
+			t.Fatalf("build(%q) error = nil", args)
+		}
+	}
+}
+
+func TestFileModeExitConditions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("booting\nServer started\nFATAL disk full\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("until match", func(t *testing.T) {
+		result := runTrailHelper(t, "--no-logo", "file", "-until-match", "Server started", path)
+
+		if result.code != exitMatched {
+			t.Fatalf("exit code = %d, want %d; stderr=%q", result.code, exitMatched, result.stderr)
+		}
+		if got, want := result.stdout, "booting\nServer started\n"; got != want {
+			t.Fatalf("stdout = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("fail on", func(t *testing.T) {
+		result := runTrailHelper(t, "--no-logo", "file", "-fail-on", "FATAL", path)
+
+		if result.code != exitFailed {
+			t.Fatalf("exit code = %d, want %d; stderr=%q", result.code, exitFailed, result.stderr)
+		}
+		requireContains(t, result.stdout, "FATAL disk full\n")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		start := time.Now()
+		result := runTrailHelper(t, "--no-logo", "file", "-n", "0", "-until-match", "never", "-timeout", "300ms", path)
+
+		if result.code != exitTimeout {
+			t.Fatalf("exit code = %d, want %d; stderr=%q", result.code, exitTimeout, result.stderr)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Fatalf("timeout took %v", elapsed)
+		}
+	})
+}
+
+func TestDirModeUntilMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeFileAt(t, filepath.Join(dir, "app.log"), "starting\nready\n", time.Now())
+
+	result := runTrailHelper(t, "--no-logo", "dir", "-until-match", "ready", dir)
+
+	if result.code != exitMatched {
+		t.Fatalf("exit code = %d, want %d; stderr=%q", result.code, exitMatched, result.stderr)
+	}
+	if got, want := result.stdout, "starting\nready\n"; got != want {
+		t.Fatalf("stdout = %q, want %q", got, want)
+	}
+}
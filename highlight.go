@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"regexp"
+)
+
+// ---------- 値の自動強調 (--auto-highlight) ----------
+
+type autoHighlightRule struct {
+	name  string
+	style string
+	regex string
+	scope patternScope
+}
+
+// 後ろほど優先される。URL や引用符で囲まれた文字列は中の数値やパスより優先する。
+var autoHighlightRules = []autoHighlightRule{
+	{"number", "yellow", `\b\d+(?:\.\d+)?(?:(?:ns|us|µs|ms|s|min|h|d|[kKMGTP]i?B|B|bytes)\b|%)`, scopeMatch},
+	{"status-2xx", "green", httpStatusRegex("2"), scopeGroup},
+	{"status-3xx", "cyan", httpStatusRegex("3"), scopeGroup},
+	{"status-4xx", "yellow", httpStatusRegex("4"), scopeGroup},
+	{"status-5xx", "bold+red", httpStatusRegex("5"), scopeGroup},
+	{"http-method", "bold", `\b(?:GET|POST|PUT|PATCH|DELETE|HEAD|OPTIONS|CONNECT|TRACE)\b`, scopeMatch},
+	{"path", "blue", `(?:^|[\s"'=(\[])((?:~|\.{1,2})?/[\w.\-]+(?:/[\w.\-]*)+|[A-Za-z]:\\[\w.\-\\]+)`, scopeGroup},
+	{"hex-id", "brightmagenta", `\b0x[0-9a-fA-F]+\b|\b[0-9a-f]{12,}\b`, scopeMatch},
+	{"ipv6", "cyan", `(?i)\b[0-9a-f]{1,4}(?::[0-9a-f]{1,4}){7}\b|(?:\b[0-9a-f]{1,4}(?::[0-9a-f]{1,4}){0,5})?::[0-9a-f]{1,4}(?::[0-9a-f]{1,4}){0,5}\b`, scopeMatch},
+	{"ipv4", "cyan", `\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)(?::\d{1,5})?\b`, scopeMatch},
+	{"uuid", "magenta", `(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`, scopeMatch},
+	{"url", "underline+blue", `\b(?:https?|wss?|ftp)://[^\s"'<>]+`, scopeMatch},
+	{"quoted", "green", `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`, scopeMatch},
+}
+
+// アクセスログの `HTTP/1.1" 404`、`status=404` などに続くステータスコード
+func httpStatusRegex(class string) string {
+	return `(?:HTTP/[\d.]+"?\s+|\bstatus[=:]\s?"?|\bcode[=:]\s?"?)(` + class + `\d\d)\b`
+}
+
+// 自動強調のパターンを加える。-c のパターンとレベルの色より優先度が低くなるよう Order は負の値にする。
+func (h *Highlighter) enableAutoHighlight() {
+	defer h.rebuild()
+	h.auto = make([]ColorPattern, 0, len(autoHighlightRules))
+	for i, rule := range autoHighlightRules {
+		c, ok := h.styleColor(rule.style)
+		if !ok {
+			log.Fatalf("invalid auto-highlight style '%s'", rule.style)
+		}
+		h.auto = append(h.auto, ColorPattern{
+			Pattern: regexp.MustCompile(rule.regex),
+			Color:   c,
+			Style:   rule.style,
+			Order:   autoHighlightOrderBase + i,
+			Spec:    "auto:" + rule.name,
+			Scope:   rule.scope,
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAutoHighlightRules(t *testing.T) {
+	h := newHighlighter(colorAlways)
+	h.enableAutoHighlight()
+
+	tests := []struct {
+		text string
+		want map[string]string // 強調される文字列 -> ルール名
+	}{
+		{`10.0.0.12:8080 GET /api/v1/users?id=3 HTTP/1.1" 404 took 35ms`, map[string]string{
+			"10.0.0.12:8080": "ipv4", "GET": "http-method", "/api/v1/users": "path", "404": "status-4xx", "35ms": "number",
+		}},
+		{`request 3f2b8c1e-9a4d-4e2b-8f6a-1c2d3e4f5a6b from fe80::1 to ::1`, map[string]string{
+			"3f2b8c1e-9a4d-4e2b-8f6a-1c2d3e4f5a6b": "uuid", "fe80::1": "ipv6", "::1": "ipv6",
+		}},
+		{`fetch https://example.com/x?a=1 ok status=503 ptr 0x7ffe12 commit 4e2b8f6a1c2d`, map[string]string{
+			"https://example.com/x?a=1": "url", "503": "status-5xx", "0x7ffe12": "hex-id", "4e2b8f6a1c2d": "hex-id",
+		}},
+		{`msg="disk 95% full" file C:\logs\app.log size 1.5GiB`, map[string]string{
+			`"disk 95% full"`: "quoted", `C:\logs\app.log`: "path", "1.5GiB": "number",
+		}},
+		{`12:34:56 Foo::Bar 200 std/lib 42`, map[string]string{}},
+	}
+	for _, tt := range tests {
+		got := map[string]string{}
+		for _, pattern := range h.patterns() {
+			spans, _ := pattern.spans(tt.text)
+			for _, span := range spans {
+				got[tt.text[span[0]:span[1]]] = pattern.Spec[len("auto:"):]
+			}
+		}
+		for token, rule := range tt.want {
+			if got[token] != rule {
+				t.Errorf("%q: %q highlighted as %q, want %q (all: %v)", tt.text, token, got[token], rule, got)
+			}
+		}
+		// 引用符内の 95% のように、優先されるルールの範囲に含まれるものは除く
+	unexpected:
+		for token, rule := range got {
+			for want := range tt.want {
+				if strings.Contains(want, token) {
+					continue unexpected
+				}
+			}
+			t.Errorf("%q: unexpected highlight %q (%s)", tt.text, token, rule)
+		}
+	}
+}
+
+func TestAutoHighlightBelowUserPatterns(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm")
+	t.Setenv("COLORTERM", "")
+
+	h := buildHighlighter(globalOptions{colorMode: colorAlways, autoHighlight: true}, []string{"red:10\\.0\\.0\\.1"})
+
+	got := h.Highlight("from 10.0.0.1 took 5ms")
+	want := fmt.Sprintf("from %s took %s", "\x1b[31m10.0.0.1\x1b[0m", "\x1b[33m5ms\x1b[0m")
+	if got != want {
+		t.Fatalf("Highlight = %q, want %q", got, want)
+	}
+	// -c のパターンだけが集計対象になる
+	if summary.matches[0] != 1 || len(h.userPatterns()) != 1 {
+		t.Fatalf("matches = %v", summary.matches)
+	}
+}
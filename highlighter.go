@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/fatih/color"
+	"github.com/yutat23/trail/pkg/trail"
+)
+
+// ---------- 色付けの規則 (Highlighter) ----------
+
+// 色付けのパターンと色の出し方 (--color) をまとめた値。
+// 規則を値ごとに持つので、規則の異なる追従を 1 つのプロセスで並べて動かせる。
+// パターンを加え終えた後は読み取るだけなので、複数の goroutine から同時に使ってよい。
+// nil は色付けしない Highlighter として扱う。
+type Highlighter struct {
+	mode    colorMode
+	enabled bool // ANSI の色を出すか。auto は作成時の color.NoColor に従う
+	level   colorLevel
+
+	auto   []ColorPattern // --auto-highlight
+	levels []ColorPattern // レベル表記の色
+	user   []ColorPattern // -c
+	all    []ColorPattern // auto, levels, user の順に合わせたもの
+
+	faintColor *color.Color // 行の接頭辞の色
+
+	// nil でなければパターンごとのマッチ数を通知する
+	record func(order, n int)
+}
+
+var _ trail.Highlighter = (*Highlighter)(nil)
+
+func newHighlighter(mode colorMode) *Highlighter {
+	h := &Highlighter{
+		mode:    mode,
+		enabled: mode == colorAlways || (mode == colorAuto && !color.NoColor),
+		level:   detectColorLevel(mode),
+	}
+	h.faintColor = h.newColor(color.Faint)
+	return h
+}
+
+// CLI の指定から Highlighter を作る。マッチ数は集計へ反映する。
+func buildHighlighter(opts globalOptions, colorOpts []string) *Highlighter {
+	h := newHighlighter(opts.colorMode)
+	h.record = recordPatternMatches
+	if opts.autoHighlight {
+		h.enableAutoHighlight()
+	}
+	if !opts.noLevelColors {
+		h.enableLevelColors()
+	}
+	h.addColorPatterns(colorOpts)
+	return h
+}
+
+// 行の接頭辞 (受信時刻や読み出し元) を薄い色で返す。nil や色を出さない場合はそのまま返す
+func (h *Highlighter) faint(s string) string {
+	if h == nil || !h.enabled {
+		return s
+	}
+	return h.faintColor.Sprint(s)
+}
+
+// color.NoColor によらず、この Highlighter の色の出し方に従う色を作る
+func (h *Highlighter) newColor(attrs ...color.Attribute) *color.Color {
+	c := color.New(attrs...)
+	if h == nil {
+		return c
+	}
+	if h.enabled {
+		c.EnableColor()
+	} else {
+		c.DisableColor()
+	}
+	return c
+}
+
+// 自動強調・レベルの色・-c のパターンを合わせた一覧
+func (h *Highlighter) patterns() []ColorPattern {
+	if h == nil {
+		return nil
+	}
+	return h.all
+}
+
+// -c で指定したパターン (集計やTUIの切り替えの対象)
+func (h *Highlighter) userPatterns() []ColorPattern {
+	if h == nil {
+		return nil
+	}
+	return h.user
+}
+
+func (h *Highlighter) rebuild() {
+	h.all = make([]ColorPattern, 0, len(h.auto)+len(h.levels)+len(h.user))
+	h.all = append(h.all, h.auto...)
+	h.all = append(h.all, h.levels...)
+	h.all = append(h.all, h.user...)
+}
+
+// 行を色付けする
+func (h *Highlighter) Highlight(text string) string {
+	if h == nil {
+		return text
+	}
+	return colorize(text, h.all, h.record)
+}
+
+// 行を色付けし、色を付けた範囲も返す (HTML への書き出し用)
+func (h *Highlighter) highlightSpans(text string) (string, []colorMatch) {
+	if h == nil {
+		return text, nil
+	}
+	return colorizeSpans(text, h.all, h.record)
+}
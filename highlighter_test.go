@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHighlighterKeepsItsOwnColorMode(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm")
+	t.Setenv("COLORTERM", "")
+	always := userHighlighter(colorAlways, "red:ERROR")
+	never := userHighlighter(colorNever, "red:ERROR")
+	// auto は作成時の color.NoColor に従う
+	auto := userHighlighter(colorAuto, "red:ERROR")
+
+	if got, want := always.Highlight("ERROR"), ansi("31", "ERROR"); got != want {
+		t.Fatalf("always = %q, want %q", got, want)
+	}
+	if got := never.Highlight("ERROR"); got != "ERROR" {
+		t.Fatalf("never = %q, want plain text", got)
+	}
+	if got := auto.Highlight("ERROR"); got != "ERROR" {
+		t.Fatalf("auto with NoColor = %q, want plain text", got)
+	}
+
+	var nilHighlighter *Highlighter
+	if got := nilHighlighter.Highlight("ERROR"); got != "ERROR" {
+		t.Fatalf("nil Highlighter = %q, want plain text", got)
+	}
+}
+
+func TestHighlightersUsedConcurrently(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm")
+	t.Setenv("COLORTERM", "")
+	tests := []struct {
+		h    *Highlighter
+		line string
+		want string
+	}{
+		{userHighlighter(colorAlways, "red:ERROR", "cyan:\\d+ms"), "ERROR took 5ms", ansi("31", "ERROR") + " took " + ansi("36", "5ms")},
+		{userHighlighter(colorAlways, "green:ERROR"), "ERROR took 5ms", ansi("32", "ERROR") + " took 5ms"},
+		{userHighlighter(colorNever, "red:ERROR"), "ERROR took 5ms", "ERROR took 5ms"},
+		{buildHighlighter(globalOptions{colorMode: colorAlways}, nil), "WARN low", ansi("33", "WARN") + " low"},
+	}
+
+	var wg sync.WaitGroup
+	for _, tt := range tests {
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 200 {
+					if got := tt.h.Highlight(tt.line); got != tt.want {
+						t.Errorf("Highlight(%q) = %q, want %q", tt.line, got, tt.want)
+						return
+					}
+					if got, _ := tt.h.highlightSpans(tt.line); got != tt.want {
+						t.Errorf("highlightSpans(%q) = %q, want %q", tt.line, got, tt.want)
+						return
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	// 複数の goroutine から通知されたマッチ数も集計される
+	if summary.matches[0] == 0 {
+		t.Fatalf("matches = %v, want counts from the highlighters", summary.matches)
+	}
+}
+
+func TestFollowersWithDifferentHighlighters(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm")
+	t.Setenv("COLORTERM", "")
+	dir := t.TempDir()
+	apiPath := filepath.Join(dir, "api.log")
+	dbPath := filepath.Join(dir, "db.log")
+	for _, path := range []string{apiPath, dbPath} {
+		if err := os.WriteFile(path, []byte("started\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := userHighlighter(colorAlways, "red:ERROR")
+	db := userHighlighter(colorAlways, "magenta:ERROR", "cyan:slow")
+
+	wait := memoryOutput(t)
+	startTestFollower(t, api, apiPath, 0)
+	startTestFollower(t, db, dbPath, 0)
+	appendToFile(t, apiPath, "api ERROR boom\n")
+	appendToFile(t, dbPath, "db ERROR slow query\n")
+	wait("boom\n")
+	out := wait("query\n")
+
+	for _, want := range []string{
+		"api " + ansi("31", "ERROR") + " boom\n",
+		"db " + ansi("35", "ERROR") + " " + ansi("36", "slow") + " query\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output = %q, want it to contain %q", out, want)
+		}
+	}
+}
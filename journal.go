@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- サブコマンド: journal ----------
+
+// journalctl -o json -f の出力を読み、journalctl の short 形式に近い 1 行にして表示する。
+// 優先度 (PRIORITY) は syslog と同じ名前 (ERR, WARNING など) で行に入れるので、
+// レベルの色付けや -min-level がそのまま効く。
+
+// テストで差し替える
+var journalctlCommand = []string{"journalctl"}
+
+// journalctl -o json の 1 エントリ。値は文字列か、表示できないバイトを含むときは数値の配列になる
+type journalEntry map[string]json.RawMessage
+
+func (e journalEntry) field(name string) string {
+	raw, ok := e[name]
+	if !ok {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var data []byte
+	if json.Unmarshal(raw, &data) == nil {
+		return string(data)
+	}
+	// 同じフィールドが複数ある場合は配列になる。最初の値を使う
+	var values []json.RawMessage
+	if json.Unmarshal(raw, &values) == nil && len(values) > 0 {
+		return journalEntry{name: values[0]}.field(name)
+	}
+	return ""
+}
+
+// 表示する行。複数行のメッセージは 2 行目以降をそのまま続ける
+func (e journalEntry) lines() []string {
+	var head []string
+	if usec, err := strconv.ParseInt(e.field("__REALTIME_TIMESTAMP"), 10, 64); err == nil {
+		head = append(head, time.UnixMicro(usec).Format(prefixTimeLayout))
+	}
+	if host := e.field("_HOSTNAME"); host != "" {
+		head = append(head, host)
+	}
+	ident := e.identifier()
+	if pid := e.field("_PID"); pid != "" {
+		ident += "[" + pid + "]"
+	} else if pid := e.field("SYSLOG_PID"); pid != "" {
+		ident += "[" + pid + "]"
+	}
+	head = append(head, ident+":")
+	if p, err := strconv.Atoi(e.field("PRIORITY")); err == nil && p >= 0 && p < len(syslogSeverityNames) {
+		head = append(head, syslogSeverityNames[p])
+	}
+	message := strings.TrimRight(e.field("MESSAGE"), "\n")
+	lines := strings.Split(message, "\n")
+	lines[0] = strings.Join(head, " ") + " " + lines[0]
+	return lines
+}
+
+// メッセージを出したプログラムの名前
+func (e journalEntry) identifier() string {
+	for _, name := range []string{"SYSLOG_IDENTIFIER", "_COMM", "_SYSTEMD_UNIT"} {
+		if v := e.field(name); v != "" {
+			return v
+		}
+	}
+	return "journal"
+}
+
+type journalReader struct {
+	units       []string
+	identifiers []string
+	matches     []string // FIELD=VALUE
+	lines       int      // 最初に表示するエントリ数。負なら全部
+	since       string   // journalctl の --since にそのまま渡す
+	cursorFile  string   // 空でなければ最後に読んだ位置を保存し、次回はその続きから読む
+
+	cursor      string    // 最後に読んだエントリの __CURSOR
+	savedAt     time.Time // 最後に cursorFile へ書いた時刻
+	savedCursor string    // 最後に cursorFile へ書いた値
+}
+
+// journalctl に渡す引数
+func (j *journalReader) args() []string {
+	args := []string{"--output=json", "--follow", "--no-pager"}
+	for _, u := range j.units {
+		args = append(args, "--unit="+u)
+	}
+	for _, id := range j.identifiers {
+		args = append(args, "--identifier="+id)
+	}
+	switch {
+	case j.cursor != "":
+		args = append(args, "--after-cursor="+j.cursor, "--no-tail")
+	case j.lines < 0:
+		args = append(args, "--no-tail")
+	default:
+		args = append(args, "--lines="+strconv.Itoa(j.lines))
+	}
+	if j.since != "" && j.cursor == "" {
+		args = append(args, "--since="+j.since)
+	}
+	return append(args, j.matches...)
+}
+
+func (j *journalReader) loadCursor() error {
+	if j.cursorFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(j.cursorFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("-cursor-file: %v", err)
+	}
+	j.cursor = strings.TrimSpace(string(data))
+	j.savedCursor = j.cursor
+	return nil
+}
+
+// 書きかけのファイルを残さないよう、別名で書いてから置き換える
+func (j *journalReader) saveCursor(force bool) {
+	if j.cursorFile == "" || j.cursor == "" || j.cursor == j.savedCursor {
+		return
+	}
+	if !force && time.Since(j.savedAt) < time.Second {
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(j.cursorFile), "."+filepath.Base(j.cursorFile)+".tmp")
+	if err := os.WriteFile(tmp, []byte(j.cursor+"\n"), 0o600); err != nil {
+		log.Printf("-cursor-file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, j.cursorFile); err != nil {
+		log.Printf("-cursor-file: %v", err)
+		return
+	}
+	j.savedAt = time.Now()
+	j.savedCursor = j.cursor
+}
+
+// ctx が終わるか journalctl が終わるまでエントリを読む
+func (j *journalReader) run(ctx context.Context, h *Highlighter) error {
+	defer j.saveCursor(true)
+	cmd := exec.CommandContext(ctx, journalctlCommand[0], append(journalctlCommand[1:], j.args()...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("cannot run journalctl: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var lastStderr string
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			lastStderr = scanner.Text()
+			log.Printf("journalctl: %s", lastStderr)
+		}
+	}()
+
+	r := bufio.NewReaderSize(stdout, 64<<10)
+	for {
+		data, readErr := r.ReadBytes('\n')
+		if len(data) > 0 {
+			var entry journalEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				log.Printf("journalctl: invalid entry: %v", err)
+			} else {
+				source := entry.identifier()
+				for _, line := range entry.lines() {
+					writeLine(h, source, line)
+				}
+				if cursor := entry.field("__CURSOR"); cursor != "" {
+					j.cursor = cursor
+				}
+			}
+			if r.Buffered() == 0 {
+				output.flush()
+				j.saveCursor(false)
+			}
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	output.flush()
+	wg.Wait()
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if lastStderr != "" {
+			return fmt.Errorf("journalctl exited with status %d: %s", exitErr.ExitCode(), lastStderr)
+		}
+		return fmt.Errorf("journalctl exited with status %d", exitErr.ExitCode())
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+func cmdJournal(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("journal", flag.ExitOnError)
+	var units, identifiers repeatedStrings
+	fs.Var(&units, "u", "systemd unit to show (can be used multiple times)")
+	fs.Var(&identifiers, "t", "syslog identifier to show (can be used multiple times)")
+	nLines := fs.Int("n", 10, "show last N entries then follow (default all entries with -since)")
+	since := fs.String("since", "", "show entries since a time, passed to journalctl (e.g. \"10 min ago\", \"2024-05-01 12:00\")")
+	cursorFile := fs.String("cursor-file", "", "remember the last entry in this file and continue after it next time")
+	pipeline := registerPipelineFlags(fs)
+	fs.Parse(args)
+	for _, match := range fs.Args() {
+		if !strings.Contains(match, "=") {
+			log.Fatalf("usage: trail journal [options] [FIELD=VALUE...]")
+		}
+	}
+	validateLineCount(*nLines)
+	reader := &journalReader{
+		units:       units,
+		identifiers: identifiers,
+		matches:     fs.Args(),
+		lines:       linesSince(fs, *nLines, *since != ""),
+		since:       *since,
+		cursorFile:  *cursorFile,
+	}
+	if err := reader.loadCursor(); err != nil {
+		log.Fatal(err)
+	}
+
+	label := "journal"
+	if len(units) > 0 {
+		label += " " + strings.Join(units, ",")
+	}
+	highlighter := pipeline.start(opts, label)
+	runSource(highlighter, label, *pipeline.showSummary, func(ctx context.Context) error {
+		return reader.run(ctx, highlighter)
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journalctl の代わりに動くテストバイナリ。
+// TRAIL_FAKE_JOURNAL_ENTRIES のファイルをそのまま出力し、受け取った引数を TRAIL_FAKE_JOURNAL_ARGS に書く。
+// TRAIL_FAKE_JOURNAL_EXIT が空なら journalctl -f のように終わらずに待つ。
+func TestFakeJournalctl(t *testing.T) {
+	if os.Getenv("TRAIL_FAKE_JOURNALCTL") != "1" {
+		return
+	}
+	args := os.Args[len(os.Args)-1:]
+	for i, arg := range os.Args {
+		if arg == "--" {
+			args = os.Args[i+1:]
+			break
+		}
+	}
+	data, _ := json.Marshal(args)
+	os.WriteFile(os.Getenv("TRAIL_FAKE_JOURNAL_ARGS"), data, 0o600)
+	entries, _ := os.ReadFile(os.Getenv("TRAIL_FAKE_JOURNAL_ENTRIES"))
+	os.Stdout.Write(entries)
+	if msg := os.Getenv("TRAIL_FAKE_JOURNAL_STDERR"); msg != "" {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	if code := os.Getenv("TRAIL_FAKE_JOURNAL_EXIT"); code != "" {
+		var n int
+		fmt.Sscan(code, &n)
+		os.Exit(n)
+	}
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+// 偽の journalctl を使うようにし、出力するエントリと引数の記録先を用意する
+func fakeJournalctl(t *testing.T, entries ...map[string]any) (argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	var lines strings.Builder
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines.Write(data)
+		lines.WriteByte('\n')
+	}
+	entriesFile := filepath.Join(dir, "entries")
+	if err := os.WriteFile(entriesFile, []byte(lines.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	argsFile = filepath.Join(dir, "args")
+	t.Setenv("TRAIL_FAKE_JOURNALCTL", "1")
+	t.Setenv("TRAIL_FAKE_JOURNAL_ENTRIES", entriesFile)
+	t.Setenv("TRAIL_FAKE_JOURNAL_ARGS", argsFile)
+	t.Setenv("TRAIL_FAKE_JOURNAL_EXIT", "")
+	t.Setenv("TRAIL_FAKE_JOURNAL_STDERR", "")
+	saved := journalctlCommand
+	journalctlCommand = []string{os.Args[0], "-test.run=^TestFakeJournalctl$", "--"}
+	t.Cleanup(func() { journalctlCommand = saved })
+	return argsFile
+}
+
+func readJournalArgs(t *testing.T, argsFile string) []string {
+	t.Helper()
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var args []string
+	if err := json.Unmarshal(data, &args); err != nil {
+		t.Fatal(err)
+	}
+	return args
+}
+
+func testJournalEntry(cursor string, priority int, message any) map[string]any {
+	return map[string]any{
+		"__CURSOR":             cursor,
+		"__REALTIME_TIMESTAMP": fmt.Sprint(time.Date(2024, 5, 1, 12, 0, 0, 250e6, time.Local).UnixMicro()),
+		"_HOSTNAME":            "web1",
+		"SYSLOG_IDENTIFIER":    "myservice",
+		"_PID":                 "812",
+		"PRIORITY":             fmt.Sprint(priority),
+		"MESSAGE":              message,
+	}
+}
+
+func TestJournalEntryLines(t *testing.T) {
+	tests := []struct {
+		entry map[string]any
+		want  []string
+	}{
+		{testJournalEntry("c1", 4, "disk almost full"), []string{"2024-05-01 12:00:00.250 web1 myservice[812]: WARNING disk almost full"}},
+		{testJournalEntry("c1", 3, "panic\n  at main.go:10\n"), []string{"2024-05-01 12:00:00.250 web1 myservice[812]: ERR panic", "  at main.go:10"}},
+		// 表示できないバイトを含むメッセージは数値の配列で届く
+		{testJournalEntry("c1", 6, []int{'o', 'k', 0xff}), []string{"2024-05-01 12:00:00.250 web1 myservice[812]: INFO ok\xff"}},
+		{map[string]any{"_COMM": "kernel", "MESSAGE": "eth0: link up"}, []string{"kernel: eth0: link up"}},
+	}
+	for i, tt := range tests {
+		data, _ := json.Marshal(tt.entry)
+		var entry journalEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if got := entry.lines(); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%d: lines = %q, want %q", i, got, tt.want)
+		}
+	}
+	// 優先度の名前はどれもレベルとして判定される
+	wantLevels := []severity{severityFatal, severityFatal, severityFatal, severityError, severityWarn, severityInfo, severityInfo, severityDebug}
+	for p, name := range syslogSeverityNames {
+		if got := detectSeverity("myservice[812]: " + name + " message"); got != wantLevels[p] {
+			t.Errorf("priority %d (%s) = %v, want %v", p, name, got, wantLevels[p])
+		}
+	}
+}
+
+func runTestJournal(t *testing.T, reader *journalReader, h *Highlighter) (cancel func() error) {
+	t.Helper()
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- reader.run(ctx, h) }()
+	t.Cleanup(stop)
+	return func() error {
+		stop()
+		return <-done
+	}
+}
+
+func TestJournalColorsByPriorityAndResumesFromCursor(t *testing.T) {
+	withReset(t)
+	wait := memoryOutput(t)
+	argsFile := fakeJournalctl(t,
+		testJournalEntry("c1", 6, "started"),
+		testJournalEntry("c2", 3, "request failed"),
+		testJournalEntry("c3", 7, "cache hit"),
+	)
+	cursorFile := filepath.Join(t.TempDir(), "cursor")
+	h := newHighlighter(colorAlways)
+	h.enableLevelColors()
+	levelRules = newLevelFilter(severityInfo)
+
+	reader := &journalReader{units: []string{"myservice"}, lines: 10, cursorFile: cursorFile}
+	stop := runTestJournal(t, reader, h)
+	got := wait("request failed\n")
+	if err := stop(); err != nil {
+		t.Fatalf("run = %v", err)
+	}
+	want := h.Highlight("2024-05-01 12:00:00.250 web1 myservice[812]: INFO started") + "\n" +
+		h.Highlight("2024-05-01 12:00:00.250 web1 myservice[812]: ERR request failed") + "\n"
+	if got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if !strings.Contains(got, "\x1b[31mERR\x1b[0m") {
+		t.Errorf("ERR is not red: %q", got)
+	}
+	if args := readJournalArgs(t, argsFile); fmt.Sprint(args) != "[--output=json --follow --no-pager --unit=myservice --lines=10]" {
+		t.Errorf("args = %q", args)
+	}
+	if data, _ := os.ReadFile(cursorFile); string(data) != "c3\n" {
+		t.Errorf("cursor file = %q, want c3", data)
+	}
+
+	// 次回は保存した位置の続きから読む
+	argsFile = fakeJournalctl(t, testJournalEntry("c4", 6, "resumed"))
+	reader = &journalReader{units: []string{"myservice"}, lines: 10, since: "today", cursorFile: cursorFile}
+	if err := reader.loadCursor(); err != nil {
+		t.Fatal(err)
+	}
+	stop = runTestJournal(t, reader, h)
+	wait("resumed\n")
+	if err := stop(); err != nil {
+		t.Fatalf("run = %v", err)
+	}
+	if args := readJournalArgs(t, argsFile); fmt.Sprint(args) != "[--output=json --follow --no-pager --unit=myservice --after-cursor=c3 --no-tail]" {
+		t.Errorf("resume args = %q", args)
+	}
+}
+
+func TestJournalReportsJournalctlFailure(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	fakeJournalctl(t)
+	t.Setenv("TRAIL_FAKE_JOURNAL_STDERR", "No journal files were found.")
+	t.Setenv("TRAIL_FAKE_JOURNAL_EXIT", "1")
+	err := (&journalReader{lines: 10}).run(context.Background(), nil)
+	if err == nil || err.Error() != "journalctl exited with status 1: No journal files were found." {
+		t.Fatalf("run = %v", err)
+	}
+
+	journalctlCommand = []string{filepath.Join(t.TempDir(), "no-journalctl")}
+	if err := (&journalReader{lines: 10}).run(context.Background(), nil); err == nil || !strings.HasPrefix(err.Error(), "cannot run journalctl: ") {
+		t.Fatalf("run without journalctl = %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- JSON 出力 (--output json) ----------
+
+// --output json が指定されたか
+var jsonOutput bool
+
+// --parse が指定されたか。JSON / logfmt の行を fields に展開する。
+var parseFields bool
+
+// 1 行分のレコード
+type lineRecord struct {
+	Type       string         `json:"type"`
+	File       string         `json:"file,omitempty"`
+	Offset     *int64         `json:"offset,omitempty"`
+	LineNumber int            `json:"line_number,omitempty"`
+	ReceivedAt string         `json:"received_at"`
+	Text       string         `json:"text"`
+	Level      string         `json:"level,omitempty"`
+	Patterns   []string       `json:"patterns,omitempty"`
+	Fields     map[string]any `json:"fields,omitempty"`
+}
+
+// ファイルの切り替えなどの出来事のレコード
+type eventRecord struct {
+	Type    string `json:"type"`
+	Event   string `json:"event"`
+	File    string `json:"file,omitempty"`
+	Time    string `json:"time"`
+	Message string `json:"message"`
+}
+
+func setOutputFormat(format string) {
+	switch strings.ToLower(format) {
+	case "text":
+		jsonOutput = false
+	case "json":
+		jsonOutput = true
+	default:
+		log.Fatalf("invalid --output value %q (expected text, json)", format)
+	}
+}
+
+func marshalRecord(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprintf(`{"type":"error","message":%q}`, err.Error())
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// 行のレコードを組み立てる。patterns は一致したパターンの Spec。
+func newLineRecord(info lineInfo, text string, patterns []string) lineRecord {
+	received := info.received
+	if received.IsZero() {
+		received = time.Now()
+	}
+	record := lineRecord{
+		Type:       "line",
+		File:       info.source,
+		LineNumber: info.number,
+		ReceivedAt: received.Format(time.RFC3339Nano),
+		Text:       text,
+		Patterns:   patterns,
+	}
+	if info.offset >= 0 {
+		offset := info.offset
+		record.Offset = &offset
+	}
+	if level := detectSeverity(text); level != severityUnknown {
+		record.Level = level.String()
+	}
+	if parseFields {
+		record.Fields = parseLineFields(text)
+	}
+	return record
+}
+
+// 行に一致したパターンの Spec。色付けと同じくマッチ数も集計する。
+func (h *Highlighter) matchedPatterns(text string) []string {
+	patterns := h.patterns()
+	var names []string
+	colorize(text, patterns, func(order, n int) {
+		if h.record != nil {
+			h.record(order, n)
+		}
+		if n == 0 {
+			return
+		}
+		for _, p := range patterns {
+			if p.Order == order {
+				names = append(names, p.Spec)
+				break
+			}
+		}
+	})
+	return names
+}
+
+// 出来事を知らせる。JSON 出力ではレコードとして標準出力へ、それ以外は log に書く。
+func logEvent(event, file, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if !jsonOutput {
+		log.Print(message)
+		return
+	}
+	output.writeLine(marshalRecord(eventRecord{
+		Type:    "event",
+		Event:   event,
+		File:    file,
+		Time:    time.Now().Format(time.RFC3339Nano),
+		Message: message,
+	}))
+	output.flush()
+}
+
+var logfmtPairRE = regexp.MustCompile(`(?:^|\s)([\w.\-]+)=("(?:[^"\\]|\\.)*"|[^\s"]*)`)
+
+// JSON オブジェクトの行、または key=value が並ぶ (logfmt) 行を解析する。解析できなければ nil。
+func parseLineFields(text string) map[string]any {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "{") {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(trimmed), &fields); err == nil {
+			return fields
+		}
+	}
+	matches := logfmtPairRE.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	fields := make(map[string]any, len(matches))
+	for _, m := range matches {
+		value := m[2]
+		if strings.HasPrefix(value, `"`) {
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		}
+		fields[m[1]] = value
+	}
+	return fields
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeRecords(t *testing.T, out string) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestFollowLastLinesJSONOutput(t *testing.T) {
+	withReset(t)
+	jsonOutput = true
+	parseFields = true
+	h := userHighlighter(colorAuto, "red:ERROR", "cyan:took \\d+ms")
+	path := filepath.Join(t.TempDir(), "app.log")
+	content := "skipped\nlevel=error msg=\"db down\" took 5ms\n{\"level\":\"info\",\"n\":1}\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := memoryOutput(t)
+	startTestFollower(t, h, path, 2)
+	out := wait(`"line_number":3`)
+	requireNotContains(t, out, "\x1b[")
+	records := decodeRecords(t, out)
+	if len(records) != 2 {
+		t.Fatalf("records = %v", records)
+	}
+
+	first := records[0]
+	want := map[string]any{
+		"type":        "line",
+		"file":        path,
+		"offset":      float64(8),
+		"line_number": float64(2),
+		"text":        `level=error msg="db down" took 5ms`,
+		"level":       "error",
+		"patterns":    []any{"cyan:took \\d+ms"},
+		"fields":      map[string]any{"level": "error", "msg": "db down"},
+	}
+	for key, value := range want {
+		if !reflect.DeepEqual(first[key], value) {
+			t.Errorf("%s = %#v, want %#v", key, first[key], value)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, first["received_at"].(string)); err != nil {
+		t.Errorf("received_at: %v", err)
+	}
+	if got := records[1]["fields"]; !reflect.DeepEqual(got, map[string]any{"level": "info", "n": float64(1)}) {
+		t.Errorf("JSON fields = %#v", got)
+	}
+	if summary.matches[1] != 1 {
+		t.Errorf("matches = %v", summary.matches)
+	}
+}
+
+func TestParseLineFields(t *testing.T) {
+	if got := parseLineFields("plain text"); got != nil {
+		t.Fatalf("parseLineFields(plain) = %v", got)
+	}
+	got := parseLineFields(`ts=1 user.id=42 path=/a empty= q="a \"b\""`)
+	want := map[string]any{"ts": "1", "user.id": "42", "path": "/a", "empty": "", "q": `a "b"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseLineFields = %#v, want %#v", got, want)
+	}
+}
+
+func TestLogEventJSON(t *testing.T) {
+	withReset(t)
+	jsonOutput = true
+
+	out := captureOutput(t, func() {
+		logEvent("switch", "/logs/b.log", "switching to %s", "/logs/b.log")
+	})
+	records := decodeRecords(t, out)
+	if len(records) != 1 || records[0]["type"] != "event" || records[0]["event"] != "switch" ||
+		records[0]["file"] != "/logs/b.log" || records[0]["message"] != "switching to /logs/b.log" {
+		t.Fatalf("records = %v", records)
+	}
+}
+
+func TestFollowJSONReportsTruncation(t *testing.T) {
+	withReset(t)
+	jsonOutput = true
+	path := filepath.Join(t.TempDir(), "follow.log")
+	initial := "a\nb\n"
+	if err := os.WriteFile(path, []byte(initial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := memoryOutput(t)
+	state := startTestFollower(t, nil, path, 0)
+	appendToFile(t, path, "c\n")
+	wait(`"text":"c"`)
+	if err := os.WriteFile(path, []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := wait(`"text":"x"`)
+	stopTestFollower(t, state)
+
+	var got []string
+	for _, record := range decodeRecords(t, out) {
+		switch record["type"] {
+		case "line":
+			got = append(got, fmt.Sprintf("%s@%v", record["text"], record["line_number"]))
+		case "event":
+			got = append(got, record["event"].(string))
+		}
+	}
+	if strings.Join(got, ",") != "c@3,truncated,x@1" {
+		t.Fatalf("records = %v", got)
+	}
+}
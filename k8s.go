@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- サブコマンド: k8s ----------
+
+// ラベルセレクターに合う Pod のコンテナのログをまとめて表示する。
+// Pod の一覧を監視し、新しい Pod は見つけ次第追いかけ、消えた Pod はやめる。
+// 各行には [pod/container] を付ける。
+
+// Pod のうち使う項目
+type kubePod struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Containers []struct {
+			Name string `json:"name"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		ContainerStatuses []kubeContainerStatus `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+type kubeContainerStatus struct {
+	Name  string `json:"name"`
+	State struct {
+		Running *struct {
+			StartedAt string `json:"startedAt"`
+		} `json:"running"`
+		Terminated *struct {
+			StartedAt string `json:"startedAt"`
+		} `json:"terminated"`
+	} `json:"state"`
+}
+
+// コンテナの今の実行を表す値。再起動すると変わり、起動を待っている間は空
+func (s kubeContainerStatus) runID() string {
+	switch {
+	case s.State.Running != nil:
+		return s.State.Running.StartedAt
+	case s.State.Terminated != nil:
+		return s.State.Terminated.StartedAt
+	}
+	return ""
+}
+
+type kubePodList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []kubePod `json:"items"`
+}
+
+type kubeWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// 追いかけているコンテナ 1 つ
+type kubeTail struct {
+	pod       string
+	container string
+	runID     string
+	cancel    context.CancelFunc
+}
+
+func (t *kubeTail) key() string {
+	return t.pod + "/" + t.container
+}
+
+type kubeLogs struct {
+	client    *kubeClient
+	namespace string
+	selector  string
+	container *regexp.Regexp // nil ならすべてのコンテナ
+	lines     int            // 起動時にあった Pod で最初に表示する行数。負なら全部
+	since     time.Duration  // 0 でなければこれより前の行は表示しない
+	retry     time.Duration
+	h         *Highlighter
+
+	writeMu sync.Mutex // 複数のコンテナの行を混ぜずに書く
+	mu      sync.Mutex
+	tails   map[string]*kubeTail
+	wg      sync.WaitGroup
+}
+
+func (k *kubeLogs) podsPath() string {
+	return "/api/v1/namespaces/" + url.PathEscape(k.namespace) + "/pods"
+}
+
+// ctx が終わるまで Pod の一覧と監視を繰り返す。最初の一覧が取れなければエラーを返す。
+func (k *kubeLogs) run(ctx context.Context) error {
+	defer k.stopAll()
+	initial := true
+	for {
+		rv, err := k.list(ctx, initial)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if initial {
+				return err
+			}
+			log.Printf("list pods: %v; retrying in %s", err, k.retry)
+		} else {
+			initial = false
+			err = k.watch(ctx, rv)
+			if ctx.Err() != nil {
+				return nil
+			}
+			// 監視は API サーバーが時々打ち切るので、エラーでなくても一覧から取り直す
+			if err != nil {
+				log.Printf("watch pods: %v; retrying in %s", err, k.retry)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(k.retry):
+		}
+	}
+}
+
+func (k *kubeLogs) list(ctx context.Context, initial bool) (string, error) {
+	query := url.Values{}
+	if k.selector != "" {
+		query.Set("labelSelector", k.selector)
+	}
+	resp, err := k.client.get(ctx, k.podsPath(), query)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var list kubePodList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("kubernetes: invalid pod list: %v", err)
+	}
+	if initial && len(list.Items) == 0 {
+		log.Printf("no pods match %q in namespace %s yet; waiting for new ones", k.selector, k.namespace)
+	}
+	present := map[string]bool{}
+	for _, pod := range list.Items {
+		present[pod.Metadata.Name] = true
+		k.sync(ctx, pod, initial)
+	}
+	// 監視していない間に消えた Pod
+	k.mu.Lock()
+	var gone []string
+	for _, t := range k.tails {
+		if !present[t.pod] {
+			gone = append(gone, t.pod)
+		}
+	}
+	k.mu.Unlock()
+	for _, name := range gone {
+		k.drop(name)
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+func (k *kubeLogs) watch(ctx context.Context, resourceVersion string) error {
+	query := url.Values{"watch": {"1"}, "resourceVersion": {resourceVersion}}
+	if k.selector != "" {
+		query.Set("labelSelector", k.selector)
+	}
+	resp, err := k.client.get(ctx, k.podsPath(), query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev kubeWatchEvent
+		if err := dec.Decode(&ev); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch ev.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			var pod kubePod
+			if err := json.Unmarshal(ev.Object, &pod); err != nil {
+				return fmt.Errorf("kubernetes: invalid pod: %v", err)
+			}
+			if ev.Type == "DELETED" {
+				k.drop(pod.Metadata.Name)
+			} else {
+				k.sync(ctx, pod, false)
+			}
+		case "ERROR":
+			// resourceVersion が古すぎる (410 Gone) など。一覧から取り直す
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(ev.Object, &status)
+			return &kubeAPIError{status: status.Code, message: status.Message}
+		}
+	}
+}
+
+// Pod の状態に合わせて、動き出したコンテナを追いかけ始める
+func (k *kubeLogs) sync(ctx context.Context, pod kubePod, initial bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.tails == nil {
+		k.tails = map[string]*kubeTail{}
+	}
+	for _, c := range pod.Spec.Containers {
+		if k.container != nil && !k.container.MatchString(c.Name) {
+			continue
+		}
+		var runID string
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == c.Name {
+				runID = status.runID()
+			}
+		}
+		if runID == "" {
+			continue
+		}
+		t := &kubeTail{pod: pod.Metadata.Name, container: c.Name, runID: runID}
+		old := k.tails[t.key()]
+		if old != nil && old.runID == runID {
+			continue
+		}
+		if old == nil {
+			log.Printf("+ %s", t.key())
+		} else {
+			// 前の実行のログがエラーで切れて読み直している途中でも、同じログを二重に読まないよう止める
+			old.cancel()
+		}
+		tailCtx, cancel := context.WithCancel(ctx)
+		t.cancel = cancel
+		k.tails[t.key()] = t
+		k.wg.Add(1)
+		// 起動時からある Pod は -tail 行だけ、後から現れた Pod や再起動したコンテナは最初から表示する
+		go k.follow(tailCtx, t, initial && old == nil)
+	}
+}
+
+// 消えた Pod のコンテナを追いかけるのをやめる
+func (k *kubeLogs) drop(pod string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for key, t := range k.tails {
+		if t.pod == pod {
+			t.cancel()
+			delete(k.tails, key)
+			log.Printf("- %s", key)
+		}
+	}
+}
+
+func (k *kubeLogs) stopAll() {
+	k.mu.Lock()
+	for _, t := range k.tails {
+		t.cancel()
+	}
+	k.mu.Unlock()
+	k.wg.Wait()
+}
+
+// コンテナが終わるか ctx が終わるまでログを読む。接続が切れたら続きから読み直す。
+func (k *kubeLogs) follow(ctx context.Context, t *kubeTail, limit bool) {
+	defer k.wg.Done()
+	defer t.cancel()
+	var cursor time.Time // 最後に受け取った行の時刻
+	for {
+		query := url.Values{"container": {t.container}, "follow": {"true"}, "timestamps": {"true"}}
+		switch {
+		case !cursor.IsZero():
+			// sinceTime は秒単位なので、同じ秒の行は手元で除く
+			query.Set("sinceTime", cursor.UTC().Format(time.RFC3339))
+		case k.since > 0:
+			query.Set("sinceSeconds", strconv.Itoa(int((k.since+time.Second-1)/time.Second)))
+		}
+		if limit && cursor.IsZero() && k.lines >= 0 {
+			query.Set("tailLines", strconv.Itoa(k.lines))
+		}
+		err := k.stream(ctx, t, query, &cursor)
+		if ctx.Err() != nil || err == nil {
+			return
+		}
+		var apiErr *kubeAPIError
+		if errors.As(err, &apiErr) && apiErr.status/100 == 4 {
+			log.Printf("%s: %v", t.key(), err)
+			return
+		}
+		log.Printf("logs of %s: %v; retrying in %s", t.key(), err, k.retry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(k.retry):
+		}
+	}
+}
+
+func (k *kubeLogs) stream(ctx context.Context, t *kubeTail, query url.Values, cursor *time.Time) error {
+	resp, err := k.client.get(ctx, k.podsPath()+"/"+url.PathEscape(t.pod)+"/log", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	resume := !cursor.IsZero()
+	r := bufio.NewReader(resp.Body)
+	for {
+		text, err := r.ReadString('\n')
+		if text != "" {
+			at, line := cutTimestampPrefix(strings.TrimSuffix(text, "\n"))
+			if !resume || at.IsZero() || at.After(*cursor) {
+				if at.After(*cursor) {
+					*cursor = at
+				}
+				k.writeMu.Lock()
+				writeLine(k.h, t.key(), line)
+				if r.Buffered() == 0 {
+					output.flush()
+				}
+				k.writeMu.Unlock()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func cmdK8s(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("k8s", flag.ExitOnError)
+	selector := fs.String("l", "", "label selector of the pods to follow (e.g. app=api)")
+	namespace := fs.String("n", "", "namespace (default: the namespace of the kubeconfig context)")
+	kubeconfigFlag := fs.String("kubeconfig", "", "kubeconfig file (default $KUBECONFIG or ~/.kube/config)")
+	contextName := fs.String("context", "", "kubeconfig context to use (default: current-context)")
+	container := fs.String("container", "", "only follow containers whose name matches this regex")
+	tailLines := fs.Int("tail", 10, "show last N lines of each pod running at start (-1 for all); pods that appear later are shown from the start")
+	since := fs.Duration("since", 0, "only show lines newer than this (e.g. 10m)")
+	retry := fs.Duration("retry", 2*time.Second, "wait before listing pods or reading logs again after an error")
+	noPrefix := fs.Bool("no-prefix", false, "do not prefix lines with [pod/container]")
+	pipeline := registerPipelineFlags(fs)
+	// -n は名前空間に使うので、行数は kubectl logs と同じく -tail で指定する
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		log.Fatalf("usage: trail k8s [options] [-l selector] [-n namespace]")
+	}
+	if *tailLines < -1 {
+		log.Fatalf("-tail must be >= -1")
+	}
+	if *since < 0 {
+		log.Fatalf("-since must be >= 0")
+	}
+	if *retry <= 0 {
+		log.Fatalf("-retry must be > 0")
+	}
+	var containerRe *regexp.Regexp
+	if *container != "" {
+		var err error
+		if containerRe, err = regexp.Compile(*container); err != nil {
+			log.Fatalf("invalid -container: %v", err)
+		}
+	}
+	target, err := loadKubeTarget(kubeconfigPaths(*kubeconfigFlag), *contextName, *kubeconfigFlag == "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *namespace != "" {
+		target.namespace = *namespace
+	}
+	client, err := newKubeClient(target)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	label := target.namespace
+	if *selector != "" {
+		label += " " + *selector
+	}
+	highlighter := pipeline.start(opts, label)
+	if !*noPrefix {
+		prefixRules = prefixRules.withSource()
+	}
+
+	logs := &kubeLogs{
+		client:    client,
+		namespace: target.namespace,
+		selector:  *selector,
+		container: containerRe,
+		lines:     *tailLines,
+		since:     *since,
+		retry:     *retry,
+		h:         highlighter,
+	}
+	runSource(highlighter, label, *pipeline.showSummary, logs.run)
+}
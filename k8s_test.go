@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// API サーバーのうち Pod の一覧・監視とログだけを真似る
+type fakeKube struct {
+	mu      sync.Mutex
+	changed chan struct{}
+	pods    map[string]*fakePod
+	events  []map[string]any // 監視で送るイベント。resourceVersion はこの長さ
+	queries []string         // "pods?..." や "api-1/log?..."
+	streams map[string]int   // pod/container ごとの読み出し中のログ
+	last    time.Time
+	// コンテナが再起動したとき、前の実行のログを EOF ではなく接続のエラーで終える
+	abortOnRestart bool
+}
+
+type fakePod struct {
+	name       string
+	labels     map[string]string
+	containers []string
+	started    map[string]time.Time // 空なら起動待ち
+	running    map[string]bool
+	logs       map[string][]fakeKubeLine
+}
+
+type fakeKubeLine struct {
+	at      time.Time
+	started time.Time // どの実行で書かれたか
+	text    string
+}
+
+const fakeKubeToken = "secret-token"
+
+func startFakeKube(t *testing.T) (*fakeKube, *httptest.Server) {
+	t.Helper()
+	k := &fakeKube{changed: make(chan struct{}), pods: map[string]*fakePod{}, streams: map[string]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/namespaces/prod/pods", k.listOrWatch)
+	mux.HandleFunc("GET /api/v1/namespaces/prod/pods/{pod}/log", k.logs)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeKubeToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"kind":"Status","status":"Failure","message":"Unauthorized","code":401}`)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		k.mu.Lock()
+		k.notify()
+		k.mu.Unlock()
+		server.Close()
+	})
+	return k, server
+}
+
+// 呼び出し側でロックしておく
+func (k *fakeKube) notify() {
+	close(k.changed)
+	k.changed = make(chan struct{})
+}
+
+func (k *fakeKube) now() time.Time {
+	now := time.Now()
+	if !now.After(k.last) {
+		now = k.last.Add(time.Nanosecond)
+	}
+	k.last = now
+	return now
+}
+
+func (p *fakePod) object() map[string]any {
+	var containers, statuses []map[string]any
+	for _, name := range p.containers {
+		containers = append(containers, map[string]any{"name": name})
+		state := map[string]any{"waiting": map[string]any{"reason": "ContainerCreating"}}
+		if started, ok := p.started[name]; ok {
+			key := "terminated"
+			if p.running[name] {
+				key = "running"
+			}
+			state = map[string]any{key: map[string]any{"startedAt": started.UTC().Format(time.RFC3339Nano)}}
+		}
+		statuses = append(statuses, map[string]any{"name": name, "state": state})
+	}
+	return map[string]any{
+		"metadata": map[string]any{"name": p.name, "labels": p.labels},
+		"spec":     map[string]any{"containers": containers},
+		"status":   map[string]any{"phase": "Running", "containerStatuses": statuses},
+	}
+}
+
+func (k *fakeKube) event(typ string, p *fakePod) {
+	k.events = append(k.events, map[string]any{"type": typ, "object": p.object()})
+	k.notify()
+}
+
+// コンテナをすべて起動した Pod を作る
+func (k *fakeKube) addPod(name, app string, containers ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	p := &fakePod{
+		name:       name,
+		labels:     map[string]string{"app": app},
+		containers: containers,
+		started:    map[string]time.Time{},
+		running:    map[string]bool{},
+		logs:       map[string][]fakeKubeLine{},
+	}
+	for _, c := range containers {
+		p.started[c] = k.now()
+		p.running[c] = true
+	}
+	k.pods[name] = p
+	k.event("ADDED", p)
+}
+
+func (k *fakeKube) restart(pod, container string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	p := k.pods[pod]
+	p.started[container] = k.now()
+	k.event("MODIFIED", p)
+}
+
+func (k *fakeKube) deletePod(name string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	p := k.pods[name]
+	delete(k.pods, name)
+	k.event("DELETED", p)
+}
+
+func (k *fakeKube) write(pod, container string, texts ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	p := k.pods[pod]
+	for _, text := range texts {
+		p.logs[container] = append(p.logs[container], fakeKubeLine{at: k.now(), started: p.started[container], text: text})
+	}
+	k.notify()
+}
+
+func (k *fakeKube) requests() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]string(nil), k.queries...)
+}
+
+func (k *fakeKube) activeStreams(key string) int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.streams[key]
+}
+
+func (k *fakeKube) listOrWatch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	k.mu.Lock()
+	k.queries = append(k.queries, "pods?"+query.Encode())
+	key, value, _ := strings.Cut(query.Get("labelSelector"), "=")
+	matches := func(p map[string]any) bool {
+		labels := p["metadata"].(map[string]any)["labels"].(map[string]string)
+		return key == "" || labels[key] == value
+	}
+	if query.Get("watch") != "1" {
+		var items []map[string]any
+		for _, p := range k.pods {
+			if obj := p.object(); matches(obj) {
+				items = append(items, obj)
+			}
+		}
+		rv := len(k.events)
+		k.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{
+			"metadata": map[string]any{"resourceVersion": strconv.Itoa(rv)},
+			"items":    items,
+		})
+		return
+	}
+	next, _ := strconv.Atoi(query.Get("resourceVersion"))
+	k.mu.Unlock()
+	enc := json.NewEncoder(w)
+	for {
+		k.mu.Lock()
+		changed := k.changed
+		events := k.events[next:]
+		next = len(k.events)
+		k.mu.Unlock()
+		for _, ev := range events {
+			if matches(ev["object"].(map[string]any)) {
+				enc.Encode(ev)
+			}
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (k *fakeKube) logs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name, container := r.PathValue("pod"), query.Get("container")
+	k.mu.Lock()
+	k.queries = append(k.queries, name+"/log?"+query.Encode())
+	p := k.pods[name]
+	if p == nil {
+		k.mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"kind":"Status","message":"pods %q not found","code":404}`, name)
+		return
+	}
+	started := p.started[container]
+	var lines []fakeKubeLine
+	for _, line := range p.logs[container] {
+		if line.started.Equal(started) {
+			lines = append(lines, line)
+		}
+	}
+	if n, err := strconv.Atoi(query.Get("tailLines")); err == nil && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	next := len(p.logs[container])
+	key := name + "/" + container
+	k.streams[key]++
+	k.mu.Unlock()
+	defer func() {
+		k.mu.Lock()
+		k.streams[key]--
+		k.mu.Unlock()
+	}()
+
+	send := func(lines []fakeKubeLine) {
+		for _, line := range lines {
+			if !line.started.Equal(started) {
+				continue
+			}
+			fmt.Fprintf(w, "%s %s\n", line.at.UTC().Format(time.RFC3339Nano), line.text)
+		}
+		w.(http.Flusher).Flush()
+	}
+	send(lines)
+	for {
+		k.mu.Lock()
+		changed := k.changed
+		// Pod が消えたりコンテナが再起動したりしたら、この実行のログは終わる
+		alive := k.pods[name] == p && p.started[container].Equal(started)
+		abort := k.abortOnRestart && k.pods[name] == p
+		lines := p.logs[container][next:]
+		next = len(p.logs[container])
+		k.mu.Unlock()
+		send(lines)
+		if !alive {
+			if abort {
+				panic(http.ErrAbortHandler)
+			}
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// API サーバーの証明書を信頼する kubeconfig を書く
+func writeTestKubeconfig(t *testing.T, server *httptest.Server, token string) string {
+	t.Helper()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test-cluster
+  cluster:
+    server: %s
+    certificate-authority: ca.crt
+users:
+- name: test-user
+  user:
+    token: %s
+contexts:
+- name: test
+  context:
+    cluster: test-cluster
+    user: test-user
+    namespace: prod
+`, server.URL, token)
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 複数のゴルーチンから書かれるログを受け取る
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func captureTestLog(t *testing.T) *lockedBuffer {
+	t.Helper()
+	buf := &lockedBuffer{}
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	return buf
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func startTestKubeLogs(t *testing.T, server *httptest.Server, logs *kubeLogs) <-chan error {
+	t.Helper()
+	target, err := loadKubeTarget([]string{writeTestKubeconfig(t, server, fakeKubeToken)}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs.client, err = newKubeClient(target); err != nil {
+		t.Fatal(err)
+	}
+	logs.namespace = target.namespace
+	return runTestSource(t, logs.run)
+}
+
+func TestK8sTailsMatchingPodsWithPrefixes(t *testing.T) {
+	withReset(t)
+	captureTestLog(t)
+	wait := memoryOutput(t)
+	prefixRules = prefixRules.withSource()
+	kube, server := startFakeKube(t)
+	kube.addPod("api-1", "api", "app", "proxy")
+	kube.addPod("web-1", "web", "app")
+	kube.write("api-1", "app", "old", "ERROR db timeout", "ready")
+	kube.write("api-1", "proxy", "proxy up")
+	kube.write("web-1", "app", "not selected")
+
+	h := userHighlighter(colorAlways, "red:ERROR")
+	startTestKubeLogs(t, server, &kubeLogs{selector: "app=api", lines: 2, retry: 10 * time.Millisecond, h: h})
+	wait("proxy up")
+	wait("ready")
+	kube.write("api-1", "app", "ERROR again")
+	got := wait("again")
+
+	prefix := func(key string) string { return h.faint("[" + key + "] ") }
+	for _, want := range []string{
+		prefix("api-1/app") + h.Highlight("ERROR db timeout") + "\n",
+		prefix("api-1/app") + "ready\n",
+		prefix("api-1/proxy") + "proxy up\n",
+		prefix("api-1/app") + h.Highlight("ERROR again") + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output = %q, want it to contain %q", got, want)
+		}
+	}
+	for _, unwanted := range []string{"old", "not selected"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("output = %q, want no %q", got, unwanted)
+		}
+	}
+
+	requests := kube.requests()
+	if requests[0] != "pods?labelSelector=app%3Dapi" {
+		t.Errorf("list request = %q", requests[0])
+	}
+	var logRequest string
+	for _, r := range requests {
+		if strings.HasPrefix(r, "api-1/log?container=app&") {
+			logRequest = r
+		}
+	}
+	if q, _ := url.ParseQuery(strings.SplitN(logRequest, "?", 2)[1]); q.Get("tailLines") != "2" || q.Get("follow") != "true" || q.Get("timestamps") != "true" {
+		t.Errorf("log request = %q", logRequest)
+	}
+}
+
+func TestK8sPicksUpNewPodsAndDropsDeletedOnes(t *testing.T) {
+	withReset(t)
+	logBuf := captureTestLog(t)
+	wait := memoryOutput(t)
+	kube, server := startFakeKube(t)
+	kube.addPod("api-1", "api", "app")
+	kube.write("api-1", "app", "one: 1", "one: 2")
+
+	logs := &kubeLogs{selector: "app=api", lines: 1, retry: 10 * time.Millisecond}
+	startTestKubeLogs(t, server, logs)
+	wait("one: 2\n")
+
+	// 後から現れた Pod は最初から表示する
+	kube.addPod("api-2", "api", "app")
+	kube.write("api-2", "app", "two: 1", "two: 2")
+	wait("two: 2\n")
+
+	// 再起動したコンテナは新しい実行のログを最初から表示する
+	kube.restart("api-2", "app")
+	kube.write("api-2", "app", "two restarted")
+	wait("two restarted\n")
+
+	kube.deletePod("api-1")
+	waitFor(t, "the log stream of api-1 to close", func() bool { return kube.activeStreams("api-1/app") == 0 })
+	waitFor(t, "api-1 to be dropped", func() bool { return strings.Contains(logBuf.String(), "- api-1/app\n") })
+	got := wait("two restarted\n")
+	if got != "one: 2\ntwo: 1\ntwo: 2\ntwo restarted\n" {
+		t.Errorf("output = %q", got)
+	}
+	if log := logBuf.String(); !strings.Contains(log, "+ api-1/app\n+ api-2/app\n") {
+		t.Errorf("log = %q", log)
+	}
+}
+
+func TestK8sRestartStopsOldStreamThatFailed(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	wait := memoryOutput(t)
+	kube, server := startFakeKube(t)
+	kube.abortOnRestart = true
+	kube.addPod("api-1", "api", "app")
+	kube.write("api-1", "app", "first run")
+
+	startTestKubeLogs(t, server, &kubeLogs{selector: "app=api", lines: -1, retry: 10 * time.Millisecond})
+	wait("first run\n")
+
+	// 前の実行のログはエラーで切れる。読み直さずに新しい実行のログだけを読む
+	kube.restart("api-1", "app")
+	kube.write("api-1", "app", "second run")
+	wait("second run\n")
+	time.Sleep(100 * time.Millisecond)
+	kube.write("api-1", "app", "still second run")
+	if got := wait("still second run\n"); got != "first run\nsecond run\nstill second run\n" {
+		t.Errorf("output = %q", got)
+	}
+	if n := kube.activeStreams("api-1/app"); n != 1 {
+		t.Errorf("active log streams = %d, want 1", n)
+	}
+}
+
+func TestK8sReportsAPIErrors(t *testing.T) {
+	withReset(t)
+	_, server := startFakeKube(t)
+	target, err := loadKubeTarget([]string{writeTestKubeconfig(t, server, "wrong")}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newKubeClient(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := &kubeLogs{client: client, namespace: "prod", retry: 10 * time.Millisecond}
+	if err := logs.run(context.Background()); err == nil || err.Error() != "kubernetes: Unauthorized" {
+		t.Fatalf("run = %v", err)
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ---------- Kubernetes API への接続 ----------
+
+// kubectl と同じ kubeconfig を読んで API サーバーに接続する。
+// client-go は大きいので、trail が使う分 (Pod の一覧と監視、ログ) だけを自前で扱う。
+
+// kubeconfig のうち使う項目
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string      `yaml:"name"`
+		Cluster kubeCluster `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string   `yaml:"name"`
+		User kubeUser `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string      `yaml:"name"`
+		Context kubeContext `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+type kubeCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+	TLSServerName            string `yaml:"tls-server-name"`
+}
+
+type kubeUser struct {
+	Token                 string    `yaml:"token"`
+	TokenFile             string    `yaml:"tokenFile"`
+	ClientCertificate     string    `yaml:"client-certificate"`
+	ClientCertificateData string    `yaml:"client-certificate-data"`
+	ClientKey             string    `yaml:"client-key"`
+	ClientKeyData         string    `yaml:"client-key-data"`
+	Username              string    `yaml:"username"`
+	Password              string    `yaml:"password"`
+	Exec                  *kubeExec `yaml:"exec"`
+}
+
+// 認証情報を返すコマンド (aws eks get-token など)
+type kubeExec struct {
+	Command    string        `yaml:"command"`
+	Args       []string      `yaml:"args"`
+	APIVersion string        `yaml:"apiVersion"`
+	Env        []kubeExecEnv `yaml:"env"`
+}
+
+type kubeExecEnv struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type kubeContext struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace"`
+}
+
+// 接続に必要なものをそろえた設定
+type kubeTarget struct {
+	cluster   kubeCluster
+	user      kubeUser
+	namespace string
+}
+
+const inClusterDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// -kubeconfig、$KUBECONFIG、~/.kube/config の順に探す。$KUBECONFIG の複数のファイルは kubectl と同じく
+// 先に書かれたものを優先してまとめる。
+func kubeconfigPaths(flagValue string) []string {
+	if flagValue != "" {
+		return []string{flagValue}
+	}
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		var paths []string
+		for _, path := range filepath.SplitList(env) {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+		return paths
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return []string{filepath.Join(home, ".kube", "config")}
+	}
+	return nil
+}
+
+// inCluster なら kubeconfig がないときに Pod のサービスアカウントを使う
+func loadKubeTarget(paths []string, contextName string, inCluster bool) (kubeTarget, error) {
+	var merged kubeconfig
+	found := false
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return kubeTarget{}, err
+		}
+		var config kubeconfig
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return kubeTarget{}, fmt.Errorf("%s: %v", path, err)
+		}
+		resolveKubePaths(&config, filepath.Dir(path))
+		mergeKubeconfig(&merged, config)
+		found = true
+	}
+	if !found {
+		if inCluster && contextName == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			return inClusterTarget()
+		}
+		return kubeTarget{}, fmt.Errorf("no kubeconfig found (tried %s)", strings.Join(paths, ", "))
+	}
+
+	if contextName == "" {
+		contextName = merged.CurrentContext
+	}
+	if contextName == "" {
+		return kubeTarget{}, fmt.Errorf("kubeconfig has no current-context; use -context")
+	}
+	var target kubeTarget
+	var ctxFound, clusterFound, userFound bool
+	var kctx kubeContext
+	for _, c := range merged.Contexts {
+		if c.Name == contextName {
+			kctx, ctxFound = c.Context, true
+			break
+		}
+	}
+	if !ctxFound {
+		return kubeTarget{}, fmt.Errorf("context %q not found in kubeconfig", contextName)
+	}
+	for _, c := range merged.Clusters {
+		if c.Name == kctx.Cluster {
+			target.cluster, clusterFound = c.Cluster, true
+			break
+		}
+	}
+	if !clusterFound {
+		return kubeTarget{}, fmt.Errorf("cluster %q of context %q not found in kubeconfig", kctx.Cluster, contextName)
+	}
+	for _, u := range merged.Users {
+		if u.Name == kctx.User {
+			target.user, userFound = u.User, true
+			break
+		}
+	}
+	if !userFound && kctx.User != "" {
+		return kubeTarget{}, fmt.Errorf("user %q of context %q not found in kubeconfig", kctx.User, contextName)
+	}
+	target.namespace = kctx.Namespace
+	if target.namespace == "" {
+		target.namespace = "default"
+	}
+	return target, nil
+}
+
+// 同じ名前があれば先に読んだものを使う
+func mergeKubeconfig(dst *kubeconfig, src kubeconfig) {
+	if dst.CurrentContext == "" {
+		dst.CurrentContext = src.CurrentContext
+	}
+	names := map[string]bool{}
+	for _, c := range dst.Clusters {
+		names["cluster:"+c.Name] = true
+	}
+	for _, u := range dst.Users {
+		names["user:"+u.Name] = true
+	}
+	for _, c := range dst.Contexts {
+		names["context:"+c.Name] = true
+	}
+	for _, c := range src.Clusters {
+		if !names["cluster:"+c.Name] {
+			dst.Clusters = append(dst.Clusters, c)
+		}
+	}
+	for _, u := range src.Users {
+		if !names["user:"+u.Name] {
+			dst.Users = append(dst.Users, u)
+		}
+	}
+	for _, c := range src.Contexts {
+		if !names["context:"+c.Name] {
+			dst.Contexts = append(dst.Contexts, c)
+		}
+	}
+}
+
+// kubeconfig の中の相対パスはそのファイルのディレクトリからのパス
+func resolveKubePaths(config *kubeconfig, dir string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	for i := range config.Clusters {
+		resolve(&config.Clusters[i].Cluster.CertificateAuthority)
+	}
+	for i := range config.Users {
+		user := &config.Users[i].User
+		resolve(&user.TokenFile)
+		resolve(&user.ClientCertificate)
+		resolve(&user.ClientKey)
+		// コマンドはパス区切りを含むときだけ相対パスとみなす
+		if user.Exec != nil && strings.ContainsRune(user.Exec.Command, filepath.Separator) {
+			resolve(&user.Exec.Command)
+		}
+	}
+}
+
+func inClusterTarget() (kubeTarget, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if port == "" {
+		port = "443"
+	}
+	namespace, err := os.ReadFile(filepath.Join(inClusterDir, "namespace"))
+	if err != nil {
+		return kubeTarget{}, fmt.Errorf("no kubeconfig and no service account: %v", err)
+	}
+	return kubeTarget{
+		cluster: kubeCluster{
+			Server:               "https://" + net.JoinHostPort(host, port),
+			CertificateAuthority: filepath.Join(inClusterDir, "ca.crt"),
+		},
+		user:      kubeUser{TokenFile: filepath.Join(inClusterDir, "token")},
+		namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// API サーバーのクライアント
+type kubeClient struct {
+	server string
+	user   kubeUser
+	http   *http.Client
+
+	mu          sync.Mutex
+	execToken   string    // exec で得たトークン
+	execExpires time.Time // ゼロ値なら期限なし
+}
+
+func newKubeClient(target kubeTarget) (*kubeClient, error) {
+	cluster := target.cluster
+	if cluster.Server == "" {
+		return nil, fmt.Errorf("kubeconfig cluster has no server")
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cluster.InsecureSkipTLSVerify,
+		ServerName:         cluster.TLSServerName,
+	}
+	ca, err := kubeData(cluster.CertificateAuthorityData, cluster.CertificateAuthority)
+	if err != nil {
+		return nil, fmt.Errorf("certificate-authority: %v", err)
+	}
+	if ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("certificate-authority: no certificates found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	c := &kubeClient{server: strings.TrimSuffix(cluster.Server, "/"), user: target.user}
+	if err := c.loadClientCertificate(tlsConfig); err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	c.http = &http.Client{Transport: transport}
+	return c, nil
+}
+
+// -data (base64) があればそれを、なければファイルを読む
+func kubeData(data, path string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if path != "" {
+		return os.ReadFile(path)
+	}
+	return nil, nil
+}
+
+func (c *kubeClient) loadClientCertificate(tlsConfig *tls.Config) error {
+	cert, err := kubeData(c.user.ClientCertificateData, c.user.ClientCertificate)
+	if err != nil {
+		return fmt.Errorf("client-certificate: %v", err)
+	}
+	key, err := kubeData(c.user.ClientKeyData, c.user.ClientKey)
+	if err != nil {
+		return fmt.Errorf("client-key: %v", err)
+	}
+	if cert == nil && key == nil {
+		return nil
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return fmt.Errorf("client certificate: %v", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{pair}
+	return nil
+}
+
+// exec の結果 (client.authentication.k8s.io の ExecCredential)
+type execCredential struct {
+	Status struct {
+		Token               string `json:"token"`
+		ExpirationTimestamp string `json:"expirationTimestamp"`
+	} `json:"status"`
+}
+
+// リクエストに付けるトークン。tokenFile は更新されることがあるので毎回読む
+func (c *kubeClient) bearerToken(ctx context.Context) (string, error) {
+	switch {
+	case c.user.Token != "":
+		return c.user.Token, nil
+	case c.user.TokenFile != "":
+		data, err := os.ReadFile(c.user.TokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case c.user.Exec != nil:
+		return c.runExec(ctx)
+	}
+	return "", nil
+}
+
+func (c *kubeClient) runExec(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.execToken != "" && (c.execExpires.IsZero() || time.Until(c.execExpires) > time.Minute) {
+		return c.execToken, nil
+	}
+	e := c.user.Exec
+	apiVersion := e.APIVersion
+	if apiVersion == "" {
+		apiVersion = "client.authentication.k8s.io/v1"
+	}
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range e.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	cmd.Env = append(cmd.Env, `KUBERNETES_EXEC_INFO={"apiVersion":"`+apiVersion+`","kind":"ExecCredential","spec":{"interactive":false}}`)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("exec %s: %v: %s", e.Command, err, msg)
+		}
+		return "", fmt.Errorf("exec %s: %v", e.Command, err)
+	}
+	var cred execCredential
+	if err := json.Unmarshal(out, &cred); err != nil {
+		return "", fmt.Errorf("exec %s: invalid ExecCredential: %v", e.Command, err)
+	}
+	if cred.Status.Token == "" {
+		return "", fmt.Errorf("exec %s: ExecCredential has no token (client certificates from exec are not supported)", e.Command)
+	}
+	c.execToken = cred.Status.Token
+	c.execExpires = time.Time{}
+	if cred.Status.ExpirationTimestamp != "" {
+		if t, err := time.Parse(time.RFC3339, cred.Status.ExpirationTimestamp); err == nil {
+			c.execExpires = t
+		}
+	}
+	return c.execToken, nil
+}
+
+// API サーバーが返したエラー (Status)
+type kubeAPIError struct {
+	status  int
+	message string
+}
+
+func (e *kubeAPIError) Error() string {
+	return "kubernetes: " + e.message
+}
+
+func (c *kubeClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.bearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.user.Username != "" {
+		req.SetBasicAuth(c.user.Username, c.user.Password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && c.user.Exec != nil {
+			// 期限前に失効したトークンは次のリクエストで取り直す
+			c.mu.Lock()
+			c.execToken = ""
+			c.mu.Unlock()
+		}
+		var status struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(data))
+			if status.Message == "" {
+				status.Message = resp.Status
+			}
+		}
+		return nil, &kubeAPIError{status: resp.StatusCode, message: status.Message}
+	}
+	return resp, nil
+}
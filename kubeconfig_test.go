@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeKubeconfigFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKubeTargetMergesFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := writeKubeconfigFile(t, dir, "first", `current-context: dev
+contexts:
+- name: dev
+  context: {cluster: dev, user: dev}
+users:
+- name: dev
+  user: {tokenFile: token}
+`)
+	second := writeKubeconfigFile(t, t.TempDir(), "second", `current-context: prod
+clusters:
+- name: dev
+  cluster: {server: "https://dev.example:6443", certificate-authority: certs/ca.crt}
+- name: prod
+  cluster: {server: "https://prod.example", insecure-skip-tls-verify: true}
+contexts:
+- name: dev
+  context: {cluster: prod, user: nobody}
+- name: prod
+  context: {cluster: prod, user: dev, namespace: payments}
+users:
+- name: dev
+  user: {token: ignored}
+`)
+	missing := filepath.Join(dir, "missing")
+
+	// 先に書かれたファイルの current-context と同名の項目を使い、相対パスはそれぞれのファイルから解決する
+	target, err := loadKubeTarget([]string{missing, first, second}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.cluster.Server != "https://dev.example:6443" || target.namespace != "default" {
+		t.Errorf("target = %+v", target)
+	}
+	if want := filepath.Join(filepath.Dir(second), "certs", "ca.crt"); target.cluster.CertificateAuthority != want {
+		t.Errorf("certificate-authority = %q, want %q", target.cluster.CertificateAuthority, want)
+	}
+	if want := filepath.Join(dir, "token"); target.user.TokenFile != want || target.user.Token != "" {
+		t.Errorf("user = %+v, want tokenFile %q", target.user, want)
+	}
+
+	target, err = loadKubeTarget([]string{first, second}, "prod", false)
+	if err != nil || target.namespace != "payments" || !target.cluster.InsecureSkipTLSVerify {
+		t.Errorf("context prod: %+v, %v", target, err)
+	}
+
+	for _, tt := range []struct {
+		paths   []string
+		context string
+		want    string
+	}{
+		{[]string{missing}, "", "no kubeconfig found (tried " + missing + ")"},
+		{[]string{first, second}, "staging", `context "staging" not found in kubeconfig`},
+		{[]string{first}, "", `cluster "dev" of context "dev" not found in kubeconfig`},
+	} {
+		if _, err := loadKubeTarget(tt.paths, tt.context, false); err == nil || err.Error() != tt.want {
+			t.Errorf("loadKubeTarget(%v, %q) = %v, want %q", tt.paths, tt.context, err, tt.want)
+		}
+	}
+}
+
+func TestKubeClientTokens(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := writeKubeconfigFile(t, dir, "token", "from-file\n")
+	c := &kubeClient{user: kubeUser{TokenFile: tokenFile}}
+	if token, err := c.bearerToken(context.Background()); err != nil || token != "from-file" {
+		t.Errorf("tokenFile: %q, %v", token, err)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("exec credentials are tested with sh")
+	}
+	// exec のコマンドは期限が近づくまで実行し直さない
+	counter := filepath.Join(dir, "count")
+	script := writeKubeconfigFile(t, dir, "cred.sh", `echo run >> "`+counter+`"
+echo "$KUBERNETES_EXEC_INFO" | grep -q '"interactive":false' || exit 3
+printf '{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","status":{"token":"%s","expirationTimestamp":"%s"}}' "$TOKEN" "$EXPIRES"
+`)
+	exec := &kubeExec{Command: "sh", Args: []string{script}, Env: []kubeExecEnv{
+		{Name: "TOKEN", Value: "from-exec"},
+		{Name: "EXPIRES", Value: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+	}}
+	c = &kubeClient{user: kubeUser{Exec: exec}}
+	for range 2 {
+		if token, err := c.bearerToken(context.Background()); err != nil || token != "from-exec" {
+			t.Fatalf("exec: %q, %v", token, err)
+		}
+	}
+	if data, _ := os.ReadFile(counter); strings.Count(string(data), "run") != 1 {
+		t.Errorf("exec ran %d times, want 1", strings.Count(string(data), "run"))
+	}
+
+	c = &kubeClient{user: kubeUser{Exec: &kubeExec{Command: "sh", Args: []string{"-c", "echo expired >&2; exit 1"}}}}
+	if _, err := c.bearerToken(context.Background()); err == nil || err.Error() != "exec sh: exit status 1: expired" {
+		t.Errorf("failing exec = %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ---------- ログレベルの判定・色付け・絞り込み ----------
+
+type severity int
+
+const (
+	severityUnknown severity = iota
+	severityTrace
+	severityDebug
+	severityInfo
+	severityWarn
+	severityError
+	severityFatal
+)
+
+type severityRule struct {
+	level  severity
+	name   string
+	style  string
+	words  []string // 小文字で書く。大文字の単語として単独でも判定する
+	letter string   // [W] のような 1 文字の表記
+	syslog []int    // syslog の重大度 (PRI % 8)
+}
+
+var severityRules = []severityRule{
+	{severityTrace, "trace", "dim", []string{"trace", "trc"}, "T", nil},
+	{severityDebug, "debug", "cyan", []string{"debug", "dbg"}, "D", []int{7}},
+	{severityInfo, "info", "green", []string{"info", "inf", "notice"}, "I", []int{5, 6}},
+	{severityWarn, "warn", "yellow", []string{"warn", "warning", "wrn"}, "W", []int{4}},
+	{severityError, "error", "red", []string{"error", "err"}, "E", []int{3}},
+	{severityFatal, "fatal", "bold+red", []string{"fatal", "critical", "crit", "panic", "alert", "emerg", "emergency"}, "F", []int{0, 1, 2}},
+}
+
+// syslog の重大度 (journald の PRIORITY) ごとの表記。どれも severityRules の単語として判定される
+var syslogSeverityNames = [8]string{"EMERG", "ALERT", "CRIT", "ERR", "WARNING", "NOTICE", "INFO", "DEBUG"}
+
+func (s severity) String() string {
+	for _, rule := range severityRules {
+		if rule.level == s {
+			return rule.name
+		}
+	}
+	return "unknown"
+}
+
+func parseSeverity(name string) (severity, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, rule := range severityRules {
+		if name == rule.name {
+			return rule.level, nil
+		}
+		for _, word := range rule.words {
+			if name == word {
+				return rule.level, nil
+			}
+		}
+	}
+	return severityUnknown, fmt.Errorf("invalid level %q (expected trace, debug, info, warn, error, fatal)", name)
+}
+
+// レベルごとの正規表現。レベルを表す部分だけをキャプチャする。
+//   - JSON: "level": "warn" (severity, lvl も可)
+//   - logfmt: level=warn
+//   - syslog: 行頭の <4>
+//   - 括弧: [W]
+//   - 単語: WARN (大文字のみ。文中の "error" などは対象にしない)
+func severityRegex(rule severityRule) *regexp.Regexp {
+	words := strings.Join(rule.words, "|")
+	upper := strings.ToUpper(words)
+	alternatives := []string{
+		`(?i:"(?:level|severity|lvl)"\s*:\s*"(` + words + `)")`,
+		`(?i:\b(?:level|severity|lvl)="?(` + words + `)\b)`,
+		`(\[` + rule.letter + `\])`,
+		`\b(` + upper + `)\b`,
+	}
+	if len(rule.syslog) > 0 {
+		var pris []string
+		for pri := 0; pri < 192; pri++ {
+			for _, sev := range rule.syslog {
+				if pri%8 == sev {
+					pris = append(pris, strconv.Itoa(pri))
+				}
+			}
+		}
+		alternatives = append(alternatives, `^(<(?:`+strings.Join(pris, "|")+`)>)`)
+	}
+	return regexp.MustCompile(strings.Join(alternatives, "|"))
+}
+
+var severityRegexes = func() []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(severityRules))
+	for i, rule := range severityRules {
+		res[i] = severityRegex(rule)
+	}
+	return res
+}()
+
+// 行のレベルを判定する。複数ある場合は最も左にあるものを使う。
+func detectSeverity(text string) severity {
+	found, start := severityUnknown, len(text)+1
+	for i, re := range severityRegexes {
+		if loc := re.FindStringIndex(text); loc != nil && loc[0] < start {
+			found, start = severityRules[i].level, loc[0]
+		}
+	}
+	return found
+}
+
+const (
+	autoHighlightOrderBase = -2000
+	levelOrderBase         = -1000
+)
+
+// レベル表記の色付けを加える。-c のパターンより下、--auto-highlight より上に置く。
+func (h *Highlighter) enableLevelColors() {
+	defer h.rebuild()
+	h.levels = make([]ColorPattern, 0, len(severityRules))
+	for i, rule := range severityRules {
+		c, ok := h.styleColor(rule.style)
+		if !ok {
+			log.Fatalf("invalid level style '%s'", rule.style)
+		}
+		h.levels = append(h.levels, ColorPattern{
+			Pattern: severityRegexes[i],
+			Color:   c,
+			Style:   rule.style,
+			Order:   levelOrderBase + i,
+			Spec:    "level:" + rule.name,
+			Scope:   scopeGroup,
+		})
+	}
+}
+
+type levelFlags struct {
+	minLevel *string
+}
+
+func registerLevelFlags(fs *flag.FlagSet) levelFlags {
+	return levelFlags{
+		minLevel: fs.String("min-level", "", "only show lines at or above this level (trace, debug, info, warn, error, fatal)"),
+	}
+}
+
+// レベルによる絞り込み
+type levelFilter struct {
+	min severity
+
+	mu sync.Mutex
+	// ファイルごとの直前の行のレベル。スタックトレースなどレベルの無い行に引き継ぐ。
+	last map[string]severity
+}
+
+var levelRules *levelFilter
+
+func (f levelFlags) build() (*levelFilter, error) {
+	if *f.minLevel == "" {
+		return nil, nil
+	}
+	min, err := parseSeverity(*f.minLevel)
+	if err != nil {
+		return nil, fmt.Errorf("-min-level: %v", err)
+	}
+	return newLevelFilter(min), nil
+}
+
+func newLevelFilter(min severity) *levelFilter {
+	return &levelFilter{min: min, last: make(map[string]severity)}
+}
+
+// 行を表示するか判定する。レベルの無い行は同じファイルの直前の行のレベルを使う。
+func (f *levelFilter) allow(source, text string) bool {
+	if f == nil {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	level := detectSeverity(text)
+	if level == severityUnknown {
+		level = f.last[source]
+	} else {
+		f.last[source] = level
+	}
+	return level >= f.min
+}
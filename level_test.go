@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectSeverity(t *testing.T) {
+	tests := []struct {
+		text string
+		want severity
+	}{
+		{"2024-05-01 12:00:00 ERROR connection refused", severityError},
+		{"12:00:00 [W] disk almost full", severityWarn},
+		{"ts=2024-05-01 level=warn msg=slow", severityWarn},
+		{`time=1 level="DEBUG" msg=x`, severityDebug},
+		{"<3>Jan  1 00:00:00 host app: failed", severityError},
+		{"<134>Jan  1 00:00:00 host app: started", severityInfo},
+		{"<12>warning from local1", severityWarn},
+		{`{"ts":1,"level":"error","msg":"boom"}`, severityError},
+		{`{"severity": "Warning", "msg": "x"}`, severityWarn},
+		{"panic: runtime error", severityUnknown},
+		{"PANIC: runtime error", severityFatal},
+		{"INFO retry after ERROR", severityInfo},
+		{"an error occurred in info", severityUnknown},
+		{"ERROR_CODE=5", severityUnknown},
+		{"    at com.example.Main.run(Main.java:10)", severityUnknown},
+	}
+	for _, tt := range tests {
+		if got := detectSeverity(tt.text); got != tt.want {
+			t.Errorf("detectSeverity(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for name, want := range map[string]severity{"warn": severityWarn, "WARNING": severityWarn, "err": severityError, "fatal": severityFatal} {
+		if got, err := parseSeverity(name); err != nil || got != want {
+			t.Errorf("parseSeverity(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := parseSeverity("loud"); err == nil {
+		t.Fatal("parseSeverity(loud) should fail")
+	}
+}
+
+func TestLevelFilterKeepsContinuationLines(t *testing.T) {
+	f := newLevelFilter(severityWarn)
+	lines := []struct {
+		source string
+		text   string
+		want   bool
+	}{
+		{"a.log", "stray line before any level", false},
+		{"a.log", "INFO started", false},
+		{"a.log", "ERROR failed", true},
+		{"a.log", "    at Main.run(Main.java:10)", true},
+		{"b.log", "    at other", false},
+		{"a.log", "DEBUG detail", false},
+		{"a.log", "    more detail", false},
+	}
+	for _, line := range lines {
+		if got := f.allow(line.source, line.text); got != line.want {
+			t.Errorf("allow(%q, %q) = %v, want %v", line.source, line.text, got, line.want)
+		}
+	}
+}
+
+func TestLevelColorsBelowUserPatterns(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm")
+	t.Setenv("COLORTERM", "")
+
+	h := buildHighlighter(globalOptions{colorMode: colorAlways}, nil)
+	if got := h.Highlight("12:00 WARN low disk level=error"); got != "12:00 "+ansi("33", "WARN")+" low disk level="+ansi("31", "error") {
+		t.Fatalf("Highlight = %q", got)
+	}
+
+	h = buildHighlighter(globalOptions{colorMode: colorAlways}, []string{"magenta:WARN low"})
+	if got := h.Highlight("WARN low disk"); got != ansi("35", "WARN low")+" disk" {
+		t.Fatalf("Highlight with -c = %q", got)
+	}
+}
+
+func TestNoLevelColors(t *testing.T) {
+	withReset(t)
+
+	h := buildHighlighter(globalOptions{colorMode: colorAlways, noLevelColors: true}, nil)
+	if got := h.Highlight("ERROR boom"); got != "ERROR boom" {
+		t.Fatalf("Highlight = %q", got)
+	}
+}
+
+func TestFollowLastLinesRespectsMinLevel(t *testing.T) {
+	withReset(t)
+	levelRules = newLevelFilter(severityWarn)
+	path := filepath.Join(t.TempDir(), "app.log")
+	content := strings.Join([]string{
+		"ERROR one",
+		"INFO two",
+		"WARN three",
+		"  detail of three",
+		"DEBUG four",
+		"INFO five",
+	}, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := memoryOutput(t)
+	startTestFollower(t, nil, path, 2)
+	if out := wait("detail of three\n"); out != "WARN three\n  detail of three\n" {
+		t.Fatalf("output = %q", out)
+	}
+}
@@ -1,22 +1,21 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"regexp"
 	"runtime"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
-	"github.com/fsnotify/fsnotify"
-	"github.com/nxadm/tail"
+	"github.com/yutat23/trail/pkg/trail"
+	"golang.org/x/term"
 )
 
 // ---------- 色付き表示のための構造体 ----------
@@ -25,10 +24,12 @@ type ColorPattern struct {
 	Pattern *regexp.Regexp
 	Color   *color.Color
 	Order   int
+	Spec    string
+	Scope   patternScope
+	Palette []*color.Color // hash 指定時、一致した文字列ごとにここから色を選ぶ
+	Style   string         // 範囲と hash を除いたスタイル指定 (HTML への書き出し用)
 }
 
-var colorPatterns []ColorPattern
-
 type colorMode string
 
 const (
@@ -37,29 +38,16 @@ const (
 	colorNever  colorMode = "never"
 )
 
-var selectedColorMode = colorAuto
-
 // バージョン情報
 const version = "0.1.4"
 
-func newColor(attrs ...color.Attribute) *color.Color {
-	c := color.New(attrs...)
-	switch selectedColorMode {
-	case colorAlways:
-		c.EnableColor()
-	case colorNever:
-		c.DisableColor()
-	}
-	return c
-}
-
-// 色名をcolor.Colorに変換
-func getColor(colorName string) (*color.Color, bool) {
-	attrs, ok := colorAttributes(colorName)
-	if !ok {
+// 色名やスタイル指定 (bold+red, bg:yellow, fg:#ff8800 など) をcolor.Colorに変換
+func (h *Highlighter) styleColor(colorName string) (*color.Color, bool) {
+	attrs, err := styleAttributes(colorName, h.level)
+	if err != nil {
 		return nil, false
 	}
-	return newColor(attrs...), true
+	return h.newColor(attrs...), true
 }
 
 func colorAttributes(colorName string) ([]color.Attribute, bool) {
@@ -99,82 +87,89 @@ func colorAttributes(colorName string) ([]color.Attribute, bool) {
 	}
 }
 
-// 文字列に色付きパターンを適用
-func applyColorPatterns(text string) string {
-	if len(colorPatterns) == 0 {
+// パターンごとのマッチ数を集計に反映する
+func recordPatternMatches(order, n int) {
+	summary.recordMatches(order, n)
+	stats.recordMatches(order, n)
+}
+
+// 指定したパターンで色付けする。record が nil でなければマッチ数を通知する。
+func colorize(text string, patterns []ColorPattern, record func(order, n int)) string {
+	if len(patterns) == 0 {
 		return text
 	}
+	return matcherFor(patterns).colorize(text, record)
+}
 
-	type colorMatch struct {
-		start int
-		end   int
-		color *color.Color
-		order int
+// 範囲に付ける色
+func (p ColorPattern) colorFor(matched string) *color.Color {
+	if len(p.Palette) > 0 {
+		return pickHashColor(p.Palette, matched)
 	}
+	return p.Color
+}
+
+// 色を付ける範囲とマッチ数を返す。長さ 0 の範囲は含めない。
+func (p ColorPattern) spans(text string) ([][2]int, int) {
+	return p.appendSpans(nil, text, colorGroups(p.Pattern))
+}
 
-	var allMatches []colorMatch
-	for _, pattern := range colorPatterns {
-		matches := pattern.Pattern.FindAllStringIndex(text, -1)
+// spans と同じ範囲を dst に追加する。groups は group 指定時に色付けするグループ番号。
+func (p ColorPattern) appendSpans(spans [][2]int, text string, groups []int) ([][2]int, int) {
+	switch p.Scope {
+	case scopeLine:
+		if !p.Pattern.MatchString(text) {
+			return spans, 0
+		}
+		if text != "" {
+			spans = append(spans, [2]int{0, len(text)})
+		}
+		return spans, 1
+	case scopeGroup:
+		matches := p.Pattern.FindAllStringSubmatchIndex(text, -1)
 		for _, match := range matches {
-			if match[0] == match[1] {
-				continue
+			for _, g := range groups {
+				start, end := match[2*g], match[2*g+1]
+				if start >= 0 && start != end {
+					spans = append(spans, [2]int{start, end})
+				}
 			}
-			allMatches = append(allMatches, colorMatch{
-				start: match[0],
-				end:   match[1],
-				color: pattern.Color,
-				order: pattern.Order,
-			})
 		}
-	}
-	if len(allMatches) == 0 {
-		return text
+		return spans, len(matches)
 	}
 
-	// 重複する場合は、後から指定されたパターンを優先する。
-	sort.SliceStable(allMatches, func(i, j int) bool {
-		if allMatches[i].order != allMatches[j].order {
-			return allMatches[i].order > allMatches[j].order
-		}
-		if allMatches[i].end-allMatches[i].start != allMatches[j].end-allMatches[j].start {
-			return allMatches[i].end-allMatches[i].start > allMatches[j].end-allMatches[j].start
+	found := 0
+	for _, match := range p.Pattern.FindAllStringIndex(text, -1) {
+		if match[0] == match[1] {
+			continue
 		}
-		return allMatches[i].start < allMatches[j].start
-	})
-
-	var finalMatches []colorMatch
-	for _, match := range allMatches {
-		overlaps := false
-		for _, existing := range finalMatches {
-			if (match.start >= existing.start && match.start < existing.end) ||
-				(match.end > existing.start && match.end <= existing.end) ||
-				(match.start <= existing.start && match.end >= existing.end) {
-				overlaps = true
-				break
-			}
+		spans = append(spans, [2]int{match[0], match[1]})
+		found++
+	}
+	return spans, found
+}
+
+// 色付けするグループ番号。名前付きグループがあればそれだけ、無ければすべてのグループ。
+func colorGroups(re *regexp.Regexp) []int {
+	var named, all []int
+	for i, name := range re.SubexpNames() {
+		if i == 0 {
+			continue
 		}
-		if !overlaps {
-			finalMatches = append(finalMatches, match)
+		all = append(all, i)
+		if name != "" {
+			named = append(named, i)
 		}
 	}
-	sort.Slice(finalMatches, func(i, j int) bool {
-		return finalMatches[i].start < finalMatches[j].start
-	})
-
-	var result strings.Builder
-	lastEnd := 0
-	for _, match := range finalMatches {
-		result.WriteString(text[lastEnd:match.start])
-		result.WriteString(match.color.Sprint(text[match.start:match.end]))
-		lastEnd = match.end
+	if len(named) > 0 {
+		return named
 	}
-	result.WriteString(text[lastEnd:])
-
-	return result.String()
+	return all
 }
 
-// 色付きパターンを解析
-func parseColorPatterns(colorOpts []string) {
+// 色付きパターンを解析して加える
+func (h *Highlighter) addColorPatterns(colorOpts []string) {
+	defer h.rebuild()
 	for _, colorOpt := range colorOpts {
 		patterns := splitColorPatterns(colorOpt)
 		for _, pattern := range patterns {
@@ -183,14 +178,14 @@ func parseColorPatterns(colorOpts []string) {
 				continue
 			}
 
-			parts := strings.SplitN(pattern, ":", 2)
-			if len(parts) != 2 {
+			style, regexPart, ok := splitStyleSpec(pattern)
+			if !ok {
 				log.Printf("invalid color pattern format: %s (expected 'color:regex')", pattern)
 				continue
 			}
 
-			colorName := strings.TrimSpace(parts[0])
-			regexStr := strings.TrimSpace(parts[1])
+			colorName, scope := splitScope(strings.TrimSpace(style))
+			regexStr := strings.TrimSpace(regexPart)
 			if regexStr == "" {
 				log.Printf("empty regex pattern in: %s", pattern)
 				continue
@@ -202,16 +197,41 @@ func parseColorPatterns(colorOpts []string) {
 				continue
 			}
 
-			colorValue, ok := getColor(colorName)
-			if !ok {
-				log.Printf("invalid color name '%s'", colorName)
-				continue
+			colorName, hash := splitHash(colorName)
+			var palette []*color.Color
+			var colorValue *color.Color
+			if hash {
+				var base []color.Attribute
+				if colorName != "" {
+					base, err = styleAttributes(colorName, h.level)
+					if err != nil {
+						log.Printf("invalid color name '%s'", colorName)
+						continue
+					}
+				}
+				palette = h.hashColors(base)
+				colorValue = palette[0]
+			} else {
+				colorValue, ok = h.styleColor(colorName)
+				if !ok {
+					log.Printf("invalid color name '%s'", colorName)
+					continue
+				}
+			}
+
+			if scope == scopeGroup && regex.NumSubexp() == 0 {
+				log.Printf("color pattern '%s' uses 'group' but has no capture group; coloring the whole match", pattern)
+				scope = scopeMatch
 			}
 
-			colorPatterns = append(colorPatterns, ColorPattern{
+			h.user = append(h.user, ColorPattern{
 				Pattern: regex,
 				Color:   colorValue,
-				Order:   len(colorPatterns),
+				Order:   len(h.user),
+				Spec:    strings.TrimSpace(style) + ":" + regexStr,
+				Scope:   scope,
+				Palette: palette,
+				Style:   colorName,
 			})
 		}
 	}
@@ -234,16 +254,11 @@ func splitColorPatterns(colorOpt string) []string {
 
 func isColorPatternStart(s string) bool {
 	s = strings.TrimLeft(s, " \t\r\n")
-	colon := strings.IndexByte(s, ':')
-	if colon <= 0 {
-		return false
-	}
-	name := strings.TrimSpace(s[:colon])
-	if strings.ContainsAny(name, " \t\r\n") {
+	style, _, ok := splitStyleSpec(s)
+	if !ok {
 		return false
 	}
-	_, ok := colorAttributes(name)
-	return ok
+	return isStyleSpec(style)
 }
 
 type repeatedStrings []string
@@ -267,12 +282,15 @@ func (r *repeatedStrings) Set(value string) error {
 // ---------- 共通ヘルパ ----------
 
 type globalOptions struct {
-	showLogo  bool
-	colorLogo bool
+	showLogo      bool
+	colorLogo     bool
+	colorMode     colorMode
+	autoHighlight bool // --auto-highlight
+	noLevelColors bool // --no-level-colors
 }
 
 func parseGlobalArgs(args []string) (globalOptions, string, []string) {
-	opts := globalOptions{showLogo: true, colorLogo: true}
+	opts := globalOptions{showLogo: true, colorLogo: true, colorMode: colorAuto}
 	for len(args) > 0 {
 		arg := args[0]
 		switch {
@@ -282,6 +300,46 @@ func parseGlobalArgs(args []string) (globalOptions, string, []string) {
 		case arg == "--no-color-logo":
 			opts.colorLogo = false
 			args = args[1:]
+		case arg == "--tui":
+			tuiMode = true
+			args = args[1:]
+		case arg == "--auto-highlight":
+			opts.autoHighlight = true
+			args = args[1:]
+		case arg == "--no-level-colors":
+			opts.noLevelColors = true
+			args = args[1:]
+		case arg == "--parse":
+			parseFields = true
+			args = args[1:]
+		case arg == "--output":
+			if len(args) < 2 {
+				log.Fatal("missing value for --output (text, json)")
+			}
+			setOutputFormat(args[1])
+			args = args[2:]
+		case strings.HasPrefix(arg, "--output="):
+			setOutputFormat(strings.TrimPrefix(arg, "--output="))
+			args = args[1:]
+		case arg == "--tee" || arg == "--tee-html":
+			if len(args) < 2 {
+				log.Fatalf("missing file for %s", arg)
+			}
+			setTeePath(arg, args[1])
+			args = args[2:]
+		case strings.HasPrefix(arg, "--tee=") || strings.HasPrefix(arg, "--tee-html="):
+			name, value, _ := strings.Cut(arg, "=")
+			setTeePath(name, value)
+			args = args[1:]
+		case arg == "--sink":
+			if len(args) < 2 {
+				log.Fatal("missing value for --sink")
+			}
+			addSinkSpec(args[1])
+			args = args[2:]
+		case strings.HasPrefix(arg, "--sink="):
+			addSinkSpec(strings.TrimPrefix(arg, "--sink="))
+			args = args[1:]
 		case arg == "--version" || arg == "-v":
 			fmt.Println(version)
 			os.Exit(0)
@@ -289,10 +347,10 @@ func parseGlobalArgs(args []string) (globalOptions, string, []string) {
 			if len(args) < 2 {
 				log.Fatal("missing value for --color (auto, always, never)")
 			}
-			setColorMode(args[1])
+			opts.setColorMode(args[1])
 			args = args[2:]
 		case strings.HasPrefix(arg, "--color="):
-			setColorMode(strings.TrimPrefix(arg, "--color="))
+			opts.setColorMode(strings.TrimPrefix(arg, "--color="))
 			args = args[1:]
 		case arg == "-h" || arg == "--help" || arg == "help":
 			usage(opts, 0)
@@ -303,27 +361,102 @@ func parseGlobalArgs(args []string) (globalOptions, string, []string) {
 	return opts, "", nil
 }
 
-func setColorMode(mode string) {
+// 色の出し方は Highlighter が opts.colorMode に従って決める。color.NoColor は書き換えない。
+func (o *globalOptions) setColorMode(mode string) {
 	switch strings.ToLower(mode) {
 	case "auto":
-		selectedColorMode = colorAuto
-		return
+		o.colorMode = colorAuto
 	case "always":
-		selectedColorMode = colorAlways
-		color.NoColor = false
+		o.colorMode = colorAlways
 	case "never":
-		selectedColorMode = colorNever
-		color.NoColor = true
+		o.colorMode = colorNever
 	default:
 		log.Fatalf("invalid --color value %q (expected auto, always, never)", mode)
 	}
 }
 
-func applyColorOptions(colorOpts repeatedStrings) {
-	if len(colorOpts) == 0 {
-		return
+func applyExitOptions(opts exitFlags) {
+	rules, err := opts.build()
+	if err != nil {
+		log.Fatal(err)
+	}
+	exitRules = rules
+}
+
+func applyLevelOptions(opts levelFlags) {
+	filter, err := opts.build()
+	if err != nil {
+		log.Fatal(err)
 	}
-	parseColorPatterns(colorOpts)
+	levelRules = filter
+}
+
+func applyTimeOptions(opts timeFlags) {
+	rewriter, err := opts.build()
+	if err != nil {
+		log.Fatal(err)
+	}
+	timeRules = rewriter
+}
+
+func applyTriggerOptions(opts triggerFlags) {
+	set, err := opts.build()
+	if err != nil {
+		log.Fatal(err)
+	}
+	triggers = set
+}
+
+// 行の出力と規則のオプション。読み出し元によらず、どのサブコマンドでも同じものを受け付ける。
+type pipelineFlags struct {
+	colorOpts   repeatedStrings
+	showSummary *bool
+	exit        exitFlags
+	trigger     triggerFlags
+	stats       statsFlags
+	level       levelFlags
+	time        timeFlags
+	prefix      prefixFlags
+}
+
+func registerPipelineFlags(fs *flag.FlagSet) *pipelineFlags {
+	f := &pipelineFlags{}
+	fs.Var(&f.colorOpts, "c", "color patterns in format 'color:regex' (can be used multiple times)")
+	f.showSummary = fs.Bool("summary", false, "print a summary to stderr on shutdown")
+	f.exit = registerExitFlags(fs)
+	f.trigger = registerTriggerFlags(fs)
+	f.stats = registerStatsFlags(fs)
+	f.level = registerLevelFlags(fs)
+	f.time = registerTimeFlags(fs)
+	f.prefix = registerPrefixFlags(fs)
+	return f
+}
+
+// Highlighter と出力先を作り、行の規則を設定する。label は TUI に表示する読み出し元。
+// tee のファイルは規則の指定を確かめてから開くので、指定の誤りで終了しても作りかけのファイルは残らない。
+func (f *pipelineFlags) start(opts globalOptions, label string) *Highlighter {
+	h := buildHighlighter(opts, f.colorOpts)
+	startSinks()
+	applyLevelOptions(f.level)
+	applyTimeOptions(f.time)
+	prefixRules = f.prefix.build()
+	applyExitOptions(f.exit)
+	applyTriggerOptions(f.trigger)
+	startStats(f.stats, h)
+	startTee()
+	startTUIMode(h)
+	tuiView.setFile(label)
+	return h
+}
+
+// -since を指定して -n を指定しなかった場合は、since 以降を全部表示する (-1) 行数を返す
+func linesSince(fs *flag.FlagSet, lines int, since bool) int {
+	nSet := false
+	fs.Visit(func(f *flag.Flag) { nSet = nSet || f.Name == "n" })
+	if since && !nSet {
+		return -1
+	}
+	return lines
 }
 
 func validateLineCount(n int) {
@@ -338,163 +471,356 @@ func validateInterval(interval time.Duration) {
 	}
 }
 
-func printLine(text string) {
-	text = strings.TrimRight(text, "\r")
-	fmt.Println(applyColorPatterns(text))
+// 出力先へのバッファ付き書き込み。複数の tail から同時に呼ばれても行が混ざらない。
+type outputWriter struct {
+	mu    sync.Mutex
+	sinks []namedSink
+	term  *writerSink // ステータス行を書く標準出力 (無ければ nil)
+
+	status      func() string // 最下行に固定表示するステータス (nil なら表示しない)
+	statusShown bool
 }
 
-// 最新 (mod time が最大) の通常ファイルを返す
-func newestFile(dir string) (string, error) {
-	return newestFileWithPattern(dir, "*")
+// 出力先と、エラーの報告に使う名前
+type namedSink struct {
+	name   string
+	sink   lineSink
+	failed bool // エラーを報告済み
 }
 
-// ワイルドカードパターンにマッチする最新のファイルを返す
-func newestFileWithPattern(dir, pattern string) (string, error) {
-	var newest string
-	var newestMod time.Time
+var output = newOutputWriter(namedSink{name: "stdout", sink: newStdoutSink()})
 
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return "", err
+func newOutputWriter(sinks ...namedSink) *outputWriter {
+	o := &outputWriter{}
+	o.setSinks(sinks)
+	return o
+}
+
+// 出力先を置き換える。元の出力先は flush してから閉じる。
+func (o *outputWriter) setSinks(sinks []namedSink) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.eraseStatusLocked()
+	for i := range o.sinks {
+		o.closeSinkLocked(&o.sinks[i])
 	}
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		matched, err := filepath.Match(pattern, entry.Name())
-		if err != nil {
-			return "", fmt.Errorf("invalid pattern '%s': %v", pattern, err)
-		}
-		if !matched {
-			continue
-		}
-		info, err := entry.Info()
-		if err != nil {
-			return "", err
+	o.sinks = sinks
+	o.term = nil
+	for _, s := range sinks {
+		if w, ok := s.sink.(*writerSink); ok {
+			o.term = w
+			break
 		}
-		if info.Mode().IsRegular() && info.ModTime().After(newestMod) {
-			newest = filepath.Join(dir, entry.Name())
-			newestMod = info.ModTime()
+	}
+}
+
+// 標準出力へ書いているか (ステータス行を表示できるか)
+func (o *outputWriter) writesToStdout() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.term != nil
+}
+
+func (o *outputWriter) writeLine(text string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.eraseStatusLocked()
+	for i := range o.sinks {
+		o.reportLocked(&o.sinks[i], o.sinks[i].sink.writeLine(text))
+	}
+}
+
+func (o *outputWriter) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.status != nil && o.term != nil {
+		o.eraseStatusLocked()
+		// 折り返すと \r\x1b[2K で消せない行が残るので、端末の幅に収める
+		status := o.status()
+		if width := terminalWidth(); width > 0 {
+			status = truncateWidth(status, width-1)
 		}
+		o.term.writeString(status)
+		o.statusShown = true
 	}
-	if newest == "" {
-		return "", fmt.Errorf("no files matching pattern '%s' in %s", pattern, dir)
+	o.flushLocked()
+	tee.flush()
+}
+
+func (o *outputWriter) flushLocked() {
+	for i := range o.sinks {
+		o.reportLocked(&o.sinks[i], o.sinks[i].sink.flush())
 	}
-	return newest, nil
 }
 
-// ファイルを tail して標準出力へ
-func startFollow(path string, offset int64) (*tail.Tail, <-chan error, error) {
-	cfg := tail.Config{
-		Follow:    true,
-		ReOpen:    true, // ローテーション追従
-		MustExist: true,
-		Poll:      runtime.GOOS == "windows",
-		Logger:    tail.DiscardingLogger,
-		Location:  &tail.SeekInfo{Offset: offset, Whence: io.SeekStart},
+// 出力先を閉じる。標準出力は flush だけする。
+func (o *outputWriter) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := range o.sinks {
+		o.closeSinkLocked(&o.sinks[i])
 	}
-	t, err := tail.TailFile(path, cfg)
+}
+
+func (o *outputWriter) closeSinkLocked(s *namedSink) {
+	o.reportLocked(s, s.sink.close())
+}
+
+// 標準出力の端末の桁数。端末でなければ 0。テストで差し替える
+var terminalWidth = func() int {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
 	if err != nil {
-		return nil, nil, err
+		return 0
 	}
+	return width
+}
 
-	errCh := make(chan error, 1)
-	go func() {
-		defer close(errCh)
-		for line := range t.Lines {
-			if line.Err != nil {
-				errCh <- line.Err
-				return
-			}
-			printLine(line.Text)
-		}
-	}()
-	return t, errCh, nil
+// 出力先ごとに最初のエラーだけを報告する
+func (o *outputWriter) reportLocked(s *namedSink, err error) {
+	if err == nil || s.failed {
+		return
+	}
+	s.failed = true
+	log.Printf("failed to write to %s: %v", s.name, err)
 }
 
-// ---------- サブコマンド: file ----------
+func (o *outputWriter) setStatus(status func() string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.status = status
+}
 
-func cmdFile(args []string) {
-	fs := flag.NewFlagSet("file", flag.ExitOnError)
-	nLines := fs.Int("n", 10, "show last N lines then follow")
-	var colorOpts repeatedStrings
-	fs.Var(&colorOpts, "c", "color patterns in format 'color:regex' (can be used multiple times)")
-	fs.Parse(args)
+// ステータス行を消して固定表示をやめる
+func (o *outputWriter) clearStatus() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.eraseStatusLocked()
+	o.status = nil
+	o.flushLocked()
+}
 
-	if fs.NArg() != 1 {
-		log.Fatalf("usage: trail file [options] <file>")
+func (o *outputWriter) eraseStatusLocked() {
+	if o.statusShown && o.term != nil {
+		o.term.writeString("\r\x1b[2K")
 	}
-	validateLineCount(*nLines)
-	file := fs.Arg(0)
+	o.statusShown = false
+}
 
-	applyColorOptions(colorOpts)
+// 1 行をバッファへ書き込む。表示には flush が必要。
+// source は行の読み出し元ファイル (不明な場合は空)。h が nil なら色を付けない。
+func writeLine(h *Highlighter, source, text string) {
+	writeLineFrom(h, lineInfo{source: source, offset: -1}, text)
+}
 
-	offset, err := printLastN(file, *nLines)
-	if err != nil {
-		log.Fatal(err)
+// 読み出し元の情報付きで 1 行を書き込む
+func writeLineFrom(h *Highlighter, info lineInfo, text string) {
+	text = strings.TrimRight(text, "\r")
+	if !levelRules.allow(info.source, text) {
+		return
 	}
+	emitLine(h, info, text)
+}
 
-	_, errCh, err := startFollow(file, offset)
-	if err != nil {
-		log.Fatal(err)
+// -min-level の判定を済ませた行を出力する
+func emitLine(h *Highlighter, info lineInfo, text string) {
+	source := info.source
+	if !exitRules.check(text) {
+		return
 	}
-	if err := <-errCh; err != nil {
-		log.Fatal(err)
+	summary.recordLine()
+	stats.recordLine(source, len(text)+1)
+	triggers.fire(source, text)
+	text = timeRules.rewrite(text)
+	if jsonOutput {
+		record := marshalRecord(newLineRecord(info, text, h.matchedPatterns(text)))
+		output.writeLine(record)
+		tee.writeLine(record, "", record, nil)
+		return
+	}
+	prefix := prefixRules.text(info)
+	if tuiView != nil && !tee.wantsColored() && !tee.wantsSpans() {
+		// TUI は表示時に色付けし直すので、控えに書かないなら色付けせずに元の行を渡す
+		tuiView.append(source, prefix+text)
+		return
+	}
+	var colored string
+	var spans []colorMatch
+	if tee.wantsSpans() {
+		colored, spans = h.highlightSpans(text)
+	} else {
+		colored = h.Highlight(text)
 	}
+	if prefix != "" {
+		colored = h.faint(prefix) + colored
+	}
+	tee.writeLine(colored, prefix, text, spans)
+	if tuiView != nil {
+		// TUI は表示時に色付けし直すので元の行を渡す
+		tuiView.append(source, prefix+text)
+		return
+	}
+	output.writeLine(colored)
 }
 
-func printLastN(path string, n int) (int64, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
+func printLine(h *Highlighter, text string) {
+	writeLine(h, "", text)
+	output.flush()
+}
 
-	if n == 0 {
-		offset, err := f.Seek(0, io.SeekEnd)
-		return offset, err
+// ---------- 追従 (pkg/trail) ----------
+
+// CLI の設定から Follower の設定を作る
+func followerConfig(selector trail.Selector, nLines int) trail.Config {
+	cfg := trail.Config{
+		Selector: selector,
+		Lines:    nLines,
+		Poll:     runtime.GOOS == "windows",
+		// 行番号は JSON 出力でだけ使うので、そのときだけ数える
+		LineNumbers: jsonOutput,
+		// 色付けは時刻の書き換えの後に行うので Highlighter は渡さない
 	}
+	if levelRules != nil {
+		cfg.Filter = levelRules.allow
+	}
+	return cfg
+}
 
-	initialCap := n
-	if initialCap > 1024 {
-		initialCap = 1024
+// Follower を止めるためのハンドル
+type followerHandle struct {
+	cancel context.CancelFunc
+}
+
+func (h followerHandle) Stop() error {
+	h.cancel()
+	return nil
+}
+
+func (followerHandle) Cleanup() {}
+
+// Follower を動かし、行と出来事を出力へ流す。
+// 返す errCh は追従が終わると Run のエラー (あれば) を送って閉じる。
+func startFollower(h *Highlighter, cfg trail.Config) (*trail.Follower, followState, error) {
+	follower, err := trail.New(cfg)
+	if err != nil {
+		return nil, followState{}, err
 	}
-	ring := make([]string, 0, initialCap)
-	count := 0
-	reader := bufio.NewReader(f)
-	for {
-		line, err := reader.ReadString('\n')
-		if len(line) > 0 {
-			line = strings.TrimRight(line, "\r\n")
-			if len(ring) < n {
-				ring = append(ring, line)
-			} else {
-				ring[count%n] = line
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- follower.Run(ctx)
+	}()
+
+	errCh := make(chan error, 1)
+	started := make(chan struct{})
+	go func() {
+		defer close(errCh)
+		events := follower.Events()
+		isStarted := false
+		for ev := range events {
+			if ev.Type == trail.EventStart && !isStarted {
+				// 末尾の行は読み終え、追従を始める位置が決まっている。この後の追記は追従中の行として届く
+				close(started)
+				isStarted = true
+			}
+			handleFollowEvent(h, ev)
+			if len(events) == 0 {
+				output.flush()
 			}
-			count++
-		}
-		if err == io.EOF {
-			break
 		}
-		if err != nil {
-			return 0, err
+		output.flush()
+		if err := <-runErr; err != nil {
+			errCh <- err
 		}
+	}()
+	path, _ := cfg.Selector.Select()
+	return follower, followState{path: path, tail: followerHandle{cancel: cancel}, errCh: errCh, started: started}, nil
+}
+
+// Follower から届いた行と出来事を出力する
+func handleFollowEvent(h *Highlighter, ev trail.Event) {
+	switch ev.Type {
+	case trail.EventLine:
+		line := ev.Line
+		emitLine(h, lineInfo{source: line.File, offset: line.Offset, number: line.Number, received: line.Received}, line.Text)
+	case trail.EventStart:
+		// 開始は呼び出し側が知らせる
+	case trail.EventSwitch:
+		summary.recordSwitch()
+		logEvent(string(ev.Type), ev.File, "%s", ev.Message)
+		tuiView.setFile(ev.File)
+	default:
+		logEvent(string(ev.Type), ev.File, "%s", ev.Message)
 	}
+}
 
-	start := 0
-	if count > n {
-		start = count - n
-	}
-	for i := start; i < count; i++ {
-		if count <= n {
-			printLine(ring[i])
-		} else {
-			printLine(ring[i%n])
+// Follower 以外の読み出し元 (agent や SSH など) を run で動かし、終了の条件がそろうまで待って終了する。
+// run は ctx が終わると戻る。label は止められなかったときのログに使う。
+func runSource(h *Highlighter, label string, showSummary bool, run func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		if err := run(ctx); err != nil {
+			errCh <- err
+		}
+	}()
+	state := followState{path: label, tail: followerHandle{cancel: cancel}, errCh: errCh}
+	waitFollow(h, nil, state, showSummary)
+}
+
+// 終了の条件がそろうまで追従し、終了する。follower は手元で追従しない場合 nil。
+func waitFollow(h *Highlighter, follower *trail.Follower, state followState, showSummary bool) {
+	signals := notifyShutdown()
+	timeout := exitRules.timeoutCh()
+	for {
+		select {
+		case err := <-state.errCh:
+			if err != nil {
+				exitFatal(err)
+			}
+			// ファイルが無くなるなどして追従が終わった
+			finish(h, state, showSummary)
+			os.Exit(0)
+		case path := <-tuiView.switchCh():
+			// TUI でファイルを選んだ場合は最新ファイルへの自動切り替えを止める
+			if follower != nil {
+				follower.Pin(path)
+			}
+		case <-tuiView.quitCh():
+			finish(h, state, showSummary)
+			os.Exit(0)
+		case sig := <-signals:
+			os.Exit(shutdown(h, state, sig, showSummary))
+		case code := <-exitRules.doneCh():
+			finish(h, state, showSummary)
+			os.Exit(code)
+		case <-timeout:
+			finish(h, state, showSummary)
+			os.Exit(exitRules.timeoutCode())
 		}
 	}
+}
+
+// ---------- サブコマンド: file ----------
+
+func cmdFile(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("file", flag.ExitOnError)
+	nLines := fs.Int("n", 10, "show last N lines then follow")
+	pipeline := registerPipelineFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: trail file [options] <file>")
+	}
+	validateLineCount(*nLines)
+	file := fs.Arg(0)
 
-	offset, err := f.Seek(0, io.SeekCurrent)
-	return offset, err
+	highlighter := pipeline.start(opts, file)
+	follower, state, err := startFollower(highlighter, followerConfig(trail.File(file), *nLines))
+	if err != nil {
+		exitFatal(err)
+	}
+	waitFollow(highlighter, follower, state, *pipeline.showSummary)
 }
 
 // ---------- サブコマンド: dir ----------
@@ -505,14 +831,12 @@ type followHandle interface {
 }
 
 type followState struct {
-	path  string
-	tail  followHandle
-	errCh <-chan error
+	path    string
+	tail    followHandle
+	errCh   <-chan error
+	started <-chan struct{} // startFollower の場合、読み始める位置が決まると閉じる
 }
 
-type printLastNFunc func(string, int) (int64, error)
-type startFollowFunc func(string, int64) (followHandle, <-chan error, error)
-
 func stopFollow(state followState) {
 	if state.tail == nil {
 		return
@@ -526,39 +850,12 @@ func stopFollow(state followState) {
 	state.tail.Cleanup()
 }
 
-func switchFollowToLatest(state followState, latest string, nLines int, printLast printLastNFunc, startFollow startFollowFunc) followState {
-	if latest == state.path {
-		return state
-	}
-
-	offset, err := printLast(latest, nLines)
-	if err != nil {
-		log.Printf("failed to print last lines for %s: %v", latest, err)
-		return state
-	}
-
-	nextTail, nextErrCh, err := startFollow(latest, offset)
-	if err != nil {
-		log.Printf("failed to follow %s: %v", latest, err)
-		return state
-	}
-
-	stopFollow(state)
-	log.Printf("switching to %s", latest)
-	return followState{
-		path:  latest,
-		tail:  nextTail,
-		errCh: nextErrCh,
-	}
-}
-
-func cmdDir(args []string) {
+func cmdDir(opts globalOptions, args []string) {
 	fs := flag.NewFlagSet("dir", flag.ExitOnError)
 	interval := fs.Duration("interval", 5*time.Second, "fallback polling interval")
-	var colorOpts repeatedStrings
-	fs.Var(&colorOpts, "c", "color patterns in format 'color:regex' (can be used multiple times)")
 	pattern := fs.String("pattern", "*", "file pattern to match (e.g., '*.log', 'app-*.log')")
 	nLines := fs.Int("n", 10, "show last N lines then follow")
+	pipeline := registerPipelineFlags(fs)
 	fs.Parse(args)
 	if fs.NArg() != 1 {
 		log.Fatalf("usage: trail dir [options] <directory>")
@@ -567,79 +864,22 @@ func cmdDir(args []string) {
 	validateInterval(*interval)
 	dir := fs.Arg(0)
 
-	applyColorOptions(colorOpts)
-
-	current, err := newestFileWithPattern(dir, *pattern)
+	selector := trail.Newest(dir, *pattern)
+	current, err := selector.Select()
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("trailing %s (pattern: %s)", current, *pattern)
+	highlighter := pipeline.start(opts, current)
+	tuiView.setFileLister(selector.Files)
+	logEvent("start", current, "trailing %s (pattern: %s)", current, *pattern)
 
-	offset, err := printLastN(current, *nLines)
+	cfg := followerConfig(selector, *nLines)
+	cfg.Interval = *interval
+	follower, state, err := startFollower(highlighter, cfg)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	currentTail, currentErrCh, err := startFollow(current, offset)
-	if err != nil {
-		log.Fatal(err)
-	}
-	state := followState{path: current, tail: currentTail, errCh: currentErrCh}
-
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer watcher.Close()
-	if err := watcher.Add(dir); err != nil {
-		log.Fatal(err)
-	}
-
-	timer := time.NewTicker(*interval)
-	defer timer.Stop()
-
-	switchToLatest := func() {
-		latest, err := newestFileWithPattern(dir, *pattern)
-		if err != nil {
-			log.Printf("latest file check failed: %v", err)
-			return
-		}
-		if latest == current {
-			return
-		}
-		state = switchFollowToLatest(state, latest, *nLines, printLastN, func(path string, offset int64) (followHandle, <-chan error, error) {
-			return startFollow(path, offset)
-		})
-		current = state.path
-		currentErrCh = state.errCh
-	}
-
-	for {
-		select {
-		case ev, ok := <-watcher.Events:
-			if !ok {
-				return
-			}
-			if ev.Op&(fsnotify.Create|fsnotify.Rename) != 0 {
-				switchToLatest()
-			}
-		case <-timer.C:
-			switchToLatest()
-		case err, ok := <-currentErrCh:
-			if !ok {
-				currentErrCh = nil
-				continue
-			}
-			if err != nil {
-				log.Printf("tail error for %s: %v", current, err)
-			}
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				return
-			}
-			log.Printf("watch error: %v", err)
-		}
+		exitFatal(err)
 	}
+	waitFollow(highlighter, follower, state, *pipeline.showSummary)
 }
 
 // ---------- ロゴ表示 ----------
@@ -671,12 +911,12 @@ func showSimpleLogo(w io.Writer) {
 
 func showColoredLogo(w io.Writer) error {
 	colors := []*color.Color{
-		newColor(color.FgHiBlue),
-		newColor(color.FgHiCyan),
-		newColor(color.FgHiGreen),
-		newColor(color.FgHiYellow),
-		newColor(color.FgHiRed),
-		newColor(color.FgHiMagenta),
+		color.New(color.FgHiBlue),
+		color.New(color.FgHiCyan),
+		color.New(color.FgHiGreen),
+		color.New(color.FgHiYellow),
+		color.New(color.FgHiRed),
+		color.New(color.FgHiMagenta),
 	}
 	logoLines := []string{
 		"████████╗██████╗  █████╗ ██╗██╗     ",
@@ -696,7 +936,9 @@ func showColoredLogo(w io.Writer) error {
 			continue
 		}
 		colorIndex := i % len(colors)
-		fmt.Fprintln(w, colors[colorIndex].Sprint(line))
+		c := colors[colorIndex]
+		c.EnableColor() // 色を出すかは呼び出し側が opts.colorMode で決める
+		fmt.Fprintln(w, c.Sprint(line))
 	}
 
 	return nil
@@ -709,11 +951,34 @@ func main() {
 	if command == "" {
 		usage(opts, 1)
 	}
+	if jsonOutput {
+		if tuiMode {
+			log.Fatal("--output json cannot be used with --tui")
+		}
+		// JSON には色を付けない
+		opts.setColorMode("never")
+	}
 	switch command {
 	case "-f", "file":
-		cmdFile(args)
+		cmdFile(opts, args)
 	case "-d", "dir":
-		cmdDir(args)
+		cmdDir(opts, args)
+	case "serve":
+		cmdServe(opts, args)
+	case "agent":
+		cmdAgent(opts, args)
+	case "connect":
+		cmdConnect(opts, args)
+	case "ssh":
+		cmdSSH(opts, args)
+	case "docker":
+		cmdDocker(opts, args)
+	case "k8s":
+		cmdK8s(opts, args)
+	case "journal":
+		cmdJournal(opts, args)
+	case "syslog":
+		cmdSyslog(opts, args)
 	case "-h", "--help", "help":
 		usage(opts, 0)
 	default:
@@ -727,7 +992,7 @@ func usage(opts globalOptions, exitCode int) {
 		w = os.Stderr
 	}
 	if opts.showLogo {
-		showLogo(w, opts.colorLogo)
+		showLogo(w, opts.colorLogo && newHighlighter(opts.colorMode).enabled)
 	}
 	fmt.Fprintf(w, `trail - tail with log-rotate follow
 
@@ -736,6 +1001,14 @@ USAGE
 COMMANDS
   -f, file       Tail a file and follow it
   -d, dir        Tail the latest file in a directory and follow it
+  serve          Serve a live tail over HTTP: a web page, Server-Sent Events and WebSocket
+  agent          Let trail connect clients on other hosts tail files on this host
+  connect        Tail a file or directory through a remote trail agent
+  ssh            Tail a file on another host over SSH (runs tail -F there; nothing to install)
+  docker         Tail a container's logs through the Docker Engine API, following restarts
+  k8s            Tail the containers of the pods matching a label selector, picking up new pods
+  journal        Tail the systemd journal (journalctl) with priorities shown as log levels
+  syslog         Receive syslog messages over UDP/TCP with severities shown as log levels
 
 COMMON OPTIONS
   -h, --help         Show this help
@@ -743,6 +1016,20 @@ COMMON OPTIONS
   --no-logo          Disable logo display
   --no-color-logo    Disable colored logo (use simple ASCII art)
   --color <mode>     Color output mode: auto, always, never (default auto)
+  --tui              Interactive view with scrollback, pause, search, filters and file switcher
+  --auto-highlight   Color IPs, UUIDs, hex IDs, URLs, paths, quoted strings, numbers with units,
+                     HTTP methods and status codes (below -c patterns in precedence)
+  --no-level-colors  Do not color log levels (ERROR, [W], level=warn, <3>, "level":"info") by default
+  --output <format>  Output format: text (default) or json (one object per line, plus event records
+                     for file switches, truncations and errors)
+  --parse            With --output json, add the keys of JSON and logfmt (key=value) lines as "fields"
+  --tee <file>       Also write everything printed to a file, keeping ANSI colors
+  --tee-html <file>  Also write everything printed to an HTML file with colors as styled spans
+  --sink <spec>      Where to write lines instead of stdout (can be used multiple times to write to
+                     several at once). Files and syslog get the lines without ANSI colors:
+                       stdout
+                       file:<path>[,max-size=10MB][,backups=3]   rotate before the file exceeds max-size
+                       syslog[:unix:///dev/log|udp://host:514|tcp://host:514][,tag=trail]
 
 file OPTIONS
   -n <N>         Print last N lines before following (default 10)
@@ -750,12 +1037,153 @@ file OPTIONS
                  Comma-separated color entries are also supported
                  Colors: red, green, blue, yellow, magenta, cyan, white, black
                  Bright colors: brightred, brightgreen, brightblue, brightyellow, brightmagenta, brightcyan, brightwhite
+                 Styles: bold, dim, italic, underline, blink, reverse, strikethrough
+                 Combine with '+': bold+red, underline+bg:yellow, fg:#ff8800, fg:208+bg:236
+                 Scope: add 'line' to color the whole line, or 'group' to color only the
+                 capture groups (named groups if any): red+line:ERROR, cyan+group:took (?P<hl>\d+ms)
+                 Use 'hash' instead of a color to give each distinct match its own stable color:
+                 hash:req-[0-9a-f]+, bold+hash+group:thread=(\w+)
+  -summary       Print lines seen, matches per pattern and file switches on Ctrl+C
+  -until-match <regex>  Exit 0 as soon as a line matches
+  -fail-on <regex>      Exit 1 as soon as a line matches
+  -timeout <d>          Stop after d; exit 124 if -until-match was not seen
+  -on <regex=action>    Run a shell command or POST to an http(s) URL when a line matches
+                        (can be used multiple times). Placeholders: {line} {file} {0} {1} {name} {suppressed}
+  -on-cooldown <d>      Minimum time between runs of the same -on trigger (default 1s)
+  -on-concurrency <N>   Maximum -on actions running at once; extra matches are dropped (default 4)
+  -stats                Show lines/s and bytes/s per file and matches/min per color pattern
+                        (sticky bottom line on a terminal, periodic stderr lines otherwise)
+  -stats-interval <d>   Refresh interval for -stats (default 1s)
+  -min-level <level>    Only show lines at or above trace, debug, info, warn, error or fatal;
+                        lines without a level (stack traces) follow the previous line
+  -time-format <f>      Rewrite the leading timestamp: iso, rfc3339, a Go layout such as
+                        "15:04:05.000", or delta to show the time since the previous line (+0.032s)
+  -time-zone <z>        Time zone for -time-format: local (default), UTC, or a name like Asia/Tokyo
+  -timestamps           Prefix each line with the time trail received it
+  -show-file            Prefix each line with the name of the file it came from
+  -show-offset          Prefix each line with its byte offset in the file
 
 dir  OPTIONS
   -n <N>         Print last N lines before following (default 10)
   -interval <d>  Polling fallback interval (default 5s)
   -c <pattern>   Color pattern in format 'color:regex' (can be used multiple times)
   -pattern <p>   File pattern to match (e.g., '*.log', 'app-*.log', 'service-*.txt')
+  -summary       Print lines seen, matches per pattern and file switches on Ctrl+C
+  -until-match, -fail-on, -timeout   Same as file mode
+  -on, -on-cooldown, -on-concurrency Same as file mode
+  -stats, -stats-interval            Same as file mode
+  -min-level                         Same as file mode
+  -time-format, -time-zone           Same as file mode
+  -timestamps, -show-file, -show-offset  Same as file mode
+
+serve OPTIONS    trail serve [options] file|dir <path>
+  -listen <addr>  Address to listen on (default 127.0.0.1:8080; use :8080 to serve other hosts).
+                  Open http://<addr>/ in a browser; /events streams Server-Sent Events and /ws a
+                  WebSocket, one JSON record per message (the --output json records plus "html"
+                  with the colors as styled spans)
+  -allow-origin <origin>  Let pages from another site open the WebSocket, e.g.
+                  https://dash.example.com or * (can be used multiple times). Without it only
+                  the page served by trail and clients that send no Origin may connect
+  -n <N>          Lines kept and sent to each client when it connects (default 100)
+  -c <pattern>    Color pattern in format 'color:regex' (can be used multiple times)
+  -pattern <p>    File pattern to match in dir mode
+  -interval <d>   Polling fallback interval in dir mode (default 5s)
+  -min-level                         Same as file mode
+  -time-format, -time-zone           Same as file mode
+                  Clients choose their own filter with ?filter=<regex>; WebSocket clients can
+                  change it by sending {"filter":"<regex>"}
+
+agent OPTIONS    trail agent [options]
+  -listen <addr>  Address to listen on: host:port, tcp://host:port or unix:///path
+                  (default tcp://127.0.0.1:7070)
+  -allow <dir>    Directory clients may tail files under (can be used multiple times;
+                  default: the current directory)
+  -token <t>      Token clients must send (default $TRAIL_TOKEN)
+  -tls-cert <f>, -tls-key <f>  Serve TLS with this certificate and key
+
+connect OPTIONS  trail connect [options] <address> file|dir <path>
+  -token <t>      Token to send to the agent (default $TRAIL_TOKEN)
+  -tls            Connect with TLS, verifying the agent against the system CAs
+  -tls-ca <f>     Verify the agent against this CA certificate (implies -tls)
+  -tls-server-name <name>  Name to verify the agent's certificate against
+  -retry <d>      Wait before reconnecting after the connection drops, then resume after the
+                  last line received (default 2s; 0 exits instead)
+  -n, -c, -pattern, -summary         Same as file / dir mode (coloring happens locally)
+  -until-match, -fail-on, -timeout, -on, -stats, -min-level, -time-format, -time-zone,
+  -timestamps, -show-file, -show-offset  Same as file mode
+
+ssh OPTIONS      trail ssh [options] [user@]host:/path
+  -p <port>       SSH port (default 22)
+  -i <file>       Private key (can be used multiple times). Without -i, ssh-agent ($SSH_AUTH_SOCK)
+                  and unencrypted ~/.ssh/id_ed25519, id_ecdsa, id_rsa are tried, then a password
+                  prompt on a terminal
+  -known-hosts <file>  known_hosts file to verify the host with (can be used multiple times;
+                  default ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts). Unknown hosts are refused
+  -retry <d>      Wait before reconnecting after the connection drops (default 2s; 0 exits instead).
+                  Lines written while disconnected are skipped
+  -n, -c, -summary, -until-match, -fail-on, -timeout, -on, -stats, -min-level,
+  -time-format, -time-zone, -timestamps, -show-file, -show-offset  Same as file mode
+
+docker OPTIONS   trail docker [options] <container>
+  -host <addr>    Docker daemon: unix:///path or tcp://host:port
+                  (default $DOCKER_HOST or unix:///var/run/docker.sock)
+  -since <t>      Show lines since a duration ago (10m), an RFC 3339 time or a Unix timestamp;
+                  without -n, all lines since then are shown
+  -stdout, -stderr  Show the container's stdout / stderr (both default true; e.g. -stdout=false)
+  -poll <d>       Interval to check for a restart while the container is stopped or missing
+                  (default 1s). Lines written in between are shown once it runs again
+  -n, -c, -summary, -until-match, -fail-on, -timeout, -on, -stats, -min-level,
+  -time-format, -time-zone, -timestamps, -show-file, -show-offset  Same as file mode
+
+k8s OPTIONS      trail k8s [options]
+  -l <selector>   Label selector of the pods to follow (e.g. app=api; default all pods)
+  -n <namespace>  Namespace (default: the namespace of the kubeconfig context)
+  -kubeconfig <f> kubeconfig file (default $KUBECONFIG or ~/.kube/config; inside a pod
+                  without one, the service account is used)
+  -context <name> kubeconfig context (default: current-context)
+  -container <re> Only follow containers whose name matches this regex
+  -tail <n>       Last N lines of each pod running at start (default 10; -1 for all).
+                  Pods that appear later and restarted containers are shown from the start
+  -since <d>      Only show lines newer than this (e.g. 10m)
+  -retry <d>      Wait before trying again after an API error (default 2s)
+  -no-prefix      Do not prefix lines with [pod/container]
+  -c, -summary, -until-match, -fail-on, -timeout, -on, -stats, -min-level,
+  -time-format, -time-zone, -timestamps  Same as file mode
+
+journal OPTIONS  trail journal [options] [FIELD=VALUE...]
+  -u <unit>       systemd unit to show (can be used multiple times)
+  -t <id>         Syslog identifier to show (can be used multiple times)
+  -since <t>      Show entries since this time, as journalctl understands it ("10 min ago",
+                  "2024-05-01 12:00"); without -n, all entries since then are shown
+  -cursor-file <f>  Save the position of the last entry in this file; the next run with the
+                  same file continues right after it
+  FIELD=VALUE     Journal matches passed to journalctl (e.g. _PID=812)
+  Lines look like "2024-05-01 12:00:00.000 host app[812]: WARNING message"; the priority
+  name drives level coloring and -min-level
+  -n, -c, -summary, -until-match, -fail-on, -timeout, -on, -stats, -min-level,
+  -time-format, -time-zone, -timestamps  Same as file mode
+
+syslog OPTIONS   trail syslog [options]
+  -listen <addrs> Addresses to receive on, comma separated (default udp://:5514;
+                  e.g. udp://:5514,tcp://:5514). Both RFC 3164 and RFC 5424 are accepted;
+                  over TCP, newline-delimited and octet-counted framing both work
+  -facility       Show the facility before the severity (e.g. auth.WARNING)
+  Lines look like "2024-05-01 12:00:00.000 host app[812]: WARNING message"; the severity
+  name drives level coloring and -min-level
+  -c, -summary, -until-match, -fail-on, -timeout, -on, -stats, -min-level,
+  -time-format, -time-zone, -timestamps, -show-file  Same as file mode
+
+TUI KEYS (--tui)
+  q, Ctrl+C          Quit
+  Space, p           Pause / resume the live stream
+  Up/Down, k/j       Scroll one line (scrolling up pauses)
+  PgUp/PgDn, b/f     Scroll one page
+  g/Home, G/End      Jump to the oldest line / back to live
+  /                  Incremental regex search (Enter to keep, Esc to clear)
+  n / N              Jump to the next older / newer match
+  &                  Set a filter regex (empty clears); t toggles it
+  c, 1-9             Toggle all color patterns / the Nth -c pattern
+  o                  Switch file (dir mode)
 
 EXAMPLES
   trail file -n 100 app.log
@@ -766,11 +1194,24 @@ EXAMPLES
   trail file -c "red:ERROR,green:DEBUG,blue:\d{2}-\d{2}" app.log
   trail file -c "red:\d{2,4}" app.log
   trail file -c "red:ERROR" -c "green:DEBUG" app.log
+  trail file -c "bold+red:ERROR" -c "black+bg:yellow:WARN" -c "fg:#ff8800:\d+ms" app.log
+  trail file -c "red+line:ERROR" -c "cyan+group:took (?P<hl>\d+ms)" app.log
+  trail file -c "hash:req-[0-9a-f]+" -c "bold+hash+group:host=(\S+)" app.log
   trail dir -c "yellow:WARN,red:ERROR" "C:\Logs\MyService"
   trail dir -pattern "*.log" -c "red:ERROR" "C:\Logs\MyService"
   trail --no-logo file app.log
   trail --no-color-logo file app.log
   trail --color always file -c "red:ERROR" app.log
+  trail --tui dir -pattern "*.log" -c "red:ERROR" "C:\Logs\MyService"
+  trail --auto-highlight file -c "red+line:ERROR" access.log
+  trail file -min-level warn app.log
+  trail file -time-format iso -time-zone UTC app.log
+  trail dir -time-format delta ./logs
+  trail dir -timestamps -show-file -pattern "*.log" ./logs
+  trail --output json --parse dir -pattern "*.log" ./logs | jq 'select(.level == "error")'
+  trail --tee-html session.html --auto-highlight file -c "red:ERROR" app.log
+  trail --no-logo file -n 0 -until-match "Server started" -fail-on "FATAL" -timeout 2m app.log
+  trail file -on 'ERROR=notify-send "trail" "{line}"' app.log
 `)
 	os.Exit(exitCode)
 }
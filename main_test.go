@@ -3,25 +3,36 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"regexp/syntax"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/yutat23/trail/pkg/trail"
 )
 
 var ansiRE = regexp.MustCompile(`\x1b\[[0-9;]*m`)
 
 func resetTestState() {
-	colorPatterns = nil
-	selectedColorMode = colorAuto
+	levelRules = nil
+	timeRules = nil
+	prefixRules = nil
+	jsonOutput = false
+	parseFields = false
+	summary = newSessionSummary()
+	exitRules = nil
+	triggers = nil
+	stats = nil
+	sinkSpecs = nil
 	color.NoColor = true
 	log.SetOutput(os.Stderr)
 	log.SetFlags(log.LstdFlags)
@@ -34,6 +45,14 @@ func withReset(t *testing.T) {
 	t.Cleanup(resetTestState)
 }
 
+// -c のパターンだけを持ち、マッチ数を集計へ反映する Highlighter
+func userHighlighter(mode colorMode, specs ...string) *Highlighter {
+	h := newHighlighter(mode)
+	h.record = recordPatternMatches
+	h.addColorPatterns(specs)
+	return h
+}
+
 func captureStdout(t *testing.T, fn func()) string {
 	t.Helper()
 
@@ -71,6 +90,18 @@ func captureStdout(t *testing.T, fn func()) string {
 	return string(data)
 }
 
+// output の出力先をメモリに差し替えて fn を実行し、書き込まれた行を返す
+func captureOutput(t *testing.T, fn func()) string {
+	t.Helper()
+	mem := &memorySink{}
+	saved := output
+	output = newOutputWriter(namedSink{name: "memory", sink: mem})
+	defer func() { output = saved }()
+	fn()
+	output.flush()
+	return mem.String()
+}
+
 func captureLogOutput(t *testing.T, fn func()) string {
 	t.Helper()
 
@@ -136,9 +167,8 @@ func appendToFile(t *testing.T, path, content string) {
 	}
 }
 
-func TestGetColorSupportsDocumentedNames(t *testing.T) {
-	withReset(t)
-	setColorMode("always")
+func TestStyleColorSupportsDocumentedNames(t *testing.T) {
+	h := newHighlighter(colorAlways)
 
 	tests := []struct {
 		name string
@@ -163,18 +193,18 @@ func TestGetColorSupportsDocumentedNames(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c, ok := getColor(strings.ToUpper(tt.name))
+			c, ok := h.styleColor(strings.ToUpper(tt.name))
 			if !ok {
-				t.Fatalf("getColor(%q) returned ok=false", tt.name)
+				t.Fatalf("h.styleColor(%q) returned ok=false", tt.name)
 			}
 			if got, want := c.Sprint("x"), ansi(tt.code, "x"); got != want {
-				t.Fatalf("getColor(%q).Sprint = %q, want %q", tt.name, got, want)
+				t.Fatalf("h.styleColor(%q).Sprint = %q, want %q", tt.name, got, want)
 			}
 		})
 	}
 
-	if c, ok := getColor("orange"); ok || c != nil {
-		t.Fatalf("getColor invalid = (%v, %v), want (nil, false)", c, ok)
+	if c, ok := h.styleColor("orange"); ok || c != nil {
+		t.Fatalf("styleColor invalid = (%v, %v), want (nil, false)", c, ok)
 	}
 }
 
@@ -182,90 +212,85 @@ func TestColorModesControlANSIEscapes(t *testing.T) {
 	t.Run("auto respects disabled color", func(t *testing.T) {
 		withReset(t)
 		color.NoColor = true
-		parseColorPatterns([]string{"red:ERROR"})
+		h := userHighlighter(colorAuto, "red:ERROR")
 
-		if got := applyColorPatterns("ERROR"); got != "ERROR" {
+		if got := h.Highlight("ERROR"); got != "ERROR" {
 			t.Fatalf("auto color output = %q, want plain text", got)
 		}
 	})
 
 	t.Run("always emits ANSI", func(t *testing.T) {
 		withReset(t)
-		setColorMode("always")
-		parseColorPatterns([]string{"red:ERROR"})
+		h := userHighlighter(colorAlways, "red:ERROR")
 
-		if got, want := applyColorPatterns("ERROR"), ansi("31", "ERROR"); got != want {
+		if got, want := h.Highlight("ERROR"), ansi("31", "ERROR"); got != want {
 			t.Fatalf("always color output = %q, want %q", got, want)
 		}
 	})
 
 	t.Run("never suppresses ANSI", func(t *testing.T) {
 		withReset(t)
-		setColorMode("never")
-		parseColorPatterns([]string{"red:ERROR"})
+		h := userHighlighter(colorNever, "red:ERROR")
 
-		if got := applyColorPatterns("ERROR"); got != "ERROR" {
+		if got := h.Highlight("ERROR"); got != "ERROR" {
 			t.Fatalf("never color output = %q, want plain text", got)
 		}
 	})
 }
 
-func TestParseColorPatternsTrimsMultipleOptionsAndKeepsOrder(t *testing.T) {
+func TestAddColorPatternsTrimsMultipleOptionsAndKeepsOrder(t *testing.T) {
 	withReset(t)
-	setColorMode("always")
-
-	parseColorPatterns([]string{" red:ERROR , green:DEBUG ", "cyan:日本語"})
+	h := userHighlighter(colorAlways, " red:ERROR , green:DEBUG ", "cyan:日本語")
 
-	if got, want := len(colorPatterns), 3; got != want {
-		t.Fatalf("len(colorPatterns) = %d, want %d", got, want)
+	if got, want := len(h.user), 3; got != want {
+		t.Fatalf("len(h.user) = %d, want %d", got, want)
 	}
-	for i, pattern := range colorPatterns {
+	for i, pattern := range h.user {
 		if pattern.Order != i {
 			t.Fatalf("pattern %d order = %d, want %d", i, pattern.Order, i)
 		}
 	}
 
-	got := applyColorPatterns("ERROR DEBUG 日本語")
+	got := h.Highlight("ERROR DEBUG 日本語")
 	want := ansi("31", "ERROR") + " " + ansi("32", "DEBUG") + " " + ansi("36", "日本語")
 	if got != want {
 		t.Fatalf("colored output = %q, want %q", got, want)
 	}
 }
 
-func TestParseColorPatternsAllowsCommasInsideRegex(t *testing.T) {
+func TestAddColorPatternsAllowsCommasInsideRegex(t *testing.T) {
 	withReset(t)
-	setColorMode("always")
+	h := userHighlighter(colorAlways, `red:\d{2,4}`, `green:DEBUG,yellow:WARN`)
 
-	parseColorPatterns([]string{`red:\d{2,4}`, `green:DEBUG,yellow:WARN`})
-
-	if got, want := len(colorPatterns), 3; got != want {
-		t.Fatalf("len(colorPatterns) = %d, want %d", got, want)
+	if got, want := len(h.user), 3; got != want {
+		t.Fatalf("len(h.user) = %d, want %d", got, want)
 	}
-	if got, want := colorPatterns[0].Pattern.String(), `\d{2,4}`; got != want {
+	if got, want := h.user[0].Pattern.String(), `\d{2,4}`; got != want {
 		t.Fatalf("first pattern = %q, want %q", got, want)
 	}
 
-	got := applyColorPatterns("12 1234 DEBUG WARN")
+	got := h.Highlight("12 1234 DEBUG WARN")
 	requireContains(t, got, ansi("31", "12"))
 	requireContains(t, got, ansi("32", "DEBUG"))
 	requireContains(t, got, ansi("33", "WARN"))
 }
 
-func TestParseColorPatternsLogsInvalidEntries(t *testing.T) {
+func TestAddColorPatternsLogsInvalidEntries(t *testing.T) {
 	withReset(t)
 
+	h := newHighlighter(colorAuto)
 	logs := captureLogOutput(t, func() {
-		parseColorPatterns([]string{
+		h.addColorPatterns([]string{
 			"orange:ERROR, blue:, yellow:[",
 			"magenta:成功",
 			"bad-format",
 		})
 	})
 
-	if got, want := len(colorPatterns), 1; got != want {
-		t.Fatalf("len(colorPatterns) = %d, want %d", got, want)
+	if got, want := len(h.user), 1; got != want {
+		t.Fatalf("len(h.user) = %d, want %d", got, want)
 	}
-	if got := colorPatterns[0].Pattern.String(); got != "成功" {
+	if got := h.user[0].Pattern.String(); got != "成功" {
 		t.Fatalf("valid pattern = %q, want %q", got, "成功")
 	}
 
@@ -279,75 +304,71 @@ func TestParseColorPatternsLogsInvalidEntries(t *testing.T) {
 	}
 }
 
-func TestApplyColorPatterns(t *testing.T) {
+func TestHighlight(t *testing.T) {
 	t.Run("no patterns returns original text", func(t *testing.T) {
 		withReset(t)
+		h := newHighlighter(colorAlways)
 
-		got := applyColorPatterns("2026-06-21 INFO 起動しました")
+		got := h.Highlight("2026-06-21 INFO 起動しました")
 		if got != "2026-06-21 INFO 起動しました" {
-			t.Fatalf("applyColorPatterns = %q", got)
+			t.Fatalf("Highlight = %q", got)
 		}
 	})
 
 	t.Run("no matches returns original text", func(t *testing.T) {
 		withReset(t)
-		setColorMode("always")
-		parseColorPatterns([]string{"red:ERROR"})
+		h := userHighlighter(colorAlways, "red:ERROR")
 
-		got := applyColorPatterns("2026-06-21 INFO 起動しました")
+		got := h.Highlight("2026-06-21 INFO 起動しました")
 		if got != "2026-06-21 INFO 起動しました" {
-			t.Fatalf("applyColorPatterns = %q", got)
+			t.Fatalf("Highlight = %q", got)
 		}
 	})
 
 	t.Run("colors adjacent English and Japanese matches", func(t *testing.T) {
 		withReset(t)
-		setColorMode("always")
-		parseColorPatterns([]string{"red:ERROR", "yellow:WARN", "cyan:ユーザー[0-9]+"})
+		h := userHighlighter(colorAlways, "red:ERROR", "yellow:WARN", "cyan:ユーザー[0-9]+")
 
-		got := applyColorPatterns("ERROR WARN ユーザー123 正常")
+		got := h.Highlight("ERROR WARN ユーザー123 正常")
 		want := ansi("31", "ERROR") + " " + ansi("33", "WARN") + " " + ansi("36", "ユーザー123") + " 正常"
 		if got != want {
-			t.Fatalf("applyColorPatterns = %q, want %q", got, want)
+			t.Fatalf("Highlight = %q, want %q", got, want)
 		}
 	})
 
 	t.Run("later overlapping pattern wins even when narrower", func(t *testing.T) {
 		withReset(t)
-		setColorMode("always")
-		parseColorPatterns([]string{"red:ERROR 詳細", "green:ERROR"})
+		h := userHighlighter(colorAlways, "red:ERROR 詳細", "green:ERROR")
 
-		got := applyColorPatterns("ERROR 詳細")
+		got := h.Highlight("ERROR 詳細")
 		want := ansi("32", "ERROR") + " 詳細"
 		if got != want {
-			t.Fatalf("applyColorPatterns = %q, want %q", got, want)
+			t.Fatalf("Highlight = %q, want %q", got, want)
 		}
 	})
 
 	t.Run("ignores zero length regexp matches", func(t *testing.T) {
 		withReset(t)
-		setColorMode("always")
-		parseColorPatterns([]string{"red:^", "green:INFO"})
+		h := userHighlighter(colorAlways, "red:^", "green:INFO")
 
-		got := applyColorPatterns("INFO")
+		got := h.Highlight("INFO")
 		want := ansi("32", "INFO")
 		if got != want {
-			t.Fatalf("applyColorPatterns = %q, want %q", got, want)
+			t.Fatalf("Highlight = %q, want %q", got, want)
 		}
 	})
 }
 
-func TestApplyColorPatternsDoesNotCorruptJapaneseLogs(t *testing.T) {
+func TestHighlightDoesNotCorruptJapaneseLogs(t *testing.T) {
 	withReset(t)
-	setColorMode("always")
-	parseColorPatterns([]string{
+	h := userHighlighter(colorAlways,
 		"brightcyan:ユーザー登録",
 		"brightred:失敗しました",
 		"green:処理が完了しました",
-	})
+	)
 
 	text := "2026-06-21 10:00:02 ERROR ユーザー登録に失敗しました"
-	got := applyColorPatterns(text)
+	got := h.Highlight(text)
 
 	requireContains(t, got, ansi("96", "ユーザー登録"))
 	requireContains(t, got, ansi("91", "失敗しました"))
@@ -357,13 +378,228 @@ func TestApplyColorPatternsDoesNotCorruptJapaneseLogs(t *testing.T) {
 	}
 }
 
+// 高速化前の色付け処理。新しい実装と出力が一致することの確認と性能比較に使う。
+func colorizeReference(text string, patterns []ColorPattern, record func(order, n int)) string {
+	if len(patterns) == 0 {
+		return text
+	}
+
+	type refMatch struct {
+		start int
+		end   int
+		color *color.Color
+		order int
+		fill  bool // 行全体の色: 優先されるマッチを避けて隙間だけを塗る
+	}
+
+	var allMatches []refMatch
+	for _, pattern := range patterns {
+		spans, found := pattern.spans(text)
+		for _, span := range spans {
+			matched := text[span[0]:span[1]]
+			if pattern.Scope == scopeLine && len(pattern.Palette) > 0 {
+				// 行全体の色は、行全体ではなく一致した文字列から決める
+				matched = pattern.Pattern.FindString(text)
+			}
+			allMatches = append(allMatches, refMatch{
+				start: span[0],
+				end:   span[1],
+				color: pattern.colorFor(matched),
+				order: pattern.Order,
+				fill:  pattern.Scope == scopeLine,
+			})
+		}
+		if record != nil {
+			record(pattern.Order, found)
+		}
+	}
+	if len(allMatches) == 0 {
+		return text
+	}
+
+	// 重複する場合は、後から指定されたパターンを優先する。
+	sort.SliceStable(allMatches, func(i, j int) bool {
+		if allMatches[i].order != allMatches[j].order {
+			return allMatches[i].order > allMatches[j].order
+		}
+		if allMatches[i].end-allMatches[i].start != allMatches[j].end-allMatches[j].start {
+			return allMatches[i].end-allMatches[i].start > allMatches[j].end-allMatches[j].start
+		}
+		return allMatches[i].start < allMatches[j].start
+	})
+
+	// 既に確定した範囲と重ならない部分に分割する
+	uncovered := func(match refMatch, taken []refMatch) []refMatch {
+		sorted := append([]refMatch(nil), taken...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+		var pieces []refMatch
+		pos := match.start
+		for _, t := range sorted {
+			if t.end <= pos || t.start >= match.end {
+				continue
+			}
+			if t.start > pos {
+				piece := match
+				piece.start, piece.end = pos, t.start
+				pieces = append(pieces, piece)
+			}
+			pos = t.end
+		}
+		if pos < match.end {
+			piece := match
+			piece.start = pos
+			pieces = append(pieces, piece)
+		}
+		return pieces
+	}
+
+	var finalMatches []refMatch
+	for _, match := range allMatches {
+		if match.fill {
+			finalMatches = append(finalMatches, uncovered(match, finalMatches)...)
+			continue
+		}
+		overlaps := false
+		for _, existing := range finalMatches {
+			if (match.start >= existing.start && match.start < existing.end) ||
+				(match.end > existing.start && match.end <= existing.end) ||
+				(match.start <= existing.start && match.end >= existing.end) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			finalMatches = append(finalMatches, match)
+		}
+	}
+	sort.Slice(finalMatches, func(i, j int) bool {
+		return finalMatches[i].start < finalMatches[j].start
+	})
+
+	var result strings.Builder
+	lastEnd := 0
+	for _, match := range finalMatches {
+		result.WriteString(text[lastEnd:match.start])
+		result.WriteString(match.color.Sprint(text[match.start:match.end]))
+		lastEnd = match.end
+	}
+	result.WriteString(text[lastEnd:])
+
+	return result.String()
+}
+
+// 比較に使うパターン。リテラルで絞り込めるもの・絞り込めないもの・範囲指定・hash を混ぜる。
+var benchmarkColorSpecs = []string{
+	"red:ERROR", "yellow:WARN(ING)?", "green:INFO", "blue:DEBUG", "magenta:TRACE",
+	"bold+red:FATAL|PANIC|panic:", "cyan:\\bGET\\b|\\bPOST\\b", "underline:https?://\\S+",
+	"yellow:\\d+ms", "red:(?i)timeout", "green:\\bok\\b", "blue:user=\\w+",
+	"magenta:session [0-9a-f]{8}", "cyan:\\d{4}-\\d{2}-\\d{2}", "white:nope",
+	"bold:retry(ing)?", "red+line:Exception", "cyan+group:took (?P<hl>\\d+)ms", "hash:req-[0-9a-f]+",
+	"green:(a|b)+c", "yellow:[A-Z]{5,}", "blue:\\[(\\w+)\\]", "magenta:connection (refused|reset)",
+	"red:disk full", "cyan:\\bid=\\d+", "green:success", "yellow:slow query", "bold+blue:^\\S+",
+	"red:status=5\\d\\d", "green:status=2\\d\\d", "brightred:x{0,3}$",
+}
+
+var benchmarkLines = []string{
+	"2024-05-01 12:00:01 INFO  user=alice GET /api/items took 12ms req-42af ok",
+	"2024-05-01 12:00:02 WARN  slow query took 950ms req-9c01 session deadbeef",
+	"2024-05-01 12:00:03 ERROR connection refused to db:5432 id=17 status=503",
+	"2024-05-01 12:00:04 DEBUG [worker] retrying in 5ms (attempt 2) Timeout",
+	"panic: runtime error: index out of range [3] with length 3",
+	"java.lang.IllegalStateException: disk full at https://example.com/x?a=1",
+	"plain line without anything interesting in it at all",
+	"ログ: 処理が完了しました success req-0001 took 3ms",
+	"",
+	"aac bbc abababc WARNING TRACE FATAL status=200 xxx",
+}
+
+func TestColorizeMatchesReference(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm-256color")
+	t.Setenv("COLORTERM", "")
+	h := userHighlighter(colorAlways, benchmarkColorSpecs...)
+	if len(h.user) != len(benchmarkColorSpecs) {
+		t.Fatalf("parsed %d of %d patterns", len(h.user), len(benchmarkColorSpecs))
+	}
+	h.enableAutoHighlight()
+	h.enableLevelColors()
+	sets := map[string][]ColorPattern{
+		"user":  h.user,
+		"auto":  h.auto,
+		"level": h.levels,
+		"all":   h.patterns(),
+	}
+
+	for name, patterns := range sets {
+		for _, line := range benchmarkLines {
+			var gotCounts, wantCounts []string
+			got := colorize(line, patterns, func(order, n int) { gotCounts = append(gotCounts, fmt.Sprint(order, ":", n)) })
+			want := colorizeReference(line, patterns, func(order, n int) { wantCounts = append(wantCounts, fmt.Sprint(order, ":", n)) })
+			if got != want {
+				t.Errorf("%s: colorize(%q)\n got  %q\n want %q", name, line, got, want)
+			}
+			if strings.Join(gotCounts, ",") != strings.Join(wantCounts, ",") {
+				t.Errorf("%s: counts for %q = %v, want %v", name, line, gotCounts, wantCounts)
+			}
+		}
+	}
+}
+
+func TestRequiredLiterals(t *testing.T) {
+	tests := []struct {
+		regex string
+		want  []string
+	}{
+		{"ERROR", []string{"ERROR"}},
+		{"ERROR|FATAL", []string{"ERROR", "FATAL"}},
+		{"took (?P<hl>\\d+)ms", []string{"took "}},
+		{"(?i)timeout", nil},
+		{"\\d+ms", []string{"ms"}},
+		{"x{0,3}$", nil},
+		{"(ab)+c", []string{"ab"}},
+		{"WARN(ING)?", []string{"WARN"}},
+	}
+	for _, tt := range tests {
+		re, err := syntax.Parse(tt.regex, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := requiredLiterals(re); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("requiredLiterals(%q) = %q, want %q", tt.regex, got, tt.want)
+		}
+	}
+}
+
+func benchmarkColorize(b *testing.B, fn func(string, []ColorPattern, func(order, n int)) string) {
+	resetTestState()
+	b.Setenv("TERM", "xterm-256color")
+	b.Setenv("COLORTERM", "")
+	h := userHighlighter(colorAlways, benchmarkColorSpecs...)
+	patterns := h.user
+	record := func(order, n int) {}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fn(benchmarkLines[i%len(benchmarkLines)], patterns, record)
+	}
+	b.StopTimer()
+	resetTestState()
+}
+
+func BenchmarkColorize(b *testing.B) {
+	benchmarkColorize(b, colorize)
+}
+
+func BenchmarkColorizeReference(b *testing.B) {
+	benchmarkColorize(b, colorizeReference)
+}
+
 func TestPrintLineAppliesColorsToJapaneseText(t *testing.T) {
 	withReset(t)
-	setColorMode("always")
-	parseColorPatterns([]string{"yellow:注意"})
+	h := userHighlighter(colorAlways, "yellow:注意")
 
-	out := captureStdout(t, func() {
-		printLine("WARN 注意してください")
+	out := captureOutput(t, func() {
+		printLine(h, "WARN 注意してください")
 	})
 
 	want := "WARN " + ansi("33", "注意") + "してください\n"
@@ -374,11 +610,10 @@ func TestPrintLineAppliesColorsToJapaneseText(t *testing.T) {
 
 func TestPrintLineTrimsCRBeforeApplyingAnchoredColors(t *testing.T) {
 	withReset(t)
-	setColorMode("always")
-	parseColorPatterns([]string{`red:ERROR$`})
+	h := userHighlighter(colorAlways, `red:ERROR$`)
 
-	out := captureStdout(t, func() {
-		printLine("2026-06-21 ERROR\r")
+	out := captureOutput(t, func() {
+		printLine(h, "2026-06-21 ERROR\r")
 	})
 
 	if out != "2026-06-21 "+ansi("31", "ERROR")+"\n" {
@@ -386,33 +621,67 @@ func TestPrintLineTrimsCRBeforeApplyingAnchoredColors(t *testing.T) {
 	}
 }
 
-func TestPrintLastNWithTrailingNewline(t *testing.T) {
-	withReset(t)
-
-	path := filepath.Join(t.TempDir(), "app.log")
-	content := "one\ntwo\nthree\n"
-	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+// startFollower で追従を始め、読み始める位置が決まるまで待つ。この後に追記した行は追従中の行として届く。
+// inotify はファイルの末尾まで読んでから監視を始めるので、その間の追記は次の書き込みまで届かない。
+// テストでは監視の開始を待てないため、サイズを比べるポーリングにする (空のファイルでは比べられないので 1 行以上書いておく)。
+func startTestFollower(t *testing.T, h *Highlighter, path string, n int) followState {
+	t.Helper()
+	cfg := followerConfig(trail.File(path), n)
+	cfg.Poll = true
+	_, state, err := startFollower(h, cfg)
+	if err != nil {
 		t.Fatal(err)
 	}
+	t.Cleanup(func() { stopFollow(state) })
+	select {
+	case <-state.started:
+	case err := <-state.errCh:
+		t.Fatalf("follower stopped before starting: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower did not start")
+	}
+	return state
+}
 
-	var offset int64
-	out := captureStdout(t, func() {
-		var err error
-		offset, err = printLastN(path, 3)
+// 追従を止め、Follower がエラーで終わっていないことを確かめる
+func stopTestFollower(t *testing.T, state followState) {
+	t.Helper()
+	if err := state.tail.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-state.errCh:
 		if err != nil {
 			t.Fatal(err)
 		}
-	})
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower did not stop")
+	}
+}
 
-	if out != content {
-		t.Fatalf("printLastN output = %q, want %q", out, content)
+// 末尾の n 行に続けて、追記した行が重複も欠けもなく出力される (続きを読み始める位置が正しい) ことを確かめる
+func requireLastLinesThenFollow(t *testing.T, path string, n int, want string) {
+	t.Helper()
+	wait := memoryOutput(t)
+	startTestFollower(t, nil, path, n)
+	appendToFile(t, path, "appended\n")
+	if got := wait("appended\n"); got != want+"appended\n" {
+		t.Fatalf("output = %q, want %q", got, want+"appended\n")
 	}
-	if offset != int64(len(content)) {
-		t.Fatalf("offset = %d, want %d", offset, len(content))
+}
+
+func TestFollowLastLinesWithTrailingNewline(t *testing.T) {
+	withReset(t)
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	content := "one\ntwo\nthree\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
 	}
+	requireLastLinesThenFollow(t, path, 3, content)
 }
 
-func TestPrintLastNHandlesJapaneseCRLFWithoutTrailingNewline(t *testing.T) {
+func TestFollowLastLinesHandlesJapaneseCRLFWithoutTrailingNewline(t *testing.T) {
 	withReset(t)
 
 	path := filepath.Join(t.TempDir(), "jp.log")
@@ -426,26 +695,11 @@ func TestPrintLastNHandlesJapaneseCRLFWithoutTrailingNewline(t *testing.T) {
 	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
 		t.Fatal(err)
 	}
-
-	var offset int64
-	out := captureStdout(t, func() {
-		var err error
-		offset, err = printLastN(path, 2)
-		if err != nil {
-			t.Fatal(err)
-		}
-	})
-
-	want := lines[2] + "\n" + lines[3] + "\n"
-	if out != want {
-		t.Fatalf("printLastN output = %q, want %q", out, want)
-	}
-	if offset != int64(len([]byte(content))) {
-		t.Fatalf("offset = %d, want %d", offset, len([]byte(content)))
-	}
+	// 改行の無い最後の行の続きは、その終わりから始まる行として届く
+	requireLastLinesThenFollow(t, path, 2, lines[2]+"\n"+lines[3]+"\n")
 }
 
-func TestPrintLastNVariants(t *testing.T) {
+func TestFollowLastLinesVariants(t *testing.T) {
 	t.Run("n larger than file prints all lines", func(t *testing.T) {
 		withReset(t)
 
@@ -454,19 +708,7 @@ func TestPrintLastNVariants(t *testing.T) {
 		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
 			t.Fatal(err)
 		}
-
-		out := captureStdout(t, func() {
-			offset, err := printLastN(path, 10)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if offset != int64(len(content)) {
-				t.Fatalf("offset = %d, want %d", offset, len(content))
-			}
-		})
-		if out != content {
-			t.Fatalf("printLastN output = %q, want %q", out, content)
-		}
+		requireLastLinesThenFollow(t, path, 10, content)
 	})
 
 	t.Run("huge n with small file does not preallocate huge ring", func(t *testing.T) {
@@ -477,62 +719,46 @@ func TestPrintLastNVariants(t *testing.T) {
 		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
 			t.Fatal(err)
 		}
-
-		out := captureStdout(t, func() {
-			offset, err := printLastN(path, 1_000_000_000)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if offset != int64(len(content)) {
-				t.Fatalf("offset = %d, want %d", offset, len(content))
-			}
-		})
-		if out != content {
-			t.Fatalf("printLastN output = %q, want %q", out, content)
-		}
+		requireLastLinesThenFollow(t, path, 1_000_000_000, content)
 	})
 
 	t.Run("n zero prints nothing and seeks to end", func(t *testing.T) {
 		withReset(t)
 
 		path := filepath.Join(t.TempDir(), "zero.log")
-		content := "alpha\nbeta\n"
-		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		if err := os.WriteFile(path, []byte("alpha\nbeta\n"), 0644); err != nil {
 			t.Fatal(err)
 		}
-
-		out := captureStdout(t, func() {
-			offset, err := printLastN(path, 0)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if offset != int64(len(content)) {
-				t.Fatalf("offset = %d, want %d", offset, len(content))
-			}
-		})
-		if out != "" {
-			t.Fatalf("printLastN output = %q, want empty", out)
-		}
+		requireLastLinesThenFollow(t, path, 0, "")
 	})
 
 	t.Run("missing file returns error", func(t *testing.T) {
 		withReset(t)
 
-		out := captureStdout(t, func() {
-			if _, err := printLastN(filepath.Join(t.TempDir(), "missing.log"), 1); err == nil {
-				t.Fatal("printLastN missing file error = nil")
+		out := captureOutput(t, func() {
+			_, state, err := startFollower(nil, followerConfig(trail.File(filepath.Join(t.TempDir(), "missing.log")), 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-state.errCh:
+				if err == nil {
+					t.Fatal("follower error for a missing file = nil")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("follower did not stop")
 			}
 		})
 		if out != "" {
-			t.Fatalf("printLastN output = %q, want empty", out)
+			t.Fatalf("output = %q, want empty", out)
 		}
 	})
 }
 
-func TestStartFollowPrintsAppendedJapaneseLinesWithColors(t *testing.T) {
+func TestFollowPrintsAppendedJapaneseLinesWithColors(t *testing.T) {
 	withReset(t)
-	setColorMode("always")
-	parseColorPatterns([]string{"red:ERROR", "cyan:成功"})
+	wait := memoryOutput(t)
+	h := userHighlighter(colorAlways, "red:ERROR", "cyan:成功")
 
 	path := filepath.Join(t.TempDir(), "follow.log")
 	initial := "2026-06-21 10:00:00 INFO 既存行\n"
@@ -540,42 +766,22 @@ func TestStartFollowPrintsAppendedJapaneseLinesWithColors(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	out := captureStdout(t, func() {
-		tailed, errCh, err := startFollow(path, int64(len([]byte(initial))))
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		time.Sleep(100 * time.Millisecond)
-		appendToFile(t, path, "2026-06-21 10:00:01 ERROR 失敗しました\n")
-		appendToFile(t, path, "2026-06-21 10:00:02 INFO 成功しました\n")
-		time.Sleep(1200 * time.Millisecond)
-
-		if err := tailed.Stop(); err != nil {
-			t.Fatal(err)
-		}
-		select {
-		case err, ok := <-errCh:
-			if ok && err != nil {
-				t.Fatal(err)
-			}
-		case <-time.After(2 * time.Second):
-			t.Fatal("tail did not stop")
-		}
-		tailed.Cleanup()
-	})
+	state := startTestFollower(t, h, path, 0)
+	appendToFile(t, path, "2026-06-21 10:00:01 ERROR 失敗しました\n")
+	appendToFile(t, path, "2026-06-21 10:00:02 INFO 成功しました\n")
+	out := wait(ansi("36", "成功") + "しました\n")
+	stopTestFollower(t, state)
 
 	requireNotContains(t, out, "既存行")
 	requireContains(t, out, ansi("31", "ERROR"))
-	requireContains(t, out, ansi("36", "成功"))
 	requireContains(t, stripANSI(out), "2026-06-21 10:00:01 ERROR 失敗しました\n")
 	requireContains(t, stripANSI(out), "2026-06-21 10:00:02 INFO 成功しました\n")
 }
 
-func TestStartFollowTrimsCRLFBeforeAnchoredColorMatch(t *testing.T) {
+func TestFollowTrimsCRLFBeforeAnchoredColorMatch(t *testing.T) {
 	withReset(t)
-	setColorMode("always")
-	parseColorPatterns([]string{`red:ERROR$`})
+	wait := memoryOutput(t)
+	h := userHighlighter(colorAlways, `red:ERROR$`)
 
 	path := filepath.Join(t.TempDir(), "follow-crlf.log")
 	initial := "2026-06-21 10:00:00 INFO start\r\n"
@@ -583,117 +789,16 @@ func TestStartFollowTrimsCRLFBeforeAnchoredColorMatch(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	out := captureStdout(t, func() {
-		tailed, errCh, err := startFollow(path, int64(len([]byte(initial))))
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		time.Sleep(100 * time.Millisecond)
-		appendToFile(t, path, "2026-06-21 10:00:01 ERROR\r\n")
-		time.Sleep(1200 * time.Millisecond)
-
-		if err := tailed.Stop(); err != nil {
-			t.Fatal(err)
-		}
-		select {
-		case err, ok := <-errCh:
-			if ok && err != nil {
-				t.Fatal(err)
-			}
-		case <-time.After(2 * time.Second):
-			t.Fatal("tail did not stop")
-		}
-		tailed.Cleanup()
-	})
+	state := startTestFollower(t, h, path, 0)
+	appendToFile(t, path, "2026-06-21 10:00:01 ERROR\r\n")
+	out := wait("\n")
+	stopTestFollower(t, state)
 
 	if out != "2026-06-21 10:00:01 "+ansi("31", "ERROR")+"\n" {
 		t.Fatalf("follow output = %q", out)
 	}
 }
 
-func TestNewestFileWithPatternSelectsNewestMatchingRegularFile(t *testing.T) {
-	dir := t.TempDir()
-	base := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
-
-	oldLog := filepath.Join(dir, "app-1.log")
-	newLog := filepath.Join(dir, "app-2.log")
-	newerNonMatch := filepath.Join(dir, "notes.txt")
-	matchingDir := filepath.Join(dir, "app-3.log")
-
-	writeFileAt(t, oldLog, "old", base)
-	writeFileAt(t, newLog, "new", base.Add(1*time.Minute))
-	writeFileAt(t, newerNonMatch, "newer but not log", base.Add(2*time.Minute))
-	if err := os.Mkdir(matchingDir, 0755); err != nil {
-		t.Fatal(err)
-	}
-
-	got, err := newestFileWithPattern(dir, "app-*.log")
-	if err != nil {
-		t.Fatal(err)
-	}
-	if got != newLog {
-		t.Fatalf("newestFileWithPattern = %q, want %q", got, newLog)
-	}
-
-	got, err = newestFile(dir)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if got != newerNonMatch {
-		t.Fatalf("newestFile = %q, want %q", got, newerNonMatch)
-	}
-}
-
-func TestNewestFileWithPatternUsesLiteralGlobMatching(t *testing.T) {
-	dir := t.TempDir()
-	oldFile := filepath.Join(dir, "app+1.log")
-	newFile := filepath.Join(dir, "app+2.log")
-	baseTime := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
-
-	writeFileAt(t, oldFile, "old", baseTime)
-	writeFileAt(t, newFile, "new", baseTime.Add(time.Minute))
-
-	got, err := newestFileWithPattern(dir, "app+*.log")
-	if err != nil {
-		t.Fatal(err)
-	}
-	if got != newFile {
-		t.Fatalf("newestFileWithPattern = %q, want %q", got, newFile)
-	}
-}
-
-func TestNewestFileWithPatternErrors(t *testing.T) {
-	t.Run("no matching file", func(t *testing.T) {
-		dir := t.TempDir()
-		writeFileAt(t, filepath.Join(dir, "app.txt"), "text", time.Now())
-
-		_, err := newestFileWithPattern(dir, "*.log")
-		if err == nil {
-			t.Fatal("newestFileWithPattern error = nil")
-		}
-		requireContains(t, err.Error(), "no files matching pattern")
-	})
-
-	t.Run("invalid glob pattern", func(t *testing.T) {
-		dir := t.TempDir()
-		writeFileAt(t, filepath.Join(dir, "app.log"), "log", time.Now())
-
-		_, err := newestFileWithPattern(dir, "[")
-		if err == nil {
-			t.Fatal("newestFileWithPattern error = nil")
-		}
-		requireContains(t, err.Error(), "invalid pattern")
-	})
-
-	t.Run("missing directory", func(t *testing.T) {
-		_, err := newestFileWithPattern(filepath.Join(t.TempDir(), "missing"), "*")
-		if err == nil {
-			t.Fatal("newestFileWithPattern error = nil")
-		}
-	})
-}
-
 type fakeFollowHandle struct {
 	stopped bool
 	cleaned bool
@@ -708,100 +813,6 @@ func (f *fakeFollowHandle) Cleanup() {
 	f.cleaned = true
 }
 
-func TestSwitchFollowToLatestKeepsCurrentOnFailures(t *testing.T) {
-	t.Run("print last lines fails", func(t *testing.T) {
-		withReset(t)
-		oldTail := &fakeFollowHandle{}
-		state := followState{path: "old.log", tail: oldTail}
-
-		got := switchFollowToLatest(
-			state,
-			"new.log",
-			10,
-			func(string, int) (int64, error) {
-				return 0, errors.New("missing")
-			},
-			func(string, int64) (followHandle, <-chan error, error) {
-				t.Fatal("startFollow should not be called")
-				return nil, nil, nil
-			},
-		)
-
-		if got.path != state.path || got.tail != state.tail {
-			t.Fatalf("state = %+v, want unchanged %+v", got, state)
-		}
-		if oldTail.stopped || oldTail.cleaned {
-			t.Fatalf("old tail stopped=%v cleaned=%v, want both false", oldTail.stopped, oldTail.cleaned)
-		}
-	})
-
-	t.Run("start follow fails", func(t *testing.T) {
-		withReset(t)
-		oldTail := &fakeFollowHandle{}
-		state := followState{path: "old.log", tail: oldTail}
-
-		got := switchFollowToLatest(
-			state,
-			"new.log",
-			10,
-			func(path string, n int) (int64, error) {
-				if path != "new.log" || n != 10 {
-					t.Fatalf("printLastN args = (%q, %d)", path, n)
-				}
-				return 42, nil
-			},
-			func(path string, offset int64) (followHandle, <-chan error, error) {
-				if path != "new.log" || offset != 42 {
-					t.Fatalf("startFollow args = (%q, %d)", path, offset)
-				}
-				return nil, nil, errors.New("gone")
-			},
-		)
-
-		if got.path != state.path || got.tail != state.tail {
-			t.Fatalf("state = %+v, want unchanged %+v", got, state)
-		}
-		if oldTail.stopped || oldTail.cleaned {
-			t.Fatalf("old tail stopped=%v cleaned=%v, want both false", oldTail.stopped, oldTail.cleaned)
-		}
-	})
-}
-
-func TestSwitchFollowToLatestUpdatesOnlyAfterNewFollowStarts(t *testing.T) {
-	withReset(t)
-	oldTail := &fakeFollowHandle{}
-	newTail := &fakeFollowHandle{}
-	oldErrCh := make(chan error)
-	close(oldErrCh)
-	newErrCh := make(chan error)
-	state := followState{path: "old.log", tail: oldTail, errCh: oldErrCh}
-
-	got := switchFollowToLatest(
-		state,
-		"new.log",
-		3,
-		func(path string, n int) (int64, error) {
-			if path != "new.log" || n != 3 {
-				t.Fatalf("printLastN args = (%q, %d)", path, n)
-			}
-			return 99, nil
-		},
-		func(path string, offset int64) (followHandle, <-chan error, error) {
-			if path != "new.log" || offset != 99 {
-				t.Fatalf("startFollow args = (%q, %d)", path, offset)
-			}
-			return newTail, newErrCh, nil
-		},
-	)
-
-	if got.path != "new.log" || got.tail != newTail || got.errCh != newErrCh {
-		t.Fatalf("state = %+v, want new follow", got)
-	}
-	if !oldTail.stopped || !oldTail.cleaned {
-		t.Fatalf("old tail stopped=%v cleaned=%v, want both true", oldTail.stopped, oldTail.cleaned)
-	}
-}
-
 func TestRepeatedStrings(t *testing.T) {
 	var nilRepeated *repeatedStrings
 	if got := nilRepeated.String(); got != "" {
@@ -846,8 +857,8 @@ func TestParseGlobalArgs(t *testing.T) {
 		if got, want := strings.Join(args, "\x00"), strings.Join([]string{"-pattern", "*.log", "logs"}, "\x00"); got != want {
 			t.Fatalf("args = %#v, want %#v", args, []string{"-pattern", "*.log", "logs"})
 		}
-		if selectedColorMode != colorAlways {
-			t.Fatalf("selectedColorMode = %q, want %q", selectedColorMode, colorAlways)
+		if opts.colorMode != colorAlways {
+			t.Fatalf("opts.colorMode = %q, want %q", opts.colorMode, colorAlways)
 		}
 	})
 
@@ -865,8 +876,21 @@ func TestParseGlobalArgs(t *testing.T) {
 		if got, want := strings.Join(args, "\x00"), "app.log"; got != want {
 			t.Fatalf("args = %#v, want [app.log]", args)
 		}
-		if selectedColorMode != colorNever {
-			t.Fatalf("selectedColorMode = %q, want %q", selectedColorMode, colorNever)
+		if opts.colorMode != colorNever {
+			t.Fatalf("opts.colorMode = %q, want %q", opts.colorMode, colorNever)
+		}
+	})
+
+	t.Run("highlight options stay in opts", func(t *testing.T) {
+		withReset(t)
+
+		opts, command, _ := parseGlobalArgs([]string{"--auto-highlight", "--no-level-colors", "--color=always", "file", "app.log"})
+
+		if command != "file" || !opts.autoHighlight || !opts.noLevelColors {
+			t.Fatalf("opts = %+v, command = %q, want both highlight options set", opts, command)
+		}
+		if !color.NoColor {
+			t.Fatal("--color=always changed color.NoColor")
 		}
 	})
 
@@ -902,7 +926,7 @@ func TestShowLogo(t *testing.T) {
 
 	t.Run("colored logo emits ANSI when forced", func(t *testing.T) {
 		withReset(t)
-		setColorMode("always")
+		color.NoColor = false
 
 		var buf bytes.Buffer
 		showLogo(&buf, true)
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"cmp"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// ---------- 色付けエンジン ----------
+//
+// パターンの一覧ごとに事前計算した matcher を使い回す。
+//   - 正規表現が必ず含む文字列 (リテラル) が行に無ければ、そのパターンは実行しない
+//   - リテラルを取り出せないパターンはまとめて 1 つの選択 (a|b|...) にし、どれも一致しない行では個別に実行しない
+//   - 重なりの判定は、確定した範囲を開始位置順に保ち二分探索で行う
+//   - 作業用のスライスは sync.Pool で再利用する
+
+// 色付けする範囲
+type colorMatch struct {
+	start int
+	end   int
+	color *color.Color
+	order int
+	fill  bool // 行全体の色: 優先されるマッチを避けて隙間だけを塗る
+
+	// HTML など端末以外へ書き出すときに使う
+	style  string // パターンのスタイル指定
+	hashed bool   // hash 指定の場合、key から色を決める
+	key    string
+}
+
+type matcherPattern struct {
+	ColorPattern
+	groups   []int    // group 指定時に色付けするグループ番号
+	literals []string // いずれかが行に含まれていなければ一致しない。nil なら絞り込まない
+}
+
+type matcher struct {
+	patterns []matcherPattern
+	// リテラルで絞り込めないパターンをまとめた正規表現。nil なら個別に実行する
+	combined *regexp.Regexp
+	// 色ごとのエスケープシーケンス。color.Sprint と同じ出力を毎回の書式化なしで作る
+	wraps   map[*color.Color][2]string
+	noColor bool
+}
+
+// 同時に使われるパターンの一覧は少数 (通常の出力と TUI の表示) なので、直近のものだけを保持する
+const matcherCacheSize = 4
+
+var (
+	matcherMu    sync.Mutex
+	matcherCache []*matcher
+)
+
+// パターンの一覧に対応する matcher を返す。初めての一覧なら作成する。
+func matcherFor(patterns []ColorPattern) *matcher {
+	matcherMu.Lock()
+	defer matcherMu.Unlock()
+	for i, m := range matcherCache {
+		if m.matches(patterns) {
+			if i > 0 {
+				copy(matcherCache[1:i+1], matcherCache[:i])
+				matcherCache[0] = m
+			}
+			return m
+		}
+	}
+	m := newMatcher(patterns)
+	if len(matcherCache) < matcherCacheSize {
+		matcherCache = append(matcherCache, nil)
+	}
+	copy(matcherCache[1:], matcherCache)
+	matcherCache[0] = m
+	return m
+}
+
+// 同じパターンの一覧から作られたものか
+func (m *matcher) matches(patterns []ColorPattern) bool {
+	if len(m.patterns) != len(patterns) || m.noColor != color.NoColor {
+		return false
+	}
+	for i, p := range patterns {
+		q := m.patterns[i].ColorPattern
+		if p.Pattern != q.Pattern || p.Color != q.Color || p.Order != q.Order || p.Scope != q.Scope ||
+			len(p.Palette) != len(q.Palette) || (len(p.Palette) > 0 && p.Palette[0] != q.Palette[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func newMatcher(patterns []ColorPattern) *matcher {
+	m := &matcher{
+		wraps:   make(map[*color.Color][2]string),
+		noColor: color.NoColor,
+	}
+	var unfiltered []*syntax.Regexp
+	for _, p := range patterns {
+		mp := matcherPattern{ColorPattern: p, groups: colorGroups(p.Pattern)}
+		if re, err := syntax.Parse(p.Pattern.String(), syntax.Perl); err == nil {
+			mp.literals = requiredLiterals(re)
+			if mp.literals == nil {
+				unfiltered = append(unfiltered, stripCaptures(re))
+			}
+		}
+		m.patterns = append(m.patterns, mp)
+
+		m.addWrap(p.Color)
+		for _, c := range p.Palette {
+			m.addWrap(c)
+		}
+	}
+	if len(unfiltered) > 1 {
+		alt := &syntax.Regexp{Op: syntax.OpAlternate, Sub: unfiltered}
+		if re, err := regexp.Compile(alt.String()); err == nil {
+			m.combined = re
+		}
+	}
+	return m
+}
+
+func (m *matcher) addWrap(c *color.Color) {
+	if c == nil {
+		return
+	}
+	if _, ok := m.wraps[c]; ok {
+		return
+	}
+	s := c.Sprint("\x00")
+	i := strings.IndexByte(s, 0)
+	m.wraps[c] = [2]string{s[:i], s[i+1:]}
+}
+
+// 作業用の領域
+type matcherScratch struct {
+	spans    [][2]int
+	all      []colorMatch
+	accepted []colorMatch // 開始位置順
+	buf      []byte
+}
+
+var matcherScratchPool = sync.Pool{New: func() any { return new(matcherScratch) }}
+
+func (m *matcher) colorize(text string, record func(order, n int)) string {
+	scratch := matcherScratchPool.Get().(*matcherScratch)
+	defer matcherScratchPool.Put(scratch)
+
+	accepted := m.resolve(text, record, scratch)
+	if len(accepted) == 0 {
+		return text
+	}
+	return m.renderANSI(text, accepted, scratch)
+}
+
+// 色付けした文字列と、色を付けた範囲 (開始位置順) を返す。HTML への書き出しなどに使う。
+func colorizeSpans(text string, patterns []ColorPattern, record func(order, n int)) (string, []colorMatch) {
+	if len(patterns) == 0 {
+		return text, nil
+	}
+	m := matcherFor(patterns)
+	// 範囲は呼び出し元に渡すので作業領域は使い回さない
+	scratch := new(matcherScratch)
+	accepted := m.resolve(text, record, scratch)
+	if len(accepted) == 0 {
+		return text, nil
+	}
+	return m.renderANSI(text, accepted, scratch), accepted
+}
+
+// 重なりを解決し、色を付ける範囲を開始位置順に返す
+func (m *matcher) resolve(text string, record func(order, n int), scratch *matcherScratch) []colorMatch {
+	// リテラルで絞り込めないパターンは、まとめた正規表現が一致したときだけ実行する
+	unfilteredMayMatch := m.combined == nil || m.combined.MatchString(text)
+
+	all := scratch.all[:0]
+	for i := range m.patterns {
+		p := &m.patterns[i]
+		if !p.mayMatch(text, unfilteredMayMatch) {
+			if record != nil {
+				record(p.Order, 0)
+			}
+			continue
+		}
+		spans, found := p.appendSpans(scratch.spans[:0], text, p.groups)
+		scratch.spans = spans
+		for _, span := range spans {
+			matched := text[span[0]:span[1]]
+			if p.Scope == scopeLine && len(p.Palette) > 0 {
+				// 行全体の色は、行全体ではなく一致した文字列から決める
+				matched = p.Pattern.FindString(text)
+			}
+			all = append(all, colorMatch{
+				start:  span[0],
+				end:    span[1],
+				color:  p.colorFor(matched),
+				order:  p.Order,
+				fill:   p.Scope == scopeLine,
+				style:  p.Style,
+				hashed: len(p.Palette) > 0,
+				key:    matched,
+			})
+		}
+		if record != nil {
+			record(p.Order, found)
+		}
+	}
+	scratch.all = all
+	if len(all) == 0 {
+		return nil
+	}
+
+	// 重複する場合は、後から指定されたパターンを優先する。
+	slices.SortStableFunc(all, func(a, b colorMatch) int {
+		if a.order != b.order {
+			return cmp.Compare(b.order, a.order)
+		}
+		if a.end-a.start != b.end-b.start {
+			return cmp.Compare(b.end-b.start, a.end-a.start)
+		}
+		return cmp.Compare(a.start, b.start)
+	})
+
+	accepted := scratch.accepted[:0]
+	for _, match := range all {
+		if match.fill {
+			accepted = insertUncovered(accepted, match)
+			continue
+		}
+		// match.start より後ろで終わる最初の範囲が match と重なるか調べる
+		i := sort.Search(len(accepted), func(i int) bool { return accepted[i].end > match.start })
+		if i < len(accepted) && accepted[i].start < match.end {
+			continue
+		}
+		accepted = slices.Insert(accepted, i, match)
+	}
+	scratch.accepted = accepted
+	return accepted
+}
+
+func (m *matcher) renderANSI(text string, accepted []colorMatch, scratch *matcherScratch) string {
+	buf := scratch.buf[:0]
+	lastEnd := 0
+	for _, match := range accepted {
+		buf = append(buf, text[lastEnd:match.start]...)
+		wrap := m.wraps[match.color]
+		buf = append(buf, wrap[0]...)
+		buf = append(buf, text[match.start:match.end]...)
+		buf = append(buf, wrap[1]...)
+		lastEnd = match.end
+	}
+	buf = append(buf, text[lastEnd:]...)
+	scratch.buf = buf
+	return string(buf)
+}
+
+// 確定した範囲と重ならない部分に分けて追加する
+func insertUncovered(accepted []colorMatch, match colorMatch) []colorMatch {
+	pos := match.start
+	i := sort.Search(len(accepted), func(i int) bool { return accepted[i].end > pos })
+	for pos < match.end {
+		if i >= len(accepted) || accepted[i].start >= match.end {
+			piece := match
+			piece.start = pos
+			return slices.Insert(accepted, i, piece)
+		}
+		if accepted[i].start > pos {
+			piece := match
+			piece.start, piece.end = pos, accepted[i].start
+			accepted = slices.Insert(accepted, i, piece)
+			i++
+		}
+		pos = accepted[i].end
+		i++
+	}
+	return accepted
+}
+
+// 行に一致する可能性があるか
+func (p *matcherPattern) mayMatch(text string, unfilteredMayMatch bool) bool {
+	if p.literals == nil {
+		return unfilteredMayMatch
+	}
+	for _, literal := range p.literals {
+		if strings.Contains(text, literal) {
+			return true
+		}
+	}
+	return false
+}
+
+// 一致するなら必ずどれかを含む文字列の一覧を返す。求められなければ nil。
+func requiredLiterals(re *syntax.Regexp) []string {
+	const maxLiterals = 8
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 || len(re.Rune) == 0 {
+			return nil
+		}
+		return []string{string(re.Rune)}
+	case syntax.OpCapture:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min < 1 {
+			return nil
+		}
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpConcat:
+		// 最も短いリテラルが最も長くなる要素を使う
+		var best []string
+		bestLen := 0
+		for _, sub := range re.Sub {
+			literals := requiredLiterals(sub)
+			if literals == nil {
+				continue
+			}
+			shortest := len(literals[0])
+			for _, l := range literals[1:] {
+				shortest = min(shortest, len(l))
+			}
+			if shortest > bestLen {
+				best, bestLen = literals, shortest
+			}
+		}
+		return best
+	case syntax.OpAlternate:
+		var all []string
+		for _, sub := range re.Sub {
+			literals := requiredLiterals(sub)
+			if literals == nil {
+				return nil
+			}
+			all = append(all, literals...)
+		}
+		if len(all) > maxLiterals {
+			return nil
+		}
+		return all
+	}
+	return nil
+}
+
+// グループ名の重複を避けるため、まとめる前にキャプチャを外す
+func stripCaptures(re *syntax.Regexp) *syntax.Regexp {
+	for re.Op == syntax.OpCapture {
+		re = re.Sub[0]
+	}
+	if len(re.Sub) == 0 {
+		return re
+	}
+	stripped := *re
+	stripped.Sub = make([]*syntax.Regexp, len(re.Sub))
+	for i, sub := range re.Sub {
+		stripped.Sub[i] = stripCaptures(sub)
+	}
+	return &stripped
+}
@@ -0,0 +1,225 @@
+package trail
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nxadm/tail"
+)
+
+// ファイルの末尾 n 行と、続きを読み始める位置を返す。
+// filter が nil でなければ、filter が false を返す行は数えずに n 行を集める。
+func LastLines(path string, n int, filter Filter) ([]Line, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	if n == 0 {
+		offset, err := f.Seek(0, io.SeekEnd)
+		return nil, offset, err
+	}
+
+	ring := make([]Line, 0, min(n, 1024))
+	count := 0
+	var pos int64
+	number := 0
+	reader := bufio.NewReader(f)
+	for {
+		text, err := reader.ReadString('\n')
+		if len(text) > 0 {
+			number++
+			line := Line{File: path, Offset: pos, Next: pos + int64(len(text)), Number: number, Text: strings.TrimRight(text, "\r\n")}
+			pos = line.Next
+			if filter == nil || filter(path, line.Text) {
+				if len(ring) < n {
+					ring = append(ring, line)
+				} else {
+					ring[count%n] = line
+				}
+				count++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	start := 0
+	if count > n {
+		start = count - n
+	}
+	lines := make([]Line, 0, count-start)
+	for i := start; i < count; i++ {
+		lines = append(lines, ring[i%len(ring)])
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	return lines, offset, err
+}
+
+// 1 つのファイルの追従の設定
+type TailConfig struct {
+	Poll        bool // 変更の通知を使わずにポーリングする (Windows など)
+	LineNumbers bool // 行番号を数える。開始位置までの行を数えるため読み込みが増える
+}
+
+// 1 つのファイルの追従。ローテーション (移動・切り詰め・再作成) にも追従する。
+type FileTail struct {
+	path   string
+	tail   *tail.Tail
+	events chan Event
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// offset の位置からファイルの追従を始める。
+// 読み取った行と出来事は Events に届く。Stop の後も Events が閉じるまで受け取ること。
+func TailFile(path string, offset int64, cfg TailConfig) (*FileTail, error) {
+	ft := &FileTail{
+		path:   path,
+		events: make(chan Event, 256),
+		done:   make(chan struct{}),
+	}
+	t, err := tail.TailFile(path, tail.Config{
+		Follow:    true,
+		ReOpen:    true, // ローテーション追従
+		MustExist: true,
+		Poll:      cfg.Poll,
+		Logger:    log.New(tailLogWriter{ft}, "", 0),
+		Location:  &tail.SeekInfo{Offset: offset, Whence: io.SeekStart},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ft.tail = t
+	go ft.run(offset, cfg.LineNumbers)
+	return ft, nil
+}
+
+func (ft *FileTail) Path() string {
+	return ft.path
+}
+
+// 行と出来事。追従が終わると閉じる。
+func (ft *FileTail) Events() <-chan Event {
+	return ft.events
+}
+
+// 追従をやめる。すでに Events に届いている行は受け取れる。
+// 変更の通知の監視も止まるので、nxadm/tail の Cleanup にあたる処理は要らない
+// (Stop の後に呼ぶと監視の数が合わなくなり、同じファイルを再び追従したときに通知が届かない)。
+func (ft *FileTail) Stop() error {
+	ft.stopOnce.Do(func() { close(ft.done) })
+	return ft.tail.Stop()
+}
+
+func (ft *FileTail) run(offset int64, numbers bool) {
+	defer close(ft.events)
+	number := 0
+	if numbers {
+		number = countLines(ft.path, offset)
+	}
+	for line := range ft.tail.Lines {
+		if line.Err != nil {
+			ft.send(Event{
+				Type:    EventError,
+				File:    ft.path,
+				Message: fmt.Sprintf("tail error for %s: %v", ft.path, line.Err),
+				Err:     line.Err,
+			})
+			return
+		}
+		// line.SeekInfo は行を読み終えた位置なので、改行の分も戻して行頭を求める
+		start := line.SeekInfo.Offset - int64(len(line.Text)) - 1
+		if line.SeekInfo.Offset-int64(len(line.Text)) == offset {
+			// 末尾の改行の無い (書きかけの) 行。前の行の続きが行頭になる
+			start = offset
+		}
+		if numbers {
+			if start < offset {
+				// 切り詰められて先頭から読み直している
+				number = countLines(ft.path, start)
+			}
+			number++
+		}
+		offset = line.SeekInfo.Offset
+		ft.send(Event{
+			Type: EventLine,
+			File: ft.path,
+			Time: line.Time,
+			Line: Line{
+				File:     ft.path,
+				Offset:   start,
+				Next:     offset,
+				Number:   number,
+				Received: line.Time,
+				Text:     strings.TrimRight(line.Text, "\r"),
+			},
+		})
+	}
+}
+
+// Stop の後は受け取られない可能性があるので捨てる
+func (ft *FileTail) send(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case ft.events <- ev:
+	case <-ft.done:
+	}
+}
+
+// nxadm/tail のログから切り詰め・再作成を読み取り、出来事として送る
+type tailLogWriter struct {
+	ft *FileTail
+}
+
+func (w tailLogWriter) Write(p []byte) (int, error) {
+	msg := string(p)
+	path := w.ft.path
+	switch {
+	case strings.HasPrefix(msg, "Re-opening truncated file"):
+		w.ft.send(Event{Type: EventTruncated, File: path, Message: "file truncated: " + path})
+	case strings.HasPrefix(msg, "Re-opening moved/deleted file"):
+		w.ft.send(Event{Type: EventReopened, File: path, Message: "file moved or deleted, reopening: " + path})
+	case strings.HasPrefix(msg, "Stopping tail as file no longer exists"):
+		w.ft.send(Event{Type: EventRemoved, File: path, Message: "file no longer exists: " + path})
+	}
+	return len(p), nil
+}
+
+// ファイルの先頭から offset までの行数
+func countLines(path string, offset int64) int {
+	if offset <= 0 {
+		return 0
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	buf := make([]byte, 64*1024)
+	lines := 0
+	for offset > 0 {
+		n, err := f.Read(buf[:min(int64(len(buf)), offset)])
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		offset -= int64(n)
+		if err != nil {
+			break
+		}
+	}
+	return lines
+}
@@ -0,0 +1,164 @@
+package trail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func appendToFile(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func lineTexts(lines []Line) []string {
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	return texts
+}
+
+func TestLastLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	content := "one\r\ntwo\nthree\nfour"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{0, nil},
+		{2, []string{"three", "four"}},
+		{10, []string{"one", "two", "three", "four"}},
+	}
+	for _, tt := range tests {
+		lines, offset, err := LastLines(path, tt.n, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := lineTexts(lines); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("LastLines(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+		if offset != int64(len(content)) {
+			t.Errorf("LastLines(%d) offset = %d, want %d", tt.n, offset, len(content))
+		}
+	}
+
+	lines, _, err := LastLines(path, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines[0].Offset != 9 || lines[0].Next != 15 || lines[0].Number != 3 || lines[1].Offset != 15 || lines[1].Next != 19 || lines[1].Number != 4 {
+		t.Errorf("LastLines positions = %+v", lines)
+	}
+
+	// Filter で隠れる行は数えない
+	lines, _, err = LastLines(path, 2, func(file, text string) bool { return file == path && strings.Contains(text, "o") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lineTexts(lines); strings.Join(got, "|") != "two|four" {
+		t.Errorf("LastLines with filter = %q, want [two four]", got)
+	}
+
+	if _, _, err := LastLines(filepath.Join(t.TempDir(), "missing.log"), 1, nil); err == nil {
+		t.Error("LastLines missing file error = nil")
+	}
+}
+
+func TestTailFileOffsetsOfLineWithoutNewline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("a\nbc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ft, err := TailFile(path, 0, TailConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.Stop()
+	next := func() Line {
+		t.Helper()
+		for {
+			select {
+			case ev := <-ft.Events():
+				if ev.Type == EventLine {
+					return ev.Line
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for a line")
+			}
+		}
+	}
+
+	want := []Line{{Text: "a", Offset: 0, Next: 2}, {Text: "bc", Offset: 2, Next: 4}, {Text: "d", Offset: 4, Next: 6}}
+	for i, w := range want {
+		if i == 2 {
+			// 書きかけの行の続きは、その終わりから始まる行として届く。
+			// nxadm/tail は書きかけの行を送った後で末尾へ移動するので、移動を待ってから追記する
+			time.Sleep(100 * time.Millisecond)
+			appendToFile(t, path, "d\n")
+		}
+		if got := next(); got.Text != w.Text || got.Offset != w.Offset || got.Next != w.Next {
+			t.Errorf("line %d = %q at %d..%d, want %q at %d..%d", i, got.Text, got.Offset, got.Next, w.Text, w.Offset, w.Next)
+		}
+	}
+}
+
+func TestTailFileSendsLinesAndTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	initial := "a\nb\n"
+	if err := os.WriteFile(path, []byte(initial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ft, err := TailFile(path, int64(len(initial)), TailConfig{LineNumbers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	appendToFile(t, path, "c\r\n")
+	time.Sleep(1200 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1200 * time.Millisecond)
+	if err := ft.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []Line
+	truncated := false
+	for ev := range ft.Events() {
+		switch ev.Type {
+		case EventLine:
+			lines = append(lines, ev.Line)
+		case EventTruncated:
+			truncated = ev.File == path
+		}
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("lines = %+v, want c and x", lines)
+	}
+	if got := lines[0]; got.Text != "c" || got.Offset != 4 || got.Number != 3 || got.Received.IsZero() {
+		t.Errorf("first line = %+v, want c at offset 4, line 3", got)
+	}
+	if got := lines[1]; got.Text != "x" || got.Offset != 0 || got.Number != 1 {
+		t.Errorf("line after truncation = %+v, want x at offset 0, line 1", got)
+	}
+	if !truncated {
+		t.Error("expected a truncated event")
+	}
+}
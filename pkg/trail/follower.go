@@ -0,0 +1,300 @@
+package trail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Follower の設定
+type Config struct {
+	// 追従するファイルの選び方。DirSelector ならディレクトリを監視して最新のファイルに切り替える
+	Selector Selector
+	// 追従を始める前 (とファイルの切り替え時) に表示する末尾の行数
+	Lines int
+	// DirSelector の選び直しの間隔 (変更の通知の取りこぼし対策)。0 なら 5 秒
+	Interval time.Duration
+	// 変更の通知を使わずにポーリングする
+	Poll bool
+	// 追従中の行にも行番号を付ける
+	LineNumbers bool
+	// nil でなければ Line.Colored をこれで色付けする
+	Highlighter Highlighter
+	// nil でなければ false を返す行を送らない
+	Filter Filter
+	// nil でなく、最初に選んだファイルが Resume.File と同じなら、末尾の行を送らずに
+	// Resume.Offset から読み始める。ファイルがそれより短い (切り詰められた) 場合は通常どおり始める。
+	Resume *Position
+}
+
+// Selector で選んだファイルの末尾の行を送り、追記された行を送り続ける
+type Follower struct {
+	cfg    Config
+	events chan Event
+	pin    chan string
+	done   chan struct{}
+
+	// テストで差し替える
+	lastLines func(path string, n int, filter Filter) ([]Line, int64, error)
+	tailFile  func(path string, offset int64, cfg TailConfig) (tailHandle, error)
+}
+
+type tailHandle interface {
+	Events() <-chan Event
+	Stop() error
+}
+
+func New(cfg Config) (*Follower, error) {
+	if cfg.Selector == nil {
+		return nil, errors.New("trail: Config.Selector is required")
+	}
+	if cfg.Lines < 0 {
+		return nil, errors.New("trail: Config.Lines must be >= 0")
+	}
+	if cfg.Interval < 0 {
+		return nil, errors.New("trail: Config.Interval must be >= 0")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	return &Follower{
+		cfg:       cfg,
+		events:    make(chan Event, 256),
+		pin:       make(chan string),
+		done:      make(chan struct{}),
+		lastLines: LastLines,
+		tailFile: func(path string, offset int64, cfg TailConfig) (tailHandle, error) {
+			return TailFile(path, offset, cfg)
+		},
+	}, nil
+}
+
+// 行と出来事。Run が終わると閉じる。
+func (f *Follower) Events() <-chan Event {
+	return f.events
+}
+
+// DirSelector の自動の切り替えを止め、path を追従する。空なら最新のファイルへの自動の切り替えに戻す。
+func (f *Follower) Pin(path string) {
+	select {
+	case f.pin <- path:
+	case <-f.done:
+	}
+}
+
+// 追従している状態
+type followState struct {
+	path string
+	tail tailHandle
+}
+
+// ctx が終わるまで追従する。ctx の終了では nil を返す。
+// 最初のファイルを選べない・開けない場合と、Selector が DirSelector でなく追従中にエラーが起きた場合はエラーを返す。
+// Run は 1 つの Follower につき 1 度だけ呼べる。
+func (f *Follower) Run(ctx context.Context) error {
+	defer close(f.events)
+	defer close(f.done)
+
+	path, err := f.cfg.Selector.Select()
+	if err != nil {
+		return err
+	}
+	offset, resumed := f.resumeOffset(path)
+	if resumed {
+		f.send(ctx, Event{Type: EventStart, File: path, Message: fmt.Sprintf("resuming %s at offset %d", path, offset)})
+	} else {
+		var lines []Line
+		lines, offset, err = f.lastLines(path, f.cfg.Lines, f.cfg.Filter)
+		if err != nil {
+			return err
+		}
+		f.send(ctx, Event{Type: EventStart, File: path, Message: "trailing " + path})
+		f.sendLines(ctx, path, lines)
+	}
+	t, err := f.tailFile(path, offset, f.tailConfig())
+	if err != nil {
+		return err
+	}
+	state := followState{path: path, tail: t}
+	defer func() { f.stop(ctx, state, false) }()
+
+	dirSelector, watchDir := f.cfg.Selector.(DirSelector)
+	var watchEvents <-chan fsnotify.Event
+	var watchErrors <-chan error
+	var tick <-chan time.Time
+	if watchDir {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		defer watcher.Close()
+		if err := watcher.Add(dirSelector.Dir()); err != nil {
+			return err
+		}
+		watchEvents, watchErrors = watcher.Events, watcher.Errors
+		ticker := time.NewTicker(f.cfg.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// Pin で選ばれた場合は最新ファイルへの自動切り替えを止める
+	pinned := false
+	switchToLatest := func() {
+		if pinned {
+			return
+		}
+		latest, err := f.cfg.Selector.Select()
+		if err != nil {
+			f.sendError(ctx, "", err, "latest file check failed: %v", err)
+			return
+		}
+		state = f.switchTo(ctx, state, latest)
+	}
+
+	current := state.tail
+	tailEvents := current.Events()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-tailEvents:
+			if !ok {
+				if !watchDir {
+					// ファイルが無くなって追従が終わった
+					return nil
+				}
+				tailEvents = nil
+				continue
+			}
+			if ev.Type == EventError && !watchDir {
+				return ev.Err
+			}
+			f.forward(ctx, ev)
+		case ev, ok := <-watchEvents:
+			if !ok {
+				return nil
+			}
+			if ev.Op&(fsnotify.Create|fsnotify.Rename) != 0 {
+				switchToLatest()
+			}
+		case <-tick:
+			switchToLatest()
+		case err, ok := <-watchErrors:
+			if !ok {
+				return nil
+			}
+			f.sendError(ctx, "", err, "watch error: %v", err)
+		case path := <-f.pin:
+			if path == "" {
+				pinned = false
+				switchToLatest()
+			} else {
+				pinned = true
+				state = f.switchTo(ctx, state, path)
+			}
+		}
+		// 切り替えた場合は新しいファイルの行を待つ
+		if state.tail != current {
+			current = state.tail
+			tailEvents = current.Events()
+		}
+	}
+}
+
+// Config.Resume から読み始められるなら、その位置を返す
+func (f *Follower) resumeOffset(path string) (int64, bool) {
+	resume := f.cfg.Resume
+	if resume == nil || resume.File != path || resume.Offset < 0 {
+		return 0, false
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < resume.Offset {
+		return 0, false
+	}
+	return resume.Offset, true
+}
+
+// 新しいファイルの末尾の行を送って追従を始めてから、元のファイルの追従をやめる。
+// どちらかに失敗した場合は元のファイルの追従を続ける。
+func (f *Follower) switchTo(ctx context.Context, state followState, path string) followState {
+	if path == state.path {
+		return state
+	}
+	lines, offset, err := f.lastLines(path, f.cfg.Lines, f.cfg.Filter)
+	if err != nil {
+		f.sendError(ctx, path, err, "failed to print last lines for %s: %v", path, err)
+		return state
+	}
+	f.sendLines(ctx, path, lines)
+	next, err := f.tailFile(path, offset, f.tailConfig())
+	if err != nil {
+		f.sendError(ctx, path, err, "failed to follow %s: %v", path, err)
+		return state
+	}
+	f.stop(ctx, state, true)
+	f.send(ctx, Event{Type: EventSwitch, File: path, Message: "switching to " + path})
+	return followState{path: path, tail: next}
+}
+
+// 追従をやめる。forward なら、すでに読み取った行を送ってから終える。
+func (f *Follower) stop(ctx context.Context, state followState, forward bool) {
+	if state.tail == nil {
+		return
+	}
+	if err := state.tail.Stop(); err != nil {
+		f.sendError(ctx, state.path, err, "failed to stop tail for %s: %v", state.path, err)
+	}
+	for ev := range state.tail.Events() {
+		if forward && ev.Type != EventError {
+			f.forward(ctx, ev)
+		}
+	}
+}
+
+func (f *Follower) tailConfig() TailConfig {
+	return TailConfig{Poll: f.cfg.Poll, LineNumbers: f.cfg.LineNumbers}
+}
+
+// 末尾の行を送る
+func (f *Follower) sendLines(ctx context.Context, path string, lines []Line) {
+	for _, line := range lines {
+		f.send(ctx, Event{Type: EventLine, File: path, Line: f.highlight(line)})
+	}
+}
+
+// FileTail から届いた出来事を送る。行には Filter と Highlighter を適用する。
+func (f *Follower) forward(ctx context.Context, ev Event) {
+	if ev.Type == EventLine {
+		if f.cfg.Filter != nil && !f.cfg.Filter(ev.File, ev.Line.Text) {
+			return
+		}
+		ev.Line = f.highlight(ev.Line)
+	}
+	f.send(ctx, ev)
+}
+
+func (f *Follower) highlight(line Line) Line {
+	line.Colored = line.Text
+	if f.cfg.Highlighter != nil {
+		line.Colored = f.cfg.Highlighter.Highlight(line.Text)
+	}
+	return line
+}
+
+func (f *Follower) sendError(ctx context.Context, path string, err error, format string, args ...any) {
+	f.send(ctx, Event{Type: EventError, File: path, Message: fmt.Sprintf(format, args...), Err: err})
+}
+
+func (f *Follower) send(ctx context.Context, ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case f.events <- ev:
+	case <-ctx.Done():
+	}
+}
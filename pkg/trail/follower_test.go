@@ -0,0 +1,313 @@
+package trail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeTail struct {
+	events  chan Event
+	stopped bool
+}
+
+func newFakeTail(lines ...string) *fakeTail {
+	ft := &fakeTail{events: make(chan Event, len(lines))}
+	for _, text := range lines {
+		ft.events <- Event{Type: EventLine, File: "fake", Line: Line{File: "fake", Text: text}}
+	}
+	return ft
+}
+
+func (f *fakeTail) Events() <-chan Event { return f.events }
+
+func (f *fakeTail) Stop() error {
+	if !f.stopped {
+		close(f.events)
+	}
+	f.stopped = true
+	return nil
+}
+
+func newTestFollower(t *testing.T, cfg Config) *Follower {
+	t.Helper()
+	f, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// 送られた出来事を集める
+func collect(f *Follower) []Event {
+	var events []Event
+	for {
+		select {
+		case ev := <-f.events:
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	for _, cfg := range []Config{{}, {Selector: File("a"), Lines: -1}, {Selector: File("a"), Interval: -1}} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) error = nil", cfg)
+		}
+	}
+}
+
+func TestSwitchToKeepsCurrentOnFailures(t *testing.T) {
+	ctx := context.Background()
+	t.Run("last lines fail", func(t *testing.T) {
+		f := newTestFollower(t, Config{Selector: File("old.log"), Lines: 10})
+		f.lastLines = func(string, int, Filter) ([]Line, int64, error) {
+			return nil, 0, errors.New("missing")
+		}
+		f.tailFile = func(string, int64, TailConfig) (tailHandle, error) {
+			t.Fatal("tailFile should not be called")
+			return nil, nil
+		}
+		old := newFakeTail()
+		state := followState{path: "old.log", tail: old}
+
+		got := f.switchTo(ctx, state, "new.log")
+		if got != state {
+			t.Fatalf("state = %+v, want unchanged %+v", got, state)
+		}
+		if old.stopped {
+			t.Fatal("old tail stopped, want it kept")
+		}
+		events := collect(f)
+		if len(events) != 1 || events[0].Type != EventError || !strings.Contains(events[0].Message, "failed to print last lines for new.log") {
+			t.Fatalf("events = %+v, want one error", events)
+		}
+	})
+
+	t.Run("tail fails", func(t *testing.T) {
+		f := newTestFollower(t, Config{Selector: File("old.log"), Lines: 10})
+		f.lastLines = func(path string, n int, _ Filter) ([]Line, int64, error) {
+			if path != "new.log" || n != 10 {
+				t.Fatalf("lastLines args = (%q, %d)", path, n)
+			}
+			return nil, 42, nil
+		}
+		f.tailFile = func(path string, offset int64, _ TailConfig) (tailHandle, error) {
+			if path != "new.log" || offset != 42 {
+				t.Fatalf("tailFile args = (%q, %d)", path, offset)
+			}
+			return nil, errors.New("gone")
+		}
+		old := newFakeTail()
+		state := followState{path: "old.log", tail: old}
+
+		got := f.switchTo(ctx, state, "new.log")
+		if got != state {
+			t.Fatalf("state = %+v, want unchanged %+v", got, state)
+		}
+		if old.stopped {
+			t.Fatal("old tail stopped, want it kept")
+		}
+		events := collect(f)
+		if len(events) != 1 || !strings.Contains(events[0].Message, "failed to follow new.log: gone") {
+			t.Fatalf("events = %+v, want one error", events)
+		}
+	})
+}
+
+func TestSwitchToUpdatesOnlyAfterNewFollowStarts(t *testing.T) {
+	f := newTestFollower(t, Config{Selector: File("old.log"), Lines: 3})
+	next := newFakeTail()
+	f.lastLines = func(path string, n int, _ Filter) ([]Line, int64, error) {
+		if path != "new.log" || n != 3 {
+			t.Fatalf("lastLines args = (%q, %d)", path, n)
+		}
+		return []Line{{File: path, Text: "new last"}}, 99, nil
+	}
+	f.tailFile = func(path string, offset int64, _ TailConfig) (tailHandle, error) {
+		if path != "new.log" || offset != 99 {
+			t.Fatalf("tailFile args = (%q, %d)", path, offset)
+		}
+		return next, nil
+	}
+	// 切り替え前に読み取っていた行も送る
+	old := newFakeTail("old pending")
+
+	got := f.switchTo(context.Background(), followState{path: "old.log", tail: old}, "new.log")
+	if got.path != "new.log" || got.tail != next {
+		t.Fatalf("state = %+v, want new follow", got)
+	}
+	if !old.stopped {
+		t.Fatal("old tail not stopped")
+	}
+	var got2 []string
+	for _, ev := range collect(f) {
+		if ev.Type == EventLine {
+			got2 = append(got2, ev.Line.Text)
+		} else {
+			got2 = append(got2, string(ev.Type)+" "+ev.File)
+		}
+	}
+	if want := "new last|old pending|switch new.log"; strings.Join(got2, "|") != want {
+		t.Fatalf("events = %q, want %q", got2, want)
+	}
+}
+
+func TestFollowerRunFollowsNewestFile(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	first := filepath.Join(dir, "app-1.log")
+	writeFileAt(t, first, "old 1\nold 2\n", base)
+
+	f := newTestFollower(t, Config{
+		Selector:    Newest(dir, "*.log"),
+		Lines:       1,
+		Interval:    50 * time.Millisecond,
+		Highlighter: HighlighterFunc(strings.ToUpper),
+		Filter:      func(_, text string) bool { return !strings.Contains(text, "skip") },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var runErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = f.Run(ctx)
+	}()
+
+	next := func() Event {
+		t.Helper()
+		select {
+		case ev := <-f.Events():
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+			return Event{}
+		}
+	}
+	expectLine := func(file, text string) {
+		t.Helper()
+		ev := next()
+		if ev.Type != EventLine || ev.File != file || ev.Line.Text != text || ev.Line.Colored != strings.ToUpper(text) {
+			t.Fatalf("event = %+v, want line %q from %s", ev, text, file)
+		}
+	}
+
+	if ev := next(); ev.Type != EventStart || ev.File != first {
+		t.Fatalf("event = %+v, want start", ev)
+	}
+	expectLine(first, "old 2")
+
+	time.Sleep(100 * time.Millisecond)
+	appendToFile(t, first, "skip me\nappended\n")
+	expectLine(first, "appended")
+
+	// 書き終えたファイルを移動して作る
+	second := filepath.Join(dir, "app-2.log")
+	staged := filepath.Join(t.TempDir(), "app-2.log")
+	writeFileAt(t, staged, "new 1\n", time.Now().Add(time.Minute))
+	if err := os.Rename(staged, second); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(second, "new 1")
+	if ev := next(); ev.Type != EventSwitch || ev.File != second || ev.Message != "switching to "+second {
+		t.Fatalf("event = %+v, want switch", ev)
+	}
+
+	// Pin したファイルは新しいファイルができても切り替えない
+	f.Pin(first)
+	expectLine(first, "appended")
+	if ev := next(); ev.Type != EventSwitch || ev.File != first {
+		t.Fatalf("event = %+v, want switch back to pinned file", ev)
+	}
+	writeFileAt(t, filepath.Join(dir, "app-3.log"), "newest\n", time.Now().Add(2*time.Minute))
+	time.Sleep(200 * time.Millisecond)
+	appendToFile(t, first, "still pinned\n")
+	expectLine(first, "still pinned")
+
+	cancel()
+	wg.Wait()
+	if runErr != nil {
+		t.Fatalf("Run = %v, want nil after cancel", runErr)
+	}
+	for range f.Events() {
+	}
+}
+
+func TestFollowerRunReturnsStartErrors(t *testing.T) {
+	f := newTestFollower(t, Config{Selector: File(filepath.Join(t.TempDir(), "missing.log"))})
+	if err := f.Run(context.Background()); err == nil {
+		t.Fatal("Run error = nil for a missing file")
+	}
+	if _, ok := <-f.Events(); ok {
+		t.Fatal("Events should be closed after Run returns")
+	}
+}
+
+func TestFollowerRunResumesFromPosition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("one\r\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := newTestFollower(t, Config{Selector: File(path), Lines: 1, Resume: &Position{File: path, Offset: 5}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- f.Run(ctx) }()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		appendToFile(t, path, "four\n")
+	}()
+
+	// "one\r\n" の後から読み始め、末尾の行は送らない
+	var events []Event
+	for ev := range f.Events() {
+		events = append(events, ev)
+		if ev.Type == EventLine && ev.Line.Text == "four" {
+			cancel()
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, ev := range events {
+		if ev.Type == EventLine {
+			texts = append(texts, ev.Line.Text)
+		}
+	}
+	if events[0].Type != EventStart || events[0].Message != "resuming "+path+" at offset 5" {
+		t.Fatalf("first event = %+v, want resuming start", events[0])
+	}
+	if got := strings.Join(texts, "|"); got != "two|three|four" {
+		t.Fatalf("lines = %q, want two|three|four", got)
+	}
+	last := events[len(events)-1].Line
+	if last.Offset != 15 || last.Next != 20 {
+		t.Fatalf("last line position = %d..%d, want 15..20", last.Offset, last.Next)
+	}
+
+	// 別のファイルやファイルより先の位置は使わず、末尾の行から始める
+	for _, resume := range []*Position{{File: path + ".1", Offset: 5}, {File: path, Offset: 1000}} {
+		f := newTestFollower(t, Config{Selector: File(path), Lines: 1, Resume: resume})
+		ctx, cancel := context.WithCancel(context.Background())
+		go f.Run(ctx)
+		if ev := <-f.Events(); ev.Type != EventStart || ev.Message != "trailing "+path {
+			t.Fatalf("resume %+v: event = %+v, want trailing start", resume, ev)
+		}
+		if ev := <-f.Events(); ev.Line.Text != "four" {
+			t.Fatalf("resume %+v: event = %+v, want last line", resume, ev)
+		}
+		cancel()
+		for range f.Events() {
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package trail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 追従するファイルを選ぶ
+type Selector interface {
+	Select() (string, error)
+}
+
+// ディレクトリ内から選ぶ Selector。Follower はディレクトリを監視し、変化があれば選び直す。
+type DirSelector interface {
+	Selector
+	Dir() string
+}
+
+type fileSelector string
+
+// 常に同じファイルを選ぶ
+func File(path string) Selector {
+	return fileSelector(path)
+}
+
+func (s fileSelector) Select() (string, error) {
+	return string(s), nil
+}
+
+// ワイルドカードパターンにマッチする最新 (mod time が最大) のファイルを選ぶ
+type NewestSelector struct {
+	Directory string
+	Pattern   string // 空なら "*"
+}
+
+func Newest(dir, pattern string) *NewestSelector {
+	return &NewestSelector{Directory: dir, Pattern: pattern}
+}
+
+func (s *NewestSelector) Select() (string, error) {
+	return NewestFile(s.Directory, s.pattern())
+}
+
+func (s *NewestSelector) Dir() string {
+	return s.Directory
+}
+
+// 候補のファイルを新しい順に返す
+func (s *NewestSelector) Files() ([]string, error) {
+	return MatchingFiles(s.Directory, s.pattern())
+}
+
+func (s *NewestSelector) pattern() string {
+	if s.Pattern == "" {
+		return "*"
+	}
+	return s.Pattern
+}
+
+// ワイルドカードパターンにマッチする最新の通常ファイルを返す
+func NewestFile(dir, pattern string) (string, error) {
+	files, err := MatchingFiles(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no files matching pattern '%s' in %s", pattern, dir)
+	}
+	return files[0], nil
+}
+
+// ワイルドカードパターンにマッチする通常ファイルを新しい順に返す
+func MatchingFiles(dir, pattern string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		path    string
+		modTime time.Time
+	}
+	var candidates []candidate
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matched, err := filepath.Match(pattern, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+		}
+		if !matched {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			candidates = append(candidates, candidate{filepath.Join(dir, entry.Name()), info.ModTime()})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].modTime.After(candidates[j].modTime)
+	})
+	files := make([]string, len(candidates))
+	for i, c := range candidates {
+		files[i] = c.path
+	}
+	return files, nil
+}
@@ -0,0 +1,119 @@
+package trail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFileAt(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewestFileSelectsNewestMatchingRegularFile(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+
+	oldLog := filepath.Join(dir, "app-1.log")
+	newLog := filepath.Join(dir, "app-2.log")
+	newerNonMatch := filepath.Join(dir, "notes.txt")
+	matchingDir := filepath.Join(dir, "app-3.log")
+
+	writeFileAt(t, oldLog, "old", base)
+	writeFileAt(t, newLog, "new", base.Add(1*time.Minute))
+	writeFileAt(t, newerNonMatch, "newer but not log", base.Add(2*time.Minute))
+	if err := os.Mkdir(matchingDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewestFile(dir, "app-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != newLog {
+		t.Fatalf("NewestFile = %q, want %q", got, newLog)
+	}
+
+	got, err = Newest(dir, "").Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != newerNonMatch {
+		t.Fatalf("Newest(dir, \"\").Select = %q, want %q", got, newerNonMatch)
+	}
+}
+
+func TestNewestFileUsesLiteralGlobMatching(t *testing.T) {
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "app+1.log")
+	newFile := filepath.Join(dir, "app+2.log")
+	baseTime := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+
+	writeFileAt(t, oldFile, "old", baseTime)
+	writeFileAt(t, newFile, "new", baseTime.Add(time.Minute))
+
+	got, err := NewestFile(dir, "app+*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != newFile {
+		t.Fatalf("NewestFile = %q, want %q", got, newFile)
+	}
+}
+
+func TestNewestFileErrors(t *testing.T) {
+	t.Run("no matching file", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFileAt(t, filepath.Join(dir, "app.txt"), "text", time.Now())
+
+		_, err := NewestFile(dir, "*.log")
+		if err == nil || !strings.Contains(err.Error(), "no files matching pattern") {
+			t.Fatalf("NewestFile error = %v, want no files matching pattern", err)
+		}
+	})
+
+	t.Run("invalid glob pattern", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFileAt(t, filepath.Join(dir, "app.log"), "log", time.Now())
+
+		_, err := NewestFile(dir, "[")
+		if err == nil || !strings.Contains(err.Error(), "invalid pattern") {
+			t.Fatalf("NewestFile error = %v, want invalid pattern", err)
+		}
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		_, err := NewestFile(filepath.Join(t.TempDir(), "missing"), "*")
+		if err == nil {
+			t.Fatal("NewestFile error = nil")
+		}
+	})
+}
+
+func TestMatchingFilesNewestFirst(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	writeFileAt(t, filepath.Join(dir, "a.log"), "a", base)
+	writeFileAt(t, filepath.Join(dir, "b.log"), "b", base.Add(time.Minute))
+	writeFileAt(t, filepath.Join(dir, "c.txt"), "c", base.Add(2*time.Minute))
+	if err := os.Mkdir(filepath.Join(dir, "d.log"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Newest(dir, "*.log").Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "b.log"), filepath.Join(dir, "a.log")}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Files = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,78 @@
+// Package trail はファイルの末尾の行を表示し、ローテーションに追従しながら
+// 追記された行を読み続ける処理をまとめたもの。trail コマンドはこのパッケージの薄いラッパー。
+//
+//	f, err := trail.New(trail.Config{
+//		Selector: trail.Newest("/var/log/app", "*.log"),
+//		Lines:    10,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	go f.Run(ctx)
+//	for ev := range f.Events() {
+//		if ev.Type == trail.EventLine {
+//			fmt.Println(ev.Line.Text)
+//		}
+//	}
+//
+// パッケージレベルの状態は持たないので、設定の異なる Follower を同じプロセスで同時に動かせる。
+package trail
+
+import "time"
+
+// 読み取った 1 行
+type Line struct {
+	File     string
+	Offset   int64     // 行頭のバイト位置。不明なら -1
+	Next     int64     // 次の行の先頭のバイト位置 (続きを読み始める位置)。不明なら -1
+	Number   int       // 1 始まりの行番号。数えていなければ 0
+	Received time.Time // 追従中に読み取った時刻。末尾の行の表示ではゼロ
+	Text     string    // 改行 (CRLF の CR も) を除いた行
+	Colored  string    // Highlighter で色付けした行。Highlighter が無ければ Text と同じ
+}
+
+// ファイル中の位置。Config.Resume で続きから読み始めるのに使う。
+type Position struct {
+	File   string
+	Offset int64 // 最後に受け取った行の Line.Next
+}
+
+// 出来事の種類
+type EventType string
+
+const (
+	EventLine      EventType = "line"      // 行を読み取った
+	EventStart     EventType = "start"     // 追従を始めた
+	EventSwitch    EventType = "switch"    // 別のファイルに切り替えた
+	EventTruncated EventType = "truncated" // ファイルが切り詰められ、先頭から読み直す
+	EventReopened  EventType = "reopened"  // ファイルが移動・削除され、開き直す
+	EventRemoved   EventType = "removed"   // ファイルが無くなり、追従をやめた
+	EventError     EventType = "error"     // 追従を続けられるエラー
+)
+
+// Follower が送る行または出来事
+type Event struct {
+	Type    EventType
+	File    string
+	Time    time.Time
+	Line    Line   // Type が EventLine のときだけ
+	Message string // 人が読むための説明 (EventLine 以外)
+	Err     error  // Type が EventError のときだけ
+}
+
+// 行に色を付ける。複数の goroutine から同時に呼ばれてもよい実装にする。
+type Highlighter interface {
+	Highlight(text string) string
+}
+
+// 関数を Highlighter として使う
+type HighlighterFunc func(text string) string
+
+func (f HighlighterFunc) Highlight(text string) string {
+	return f(text)
+}
+
+// 行を表示するか判定する。file は行の読み出し元。
+// 直前の行によって結果が変わる (スタックトレースの行をまとめて隠すなど) 実装でもよいように、
+// 1 つの Follower からは読み取った順に 1 行ずつ呼ぶ。
+type Filter func(file, text string) bool
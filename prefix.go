@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- 行の先頭に受信時刻や読み出し元を付ける ----------
+
+// 行の読み出し元の情報
+type lineInfo struct {
+	source   string    // ファイルのパス (不明な場合は空)
+	offset   int64     // ファイル内の行頭のバイト位置 (不明な場合は -1)
+	number   int       // 1 から始まる行番号 (不明な場合は 0)
+	received time.Time // trail が行を読んだ時刻 (不明な場合はゼロ値)
+}
+
+const prefixTimeLayout = "2006-01-02 15:04:05.000"
+
+type prefixFlags struct {
+	timestamps *bool
+	showFile   *bool
+	showOffset *bool
+}
+
+func registerPrefixFlags(fs *flag.FlagSet) prefixFlags {
+	return prefixFlags{
+		timestamps: fs.Bool("timestamps", false, "prefix each line with the time trail received it"),
+		showFile:   fs.Bool("show-file", false, "prefix each line with the name of the file it came from"),
+		showOffset: fs.Bool("show-offset", false, "prefix each line with its byte offset in the file"),
+	}
+}
+
+type linePrefix struct {
+	timestamps bool
+	showFile   bool
+	showOffset bool
+	fullSource bool // showFile でファイル名ではなく読み出し元 (pod/container など) をそのまま表示する
+	now        func() time.Time
+}
+
+var prefixRules *linePrefix
+
+func (f prefixFlags) build() *linePrefix {
+	if !*f.timestamps && !*f.showFile && !*f.showOffset {
+		return nil
+	}
+	return &linePrefix{
+		timestamps: *f.timestamps,
+		showFile:   *f.showFile,
+		showOffset: *f.showOffset,
+		now:        time.Now,
+	}
+}
+
+// 読み出し元を必ず表示する。ファイルではない読み出し元 (k8s の pod/container など) 用
+func (p *linePrefix) withSource() *linePrefix {
+	if p == nil {
+		p = &linePrefix{now: time.Now}
+	}
+	p.showFile = true
+	p.fullSource = true
+	return p
+}
+
+// "2024-05-01 12:00:00.123 [app.log:1024] " のような接頭辞を返す
+func (p *linePrefix) text(info lineInfo) string {
+	if p == nil {
+		return ""
+	}
+	var parts []string
+	if p.timestamps {
+		received := info.received
+		if received.IsZero() {
+			received = p.now()
+		}
+		parts = append(parts, received.Format(prefixTimeLayout))
+	}
+	var where []string
+	if p.showFile && info.source != "" {
+		if p.fullSource {
+			where = append(where, info.source)
+		} else {
+			where = append(where, filepath.Base(info.source))
+		}
+	}
+	if p.showOffset && info.offset >= 0 {
+		where = append(where, strconv.FormatInt(info.offset, 10))
+	}
+	if len(where) > 0 {
+		parts = append(parts, "["+strings.Join(where, ":")+"]")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, " ") + " "
+}
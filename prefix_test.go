@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestPrefix(timestamps, showFile, showOffset bool) *linePrefix {
+	p := prefixFlags{timestamps: &timestamps, showFile: &showFile, showOffset: &showOffset}.build()
+	if p != nil {
+		p.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 5e6, time.Local) }
+	}
+	return p
+}
+
+func TestLinePrefixText(t *testing.T) {
+	received := time.Date(2024, 5, 1, 9, 30, 0, 123e6, time.Local)
+	info := lineInfo{source: "/var/log/app.log", offset: 1024, received: received}
+	tests := []struct {
+		prefix *linePrefix
+		info   lineInfo
+		want   string
+	}{
+		{newTestPrefix(false, false, false), info, ""},
+		{newTestPrefix(true, false, false), info, "2024-05-01 09:30:00.123 "},
+		{newTestPrefix(false, true, false), info, "[app.log] "},
+		{newTestPrefix(false, true, true), info, "[app.log:1024] "},
+		{newTestPrefix(true, false, true), info, "2024-05-01 09:30:00.123 [1024] "},
+		{newTestPrefix(true, true, true), lineInfo{offset: -1}, "2024-05-01 12:00:00.005 "},
+		{newTestPrefix(false, true, true), lineInfo{offset: -1}, ""},
+		{newTestPrefix(false, false, false).withSource(), lineInfo{source: "api-1/app", offset: -1}, "[api-1/app] "},
+		{newTestPrefix(true, false, false).withSource(), lineInfo{source: "api-1/app", offset: -1, received: received}, "2024-05-01 09:30:00.123 [api-1/app] "},
+	}
+	for i, tt := range tests {
+		if got := tt.prefix.text(tt.info); got != tt.want {
+			t.Errorf("%d: text = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestFollowLastLinesShowsFileAndOffset(t *testing.T) {
+	withReset(t)
+	prefixRules = newTestPrefix(false, true, true)
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("first\r\nsecond\nthird\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := memoryOutput(t)
+	startTestFollower(t, nil, path, 2)
+	if out := wait("third\n"); out != "[app.log:7] second\n[app.log:14] third\n" {
+		t.Fatalf("output = %q", out)
+	}
+}
+
+func TestFollowReportsLineOffsets(t *testing.T) {
+	withReset(t)
+	prefixRules = newTestPrefix(false, false, true)
+	path := filepath.Join(t.TempDir(), "follow.log")
+	initial := "existing\n"
+	if err := os.WriteFile(path, []byte(initial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := memoryOutput(t)
+	state := startTestFollower(t, nil, path, 0)
+	appendToFile(t, path, "one\r\ntwo\n")
+	out := wait("two\n")
+	stopTestFollower(t, state)
+
+	if out != "[9] one\n[14] two\n" {
+		t.Fatalf("follow output = %q", out)
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yutat23/trail/pkg/trail"
+)
+
+// ---------- サブコマンド: serve ----------
+
+// 追従している行を HTTP で配信する。シェルに入れない人もブラウザから見られるように、
+// Server-Sent Events (/events) と WebSocket (/ws) の両方で同じ JSON を送り、
+// それを表示するページ (/) も返す。
+
+// クライアントごとの送信待ちの上限 (バックログに加えて)。溢れたクライアントは切断する。
+const liveClientBuffer = 256
+
+// 接続が切れていないことを確かめる間隔
+const liveKeepalive = 15 * time.Second
+
+// 送信する 1 件。JSON の行には色付けした HTML を加える。
+type liveLine struct {
+	lineRecord
+	HTML string `json:"html"`
+}
+
+type liveMessage struct {
+	text  string // 絞り込みに使う色付け前の行
+	data  string // 送る JSON
+	event bool   // 出来事は絞り込まずに送る
+}
+
+type liveClient struct {
+	ch     chan string
+	filter *regexp.Regexp // liveHub.mu で保護する
+	slow   chan struct{}  // 送信が追いつかず切断されたら閉じる
+}
+
+func (c *liveClient) accepts(msg liveMessage) bool {
+	return msg.event || c.filter == nil || c.filter.MatchString(msg.text)
+}
+
+// 直近の行を持ち、接続中のクライアントへ配る
+type liveHub struct {
+	mu      sync.Mutex
+	size    int
+	backlog []liveMessage // 古い順。size を超えたら先頭から捨てる
+	clients map[*liveClient]struct{}
+	closed  bool
+}
+
+func newLiveHub(size int) *liveHub {
+	return &liveHub{size: size, clients: make(map[*liveClient]struct{})}
+}
+
+// クライアントを加え、絞り込んだバックログを送信待ちに入れる。終了中なら nil。
+func (h *liveHub) subscribe(filter *regexp.Regexp) *liveClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	c := &liveClient{ch: make(chan string, h.size+liveClientBuffer), filter: filter, slow: make(chan struct{})}
+	h.clients[c] = struct{}{}
+	h.replayLocked(c)
+	return c
+}
+
+func (h *liveHub) unsubscribe(c *liveClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dropLocked(c)
+}
+
+// 絞り込みを変え、表示し直すためにバックログを送り直す
+func (h *liveHub) setFilter(c *liveClient, filter *regexp.Regexp) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	c.filter = filter
+	if !h.sendLocked(c, `{"type":"reset"}`) {
+		return
+	}
+	h.replayLocked(c)
+}
+
+func (h *liveHub) replayLocked(c *liveClient) {
+	for _, msg := range h.backlog {
+		if c.accepts(msg) && !h.sendLocked(c, msg.data) {
+			return
+		}
+	}
+}
+
+func (h *liveHub) publish(msg liveMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	if h.size > 0 {
+		if len(h.backlog) == h.size {
+			copy(h.backlog, h.backlog[1:])
+			h.backlog = h.backlog[:h.size-1]
+		}
+		h.backlog = append(h.backlog, msg)
+	}
+	for c := range h.clients {
+		if c.accepts(msg) {
+			h.sendLocked(c, msg.data)
+		}
+	}
+}
+
+// 送信待ちが溢れたクライアントは切断し、他のクライアントや追従を待たせない
+func (h *liveHub) sendLocked(c *liveClient, data string) bool {
+	select {
+	case c.ch <- data:
+		return true
+	default:
+		log.Printf("dropping a slow client (more than %d messages behind)", cap(c.ch))
+		close(c.slow)
+		h.dropLocked(c)
+		return false
+	}
+}
+
+func (h *liveHub) dropLocked(c *liveClient) {
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.ch)
+	}
+}
+
+// 全クライアントの送信待ちを閉じ、以降の接続を断る
+func (h *liveHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		h.dropLocked(c)
+	}
+}
+
+// 配信の HTTP ハンドラと、Follower の出来事を JSON にする処理
+type liveServer struct {
+	hub         *liveHub
+	highlighter *Highlighter
+	renderer    *htmlRenderer // consume の goroutine からだけ使う
+	title       string
+
+	allowedOrigins []string // -allow-origin: ページと別のホストから WebSocket を開いてよい Origin
+}
+
+func newLiveServer(h *Highlighter, backlog int, title string) *liveServer {
+	return &liveServer{
+		hub:         newLiveHub(backlog),
+		highlighter: h,
+		renderer:    newHTMLRenderer(),
+		title:       title,
+	}
+}
+
+func (s *liveServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.servePage)
+	mux.HandleFunc("GET /events", s.serveEvents)
+	mux.HandleFunc("GET /ws", s.serveWebsocket)
+	return mux
+}
+
+// Follower の出来事を Events が閉じるまで配る
+func (s *liveServer) consume(events <-chan trail.Event) {
+	for ev := range events {
+		s.publish(ev)
+	}
+}
+
+func (s *liveServer) publish(ev trail.Event) {
+	if ev.Type == trail.EventLine {
+		line := ev.Line
+		text := timeRules.rewrite(line.Text)
+		_, spans := s.highlighter.highlightSpans(text)
+		record := newLineRecord(lineInfo{source: line.File, offset: line.Offset, number: line.Number, received: line.Received}, text, nil)
+		s.hub.publish(liveMessage{
+			text: text,
+			data: marshalRecord(liveLine{lineRecord: record, HTML: s.renderer.render(text, spans)}),
+		})
+		return
+	}
+	if ev.Message == "" {
+		return
+	}
+	if ev.Type != trail.EventStart {
+		log.Print(ev.Message)
+	}
+	when := ev.Time
+	if when.IsZero() {
+		when = time.Now()
+	}
+	s.hub.publish(liveMessage{
+		event: true,
+		data: marshalRecord(eventRecord{
+			Type:    "event",
+			Event:   string(ev.Type),
+			File:    ev.File,
+			Time:    when.Format(time.RFC3339Nano),
+			Message: ev.Message,
+		}),
+	})
+}
+
+func (s *liveServer) close() {
+	s.hub.close()
+}
+
+// クエリの filter を正規表現にする。空なら絞り込まない。
+func parseLiveFilter(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+	return re, nil
+}
+
+func (s *liveServer) servePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, strings.ReplaceAll(livePage, "{{title}}", html.EscapeString(s.title)))
+}
+
+// Server-Sent Events で 1 件ずつ "data: <JSON>" として送る
+func (s *liveServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLiveFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	client := s.hub.subscribe(filter)
+	if client == nil {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.hub.unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(liveKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case data, ok := <-client.ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			if len(client.ch) == 0 {
+				flusher.Flush()
+			}
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// クライアントから受け取るメッセージ
+type liveCommand struct {
+	Filter *string `json:"filter"`
+}
+
+// WebSocket で 1 件ずつテキストメッセージとして送る。
+// クライアントは {"filter":"regex"} を送って絞り込みを変えられる。
+func (s *liveServer) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLiveFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := upgradeWebsocket(w, r, s.allowedOrigins)
+	if err != nil {
+		return
+	}
+	client := s.hub.subscribe(filter)
+	if client == nil {
+		conn.close(1001, "server is shutting down")
+		return
+	}
+	defer s.hub.unsubscribe(client)
+
+	// 切断されたクライアントへの書き込みで止まっていても、接続を閉じて抜けさせる
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-client.slow:
+			conn.conn.Close()
+		case <-stop:
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			text, err := conn.readText()
+			if err != nil {
+				return
+			}
+			var cmd liveCommand
+			if err := json.Unmarshal([]byte(text), &cmd); err != nil || cmd.Filter == nil {
+				conn.writeText(marshalRecord(map[string]string{"type": "error", "message": "expected {\"filter\":\"regex\"}"}))
+				continue
+			}
+			filter, err := parseLiveFilter(*cmd.Filter)
+			if err != nil {
+				conn.writeText(marshalRecord(map[string]string{"type": "error", "message": err.Error()}))
+				continue
+			}
+			s.hub.setFilter(client, filter)
+		}
+	}()
+
+	keepalive := time.NewTicker(liveKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case data, ok := <-client.ch:
+			if !ok {
+				// 終了するか、送信が追いつかず切断された
+				conn.close(1001, "stream closed")
+				<-done
+				return
+			}
+			if err := conn.writeText(data); err != nil {
+				conn.conn.Close()
+				<-done
+				return
+			}
+		case <-keepalive.C:
+			conn.writeFrame(wsOpPing, nil)
+		case <-done:
+			conn.conn.Close()
+			return
+		}
+	}
+}
+
+func cmdServe(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "address to listen on")
+	backlog := fs.Int("n", 100, "lines sent to each client on connect")
+	var colorOpts, allowOrigins repeatedStrings
+	fs.Var(&colorOpts, "c", "color patterns in format 'color:regex' (can be used multiple times)")
+	fs.Var(&allowOrigins, "allow-origin", "origin of another site allowed to open the WebSocket, or * for any (can be used multiple times)")
+	pattern := fs.String("pattern", "*", "file pattern to match in dir mode")
+	interval := fs.Duration("interval", 5*time.Second, "fallback polling interval in dir mode")
+	levelOpts := registerLevelFlags(fs)
+	timeOpts := registerTimeFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		log.Fatalf("usage: trail serve [options] file|dir <path>")
+	}
+	validateLineCount(*backlog)
+	validateInterval(*interval)
+	path := fs.Arg(1)
+
+	var selector trail.Selector
+	switch fs.Arg(0) {
+	case "-f", "file":
+		selector = trail.File(path)
+	case "-d", "dir":
+		selector = trail.Newest(path, *pattern)
+	default:
+		log.Fatalf("unknown serve mode %q (expected file or dir)", fs.Arg(0))
+	}
+	if _, err := selector.Select(); err != nil {
+		log.Fatal(err)
+	}
+
+	// HTML の色は色を付けた範囲から決めるので、--color の設定は影響しない
+	highlighter := buildHighlighter(opts, colorOpts)
+	applyLevelOptions(levelOpts)
+	applyTimeOptions(timeOpts)
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	live := newLiveServer(highlighter, *backlog, path)
+	live.allowedOrigins = allowOrigins
+	cfg := followerConfig(selector, *backlog)
+	cfg.Interval = *interval
+	cfg.LineNumbers = true
+	follower, err := trail.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- follower.Run(ctx)
+	}()
+	consumed := make(chan struct{})
+	go func() {
+		live.consume(follower.Events())
+		close(consumed)
+	}()
+
+	server := &http.Server{Handler: live.handler(), ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(ln)
+	}()
+	log.Printf("serving %s on http://%s/", path, ln.Addr())
+
+	code := 0
+	select {
+	case sig := <-notifyShutdown():
+		code = signalExitCode(sig)
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-consumed:
+		// ファイルが無くなるなどして追従が終わった
+		if err := <-runErr; err != nil {
+			log.Print(err)
+			code = 1
+		}
+	}
+	cancel()
+	live.close()
+	shutdownCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
+
+const livePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>trail - {{title}}</title>
+<style>
+body { margin: 0; background: #1e1e1e; color: #d0d0d0; font-family: ui-monospace, Menlo, Consolas, monospace; font-size: 13px; }
+header { position: sticky; top: 0; display: flex; gap: 1em; align-items: center; padding: 0.5em 1em; background: #2a2a2a; border-bottom: 1px solid #3a3a3a; }
+header input { flex: 1; font: inherit; color: inherit; background: #1e1e1e; border: 1px solid #3a3a3a; padding: 0.2em 0.4em; }
+#status { opacity: 0.6; }
+#lines { margin: 0; padding: 0.5em 1em; white-space: pre-wrap; word-break: break-all; }
+#lines .event { opacity: 0.6; font-style: italic; }
+</style>
+</head>
+<body>
+<header>
+<strong>{{title}}</strong>
+<input id="filter" placeholder="filter (regex)" autocomplete="off">
+<span id="status">connecting</span>
+</header>
+<pre id="lines"></pre>
+<script>
+"use strict";
+const maxLines = 5000;
+const lines = document.getElementById("lines");
+const filter = document.getElementById("filter");
+const status = document.getElementById("status");
+const useSSE = new URLSearchParams(location.search).get("transport") === "sse" || !window.WebSocket;
+let source = null;
+
+function add(msg) {
+  if (msg.type === "reset") { lines.textContent = ""; return; }
+  if (msg.type === "error") { status.textContent = msg.message; return; }
+  const follow = window.innerHeight + window.scrollY >= document.body.scrollHeight - 4;
+  const div = document.createElement("div");
+  if (msg.type === "line") {
+    div.innerHTML = msg.html;
+  } else {
+    div.className = "event";
+    div.textContent = "-- " + msg.message;
+  }
+  lines.appendChild(div);
+  while (lines.childElementCount > maxLines) lines.firstChild.remove();
+  if (follow) window.scrollTo(0, document.body.scrollHeight);
+}
+
+function query() {
+  return filter.value ? "?filter=" + encodeURIComponent(filter.value) : "";
+}
+
+function connect() {
+  if (source) source.close();
+  lines.textContent = "";
+  if (useSSE) {
+    source = new EventSource("events" + query());
+    source.onopen = () => { status.textContent = "live (SSE)"; };
+    source.onmessage = (e) => add(JSON.parse(e.data));
+    source.onerror = () => { status.textContent = source.readyState === EventSource.CLOSED ? "disconnected" : "reconnecting"; };
+    return;
+  }
+  const url = new URL("ws" + query(), location.href);
+  url.protocol = url.protocol === "https:" ? "wss:" : "ws:";
+  const ws = new WebSocket(url);
+  source = ws;
+  ws.onopen = () => { status.textContent = "live (WebSocket)"; };
+  ws.onmessage = (e) => add(JSON.parse(e.data));
+  ws.onclose = () => {
+    if (source !== ws) return;
+    status.textContent = "reconnecting";
+    setTimeout(() => { if (source === ws) connect(); }, 2000);
+  };
+}
+
+let timer = null;
+filter.addEventListener("input", () => {
+  clearTimeout(timer);
+  timer = setTimeout(() => {
+    if (!useSSE && source && source.readyState === WebSocket.OPEN) {
+      source.send(JSON.stringify({filter: filter.value}));
+    } else {
+      connect();
+    }
+  }, 300);
+});
+connect();
+</script>
+</body>
+</html>
+`
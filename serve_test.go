@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yutat23/trail/pkg/trail"
+)
+
+// 受け取ったメッセージのうちテストで見る項目
+type liveTestMessage struct {
+	Type    string `json:"type"`
+	Event   string `json:"event"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+func newTestLiveServer(t *testing.T, backlog int, specs ...string) (*liveServer, *httptest.Server) {
+	t.Helper()
+	withReset(t)
+	live := newLiveServer(userHighlighter(colorNever, specs...), backlog, "app <1>.log")
+	srv := httptest.NewServer(live.handler())
+	t.Cleanup(func() {
+		live.close()
+		srv.Close()
+	})
+	return live, srv
+}
+
+func publishLines(live *liveServer, lines ...string) {
+	for _, line := range lines {
+		live.publish(trail.Event{Type: trail.EventLine, File: "app.log", Line: trail.Line{File: "app.log", Offset: -1, Text: line}})
+	}
+}
+
+// SSE の data を 1 件ずつ送るチャネル
+func readSSE(t *testing.T, url string) <-chan liveTestMessage {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	ch := make(chan liveTestMessage, 64)
+	go func() {
+		defer resp.Body.Close()
+		defer close(ch)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var msg liveTestMessage
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				t.Errorf("invalid JSON %q: %v", data, err)
+				return
+			}
+			ch <- msg
+		}
+	}()
+	return ch
+}
+
+func nextMessage(t *testing.T, ch <-chan liveTestMessage) liveTestMessage {
+	t.Helper()
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	return liveTestMessage{}
+}
+
+func requireTexts(t *testing.T, ch <-chan liveTestMessage, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if msg := nextMessage(t, ch); msg.Text != w {
+			t.Fatalf("message = %+v, want text %q", msg, w)
+		}
+	}
+}
+
+func TestLivePageIsServed(t *testing.T) {
+	_, srv := newTestLiveServer(t, 10)
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("status = %d, content type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{"<title>trail - app &lt;1&gt;.log</title>", `new EventSource("events"`, `new WebSocket(url)`} {
+		requireContains(t, string(body), want)
+	}
+
+	resp, err = http.Get(srv.URL + "/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET /missing status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestLiveEventsSendBacklogThenLiveLines(t *testing.T) {
+	live, srv := newTestLiveServer(t, 2, "red:ERROR", "hash:user=\\w+")
+	publishLines(live, "first", "ERROR <boom>", "user=alice")
+
+	ch := readSSE(t, srv.URL+"/events")
+	// バックログは直近の 2 行だけ
+	msg := nextMessage(t, ch)
+	if msg.Type != "line" || msg.Text != "ERROR <boom>" || msg.Level != "error" {
+		t.Fatalf("first message = %+v", msg)
+	}
+	if want := `<span style="color:#cd0000">ERROR</span> &lt;boom&gt;`; msg.HTML != want {
+		t.Fatalf("html = %q, want %q", msg.HTML, want)
+	}
+	if msg := nextMessage(t, ch); !strings.HasPrefix(msg.HTML, `<span style="color:`) || msg.Text != "user=alice" {
+		t.Fatalf("hashed message = %+v", msg)
+	}
+
+	publishLines(live, "live line")
+	live.publish(trail.Event{Type: trail.EventSwitch, File: "app.2.log", Message: "switched to app.2.log"})
+	requireTexts(t, ch, "live line")
+	if msg := nextMessage(t, ch); msg.Type != "event" || msg.Event != "switch" || msg.Message != "switched to app.2.log" {
+		t.Fatalf("event message = %+v", msg)
+	}
+}
+
+func TestLiveEventsFilterPerClient(t *testing.T) {
+	live, srv := newTestLiveServer(t, 10)
+	publishLines(live, "INFO GET /health", "ERROR db down", "INFO GET /users")
+
+	errors := readSSE(t, srv.URL+"/events?filter=ERROR|WARN")
+	users := readSSE(t, srv.URL+"/events?filter="+"%2Fusers")
+	all := readSSE(t, srv.URL+"/events")
+	requireTexts(t, errors, "ERROR db down")
+	requireTexts(t, users, "INFO GET /users")
+	requireTexts(t, all, "INFO GET /health", "ERROR db down", "INFO GET /users")
+
+	publishLines(live, "WARN slow", "INFO GET /users/1")
+	requireTexts(t, errors, "WARN slow")
+	requireTexts(t, users, "INFO GET /users/1")
+	requireTexts(t, all, "WARN slow", "INFO GET /users/1")
+}
+
+func TestLiveRejectsInvalidFilter(t *testing.T) {
+	_, srv := newTestLiveServer(t, 10)
+	for _, path := range []string{"/events?filter=(", "/ws?filter=("} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "invalid filter") {
+			t.Errorf("GET %s = %d %q, want 400 invalid filter", path, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestLiveHubDropsSlowClients(t *testing.T) {
+	hub := newLiveHub(0)
+	slow := hub.subscribe(nil)
+	fast := hub.subscribe(nil)
+	for range liveClientBuffer + 1 {
+		hub.publish(liveMessage{text: "x", data: `{}`})
+		// fast だけ読み進める
+		<-fast.ch
+	}
+	n := 0
+	for range slow.ch {
+		n++
+	}
+	if n != liveClientBuffer {
+		t.Fatalf("slow client got %d messages before being dropped, want %d", n, liveClientBuffer)
+	}
+	select {
+	case <-slow.slow:
+	default:
+		t.Fatal("slow channel not closed for a dropped client")
+	}
+	hub.publish(liveMessage{text: "x", data: `{}`})
+	if data := <-fast.ch; data != `{}` {
+		t.Fatalf("fast client got %q", data)
+	}
+
+	hub.close()
+	if _, ok := <-fast.ch; ok {
+		t.Fatal("client channel still open after close")
+	}
+	select {
+	case <-fast.slow:
+		t.Fatal("slow channel closed on shutdown")
+	default:
+	}
+	if hub.subscribe(nil) != nil {
+		t.Fatal("subscribe after close should return nil")
+	}
+}
+
+func TestWebsocketAccept(t *testing.T) {
+	// RFC 6455 1.3 の例
+	if got := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("websocketAccept = %q", got)
+	}
+}
+
+// テスト用の最小限の WebSocket クライアント
+func TestWebsocketWriteTimesOut(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &websocketConn{conn: server, r: bufio.NewReader(server), w: bufio.NewWriter(server), writeTimeout: 50 * time.Millisecond}
+	errc := make(chan error, 1)
+	go func() { errc <- conn.writeText("nobody reads this") }()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("writeText = %v, want a deadline error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writeText still blocked on a client that does not read")
+	}
+}
+
+type testWebsocket struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialTestWebsocket(t *testing.T, url string) *testWebsocket {
+	t.Helper()
+	addr := strings.TrimPrefix(url, "http://")
+	host, path, _ := strings.Cut(addr, "/")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	key := make([]byte, 16)
+	rand.Read(key)
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+	io.WriteString(conn, "GET /"+path+" HTTP/1.1\r\nHost: "+host+"\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+encodedKey+"\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(encodedKey) {
+		t.Fatalf("handshake status = %d, accept = %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return &testWebsocket{conn: conn, r: r}
+}
+
+// クライアントのフレームはマスクして送る
+func (ws *testWebsocket) send(t *testing.T, opcode byte, payload string) {
+	t.Helper()
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i := range len(payload) {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	if _, err := ws.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (ws *testWebsocket) read(t *testing.T) (byte, string) {
+	t.Helper()
+	ws.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(ws.r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	n := int(head[1])
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(ws.r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(ws.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0F, string(payload)
+}
+
+func (ws *testWebsocket) readMessage(t *testing.T) liveTestMessage {
+	t.Helper()
+	opcode, payload := ws.read(t)
+	if opcode != wsOpText {
+		t.Fatalf("opcode = %#x, want text", opcode)
+	}
+	var msg liveTestMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		t.Fatalf("invalid JSON %q: %v", payload, err)
+	}
+	return msg
+}
+
+func TestLiveWebsocketChecksOrigin(t *testing.T) {
+	live, srv := newTestLiveServer(t, 10)
+	live.allowedOrigins = []string{"https://dash.example.com"}
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	for origin, want := range map[string]int{
+		"":                            http.StatusSwitchingProtocols,
+		"http://" + host:              http.StatusSwitchingProtocols,
+		"https://dash.example.com":    http.StatusSwitchingProtocols,
+		"https://evil.example.com":    http.StatusForbidden,
+		"http://" + host + ".evil.io": http.StatusForbidden,
+		"null":                        http.StatusForbidden,
+	} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Origin %q: status = %d, want %d", origin, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestLiveWebsocketStreamsAndChangesFilter(t *testing.T) {
+	live, srv := newTestLiveServer(t, 10, "red:ERROR")
+	publishLines(live, "INFO started", "ERROR failed")
+
+	ws := dialTestWebsocket(t, srv.URL+"/ws?filter=ERROR")
+	if msg := ws.readMessage(t); msg.Text != "ERROR failed" || !strings.Contains(msg.HTML, "<span") {
+		t.Fatalf("backlog message = %+v", msg)
+	}
+
+	ws.send(t, wsOpPing, "hi")
+	if opcode, payload := ws.read(t); opcode != wsOpPong || payload != "hi" {
+		t.Fatalf("reply to ping = %#x %q", opcode, payload)
+	}
+
+	// 絞り込みを変えるとバックログを送り直す
+	ws.send(t, wsOpText, `{"filter":"INFO"}`)
+	if msg := ws.readMessage(t); msg.Type != "reset" {
+		t.Fatalf("message = %+v, want reset", msg)
+	}
+	if msg := ws.readMessage(t); msg.Text != "INFO started" {
+		t.Fatalf("replayed message = %+v", msg)
+	}
+	ws.send(t, wsOpText, `{"filter":"("}`)
+	if msg := ws.readMessage(t); msg.Type != "error" || !strings.Contains(msg.Message, "invalid filter") {
+		t.Fatalf("message = %+v, want invalid filter error", msg)
+	}
+
+	publishLines(live, "ERROR again", "INFO done")
+	if msg := ws.readMessage(t); msg.Text != "INFO done" {
+		t.Fatalf("live message = %+v", msg)
+	}
+
+	// 終了時は close フレームを送る
+	live.close()
+	if opcode, payload := ws.read(t); opcode != wsOpClose || binary.BigEndian.Uint16([]byte(payload)) != 1001 {
+		t.Fatalf("frame = %#x %q, want close 1001", opcode, payload)
+	}
+}
+
+func TestServeFollowsAFile(t *testing.T) {
+	withReset(t)
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	live := newLiveServer(userHighlighter(colorNever, "green:ready"), 2, path)
+	srv := httptest.NewServer(live.handler())
+	defer srv.Close()
+	defer live.close()
+
+	cfg := followerConfig(trail.File(path), 2)
+	cfg.LineNumbers = true
+	follower, err := trail.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go follower.Run(ctx)
+	go live.consume(follower.Events())
+
+	ch := readSSE(t, srv.URL+"/events")
+	requireTexts(t, ch, "two", "three")
+	appendToFile(t, path, "ready\r\n")
+	msg := nextMessage(t, ch)
+	if msg.Text != "ready" || msg.HTML != `<span style="color:#00cd00">ready</span>` {
+		t.Fatalf("appended message = %+v", msg)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ---------- シャットダウンとサマリー ----------
+
+// セッション中の集計値
+type sessionSummary struct {
+	mu            sync.Mutex
+	lines         int
+	filesSwitched int
+	matches       map[int]int // ColorPattern.Order -> マッチ数
+}
+
+var summary = newSessionSummary()
+
+func newSessionSummary() *sessionSummary {
+	return &sessionSummary{matches: make(map[int]int)}
+}
+
+func (s *sessionSummary) recordLine() {
+	s.mu.Lock()
+	s.lines++
+	s.mu.Unlock()
+}
+
+func (s *sessionSummary) recordMatches(order, n int) {
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.matches[order] += n
+	s.mu.Unlock()
+}
+
+func (s *sessionSummary) recordSwitch() {
+	s.mu.Lock()
+	s.filesSwitched++
+	s.mu.Unlock()
+}
+
+func (s *sessionSummary) write(w io.Writer, patterns []ColorPattern) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintln(w, "--- trail summary ---")
+	fmt.Fprintf(w, "lines seen:     %d\n", s.lines)
+	fmt.Fprintf(w, "files switched: %d\n", s.filesSwitched)
+	for _, pattern := range patterns {
+		fmt.Fprintf(w, "matches %s: %d\n", pattern.Spec, s.matches[pattern.Order])
+	}
+}
+
+// SIGINT / SIGTERM を受け取るチャネルを返す
+func notifyShutdown() <-chan os.Signal {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	return ch
+}
+
+// シグナル受信時に追従を止めて端末の状態を戻し、終了コードを返す
+func shutdown(h *Highlighter, state followState, sig os.Signal, showSummary bool) int {
+	finish(h, state, showSummary)
+	return signalExitCode(sig)
+}
+
+// 追従を止め、出力を flush して端末の色をリセットする
+func finish(h *Highlighter, state followState, showSummary bool) {
+	stopFollow(state)
+	triggers.wait()
+	output.clearStatus()
+	output.flush()
+	output.close()
+	tee.close()
+	if tuiView != nil {
+		tuiView.close()
+		log.SetOutput(os.Stderr)
+	}
+	if h != nil && h.enabled {
+		// 色付き出力の途中で止まっても端末に色が残らないようにする
+		fmt.Fprint(os.Stdout, "\x1b[0m")
+	}
+	if stats != nil {
+		fmt.Fprintln(os.Stderr, stats.line(h.userPatterns()))
+	}
+	if showSummary {
+		summary.write(os.Stderr, h.userPatterns())
+	}
+}
+
+// シェルの慣習に合わせて 128 + シグナル番号を返す
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+func TestSessionSummaryCountsLinesMatchesAndSwitches(t *testing.T) {
+	withReset(t)
+	h := userHighlighter(colorNever, "red:ERROR", "yellow:WARN")
+
+	captureOutput(t, func() {
+		printLine(h, "ERROR one ERROR two")
+		printLine(h, "WARN three")
+		printLine(h, "INFO four")
+	})
+	summary.recordSwitch()
+
+	var buf bytes.Buffer
+	summary.write(&buf, h.user)
+
+	got := buf.String()
+	requireContains(t, got, "lines seen:     3\n")
+	requireContains(t, got, "files switched: 1\n")
+	requireContains(t, got, "matches red:ERROR: 2\n")
+	requireContains(t, got, "matches yellow:WARN: 1\n")
+}
+
+func TestShutdownStopsFollowAndReturnsSignalExitCode(t *testing.T) {
+	withReset(t)
+	handle := &fakeFollowHandle{}
+	errCh := make(chan error)
+	close(errCh)
+
+	var code int
+	captureOutput(t, func() {
+		code = shutdown(nil, followState{path: "app.log", tail: handle, errCh: errCh}, syscall.SIGTERM, false)
+	})
+
+	if code != 128+int(syscall.SIGTERM) {
+		t.Fatalf("exit code = %d, want %d", code, 128+int(syscall.SIGTERM))
+	}
+	if !handle.stopped || !handle.cleaned {
+		t.Fatalf("tail stopped=%v cleaned=%v, want both true", handle.stopped, handle.cleaned)
+	}
+}
+
+func TestShutdownResetsColorWhenEnabled(t *testing.T) {
+	withReset(t)
+
+	out := captureStdout(t, func() {
+		shutdown(newHighlighter(colorAlways), followState{}, os.Interrupt, false)
+	})
+	if out != "\x1b[0m" {
+		t.Fatalf("shutdown output = %q, want color reset", out)
+	}
+
+	// --color never では color.NoColor によらずリセットを出さない
+	color.NoColor = false
+	out = captureStdout(t, func() {
+		shutdown(newHighlighter(colorNever), followState{}, os.Interrupt, false)
+	})
+	if out != "" {
+		t.Fatalf("shutdown output with --color never = %q, want nothing", out)
+	}
+}
+
+func TestFileModeExitsOnSignalWithSummary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals cannot be sent to child processes on windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("INFO start\nERROR boom\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	encodedArgs, err := json.Marshal([]string{"--no-logo", "file", "-summary", "-c", "red:ERROR", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestTrailHelperProcess$")
+	cmd.Env = append(os.Environ(),
+		"TRAIL_TEST_HELPER=1",
+		"TRAIL_TEST_ARGS="+string(encodedArgs),
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	err = cmd.Wait()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("wait error = %v, want exit error", err)
+	}
+	if got, want := exitErr.ExitCode(), 130; got != want {
+		t.Fatalf("exit code = %d, want %d; stderr=%q", got, want, stderr.String())
+	}
+	requireContains(t, stdout.String(), "ERROR boom\n")
+	requireContains(t, stderr.String(), "lines seen:     2\n")
+	requireContains(t, stderr.String(), "matches red:ERROR: 1\n")
+}
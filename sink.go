@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- 出力先 (--sink) ----------
+
+// 行の出力先。outputWriter が排他してから呼ぶので、実装は同時に呼ばれることを考えなくてよい。
+type lineSink interface {
+	writeLine(text string) error
+	flush() error
+	close() error
+}
+
+// --sink で指定された出力先。無ければ標準出力だけに書く。
+var sinkSpecs []string
+
+func addSinkSpec(spec string) {
+	if strings.TrimSpace(spec) == "" {
+		log.Fatal("missing value for --sink")
+	}
+	sinkSpecs = append(sinkSpecs, spec)
+}
+
+// --sink の指定で出力先を開き、output の出力先を置き換える
+func startSinks() {
+	if len(sinkSpecs) == 0 {
+		return
+	}
+	sinks := make([]namedSink, 0, len(sinkSpecs))
+	for _, spec := range sinkSpecs {
+		s, err := openSink(spec)
+		if err != nil {
+			for _, opened := range sinks {
+				opened.sink.close()
+			}
+			log.Fatalf("--sink %s: %v", spec, err)
+		}
+		sinks = append(sinks, namedSink{name: spec, sink: s})
+	}
+	output.setSinks(sinks)
+}
+
+// 出力先の指定を解釈して開く
+//   - stdout
+//   - file:<path>[,max-size=<size>][,backups=<n>]
+//   - syslog[:<unix|unixgram|udp|tcp>://<address>][,tag=<tag>]
+func openSink(spec string) (lineSink, error) {
+	kind, rest, _ := strings.Cut(spec, ":")
+	switch strings.ToLower(kind) {
+	case "stdout":
+		if rest != "" {
+			return nil, fmt.Errorf("stdout takes no options")
+		}
+		return newStdoutSink(), nil
+	case "file":
+		path, options, err := splitSinkOptions(rest)
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			return nil, fmt.Errorf("missing file path (expected file:<path>)")
+		}
+		var maxSize int64
+		backups := 3
+		for key, value := range options {
+			switch key {
+			case "max-size":
+				if maxSize, err = parseByteSize(value); err != nil {
+					return nil, err
+				}
+			case "backups":
+				if backups, err = strconv.Atoi(value); err != nil || backups < 0 {
+					return nil, fmt.Errorf("invalid backups %q", value)
+				}
+			default:
+				return nil, fmt.Errorf("unknown file option %q", key)
+			}
+		}
+		return openFileSink(path, maxSize, backups)
+	case "syslog":
+		address, options, err := splitSinkOptions(rest)
+		if err != nil {
+			return nil, err
+		}
+		tag := "trail"
+		for key, value := range options {
+			switch key {
+			case "tag":
+				tag = value
+			default:
+				return nil, fmt.Errorf("unknown syslog option %q", key)
+			}
+		}
+		network, addr := "", ""
+		if address != "" {
+			u, err := url.Parse(address)
+			if err != nil || u.Scheme == "" {
+				return nil, fmt.Errorf("invalid syslog address %q (expected unix:///dev/log, udp://host:514, tcp://host:514)", address)
+			}
+			network, addr = u.Scheme, u.Host
+			if network == "unix" || network == "unixgram" {
+				addr = u.Path
+			}
+		}
+		return dialSyslogSink(network, addr, tag)
+	default:
+		return nil, fmt.Errorf("unknown sink %q (expected stdout, file:<path>, syslog)", kind)
+	}
+}
+
+// "<値>,key=value,..." を分ける
+func splitSinkOptions(spec string) (string, map[string]string, error) {
+	parts := strings.Split(spec, ",")
+	options := make(map[string]string)
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || key == "" {
+			return "", nil, fmt.Errorf("invalid option %q (expected key=value)", part)
+		}
+		options[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return parts[0], options, nil
+}
+
+// 10MB, 512k, 1GiB のような大きさ (1024 倍ずつ)
+func parseByteSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffixes []string
+		n        int64
+	}{
+		{[]string{"gib", "gb", "g"}, 1 << 30},
+		{[]string{"mib", "mb", "m"}, 1 << 20},
+		{[]string{"kib", "kb", "k"}, 1 << 10},
+		{[]string{"b"}, 1},
+	} {
+		if i := slices.IndexFunc(unit.suffixes, func(suffix string) bool { return strings.HasSuffix(value, suffix) }); i >= 0 {
+			value = strings.TrimSuffix(value, unit.suffixes[i])
+			multiplier = unit.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 10MB)", s)
+	}
+	return n * multiplier, nil
+}
+
+// 端末の色などのエスケープシーケンス。ファイルと syslog には書かない。
+var ansiEscapeRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+func stripEscapes(text string) string {
+	if !strings.Contains(text, "\x1b") {
+		return text
+	}
+	return ansiEscapeRegexp.ReplaceAllString(text, "")
+}
+
+// ---------- stdout ----------
+
+// バッファ付きで書き込む。端末ならステータス行もここへ書く。
+type writerSink struct {
+	w *bufio.Writer
+}
+
+// 書き込み時点の os.Stdout へ委譲する
+type stdoutProxy struct{}
+
+func (stdoutProxy) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func newStdoutSink() *writerSink {
+	return &writerSink{w: bufio.NewWriter(stdoutProxy{})}
+}
+
+func (s *writerSink) writeLine(text string) error {
+	s.w.WriteString(text)
+	return s.w.WriteByte('\n')
+}
+
+func (s *writerSink) writeString(text string) {
+	s.w.WriteString(text)
+}
+
+func (s *writerSink) flush() error {
+	return s.w.Flush()
+}
+
+// 標準出力は閉じない
+func (s *writerSink) close() error {
+	return s.w.Flush()
+}
+
+// ---------- file ----------
+
+// ファイルへ追記する。maxSize を超える前に path.1, path.2 ... へ移して新しいファイルに切り替える。
+type fileSink struct {
+	path    string
+	maxSize int64 // 0 なら切り替えない
+	backups int   // 残す古いファイルの数
+
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+
+	rotateFailed bool // 切り替えに失敗した。開き直した後は maxSize 分書くまで切り替えない
+}
+
+func openFileSink(path string, maxSize int64, backups int) (*fileSink, error) {
+	s := &fileSink{path: path, maxSize: maxSize, backups: backups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.w, s.size = f, bufio.NewWriter(f), info.Size()
+	return nil
+}
+
+func (s *fileSink) writeLine(text string) error {
+	text = stripEscapes(text)
+	n := int64(len(text) + 1)
+	if s.w == nil {
+		// 切り替えに失敗した後は開き直してから書く。
+		// 行ごとに切り替えを試して古いファイルを何度もずらさないよう、大きさを数え直す
+		if err := s.open(); err != nil {
+			return err
+		}
+		if s.rotateFailed {
+			s.size, s.rotateFailed = 0, false
+		}
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+n > s.maxSize {
+		if err := s.rotate(); err != nil {
+			s.rotateFailed = true
+			return err
+		}
+	}
+	s.size += n
+	s.w.WriteString(text)
+	return s.w.WriteByte('\n')
+}
+
+// 古いファイルを 1 つずつずらし、新しいファイルを開く
+func (s *fileSink) rotate() error {
+	if err := s.close(); err != nil {
+		return err
+	}
+	if s.backups == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		for i := s.backups - 1; i >= 1; i-- {
+			if err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return s.open()
+}
+
+func (s *fileSink) flush() error {
+	if s.w == nil {
+		return nil
+	}
+	return s.w.Flush()
+}
+
+func (s *fileSink) close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f, s.w = nil, nil
+	return err
+}
+
+// ---------- syslog ----------
+
+// syslog へ 1 行ずつ送る。重大度は行のレベルから決める。
+// log/syslog は Windows で使えないので、同じ形式のメッセージを自前で組み立てる。
+type syslogSink struct {
+	network  string // 空なら既定のローカルのソケットを探す
+	address  string
+	tag      string
+	hostname string
+	conn     net.Conn
+	local    bool // ローカルのソケットにはホスト名を付けない
+}
+
+const syslogFacilityUser = 1
+
+// ローカルの syslog のソケット
+var syslogSocketPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+func dialSyslogSink(network, address, tag string) (*syslogSink, error) {
+	switch network {
+	case "", "unix", "unixgram", "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q (expected unix, unixgram, udp, tcp)", network)
+	}
+	hostname, _ := os.Hostname()
+	s := &syslogSink{network: network, address: address, tag: tag, hostname: hostname}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *syslogSink) connect() error {
+	if s.network != "" {
+		conn, err := net.DialTimeout(s.network, s.address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn, s.local = conn, s.network == "unix" || s.network == "unixgram"
+		return nil
+	}
+	for _, path := range syslogSocketPaths {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				s.conn, s.local = conn, true
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no syslog socket found (tried %s)", strings.Join(syslogSocketPaths, ", "))
+}
+
+func (s *syslogSink) writeLine(text string) error {
+	text = stripEscapes(text)
+	msg := s.format(syslogSeverity(detectSeverity(text)), time.Now(), text)
+	if s.conn != nil {
+		if _, err := io.WriteString(s.conn, msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	// 切断された場合は 1 度だけつなぎ直す
+	if err := s.connect(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(s.conn, msg)
+	return err
+}
+
+// ローカルは "<PRI>Jan  2 15:04:05 tag[pid]: msg"、ネットワーク越しはホスト名と RFC 3339 の時刻を付ける
+func (s *syslogSink) format(severity int, now time.Time, text string) string {
+	pri := syslogFacilityUser*8 + severity
+	var msg string
+	if s.local {
+		msg = fmt.Sprintf("<%d>%s %s[%d]: %s", pri, now.Format(time.Stamp), s.tag, os.Getpid(), text)
+	} else {
+		msg = fmt.Sprintf("<%d>%s %s %s[%d]: %s", pri, now.Format(time.RFC3339), s.hostname, s.tag, os.Getpid(), text)
+	}
+	if s.network == "tcp" {
+		// TCP はメッセージを改行で区切る
+		msg += "\n"
+	}
+	return msg
+}
+
+// 行のレベルに対応する syslog の重大度。分からない場合は info にする。
+func syslogSeverity(level severity) int {
+	for _, rule := range severityRules {
+		if rule.level == level && len(rule.syslog) > 0 {
+			// info (notice, info) のように複数ある場合は軽い方を使う
+			return slices.Max(rule.syslog)
+		}
+	}
+	if level == severityTrace {
+		return 7
+	}
+	return 6
+}
+
+func (s *syslogSink) flush() error {
+	return nil
+}
+
+func (s *syslogSink) close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// ---------- memory ----------
+
+// 行をメモリに溜める。テストや、出力を後から取り出したい場合に使う。
+type memorySink struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (s *memorySink) writeLine(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, text)
+	return nil
+}
+
+func (s *memorySink) flush() error { return nil }
+
+func (s *memorySink) close() error { return nil }
+
+// 書き込まれた行を改行付きでつなげて返す
+func (s *memorySink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	for _, line := range s.lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
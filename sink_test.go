@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenSinkRejectsInvalidSpecs(t *testing.T) {
+	dir := t.TempDir()
+	for _, spec := range []string{
+		"nope",
+		"stdout:x",
+		"file:",
+		"file:" + filepath.Join(dir, "a.log") + ",max-size=big",
+		"file:" + filepath.Join(dir, "a.log") + ",backups=-1",
+		"file:" + filepath.Join(dir, "a.log") + ",color=on",
+		"file:" + filepath.Join(dir, "a.log") + ",max-size",
+		"file:" + filepath.Join(dir, "missing", "a.log"),
+		"syslog:localhost:514",
+		"syslog:ftp://localhost:514",
+		"syslog:udp://127.0.0.1:514,facility=local0",
+	} {
+		if s, err := openSink(spec); err == nil {
+			s.close()
+			t.Errorf("openSink(%q) error = nil", spec)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"100", 100},
+		{"100B", 100},
+		{"512k", 512 << 10},
+		{"10MB", 10 << 20},
+		{"2MiB", 2 << 20},
+		{"1 GB", 1 << 30},
+	}
+	for _, tt := range tests {
+		if got, err := parseByteSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "MB", "-1MB", "0", "1TB"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) error = nil", in)
+		}
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	return strings.Split(strings.TrimSuffix(readTestFile(t, path), "\n"), "\n")
+}
+
+func TestFileSinkRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	if err := os.WriteFile(path, []byte("old-00\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openSink("file:" + path + ",max-size=21,backups=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1 行 7 バイトなので 3 行ごとに切り替わり、最も古いファイルは消える
+	for i := 1; i <= 10; i++ {
+		if err := s.writeLine(fmt.Sprintf("\x1b[31mline-%d\x1b[0m", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]string{
+		path:        "line-9|line-10",
+		path + ".1": "line-6|line-7|line-8",
+		path + ".2": "line-3|line-4|line-5",
+	} {
+		if got := strings.Join(readLines(t, file), "|"); got != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 should not exist (backups=2): %v", path, err)
+	}
+}
+
+func TestFileSinkWithoutBackupsStartsOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	s, err := openFileSink(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"aaaa", "bbbb", "cccc"} {
+		if err := s.writeLine(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.close()
+	if got := strings.Join(readLines(t, path), "|"); got != "cccc" {
+		t.Fatalf("out.log = %q, want only the last line", got)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Fatalf("unexpected backups %v", matches)
+	}
+}
+
+func TestFileSinkBacksOffAfterFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	// out.log.2 がディレクトリなので 2 回目の切り替えで out.log.1 をずらせない
+	if err := os.MkdirAll(filepath.Join(path+".2", "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openFileSink(path, 14, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 7; i++ {
+		err := s.writeLine(fmt.Sprintf("line-%d", i))
+		if (err != nil) != (i == 5) {
+			t.Fatalf("line-%d: err = %v", i, err)
+		}
+	}
+	s.close()
+
+	for file, want := range map[string]string{
+		path:        "line-3|line-4|line-6|line-7",
+		path + ".1": "line-1|line-2",
+	} {
+		if got := strings.Join(readLines(t, file), "|"); got != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+}
+
+func TestSyslogSinkOverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := openSink("syslog:udp://" + conn.LocalAddr().String() + ",tag=api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	hostname, _ := os.Hostname()
+	tests := []struct {
+		line string
+		pri  string
+		msg  string
+	}{
+		{"\x1b[31mERROR\x1b[0m boom", "<11>", "ERROR boom"},
+		{"level=warn slow", "<12>", "level=warn slow"},
+		{"plain", "<14>", "plain"},
+		{"FATAL out of memory", "<10>", "FATAL out of memory"},
+	}
+	buf := make([]byte, 1024)
+	for _, tt := range tests {
+		if err := s.writeLine(tt.line); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(buf[:n])
+		want := regexp.MustCompile(`^` + regexp.QuoteMeta(tt.pri) + `\d{4}-\d\d-\d\dT\S+ ` + regexp.QuoteMeta(fmt.Sprintf("%s api[%d]: %s", hostname, os.Getpid(), tt.msg)) + `$`)
+		if !want.MatchString(got) {
+			t.Errorf("syslog message = %q, want %s", got, want)
+		}
+	}
+}
+
+func TestSyslogSinkOverTCPTerminatesMessages(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	s, err := openSink("syslog:tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, line := range []string{"first", "DEBUG second"} {
+		if err := s.writeLine(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"<14>", "<15>"} {
+		msg, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(msg, want) || !strings.Contains(msg, " trail[") {
+			t.Errorf("message = %q, want %s... trail[pid]", msg, want)
+		}
+	}
+}
+
+func TestSyslogSinkOverUnixSocketOmitsHostname(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix datagram sockets are not available on windows")
+	}
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Skipf("unixgram not supported: %v", err)
+	}
+	defer conn.Close()
+
+	s, err := openSink("syslog:unixgram://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if err := s.writeLine("INFO ready"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := regexp.MustCompile(fmt.Sprintf(`^<14>[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d trail\[%d\]: INFO ready$`, os.Getpid()))
+	if got := string(buf[:n]); !want.MatchString(got) {
+		t.Fatalf("message = %q, want %s", got, want)
+	}
+}
+
+type failingSink struct {
+	writes int
+}
+
+func (s *failingSink) writeLine(string) error {
+	s.writes++
+	return errors.New("disk full")
+}
+
+func (s *failingSink) flush() error { return nil }
+
+func (s *failingSink) close() error { return nil }
+
+func TestOutputWriterFansOutToAllSinks(t *testing.T) {
+	withReset(t)
+	first, second := &memorySink{}, &memorySink{}
+	broken := &failingSink{}
+	saved := output
+	output = newOutputWriter(
+		namedSink{name: "first", sink: first},
+		namedSink{name: "file:/full/disk.log", sink: broken},
+		namedSink{name: "second", sink: second},
+	)
+	t.Cleanup(func() { output = saved })
+	h := userHighlighter(colorAlways, "red:ERROR")
+
+	logs := captureLogOutput(t, func() {
+		printLine(h, "ERROR one")
+		printLine(h, "two")
+	})
+
+	want := ansi("31", "ERROR") + " one\ntwo\n"
+	if first.String() != want || second.String() != want {
+		t.Fatalf("sinks = %q, %q; want %q in both", first.String(), second.String(), want)
+	}
+	// 書けない出力先があっても他へは書き、エラーは 1 度だけ知らせる
+	if broken.writes != 2 || strings.Count(logs, "failed to write to file:/full/disk.log: disk full") != 1 {
+		t.Fatalf("writes = %d, logs = %q", broken.writes, logs)
+	}
+	if output.writesToStdout() {
+		t.Fatal("writesToStdout = true without a stdout sink")
+	}
+}
+
+func TestSinkFlagWritesPlainLinesToFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("INFO start\nERROR boom\nready\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outPath := filepath.Join(dir, "out.log")
+
+	result := runTrailHelper(t, "--no-logo", "--color", "always", "--sink", "file:"+outPath, "--sink=stdout",
+		"file", "-c", "red:ERROR", "-until-match", "ready", path)
+	if result.code != 0 {
+		t.Fatalf("exit code = %d, stderr = %q", result.code, result.stderr)
+	}
+	requireContains(t, result.stdout, ansi("31", "ERROR")+" boom")
+	if got := readTestFile(t, outPath); got != "INFO start\nERROR boom\nready\n" {
+		t.Fatalf("out.log = %q, want plain lines", got)
+	}
+}
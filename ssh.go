@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+// ---------- サブコマンド: ssh ----------
+
+// 相手のホストに trail を入れずに、SSH で tail -F を実行して行を受け取る。
+// 色付けや絞り込みは file モードと同じく手元で行う。
+
+// 既定で試す秘密鍵 (~/.ssh からの相対パス)
+var defaultSSHIdentities = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// 接続先。[user@]host:/path
+type sshTarget struct {
+	user string
+	host string
+	port string
+	path string
+}
+
+func (t sshTarget) addr() string {
+	return net.JoinHostPort(t.host, t.port)
+}
+
+// [user@]host:path を解析する。host が IPv6 アドレスなら [::1]:path のように括弧で囲む。
+func parseSSHTarget(s string, port int) (sshTarget, error) {
+	target := sshTarget{port: strconv.Itoa(port)}
+	if user, rest, ok := strings.Cut(s, "@"); ok {
+		if user == "" {
+			return sshTarget{}, fmt.Errorf("empty user in %q", s)
+		}
+		target.user, s = user, rest
+	}
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]:")
+		if end < 0 {
+			return sshTarget{}, fmt.Errorf("invalid target %q (expected [user@]host:/path)", s)
+		}
+		target.host, target.path = s[1:end], s[end+2:]
+	} else {
+		host, path, ok := strings.Cut(s, ":")
+		if !ok {
+			return sshTarget{}, fmt.Errorf("missing path in %q (expected [user@]host:/path)", s)
+		}
+		target.host, target.path = host, path
+	}
+	if target.host == "" {
+		return sshTarget{}, fmt.Errorf("missing host in %q", s)
+	}
+	if target.path == "" {
+		return sshTarget{}, fmt.Errorf("missing path in %q", s)
+	}
+	if target.user == "" {
+		target.user = currentUserName()
+	}
+	return target, nil
+}
+
+func currentUserName() string {
+	for _, name := range []string{"USER", "USERNAME", "LOGNAME"} {
+		if user := os.Getenv(name); user != "" {
+			return user
+		}
+	}
+	return "root"
+}
+
+// sh に渡すため単一引用符で囲む
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// 相手のホストで実行するコマンド。-F でローテーションにも追従する。
+// 引用符の中では ~ が展開されないので、先頭の ~/ は "$HOME"/ に置き換える。
+func remoteTailCommand(path string, n int) string {
+	arg := shellQuote(path)
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		arg = `"$HOME"/` + shellQuote(rest)
+	}
+	return fmt.Sprintf("tail -n %d -F -- %s", n, arg)
+}
+
+// 認証と相手の確認の設定
+type sshAuthOptions struct {
+	identities []string // -i。空なら ~/.ssh の既定の鍵
+	knownHosts []string // -known-hosts。空なら ~/.ssh/known_hosts と /etc/ssh/ssh_known_hosts
+	password   bool     // 端末ならパスワードも尋ねる
+}
+
+// ssh-agent・秘密鍵・パスワードの順に試す設定を作る。返す関数で ssh-agent との接続を閉じる。
+func sshClientConfig(target sshTarget, opts sshAuthOptions) (*ssh.ClientConfig, func(), error) {
+	hostKeys, algorithms, err := sshHostKeyCallback(target, opts.knownHosts)
+	if err != nil {
+		return nil, nil, err
+	}
+	var methods []ssh.AuthMethod
+	cleanup := func() {}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			cleanup = func() { conn.Close() }
+		} else {
+			log.Printf("ssh-agent not available: %v", err)
+		}
+	}
+	signers, err := sshIdentitySigners(opts.identities)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if opts.password {
+		methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+			return readSecret(fmt.Sprintf("%s@%s's password: ", target.user, target.host))
+		}))
+	}
+	if len(methods) == 0 {
+		cleanup()
+		return nil, nil, errors.New("no SSH credentials: start ssh-agent, add a key with -i, or run from a terminal to enter a password")
+	}
+	return &ssh.ClientConfig{
+		User:              target.user,
+		Auth:              methods,
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: algorithms,
+		Timeout:           15 * time.Second,
+	}, cleanup, nil
+}
+
+// known_hosts で相手を確かめる。known_hosts にある鍵の種類を優先して交渉するよう、その一覧も返す。
+func sshHostKeyCallback(target sshTarget, files []string) (ssh.HostKeyCallback, []string, error) {
+	if len(files) == 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			files = append(files, filepath.Join(home, ".ssh", "known_hosts"))
+		}
+		files = append(files, "/etc/ssh/ssh_known_hosts")
+		var existing []string
+		for _, file := range files {
+			if _, err := os.Stat(file); err == nil {
+				existing = append(existing, file)
+			}
+		}
+		files = existing
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no known_hosts file; connect once with ssh or add the host key with ssh-keyscan %s >> ~/.ssh/known_hosts", target.host)
+	}
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("known_hosts: %v", err)
+	}
+	check := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("host key for %s is not in %s; connect once with ssh or add it with ssh-keyscan", hostname, strings.Join(files, ", "))
+		}
+		if errors.As(err, &keyErr) {
+			return fmt.Errorf("host key for %s does not match %s:%d (possible man-in-the-middle attack)", hostname, keyErr.Want[0].Filename, keyErr.Want[0].Line)
+		}
+		return err
+	}
+	return check, knownHostKeyTypes(callback, target), nil
+}
+
+// known_hosts に登録された鍵の種類。ダミーの鍵で照合した結果から取り出す。
+func knownHostKeyTypes(callback ssh.HostKeyCallback, target sshTarget) []string {
+	addr := &net.TCPAddr{IP: net.ParseIP(target.host)}
+	if addr.IP == nil {
+		addr.IP = net.IPv4zero
+	}
+	if port, err := strconv.Atoi(target.port); err == nil {
+		addr.Port = port
+	}
+	var keyErr *knownhosts.KeyError
+	if err := callback(target.addr(), addr, dummyHostKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var types []string
+	for _, known := range keyErr.Want {
+		switch keyType := known.Key.Type(); keyType {
+		case ssh.KeyAlgoRSA:
+			// RSA の鍵は SHA-2 の署名でも使える
+			types = append(types, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			types = append(types, keyType)
+		}
+	}
+	return types
+}
+
+// known_hosts の照合に使う、どの鍵とも一致しない鍵
+type dummyHostKey struct{}
+
+func (dummyHostKey) Type() string                                 { return "trail-dummy" }
+func (dummyHostKey) Marshal() []byte                              { return []byte("trail-dummy") }
+func (dummyHostKey) Verify(data []byte, sig *ssh.Signature) error { return errors.New("dummy key") }
+
+// 秘密鍵を読み込む。既定の鍵は、無いものやパスフレーズが必要なものを飛ばす (ssh-agent に任せる)。
+func sshIdentitySigners(files []string) ([]ssh.Signer, error) {
+	explicit := len(files) > 0
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		for _, name := range defaultSSHIdentities {
+			files = append(files, filepath.Join(home, ".ssh", name))
+		}
+	}
+	var signers []ssh.Signer
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if explicit {
+				return nil, fmt.Errorf("-i: %v", err)
+			}
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			if !explicit {
+				continue
+			}
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				return nil, fmt.Errorf("-i %s: the key needs a passphrase; add it to ssh-agent instead", file)
+			}
+			passphrase, promptErr := readSecret(fmt.Sprintf("Enter passphrase for %s: ", file))
+			if promptErr != nil {
+				return nil, promptErr
+			}
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+		}
+		if err != nil {
+			if explicit {
+				return nil, fmt.Errorf("-i %s: %v", file, err)
+			}
+			log.Printf("skipping %s: %v", file, err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// 端末から表示せずに読み取る
+func readSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("cannot prompt without a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(secret), err
+}
+
+// 相手のコマンドが失敗した。実行し直しても同じなので諦める。
+type remoteExitError struct {
+	status int
+	stderr string
+}
+
+func (e *remoteExitError) Error() string {
+	if e.stderr == "" {
+		return fmt.Sprintf("remote tail exited with status %d", e.status)
+	}
+	return fmt.Sprintf("remote tail exited with status %d: %s", e.status, e.stderr)
+}
+
+// SSH で tail -F を実行し、受け取った行を出力する
+type sshTail struct {
+	target sshTarget
+	config *ssh.ClientConfig
+	lines  int
+	// 切断されてから接続し直すまでの間隔。0 なら接続し直さない
+	retry time.Duration
+
+	connected bool // 1 度でもコマンドを実行できた
+}
+
+// ctx が終わるか、相手のコマンドが終わるまで行を受け取る。切断されたら -n 0 で実行し直す。
+func (s *sshTail) run(ctx context.Context, h *Highlighter) error {
+	return runReconnecting(ctx, s.retry,
+		func(ctx context.Context) error { return s.stream(ctx, h) },
+		func(err error) bool {
+			var exitErr *remoteExitError
+			return s.connected && !errors.As(err, &exitErr)
+		},
+		func(err error) {
+			log.Printf("connection to %s lost: %v; reconnecting in %s (lines written meanwhile are skipped)", s.target.host, err, s.retry)
+		})
+}
+
+func (s *sshTail) dial(ctx context.Context) (*ssh.Client, error) {
+	dialer := &net.Dialer{Timeout: s.config.Timeout, KeepAlive: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", s.target.addr())
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, s.target.addr(), s.config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func (s *sshTail) stream(ctx context.Context, h *Highlighter) error {
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start(remoteTailCommand(s.target.path, s.lines)); err != nil {
+		return err
+	}
+	if s.connected {
+		log.Printf("reconnected to %s", s.target.host)
+	}
+	s.connected = true
+	// 次の接続では続きだけを受け取る
+	s.lines = 0
+
+	var wg sync.WaitGroup
+	var lastStderr string
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			lastStderr = scanner.Text()
+			log.Printf("%s: %s", s.target.host, lastStderr)
+		}
+	}()
+
+	r := bufio.NewReader(stdout)
+	for {
+		text, err := r.ReadString('\n')
+		if text != "" {
+			writeLine(h, s.target.path, strings.TrimSuffix(text, "\n"))
+			if r.Buffered() == 0 {
+				output.flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	output.flush()
+	wg.Wait()
+
+	err = session.Wait()
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return &remoteExitError{status: exitErr.ExitStatus(), stderr: lastStderr}
+	}
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func cmdSSH(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("ssh", flag.ExitOnError)
+	nLines := fs.Int("n", 10, "show last N lines then follow")
+	port := fs.Int("p", 22, "SSH port")
+	var identities repeatedStrings
+	fs.Var(&identities, "i", "private key file (can be used multiple times; default ~/.ssh/id_ed25519, id_ecdsa, id_rsa)")
+	var knownHosts repeatedStrings
+	fs.Var(&knownHosts, "known-hosts", "known_hosts file (can be used multiple times; default ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts)")
+	retry := fs.Duration("retry", 2*time.Second, "wait before reconnecting after the connection drops (0 to exit instead)")
+	pipeline := registerPipelineFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: trail ssh [options] [user@]host:/path")
+	}
+	validateLineCount(*nLines)
+	if *retry < 0 {
+		log.Fatalf("-retry must be >= 0")
+	}
+	target, err := parseSSHTarget(fs.Arg(0), *port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, cleanup, err := sshClientConfig(target, sshAuthOptions{
+		identities: identities,
+		knownHosts: knownHosts,
+		// TUI は端末を使うのでパスワードを尋ねない
+		password: !tuiMode && term.IsTerminal(int(os.Stdin.Fd())),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	tail := &sshTail{target: target, config: config, lines: *nLines, retry: *retry}
+
+	highlighter := pipeline.start(opts, target.path)
+	// runSource は os.Exit で終わるので、ssh-agent への接続は run の中で閉じる
+	runSource(highlighter, fs.Arg(0), *pipeline.showSummary, func(ctx context.Context) error {
+		defer cleanup()
+		return tail.run(ctx, highlighter)
+	})
+}
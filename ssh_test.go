@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestParseSSHTarget(t *testing.T) {
+	t.Setenv("USER", "me")
+	tests := []struct {
+		in   string
+		want sshTarget
+	}{
+		{"deploy@web1:/var/log/app.log", sshTarget{"deploy", "web1", "22", "/var/log/app.log"}},
+		{"web1:app.log", sshTarget{"me", "web1", "22", "app.log"}},
+		{"ops@[::1]:/var/log/a:b.log", sshTarget{"ops", "::1", "22", "/var/log/a:b.log"}},
+	}
+	for _, tt := range tests {
+		got, err := parseSSHTarget(tt.in, 22)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSSHTarget(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"web1", "@web1:/a", "web1:", ":/var/log", "[::1]/a"} {
+		if _, err := parseSSHTarget(in, 22); err == nil {
+			t.Errorf("parseSSHTarget(%q) error = nil", in)
+		}
+	}
+	if got := remoteTailCommand("/var/log/it's here.log", 5); got != `tail -n 5 -F -- '/var/log/it'\''s here.log'` {
+		t.Errorf("remoteTailCommand = %q", got)
+	}
+	if got := remoteTailCommand("~/logs/app.log", 0); got != `tail -n 0 -F -- "$HOME"/'logs/app.log'` {
+		t.Errorf("remoteTailCommand = %q", got)
+	}
+}
+
+func newTestSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, key
+}
+
+// 1 回の exec を処理する。戻り値は exit-status (負なら送らずに切断する)。
+type testSSHHandler func(conn int, command string, ch ssh.Channel) int
+
+// 公開鍵認証だけを受け付ける SSH サーバーの代わり
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.Signer
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func startTestSSHServer(t *testing.T, authorized ssh.PublicKey, handle testSSHHandler) *testSSHServer {
+	t.Helper()
+	hostKey, _ := newTestSigner(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "deploy" && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", meta.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &testSSHServer{addr: ln.Addr().String(), hostKey: hostKey}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		ln.Close()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; ; n++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				server.serveConn(n, conn, config, handle)
+			}()
+		}
+	}()
+	return server
+}
+
+func (s *testSSHServer) serveConn(n int, conn net.Conn, config *ssh.ServerConfig, handle testSSHHandler) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			return
+		}
+		for req := range requests {
+			if req.Type != "exec" {
+				req.Reply(false, nil)
+				continue
+			}
+			var exec struct{ Command string }
+			ssh.Unmarshal(req.Payload, &exec)
+			req.Reply(true, nil)
+			s.mu.Lock()
+			s.commands = append(s.commands, exec.Command)
+			s.mu.Unlock()
+			status := handle(n, exec.Command, ch)
+			if status < 0 {
+				// exit-status を送らずに接続ごと切る
+				sconn.Close()
+				return
+			}
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			ch.Close()
+			break
+		}
+	}
+}
+
+func (s *testSSHServer) executed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *testSSHServer) target(t *testing.T, path string) sshTarget {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(s.addr)
+	return sshTarget{user: "deploy", host: host, port: port, path: path}
+}
+
+// サーバーのホスト鍵だけを登録した known_hosts
+func (s *testSSHServer) knownHosts(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, s.hostKey.PublicKey())
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeTestIdentity(t *testing.T, key ed25519.PrivateKey) string {
+	t.Helper()
+	block, err := ssh.MarshalPrivateKey(key, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 行を送ってからクライアントが閉じるまで待つ
+func streamThenWait(lines string) testSSHHandler {
+	return func(_ int, _ string, ch ssh.Channel) int {
+		io.WriteString(ch, lines)
+		io.Copy(io.Discard, ch)
+		return 0
+	}
+}
+
+func TestSSHTailStreamsWithIdentityFile(t *testing.T) {
+	withReset(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	signer, key := newTestSigner(t)
+	server := startTestSSHServer(t, signer.PublicKey(), streamThenWait("INFO ready\r\nERROR boom\n"))
+	target := server.target(t, "/var/log/app.log")
+	config, cleanup, err := sshClientConfig(target, sshAuthOptions{
+		identities: []string{writeTestIdentity(t, key)},
+		knownHosts: []string{server.knownHosts(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if len(config.HostKeyAlgorithms) != 1 || config.HostKeyAlgorithms[0] != ssh.KeyAlgoED25519 {
+		t.Fatalf("HostKeyAlgorithms = %v, want the known_hosts key type", config.HostKeyAlgorithms)
+	}
+
+	waitOutput := memoryOutput(t)
+	tail := &sshTail{target: target, config: config, lines: 2}
+	h := userHighlighter(colorAlways, "red:ERROR")
+	runTestSource(t, func(ctx context.Context) error { return tail.run(ctx, h) })
+	if got := waitOutput("boom\n"); got != "INFO ready\n"+ansi("31", "ERROR")+" boom\n" {
+		t.Fatalf("output = %q", got)
+	}
+	if got := server.executed(); len(got) != 1 || got[0] != "tail -n 2 -F -- '/var/log/app.log'" {
+		t.Fatalf("commands = %q", got)
+	}
+}
+
+func TestSSHTailUsesAgentAndReconnects(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	signer, key := newTestSigner(t)
+	// ssh-agent の代わり
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	// 手元の ~/.ssh の鍵は使わない
+	t.Setenv("HOME", t.TempDir())
+
+	server := startTestSSHServer(t, signer.PublicKey(), func(conn int, _ string, ch ssh.Channel) int {
+		if conn == 0 {
+			io.WriteString(ch, "before\n")
+			// 切断された場合は接続し直す
+			return -1
+		}
+		io.WriteString(ch, "after\n")
+		io.Copy(io.Discard, ch)
+		return 0
+	})
+	target := server.target(t, "/var/log/app.log")
+	config, cleanup, err := sshClientConfig(target, sshAuthOptions{knownHosts: []string{server.knownHosts(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	waitOutput := memoryOutput(t)
+	tail := &sshTail{target: target, config: config, lines: 10, retry: 10 * time.Millisecond}
+	runTestSource(t, func(ctx context.Context) error { return tail.run(ctx, nil) })
+	if got := waitOutput("after\n"); got != "before\nafter\n" {
+		t.Fatalf("output = %q", got)
+	}
+	// 接続し直したときは続きだけを受け取る
+	want := []string{"tail -n 10 -F -- '/var/log/app.log'", "tail -n 0 -F -- '/var/log/app.log'"}
+	if got := server.executed(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestSSHTailStopsOnRemoteFailure(t *testing.T) {
+	withReset(t)
+	log.SetOutput(io.Discard)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	signer, key := newTestSigner(t)
+	server := startTestSSHServer(t, signer.PublicKey(), func(_ int, _ string, ch ssh.Channel) int {
+		io.WriteString(ch.Stderr(), "tail: cannot open '/nope' for reading: Permission denied\n")
+		return 1
+	})
+	target := server.target(t, "/nope")
+	config, cleanup, err := sshClientConfig(target, sshAuthOptions{
+		identities: []string{writeTestIdentity(t, key)},
+		knownHosts: []string{server.knownHosts(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	tail := &sshTail{target: target, config: config, retry: 10 * time.Millisecond}
+	select {
+	case err := <-runTestSource(t, func(ctx context.Context) error { return tail.run(ctx, nil) }):
+		if err == nil || err.Error() != "remote tail exited with status 1: tail: cannot open '/nope' for reading: Permission denied" {
+			t.Fatalf("run = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run kept retrying after the remote command failed")
+	}
+}
+
+func TestSSHTailRefusesUnknownHostsAndKeys(t *testing.T) {
+	withReset(t)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	signer, key := newTestSigner(t)
+	server := startTestSSHServer(t, signer.PublicKey(), streamThenWait("secret\n"))
+	target := server.target(t, "/var/log/app.log")
+	identity := writeTestIdentity(t, key)
+
+	run := func(config *ssh.ClientConfig) error {
+		t.Helper()
+		tail := &sshTail{target: target, config: config, retry: 10 * time.Millisecond}
+		select {
+		case err := <-runTestSource(t, func(ctx context.Context) error { return tail.run(ctx, nil) }):
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("run kept retrying")
+			return nil
+		}
+	}
+
+	// known_hosts に無いホスト
+	other := startTestSSHServer(t, signer.PublicKey(), streamThenWait(""))
+	config, _, err := sshClientConfig(target, sshAuthOptions{identities: []string{identity}, knownHosts: []string{other.knownHosts(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(config); err == nil || !strings.Contains(err.Error(), "host key for "+server.addr+" is not in") {
+		t.Fatalf("unknown host: run = %v", err)
+	}
+
+	// 登録と違う鍵のホスト
+	mismatched := filepath.Join(t.TempDir(), "known_hosts")
+	otherKey, _ := newTestSigner(t)
+	os.WriteFile(mismatched, []byte(knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, otherKey.PublicKey())+"\n"), 0600)
+	config, _, err = sshClientConfig(target, sshAuthOptions{identities: []string{identity}, knownHosts: []string{mismatched}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(config); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("mismatched host key: run = %v", err)
+	}
+
+	// 許可されていない鍵
+	_, wrongKey := newTestSigner(t)
+	config, _, err = sshClientConfig(target, sshAuthOptions{identities: []string{writeTestIdentity(t, wrongKey)}, knownHosts: []string{server.knownHosts(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(config); err == nil || !strings.Contains(err.Error(), "unable to authenticate") {
+		t.Fatalf("wrong key: run = %v", err)
+	}
+	if got := server.executed(); len(got) != 0 {
+		t.Fatalf("commands = %q, want none", got)
+	}
+
+	if _, _, err := sshClientConfig(target, sshAuthOptions{knownHosts: []string{server.knownHosts(t)}, identities: []string{filepath.Join(t.TempDir(), "missing")}}); err == nil {
+		t.Fatal("missing -i file error = nil")
+	}
+}
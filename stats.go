@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// ---------- 流量とマッチ数の統計 ----------
+
+// 直近 1 分を 1 秒単位で数えるリングバッファ
+const rateWindowSeconds = 60
+
+type rateWindow struct {
+	buckets [rateWindowSeconds]int64
+	stamps  [rateWindowSeconds]int64 // 各バケットが表す UNIX 秒
+}
+
+func (r *rateWindow) add(now time.Time, n int64) {
+	sec := now.Unix()
+	i := sec % rateWindowSeconds
+	if r.stamps[i] != sec {
+		r.stamps[i] = sec
+		r.buckets[i] = 0
+	}
+	r.buckets[i] += n
+}
+
+// now を含まない直近 seconds 秒の合計
+func (r *rateWindow) sum(now time.Time, seconds int64) int64 {
+	cur := now.Unix()
+	var total int64
+	for i := range r.buckets {
+		age := cur - r.stamps[i]
+		if age >= 1 && age <= seconds {
+			total += r.buckets[i]
+		}
+	}
+	return total
+}
+
+type fileStats struct {
+	lines    int64
+	bytes    int64
+	lineRate rateWindow
+	byteRate rateWindow
+}
+
+type statsCollector struct {
+	mu       sync.Mutex
+	files    map[string]*fileStats
+	order    []string
+	patterns map[int]*rateWindow
+	now      func() time.Time
+}
+
+var stats *statsCollector
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		files:    make(map[string]*fileStats),
+		patterns: make(map[int]*rateWindow),
+		now:      time.Now,
+	}
+}
+
+func (s *statsCollector) recordLine(source string, bytes int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[source]
+	if !ok {
+		f = &fileStats{}
+		s.files[source] = f
+		s.order = append(s.order, source)
+	}
+	now := s.now()
+	f.lines++
+	f.bytes += int64(bytes)
+	f.lineRate.add(now, 1)
+	f.byteRate.add(now, int64(bytes))
+}
+
+func (s *statsCollector) recordMatches(order, n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.patterns[order]
+	if !ok {
+		r = &rateWindow{}
+		s.patterns[order] = r
+	}
+	r.add(s.now(), int64(n))
+}
+
+// 1 行のステータス表示を組み立てる
+func (s *statsCollector) line(patterns []ColorPattern) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+
+	var parts []string
+	for _, source := range s.order {
+		f := s.files[source]
+		name := filepath.Base(source)
+		if source == "" {
+			name = "-"
+		}
+		parts = append(parts, fmt.Sprintf("%s %d lines, %d/s, %s/s, %s read",
+			name, f.lines, f.lineRate.sum(now, 1), formatBytes(f.byteRate.sum(now, 1)), formatBytes(f.bytes)))
+	}
+	for _, pattern := range patterns {
+		var perMinute int64
+		if r, ok := s.patterns[pattern.Order]; ok {
+			perMinute = r.sum(now, rateWindowSeconds)
+		}
+		parts = append(parts, fmt.Sprintf("%s %d/min", pattern.Spec, perMinute))
+	}
+	return "[stats] " + strings.Join(parts, " | ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type statsFlags struct {
+	enabled  *bool
+	interval *time.Duration
+}
+
+func registerStatsFlags(fs *flag.FlagSet) statsFlags {
+	return statsFlags{
+		enabled:  fs.Bool("stats", false, "show per-file rates and per-pattern matches per minute"),
+		interval: fs.Duration("stats-interval", time.Second, "refresh interval for -stats"),
+	}
+}
+
+// 統計を有効にする。端末ではステータス行を最下行に固定し、それ以外は stderr へ定期出力する。
+func startStats(opts statsFlags, h *Highlighter) {
+	if !*opts.enabled {
+		return
+	}
+	if *opts.interval <= 0 {
+		log.Fatalf("-stats-interval must be > 0")
+	}
+	stats = newStatsCollector()
+	if tuiMode {
+		// TUI のステータス行に表示する
+		return
+	}
+
+	sticky := !jsonOutput && output.writesToStdout() && (isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd()))
+	if sticky {
+		output.setStatus(func() string {
+			return stats.line(h.userPatterns())
+		})
+	}
+	go func() {
+		ticker := time.NewTicker(*opts.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if sticky {
+				output.flush()
+			} else {
+				fmt.Fprintln(os.Stderr, stats.line(h.userPatterns()))
+			}
+		}
+	}()
+}
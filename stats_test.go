@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRateWindowSumsCompletedSeconds(t *testing.T) {
+	var r rateWindow
+	base := time.Unix(1_000_000, 0)
+
+	r.add(base, 5)
+	r.add(base.Add(500*time.Millisecond), 5)
+	r.add(base.Add(time.Second), 3)
+	r.add(base.Add(2*time.Second), 7) // 現在の秒は数えない
+
+	now := base.Add(2 * time.Second)
+	if got := r.sum(now, 1); got != 3 {
+		t.Fatalf("sum(1s) = %d, want 3", got)
+	}
+	if got := r.sum(now, 60); got != 13 {
+		t.Fatalf("sum(60s) = %d, want 13", got)
+	}
+	if got := r.sum(base.Add(2*time.Minute), 60); got != 0 {
+		t.Fatalf("sum after window = %d, want 0", got)
+	}
+}
+
+func TestRateWindowReusesStaleBuckets(t *testing.T) {
+	var r rateWindow
+	base := time.Unix(2_000_000, 0)
+
+	r.add(base, 100)
+	r.add(base.Add(rateWindowSeconds*time.Second), 1)
+
+	if got := r.sum(base.Add((rateWindowSeconds+1)*time.Second), 1); got != 1 {
+		t.Fatalf("sum = %d, want 1 (stale bucket must be reset)", got)
+	}
+}
+
+func TestStatsCollectorLine(t *testing.T) {
+	withReset(t)
+	h := userHighlighter(colorNever, "red:ERROR", "yellow:WARN")
+
+	stats = newStatsCollector()
+	now := time.Unix(3_000_000, 0)
+	stats.now = func() time.Time { return now }
+
+	captureOutput(t, func() {
+		for i := 0; i < 4; i++ {
+			writeLine(h, "/var/log/app.log", "ERROR boom")
+		}
+		writeLine(h, "/var/log/app.log", "WARN slow")
+		writeLine(h, "/var/log/other.log", "INFO")
+		output.flush()
+	})
+	now = now.Add(time.Second)
+
+	got := stats.line(h.user)
+	requireContains(t, got, "[stats] app.log 5 lines, 5/s, 54 B/s, 54 B read | ")
+	requireContains(t, got, "other.log 1 lines, 1/s, 5 B/s, 5 B read")
+	requireContains(t, got, "red:ERROR 4/min")
+	requireContains(t, got, "yellow:WARN 1/min")
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Fatalf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestOutputWriterKeepsStatusLineAtBottom(t *testing.T) {
+	withReset(t)
+	status := "[stats] 1"
+	var term bytes.Buffer
+	mem := &memorySink{}
+	saved := output
+	output = newOutputWriter(
+		namedSink{name: "stdout", sink: &writerSink{w: bufio.NewWriter(&term)}},
+		namedSink{name: "memory", sink: mem},
+	)
+	t.Cleanup(func() { output = saved })
+
+	output.setStatus(func() string { return status })
+	printLine(nil, "first")
+	status = "[stats] 2"
+	printLine(nil, "second")
+	output.clearStatus()
+
+	want := "first\n[stats] 1" +
+		"\r\x1b[2K" + "second\n[stats] 2" +
+		"\r\x1b[2K"
+	if got := term.String(); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+	// ステータス行は標準出力にだけ書く
+	if got := mem.String(); got != "first\nsecond\n" {
+		t.Fatalf("memory sink = %q, want only the lines", got)
+	}
+}
+
+func TestOutputWriterTruncatesStatusLineToTerminalWidth(t *testing.T) {
+	withReset(t)
+	var term bytes.Buffer
+	saved, savedWidth := output, terminalWidth
+	output = newOutputWriter(namedSink{name: "stdout", sink: &writerSink{w: bufio.NewWriter(&term)}})
+	terminalWidth = func() int { return 12 }
+	t.Cleanup(func() { output, terminalWidth = saved, savedWidth })
+
+	// 最後の桁に書くと折り返す端末があるので、幅より 1 桁短くする。全角は 2 桁
+	output.setStatus(func() string { return "[stats] 日本語.log 10 lines" })
+	output.flush()
+	if got := term.String(); got != "[stats] 日" {
+		t.Fatalf("status = %q", got)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// ---------- スタイル指定 (bold+red, bg:yellow, fg:#ff8800, 208 など) ----------
+
+// 端末が表現できる色数
+type colorLevel int
+
+const (
+	levelNone colorLevel = iota
+	level16
+	level256
+	levelTrueColor
+)
+
+// TERM / COLORTERM と --color の指定から色数を判定する
+func detectColorLevel(mode colorMode) colorLevel {
+	if mode == colorNever {
+		return levelNone
+	}
+	switch strings.ToLower(os.Getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return levelTrueColor
+	}
+	term := strings.ToLower(os.Getenv("TERM"))
+	switch {
+	case strings.Contains(term, "truecolor"), strings.Contains(term, "24bit"), strings.Contains(term, "direct"):
+		return levelTrueColor
+	case strings.Contains(term, "256color"):
+		return level256
+	case term == "dumb" && mode != colorAlways:
+		return levelNone
+	}
+	if runtime.GOOS == "windows" && os.Getenv("WT_SESSION") != "" {
+		// Windows Terminal は 24bit 色に対応している
+		return levelTrueColor
+	}
+	return level16
+}
+
+var styleAttributeNames = map[string]color.Attribute{
+	"bold":          color.Bold,
+	"faint":         color.Faint,
+	"dim":           color.Faint,
+	"italic":        color.Italic,
+	"underline":     color.Underline,
+	"blink":         color.BlinkSlow,
+	"reverse":       color.ReverseVideo,
+	"strikethrough": color.CrossedOut,
+}
+
+// '+' でつないだスタイル指定を属性に変換する
+func styleAttributes(style string, level colorLevel) ([]color.Attribute, error) {
+	var attrs []color.Attribute
+	for _, token := range strings.Split(style, "+") {
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token == "" {
+			return nil, fmt.Errorf("empty style in '%s'", style)
+		}
+		if attr, ok := styleAttributeNames[token]; ok {
+			if level != levelNone {
+				attrs = append(attrs, attr)
+			}
+			continue
+		}
+
+		background := false
+		value := token
+		switch {
+		case strings.HasPrefix(token, "fg:"):
+			value = token[3:]
+		case strings.HasPrefix(token, "bg:"):
+			value = token[3:]
+			background = true
+		}
+		colorAttrs, ok := colorValueAttributes(value, background, level)
+		if !ok {
+			return nil, fmt.Errorf("unknown color '%s'", token)
+		}
+		attrs = append(attrs, colorAttrs...)
+	}
+	return attrs, nil
+}
+
+// 色名・#rrggbb・0-255 の番号を、端末の色数に合わせた属性に変換する
+func colorValueAttributes(value string, background bool, level colorLevel) ([]color.Attribute, bool) {
+	if attrs, ok := colorAttributes(value); ok {
+		if level == levelNone {
+			return nil, true
+		}
+		if background {
+			return []color.Attribute{attrs[0] + 10}, true
+		}
+		return attrs, true
+	}
+
+	var rgb [3]int
+	index := -1
+	switch {
+	case strings.HasPrefix(value, "#"):
+		parsed, ok := parseHexColor(value)
+		if !ok {
+			return nil, false
+		}
+		rgb = parsed
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 255 {
+			return nil, false
+		}
+		index = n
+		rgb = xterm256RGB(n)
+	}
+
+	base := color.Attribute(38)
+	if background {
+		base = 48
+	}
+	switch level {
+	case levelNone:
+		return nil, true
+	case levelTrueColor:
+		if index >= 0 {
+			return []color.Attribute{base, 5, color.Attribute(index)}, true
+		}
+		return []color.Attribute{base, 2, color.Attribute(rgb[0]), color.Attribute(rgb[1]), color.Attribute(rgb[2])}, true
+	case level256:
+		if index < 0 {
+			index = nearest256(rgb)
+		}
+		return []color.Attribute{base, 5, color.Attribute(index)}, true
+	}
+
+	// 16 色へ落とす
+	if index < 0 || index >= 16 {
+		index = nearest16(rgb)
+	}
+	attr := color.FgBlack + color.Attribute(index)
+	if index >= 8 {
+		attr = color.FgHiBlack + color.Attribute(index-8)
+	}
+	if background {
+		attr += 10
+	}
+	return []color.Attribute{attr}, true
+}
+
+func parseHexColor(s string) ([3]int, bool) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return [3]int{}, false
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return [3]int{}, false
+	}
+	return [3]int{int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)}, true
+}
+
+// xterm の標準 16 色
+var xterm16 = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+func xterm256RGB(n int) [3]int {
+	switch {
+	case n < 16:
+		return xterm16[n]
+	case n < 232:
+		n -= 16
+		return [3]int{cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]}
+	default:
+		gray := 8 + (n-232)*10
+		return [3]int{gray, gray, gray}
+	}
+}
+
+func colorDistance(a, b [3]int) int {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
+
+// 6x6x6 のカラーキューブとグレースケールから最も近い番号を選ぶ
+func nearest256(rgb [3]int) int {
+	best, bestDist := 16, -1
+	for n := 16; n < 256; n++ {
+		if d := colorDistance(rgb, xterm256RGB(n)); bestDist < 0 || d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+func nearest16(rgb [3]int) int {
+	best, bestDist := 0, -1
+	for n, c := range xterm16 {
+		if d := colorDistance(rgb, c); bestDist < 0 || d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// "style:regex" をスタイル部分と正規表現に分ける。
+// fg:/bg: の値にもコロンが入るため、スタイルの文法に沿って区切り位置を探す。
+func splitStyleSpec(s string) (string, string, bool) {
+	i := 0
+	for {
+		rest := strings.ToLower(s[i:])
+		if strings.HasPrefix(strings.TrimLeft(rest, " \t"), "fg:") || strings.HasPrefix(strings.TrimLeft(rest, " \t"), "bg:") {
+			i += strings.Index(rest, ":") + 1
+		}
+		end := strings.IndexAny(s[i:], "+:")
+		if end < 0 {
+			return "", "", false
+		}
+		i += end
+		if s[i] == ':' {
+			return s[:i], s[i+1:], true
+		}
+		i++ // '+'
+	}
+}
+
+// スタイル指定として解釈できるか
+func isStyleSpec(style string) bool {
+	if strings.TrimSpace(style) == "" || strings.ContainsAny(strings.TrimSpace(style), " \t\r\n") {
+		return false
+	}
+	style, _ = splitScope(style)
+	style, hash := splitHash(style)
+	if hash && style == "" {
+		return true
+	}
+	_, err := styleAttributes(style, levelTrueColor)
+	return err == nil
+}
+
+// 色を付ける範囲
+type patternScope int
+
+const (
+	scopeMatch patternScope = iota // マッチした部分 (既定)
+	scopeLine                      // マッチした行全体
+	scopeGroup                     // キャプチャグループの部分
+)
+
+var scopeNames = map[string]patternScope{
+	"match": scopeMatch,
+	"line":  scopeLine,
+	"group": scopeGroup,
+}
+
+// スタイル指定から範囲の指定 (match/line/group) を取り除き、残りのスタイルと範囲を返す
+func splitScope(style string) (string, patternScope) {
+	scope := scopeMatch
+	var rest []string
+	for _, token := range strings.Split(style, "+") {
+		if s, ok := scopeNames[strings.ToLower(strings.TrimSpace(token))]; ok {
+			scope = s
+			continue
+		}
+		rest = append(rest, token)
+	}
+	return strings.Join(rest, "+"), scope
+}
+
+// 一致した文字列から色を決める (hash) 場合の色の候補。
+// 暗い背景でも読みやすく、互いに見分けやすい色を選んでいる。
+var hashPalette256 = []int{
+	39, 41, 43, 45, 69, 75, 78, 81, 99, 105, 111, 114, 117, 135, 141, 147,
+	166, 170, 172, 176, 178, 182, 184, 203, 209, 214, 219, 221,
+}
+
+var hashPalette16 = []color.Attribute{
+	color.FgRed, color.FgGreen, color.FgYellow, color.FgBlue, color.FgMagenta, color.FgCyan,
+	color.FgHiRed, color.FgHiGreen, color.FgHiYellow, color.FgHiBlue, color.FgHiMagenta, color.FgHiCyan,
+}
+
+// スタイル指定から hash を取り除き、残りのスタイルと hash の有無を返す
+func splitHash(style string) (string, bool) {
+	hash := false
+	var rest []string
+	for _, token := range strings.Split(style, "+") {
+		if strings.EqualFold(strings.TrimSpace(token), "hash") {
+			hash = true
+			continue
+		}
+		rest = append(rest, token)
+	}
+	return strings.Join(rest, "+"), hash
+}
+
+// hash 用の色の一覧。base は bold などの共通の属性。
+func (h *Highlighter) hashColors(base []color.Attribute) []*color.Color {
+	var colors []*color.Color
+	switch h.level {
+	case levelNone:
+		return []*color.Color{h.newColor(base...)}
+	case level16:
+		for _, attr := range hashPalette16 {
+			colors = append(colors, h.newColor(append(append([]color.Attribute(nil), base...), attr)...))
+		}
+	default:
+		for _, index := range hashPalette256 {
+			colors = append(colors, h.newColor(append(append([]color.Attribute(nil), base...), 38, 5, color.Attribute(index))...))
+		}
+	}
+	return colors
+}
+
+// 同じ文字列には常に同じ色を返す
+func pickHashColor(palette []*color.Color, text string) *color.Color {
+	return palette[hashIndex(text, len(palette))]
+}
+
+// 文字列から 0 以上 n 未満の番号を決める
+func hashIndex(text string, n int) int {
+	// FNV-1a (hash/fnv と同じ値を割り当てなしで求める)
+	h := uint32(2166136261)
+	for i := 0; i < len(text); i++ {
+		h ^= uint32(text[i])
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
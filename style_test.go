@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestDetectColorLevel(t *testing.T) {
+	tests := []struct {
+		mode      colorMode
+		term      string
+		colorterm string
+		want      colorLevel
+	}{
+		{"auto", "xterm", "", level16},
+		{"auto", "xterm-256color", "", level256},
+		{"auto", "xterm-256color", "truecolor", levelTrueColor},
+		{"auto", "xterm-direct", "", levelTrueColor},
+		{"auto", "dumb", "", levelNone},
+		{"always", "dumb", "", level16},
+		{"never", "xterm-256color", "24bit", levelNone},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s/%s", tt.mode, tt.term, tt.colorterm), func(t *testing.T) {
+			t.Setenv("TERM", tt.term)
+			t.Setenv("COLORTERM", tt.colorterm)
+			t.Setenv("WT_SESSION", "")
+
+			if got := detectColorLevel(tt.mode); got != tt.want {
+				t.Fatalf("detectColorLevel = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStyleAttributes(t *testing.T) {
+	tests := []struct {
+		style string
+		level colorLevel
+		want  string
+	}{
+		{"bold+red", level16, "[1 31]"},
+		{"UNDERLINE", level16, "[4]"},
+		{"bg:yellow", level16, "[43]"},
+		{"bg:brightblue", level16, "[104]"},
+		{"italic+fg:green+bg:black", level16, "[3 32 40]"},
+		{"fg:#ff8800", levelTrueColor, "[38 2 255 136 0]"},
+		{"fg:#f80", levelTrueColor, "[38 2 255 136 0]"},
+		{"fg:#ff8800", level256, "[38 5 208]"},
+		{"fg:#ff8800", level16, "[33]"},
+		{"bg:#0000ee", level16, "[44]"},
+		{"208", level256, "[38 5 208]"},
+		{"fg:208", levelTrueColor, "[38 5 208]"},
+		{"bg:236", level256, "[48 5 236]"},
+		{"fg:9", level16, "[91]"},
+		{"fg:196", level16, "[91]"},
+		{"bold+fg:#ff8800", levelNone, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.style, tt.level), func(t *testing.T) {
+			attrs, err := styleAttributes(tt.style, tt.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(attrs); got != tt.want {
+				t.Fatalf("styleAttributes(%q) = %s, want %s", tt.style, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStyleAttributesRejectsUnknownValues(t *testing.T) {
+	for _, style := range []string{"orange", "bold+", "fg:#12", "fg:256", "bg:", "fg:#gggggg"} {
+		if _, err := styleAttributes(style, levelTrueColor); err == nil {
+			t.Fatalf("styleAttributes(%q) error = nil", style)
+		}
+	}
+}
+
+func TestSplitStyleSpec(t *testing.T) {
+	tests := []struct {
+		spec  string
+		style string
+		regex string
+		ok    bool
+	}{
+		{"red:ERROR", "red", "ERROR", true},
+		{"bold+red:ERROR: details", "bold+red", "ERROR: details", true},
+		{"fg:#ff8800:\\d+ms", "fg:#ff8800", "\\d+ms", true},
+		{"underline+bg:yellow:WARN", "underline+bg:yellow", "WARN", true},
+		{"BG:Yellow:WARN", "BG:Yellow", "WARN", true},
+		{"bad-format", "", "", false},
+		{"fg:#ff8800", "", "", false},
+	}
+	for _, tt := range tests {
+		style, regex, ok := splitStyleSpec(tt.spec)
+		if style != tt.style || regex != tt.regex || ok != tt.ok {
+			t.Fatalf("splitStyleSpec(%q) = (%q, %q, %v), want (%q, %q, %v)",
+				tt.spec, style, regex, ok, tt.style, tt.regex, tt.ok)
+		}
+	}
+}
+
+func TestAddColorPatternsWithRichStyles(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm-256color")
+	t.Setenv("COLORTERM", "")
+	h := userHighlighter(colorAlways, "bold+red:ERROR,black+bg:yellow:WARN", "fg:#ff8800:\\d+ms")
+
+	if got, want := len(h.user), 3; got != want {
+		t.Fatalf("len(h.user) = %d, want %d", got, want)
+	}
+	got := h.Highlight("ERROR WARN 35ms")
+	requireContains(t, got, "\x1b[1;31mERROR\x1b[22;0m")
+	requireContains(t, got, "\x1b[30;43mWARN\x1b[0;0m")
+	requireContains(t, got, "\x1b[38;5;208m35ms")
+	if plain := stripANSI(got); plain != "ERROR WARN 35ms" {
+		t.Fatalf("stripANSI(output) = %q", plain)
+	}
+}
+
+func TestNearestColors(t *testing.T) {
+	if got := nearest256([3]int{255, 255, 255}); got != 231 {
+		t.Fatalf("nearest256(white) = %d, want 231", got)
+	}
+	if got := nearest256([3]int{128, 128, 128}); got != 244 {
+		t.Fatalf("nearest256(gray) = %d, want 244", got)
+	}
+	if got := nearest16([3]int{250, 10, 10}); got != 9 {
+		t.Fatalf("nearest16(red) = %d, want 9", got)
+	}
+}
+
+func TestPatternScopes(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm")
+	t.Setenv("COLORTERM", "")
+	h := userHighlighter(colorAlways, "red+line:ERROR", "cyan+group:took (?P<hl>\\d+)ms", "green+group:(a)=(b)?")
+
+	if got := h.user[0].Scope; got != scopeLine {
+		t.Fatalf("scope of red+line = %d, want line", got)
+	}
+	tests := []struct {
+		pattern ColorPattern
+		text    string
+		spans   [][2]int
+		count   int
+	}{
+		{h.user[0], "x ERROR y ERROR", [][2]int{{0, 15}}, 1},
+		{h.user[0], "ok", nil, 0},
+		{h.user[1], "took 12ms, took 3ms", [][2]int{{5, 7}, {16, 17}}, 2},
+		// 参加しなかったグループは飛ばす
+		{h.user[2], "a= a=b", [][2]int{{0, 1}, {3, 4}, {5, 6}}, 2},
+	}
+	for _, tt := range tests {
+		spans, count := tt.pattern.spans(tt.text)
+		if fmt.Sprint(spans) != fmt.Sprint(tt.spans) || count != tt.count {
+			t.Fatalf("%s spans(%q) = %v, %d; want %v, %d", tt.pattern.Spec, tt.text, spans, count, tt.spans, tt.count)
+		}
+	}
+
+	// 行全体の色より後に指定したパターンが優先される
+	got := h.Highlight("ERROR took 12ms")
+	requireContains(t, got, "\x1b[31mERROR took \x1b[0m\x1b[36m12\x1b[0m\x1b[31mms\x1b[0m")
+}
+
+func TestLineScopeSpecifiedLastWins(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm")
+	t.Setenv("COLORTERM", "")
+	h := userHighlighter(colorAlways, "cyan:\\d+ms", "red+line:ERROR")
+
+	got := h.Highlight("ERROR took 12ms")
+	if got != "\x1b[31mERROR took 12ms\x1b[0m" {
+		t.Fatalf("Highlight = %q", got)
+	}
+}
+
+func TestHashColoring(t *testing.T) {
+	withReset(t)
+	t.Setenv("TERM", "xterm-256color")
+	t.Setenv("COLORTERM", "")
+	h := userHighlighter(colorAlways, "hash:req-[0-9a-f]+", "bold+hash+group:host=(\\w+)")
+
+	if len(h.user) != 2 || len(h.user[0].Palette) != len(hashPalette256) {
+		t.Fatalf("h.user = %+v", h.user)
+	}
+	first := h.Highlight("req-42af start")
+	if again := h.Highlight("other req-42af end"); !strings.Contains(again, strings.TrimSuffix(first, " start")) {
+		t.Fatalf("req-42af colored differently: %q vs %q", first, again)
+	}
+	requireContains(t, first, "\x1b[38;5;")
+
+	// 異なる ID は (ほぼ) 異なる色になる
+	seen := map[*color.Color]bool{}
+	for i := 0; i < 20; i++ {
+		seen[pickHashColor(h.user[0].Palette, fmt.Sprintf("req-%x", i))] = true
+	}
+	if len(seen) < 8 {
+		t.Fatalf("only %d colors used for 20 ids", len(seen))
+	}
+
+	got := h.Highlight("host=web1 ok")
+	requireContains(t, got, "host=\x1b[1;38;5;")
+	if plain := stripANSI(got); plain != "host=web1 ok" {
+		t.Fatalf("stripANSI(output) = %q", plain)
+	}
+}
+
+func TestHashColoringWithoutColor(t *testing.T) {
+	withReset(t)
+	h := userHighlighter(colorNever, "hash:req-\\w+")
+
+	if got := h.Highlight("req-1 req-2"); got != "req-1 req-2" {
+		t.Fatalf("Highlight = %q", got)
+	}
+	if !isColorPatternStart("hash:x") || !isColorPatternStart("underline+hash+line:x") {
+		t.Fatal("hash styles should be recognized as color patterns")
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- サブコマンド: syslog ----------
+
+// UDP や TCP で syslog (RFC 3164 / RFC 5424) を受け取り、1 行ずつ表示する。
+// 重大度は journal と同じ名前 (ERR, WARNING など) で行に入れるので、レベルの色付けや -min-level が効く。
+
+var syslogFacilityNames = [24]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// 受け取ったメッセージ
+type syslogMessage struct {
+	facility int       // PRI が無ければ -1
+	severity int       // PRI が無ければ -1
+	time     time.Time // 時刻が無ければゼロ値
+	host     string
+	app      string
+	pid      string
+	message  string
+}
+
+// 表示する行。複数行のメッセージは 2 行目以降をそのまま続ける
+func (m syslogMessage) lines(received time.Time, showFacility bool) []string {
+	t := m.time
+	if t.IsZero() {
+		t = received
+	}
+	head := []string{t.Local().Format(prefixTimeLayout)}
+	if m.host != "" {
+		head = append(head, m.host)
+	}
+	if m.app != "" {
+		app := m.app
+		if m.pid != "" {
+			app += "[" + m.pid + "]"
+		}
+		head = append(head, app+":")
+	} else {
+		head[len(head)-1] += ":"
+	}
+	if m.severity >= 0 {
+		name := syslogSeverityNames[m.severity]
+		if showFacility && m.facility >= 0 && m.facility < len(syslogFacilityNames) {
+			name = syslogFacilityNames[m.facility] + "." + name
+		}
+		head = append(head, name)
+	}
+	lines := strings.Split(strings.TrimRight(m.message, "\r\n"), "\n")
+	lines[0] = strings.Join(head, " ") + " " + lines[0]
+	return lines
+}
+
+// RFC 5424 と RFC 3164 のどちらの形式も受け付ける。読めない部分はメッセージとして扱う。
+func parseSyslog(data string, now time.Time) syslogMessage {
+	msg := syslogMessage{facility: -1, severity: -1}
+	rest := strings.TrimRight(data, "\x00\r\n")
+	if strings.HasPrefix(rest, "<") {
+		if end := strings.IndexByte(rest, '>'); end > 1 && end <= 4 {
+			if pri, err := strconv.Atoi(rest[1:end]); err == nil && pri >= 0 && pri < 192 {
+				msg.facility, msg.severity = pri/8, pri%8
+				rest = rest[end+1:]
+			}
+		}
+	}
+	if version, after, ok := strings.Cut(rest, " "); ok && version == "1" {
+		parseSyslog5424(&msg, after)
+	} else {
+		parseSyslog3164(&msg, rest, now)
+	}
+	return msg
+}
+
+// 空白で区切られた次の項目を返す。"-" は値なし
+func nextSyslogField(s string) (field, rest string) {
+	field, rest, _ = strings.Cut(s, " ")
+	if field == "-" {
+		field = ""
+	}
+	return field, rest
+}
+
+// VERSION の後の TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func parseSyslog5424(msg *syslogMessage, s string) {
+	var timestamp, msgid string
+	timestamp, s = nextSyslogField(s)
+	if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		msg.time = t
+	}
+	msg.host, s = nextSyslogField(s)
+	msg.app, s = nextSyslogField(s)
+	msg.pid, s = nextSyslogField(s)
+	msgid, s = nextSyslogField(s)
+	sd, s := cutStructuredData(s)
+	s = strings.TrimPrefix(s, "\ufeff")
+	var parts []string
+	if msgid != "" {
+		parts = append(parts, msgid)
+	}
+	if sd != "" {
+		parts = append(parts, sd)
+	}
+	if s != "" {
+		parts = append(parts, s)
+	}
+	msg.message = strings.Join(parts, " ")
+}
+
+// 先頭の STRUCTURED-DATA ([id key="value"]... か "-") を取り出す。"-" なら空を返す
+func cutStructuredData(s string) (sd, rest string) {
+	if s == "-" || strings.HasPrefix(s, "- ") {
+		return "", strings.TrimPrefix(s[1:], " ")
+	}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		end := structuredDataEnd(s[i:])
+		if end < 0 {
+			// 閉じていない。残りをすべてメッセージとして扱う
+			return "", s
+		}
+		i += end
+	}
+	return s[:i], strings.TrimPrefix(s[i:], " ")
+}
+
+// "[" で始まる SD-ELEMENT の長さ。"]" で閉じていなければ -1
+func structuredDataEnd(s string) int {
+	inQuote := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && inQuote:
+			i++
+		case s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == ']' && !inQuote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// "Jan  2 15:04:05 host tag[pid]: message"。ホスト名や時刻を省く機器もある
+func parseSyslog3164(msg *syslogMessage, s string, now time.Time) {
+	if len(s) >= 15 {
+		if t, err := time.ParseInLocation(time.Stamp, s[:15], time.Local); err == nil {
+			// 年が無いので、未来になる場合は去年とみなす
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			msg.time = t
+			s = strings.TrimPrefix(s[15:], " ")
+		}
+	}
+	// 次の項目が "tag:" や "tag[pid]:" ならホスト名は省かれている
+	if first, after, ok := strings.Cut(s, " "); ok && !isSyslogTag(first) && !msg.time.IsZero() {
+		msg.host, s = first, after
+	}
+	if first, after, ok := strings.Cut(s, " "); ok && isSyslogTag(first) {
+		tag := strings.TrimSuffix(first, ":")
+		if name, pid, ok := strings.Cut(tag, "["); ok && strings.HasSuffix(pid, "]") {
+			msg.app, msg.pid = name, strings.TrimSuffix(pid, "]")
+		} else {
+			msg.app = tag
+		}
+		s = after
+	}
+	msg.message = s
+}
+
+func isSyslogTag(s string) bool {
+	return len(s) > 1 && len(s) <= 64 && strings.HasSuffix(s, ":") && !strings.HasPrefix(s, "%")
+}
+
+// -listen で指定された待ち受け先
+type syslogAddress struct {
+	network string // udp か tcp
+	address string
+}
+
+func (a syslogAddress) String() string {
+	return a.network + "://" + a.address
+}
+
+// "udp://:5514,tcp://:5514" のような一覧を読む。"://" の無いものは UDP とみなす
+func parseSyslogListen(value string) ([]syslogAddress, error) {
+	var addrs []syslogAddress
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		network, address, ok := strings.Cut(item, "://")
+		if !ok {
+			network, address = "udp", item
+		}
+		if network != "udp" && network != "tcp" {
+			return nil, fmt.Errorf("invalid -listen %q (use udp://host:port or tcp://host:port)", item)
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid -listen %q: %v", item, err)
+		}
+		addrs = append(addrs, syslogAddress{network: network, address: address})
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("-listen needs at least one address")
+	}
+	return addrs, nil
+}
+
+// syslog の受信
+type syslogReceiver struct {
+	h            *Highlighter
+	showFacility bool
+	now          func() time.Time
+
+	writeMu   sync.Mutex // 複数の接続の行を混ぜずに書く
+	mu        sync.Mutex
+	listeners []io.Closer
+	conns     map[net.Conn]struct{}
+	closed    bool            // close の後に受け付けた接続はすぐ閉じる
+	bound     []syslogAddress // 実際に待ち受けているアドレス
+	wg        sync.WaitGroup
+}
+
+func newSyslogReceiver(showFacility bool) *syslogReceiver {
+	return &syslogReceiver{showFacility: showFacility, now: time.Now, conns: map[net.Conn]struct{}{}}
+}
+
+// すべてのアドレスで待ち受ける。1 つでも失敗したらすべて閉じてエラーを返す。
+// 受信は serve で始めるので、待ち受けられることを確かめてから出力を用意できる
+func (s *syslogReceiver) listen(addrs []syslogAddress) error {
+	for _, addr := range addrs {
+		switch addr.network {
+		case "udp":
+			conn, err := net.ListenPacket("udp", addr.address)
+			if err != nil {
+				s.close()
+				return err
+			}
+			s.listeners = append(s.listeners, conn)
+			s.bound = append(s.bound, syslogAddress{network: "udp", address: conn.LocalAddr().String()})
+		case "tcp":
+			ln, err := net.Listen("tcp", addr.address)
+			if err != nil {
+				s.close()
+				return err
+			}
+			s.listeners = append(s.listeners, ln)
+			s.bound = append(s.bound, syslogAddress{network: "tcp", address: ln.Addr().String()})
+		}
+	}
+	return nil
+}
+
+// listen で待ち受けたアドレスで受信を始め、h で色を付けて出力する
+func (s *syslogReceiver) serve(h *Highlighter) {
+	s.h = h
+	for _, l := range s.listeners {
+		s.wg.Add(1)
+		switch l := l.(type) {
+		case net.PacketConn:
+			go s.serveUDP(l)
+		case net.Listener:
+			go s.serveTCP(l)
+		}
+	}
+}
+
+// 待ち受けと接続をすべて閉じ、受信中のメッセージを書き終えるまで待つ
+func (s *syslogReceiver) close() {
+	s.mu.Lock()
+	s.closed = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *syslogReceiver) serveUDP(conn net.PacketConn) {
+	defer s.wg.Done()
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("syslog: %v", err)
+			}
+			return
+		}
+		s.emit(string(buf[:n]), from, true)
+	}
+}
+
+func (s *syslogReceiver) serveTCP(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("syslog: %v", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.handleTCP(conn)
+	}
+}
+
+// RFC 6587 の 2 つの区切り方 (先頭に長さを付ける方式と改行区切り) をメッセージごとに見分ける
+func (s *syslogReceiver) handleTCP(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReaderSize(conn, 64<<10)
+	for {
+		data, err := readSyslogFrame(r)
+		if data != "" {
+			s.emit(data, conn.RemoteAddr(), r.Buffered() == 0)
+		}
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("syslog: %s: %v", conn.RemoteAddr(), err)
+			}
+			output.flush()
+			return
+		}
+	}
+}
+
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] >= '1' && first[0] <= '9' {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			return "", err
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil || n > 1<<20 {
+			return "", fmt.Errorf("invalid octet count %q", strings.TrimSpace(length))
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+	line, err := r.ReadString('\n')
+	return strings.TrimRight(line, "\x00\r\n"), err
+}
+
+func (s *syslogReceiver) emit(data string, from net.Addr, flush bool) {
+	if strings.TrimSpace(data) == "" {
+		return
+	}
+	now := s.now()
+	msg := parseSyslog(data, now)
+	if msg.host == "" && from != nil {
+		if host, _, err := net.SplitHostPort(from.String()); err == nil {
+			msg.host = host
+		}
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	for _, line := range msg.lines(now, s.showFacility) {
+		writeLineFrom(s.h, lineInfo{source: msg.host, offset: -1, received: now}, line)
+	}
+	if flush {
+		output.flush()
+	}
+}
+
+func cmdSyslog(opts globalOptions, args []string) {
+	fs := flag.NewFlagSet("syslog", flag.ExitOnError)
+	listen := fs.String("listen", "udp://:5514", "addresses to receive syslog on, comma separated (udp://host:port, tcp://host:port)")
+	showFacility := fs.Bool("facility", false, "show the facility before the severity (e.g. auth.WARNING)")
+	pipeline := registerPipelineFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		log.Fatalf("usage: trail syslog [options]")
+	}
+	addrs, err := parseSyslogListen(*listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 待ち受けられないことは出力を用意する前に知らせる。受信は出力の規則をすべて設定してから始める
+	receiver := newSyslogReceiver(*showFacility)
+	if err := receiver.listen(addrs); err != nil {
+		log.Fatal(err)
+	}
+	var bound []string
+	for _, addr := range receiver.bound {
+		bound = append(bound, addr.String())
+	}
+	label := "syslog " + strings.Join(bound, ",")
+	highlighter := pipeline.start(opts, label)
+	receiver.serve(highlighter)
+	log.Printf("receiving syslog on %s", strings.Join(bound, ", "))
+
+	runSource(highlighter, label, *pipeline.showSummary, func(ctx context.Context) error {
+		<-ctx.Done()
+		receiver.close()
+		return nil
+	})
+}
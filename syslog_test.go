@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		data string
+		want syslogMessage
+	}{
+		// RFC 5424
+		{`<165>1 2024-05-01T11:59:58.123Z router1 sshd 812 ID47 - Accepted publickey`, syslogMessage{
+			facility: 20, severity: 5, time: time.Date(2024, 5, 1, 11, 59, 58, 123e6, time.UTC),
+			host: "router1", app: "sshd", pid: "812", message: "ID47 Accepted publickey",
+		}},
+		{"<11>1 - - app - - [exampleSDID@32473 iut=\"3\" note=\"a \\\"]\\\" b\"][meta x=\"1\"] \ufeffdisk failed", syslogMessage{
+			facility: 1, severity: 3, app: "app",
+			message: `[exampleSDID@32473 iut="3" note="a \"]\" b"][meta x="1"] disk failed`,
+		}},
+		{`<14>1 - host - - - -`, syslogMessage{facility: 1, severity: 6, host: "host"}},
+		// RFC 3164
+		{`<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed for lonvick on /dev/pts/8`, syslogMessage{
+			facility: 4, severity: 2, time: time.Date(2023, 10, 11, 22, 14, 15, 0, time.Local),
+			host: "mymachine", app: "su", pid: "230", message: "'su root' failed for lonvick on /dev/pts/8",
+		}},
+		// ホスト名を省いた機器
+		{`<28>May  1 11:00:00 dnsmasq[99]: no servers found`, syslogMessage{
+			facility: 3, severity: 4, time: time.Date(2024, 5, 1, 11, 0, 0, 0, time.Local),
+			app: "dnsmasq", pid: "99", message: "no servers found",
+		}},
+		{`<13>May  1 11:00:00 switch1 %LINK-3-UPDOWN: Interface Gi0/1, changed state to down`, syslogMessage{
+			facility: 1, severity: 5, time: time.Date(2024, 5, 1, 11, 0, 0, 0, time.Local),
+			host: "switch1", message: "%LINK-3-UPDOWN: Interface Gi0/1, changed state to down",
+		}},
+		// PRI や時刻の無いもの
+		{"myapp: started\n", syslogMessage{facility: -1, severity: -1, app: "myapp", message: "started"}},
+		{`<999>hello`, syslogMessage{facility: -1, severity: -1, message: "<999>hello"}},
+	}
+	for i, tt := range tests {
+		got := parseSyslog(tt.data, now)
+		if !got.time.Equal(tt.want.time) {
+			t.Errorf("%d: time = %v, want %v", i, got.time, tt.want.time)
+		}
+		got.time, tt.want.time = time.Time{}, time.Time{}
+		if got != tt.want {
+			t.Errorf("%d: parseSyslog(%q) = %+v, want %+v", i, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestSyslogMessageLines(t *testing.T) {
+	received := time.Date(2024, 5, 1, 12, 0, 0, 250e6, time.Local)
+	msg := syslogMessage{facility: 4, severity: 4, host: "web1", app: "sshd", pid: "812", message: "too many failures\n  from 10.0.0.5\n"}
+	want := []string{"2024-05-01 12:00:00.250 web1 sshd[812]: WARNING too many failures", "  from 10.0.0.5"}
+	if got := msg.lines(received, false); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+	want = []string{"2024-05-01 12:00:00.250 web1 sshd[812]: auth.WARNING too many failures", "  from 10.0.0.5"}
+	if got := msg.lines(received, true); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("lines with facility = %q, want %q", got, want)
+	}
+	msg = syslogMessage{facility: -1, severity: -1, host: "10.0.0.1", message: "hello"}
+	if got := msg.lines(received, true); fmt.Sprint(got) != "[2024-05-01 12:00:00.250 10.0.0.1: hello]" {
+		t.Errorf("lines without PRI = %q", got)
+	}
+	// facility を付けてもレベルとして判定される
+	if got := detectSeverity("sshd[812]: auth.WARNING too many failures"); got != severityWarn {
+		t.Errorf("auth.WARNING = %v, want warn", got)
+	}
+}
+
+func TestParseSyslogListen(t *testing.T) {
+	addrs, err := parseSyslogListen("udp://:5514, tcp://127.0.0.1:5514,:514")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(addrs) != "[udp://:5514 tcp://127.0.0.1:5514 udp://:514]" {
+		t.Errorf("addrs = %v", addrs)
+	}
+	for _, value := range []string{"", "http://:80", "udp://5514"} {
+		if _, err := parseSyslogListen(value); err == nil {
+			t.Errorf("parseSyslogListen(%q) succeeded", value)
+		}
+	}
+}
+
+func startTestSyslog(t *testing.T, h *Highlighter, listen string) *syslogReceiver {
+	t.Helper()
+	addrs, err := parseSyslogListen(listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := newSyslogReceiver(false)
+	receiver.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local) }
+	if err := receiver.listen(addrs); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(receiver.close)
+	receiver.serve(h)
+	return receiver
+}
+
+func TestSyslogReceivesUDPAndTCP(t *testing.T) {
+	withReset(t)
+	wait := memoryOutput(t)
+	h := newHighlighter(colorAlways)
+	h.enableLevelColors()
+	receiver := startTestSyslog(t, h, "udp://127.0.0.1:0,tcp://127.0.0.1:0")
+
+	udp, err := net.Dial("udp", receiver.bound[0].address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	fmt.Fprint(udp, "<11>May  1 11:59:59 router1 bgpd[7]: neighbor 10.0.0.2 down")
+	got := wait("neighbor 10.0.0.2 down\n")
+	want := h.Highlight("2024-05-01 11:59:59.000 router1 bgpd[7]: ERR neighbor 10.0.0.2 down") + "\n"
+	if got != want {
+		t.Errorf("udp output = %q, want %q", got, want)
+	}
+	if !strings.Contains(got, "\x1b[31mERR\x1b[0m") {
+		t.Errorf("ERR is not red: %q", got)
+	}
+
+	// 改行区切りと長さ付きの両方を同じ接続で受け付ける
+	tcp, err := net.Dial("tcp", receiver.bound[1].address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	framed := "<12>1 2024-05-01T12:00:01+09:00 web1 nginx - - - upstream slow"
+	fmt.Fprintf(tcp, "<14>app: hello\n%d %s", len(framed), framed)
+	got = wait("upstream slow\n")
+	slow := time.Date(2024, 5, 1, 3, 0, 1, 0, time.UTC).Local().Format(prefixTimeLayout)
+	want += h.Highlight("2024-05-01 12:00:00.000 127.0.0.1 app: INFO hello") + "\n" +
+		h.Highlight(slow+" web1 nginx: WARNING upstream slow") + "\n"
+	if got != want {
+		t.Errorf("tcp output = %q, want %q", got, want)
+	}
+}
+
+func TestSyslogReceivesOnlyAfterServe(t *testing.T) {
+	withReset(t)
+	wait := memoryOutput(t)
+	addrs, err := parseSyslogListen("udp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := newSyslogReceiver(false)
+	if err := receiver.listen(addrs); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(receiver.close)
+
+	// 待ち受けただけでは読まない。届いたメッセージは serve の後で出力の規則に従って書く
+	udp, err := net.Dial("udp", receiver.bound[0].address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	fmt.Fprint(udp, "<14>1 - host app - - - routine")
+	fmt.Fprint(udp, "<10>1 - host app - - - critical")
+	time.Sleep(50 * time.Millisecond)
+	if got := wait(""); got != "" {
+		t.Fatalf("output before serve = %q", got)
+	}
+	levelRules = newLevelFilter(severityWarn)
+	receiver.serve(nil)
+	if got := wait("critical\n"); !strings.HasSuffix(got, "host app: CRIT critical\n") || strings.Contains(got, "routine") {
+		t.Errorf("output = %q", got)
+	}
+}
+
+// close の直前に受け付けた接続を、Close の後に返す待ち受け
+type lateListener struct {
+	net.Listener
+	closed chan struct{}
+	late   net.Conn
+}
+
+func (l *lateListener) Accept() (net.Conn, error) {
+	<-l.closed
+	if conn := l.late; conn != nil {
+		l.late = nil
+		return conn, nil
+	}
+	return nil, net.ErrClosed
+}
+
+func (l *lateListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func TestSyslogClosesConnectionsAcceptedDuringClose(t *testing.T) {
+	withReset(t)
+	server, client := net.Pipe()
+	defer client.Close()
+	receiver := newSyslogReceiver(false)
+	receiver.listeners = append(receiver.listeners, &lateListener{closed: make(chan struct{}), late: server})
+	receiver.serve(nil)
+
+	closed := make(chan struct{})
+	go func() {
+		receiver.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close still waiting for a connection accepted after it started")
+	}
+}
+
+func TestSyslogMinLevel(t *testing.T) {
+	withReset(t)
+	wait := memoryOutput(t)
+	levelRules = newLevelFilter(severityWarn)
+	receiver := startTestSyslog(t, newHighlighter(colorNever), "tcp://127.0.0.1:0")
+	conn, err := net.Dial("tcp", receiver.bound[0].address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "<14>1 - host app - - - routine\n<10>1 - host app - - - critical\n")
+	if got := wait("critical\n"); got != "2024-05-01 12:00:00.000 host app: CRIT critical\n" {
+		t.Errorf("output = %q", got)
+	}
+}
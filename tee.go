@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// ---------- 出力の複製 (--tee / --tee-html) ----------
+
+// --tee / --tee-html で指定されたファイル
+var (
+	teePath     string
+	teeHTMLPath string
+)
+
+// 端末へ出力した行をファイルにも書き出す。nil なら何もしない。
+type sessionTee struct {
+	mu    sync.Mutex
+	plain *bufio.Writer // エスケープシーケンスを含めてそのまま書く
+	html  *bufio.Writer // 色を <span style="..."> にして書く
+	files []*os.File
+
+	renderer *htmlRenderer
+}
+
+var tee *sessionTee
+
+const teeHTMLHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>trail</title>
+<style>
+body { margin: 0; background: #1e1e1e; }
+pre { margin: 0; padding: 1em; color: #d0d0d0; background: #1e1e1e; font-family: ui-monospace, Menlo, Consolas, monospace; font-size: 13px; white-space: pre-wrap; }
+.prefix { opacity: 0.6; }
+</style>
+</head>
+<body>
+<pre>
+`
+
+const teeHTMLFooter = `</pre>
+</body>
+</html>
+`
+
+// HTML での既定の文字色と背景色 (反転表示に使う)
+const (
+	teeHTMLForeground = "#d0d0d0"
+	teeHTMLBackground = "#1e1e1e"
+)
+
+func setTeePath(option, path string) {
+	if path == "" {
+		log.Fatalf("missing file for %s", option)
+	}
+	if option == "--tee" {
+		teePath = path
+	} else {
+		teeHTMLPath = path
+	}
+}
+
+func startTee() {
+	t, err := openTee(teePath, teeHTMLPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tee = t
+}
+
+func openTee(plainPath, htmlPath string) (*sessionTee, error) {
+	if plainPath == "" && htmlPath == "" {
+		return nil, nil
+	}
+	t := &sessionTee{renderer: newHTMLRenderer()}
+	if plainPath != "" {
+		f, err := os.Create(plainPath)
+		if err != nil {
+			return nil, fmt.Errorf("--tee: %v", err)
+		}
+		t.files = append(t.files, f)
+		t.plain = bufio.NewWriter(f)
+	}
+	if htmlPath != "" {
+		f, err := os.Create(htmlPath)
+		if err != nil {
+			t.close()
+			return nil, fmt.Errorf("--tee-html: %v", err)
+		}
+		t.files = append(t.files, f)
+		t.html = bufio.NewWriter(f)
+		t.html.WriteString(teeHTMLHeader)
+	}
+	return t, nil
+}
+
+// テキストの控えに書くため、色を付けた行が必要か
+func (t *sessionTee) wantsColored() bool {
+	return t != nil && t.plain != nil
+}
+
+// HTML に書くため、色を付けた範囲が必要か
+func (t *sessionTee) wantsSpans() bool {
+	return t != nil && t.html != nil
+}
+
+// 1 行を書き出す。colored は端末へ出力した行、prefix と text は色付け前の行、
+// spans は text に色を付けた範囲 (colorizeSpans の結果)。
+func (t *sessionTee) writeLine(colored, prefix, text string, spans []colorMatch) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.plain != nil {
+		t.plain.WriteString(colored)
+		t.plain.WriteByte('\n')
+	}
+	if t.html != nil {
+		if prefix != "" {
+			t.html.WriteString(`<span class="prefix">`)
+			t.html.WriteString(html.EscapeString(prefix))
+			t.html.WriteString(`</span>`)
+		}
+		t.html.WriteString(t.renderer.render(text, spans))
+		t.html.WriteByte('\n')
+	}
+}
+
+// 色を付けた範囲を <span style="..."> にする。同時に使う場合は呼び出し側で排他する。
+type htmlRenderer struct {
+	css map[string]string // スタイル指定ごとの CSS
+}
+
+func newHTMLRenderer() *htmlRenderer {
+	return &htmlRenderer{css: make(map[string]string)}
+}
+
+// 色を付けた範囲を <span> にする
+func (r *htmlRenderer) render(text string, spans []colorMatch) string {
+	var b strings.Builder
+	lastEnd := 0
+	for _, span := range spans {
+		b.WriteString(html.EscapeString(text[lastEnd:span.start]))
+		style := r.spanCSS(span)
+		if style != "" {
+			fmt.Fprintf(&b, `<span style="%s">`, style)
+		}
+		b.WriteString(html.EscapeString(text[span.start:span.end]))
+		if style != "" {
+			b.WriteString(`</span>`)
+		}
+		lastEnd = span.end
+	}
+	b.WriteString(html.EscapeString(text[lastEnd:]))
+	return b.String()
+}
+
+func (r *htmlRenderer) spanCSS(span colorMatch) string {
+	key := span.style
+	if span.hashed {
+		// 端末の色数によらず 256 色の候補から選ぶ
+		key = fmt.Sprintf("%s\x00%d", span.style, hashPalette256[hashIndex(span.key, len(hashPalette256))])
+	}
+	if style, ok := r.css[key]; ok {
+		return style
+	}
+	attrs, err := styleAttributes(span.style, levelTrueColor)
+	if err != nil {
+		attrs = nil
+	}
+	if span.hashed {
+		attrs = append(attrs, 38, 5, color.Attribute(hashPalette256[hashIndex(span.key, len(hashPalette256))]))
+	}
+	style := attributesCSS(attrs)
+	r.css[key] = style
+	return style
+}
+
+// SGR の属性を CSS に変換する
+func attributesCSS(attrs []color.Attribute) string {
+	var fg, bg string
+	var bold, faint, italic, underline, strike, reverse, hidden bool
+	for i := 0; i < len(attrs); i++ {
+		switch a := int(attrs[i]); {
+		case a == int(color.Bold):
+			bold = true
+		case a == int(color.Faint):
+			faint = true
+		case a == int(color.Italic):
+			italic = true
+		case a == int(color.Underline):
+			underline = true
+		case a == int(color.ReverseVideo):
+			reverse = true
+		case a == int(color.Concealed):
+			hidden = true
+		case a == int(color.CrossedOut):
+			strike = true
+		case a >= 30 && a <= 37:
+			fg = cssColor(xterm16[a-30])
+		case a >= 90 && a <= 97:
+			fg = cssColor(xterm16[a-90+8])
+		case a >= 40 && a <= 47:
+			bg = cssColor(xterm16[a-40])
+		case a >= 100 && a <= 107:
+			bg = cssColor(xterm16[a-100+8])
+		case a == 38 || a == 48:
+			var value string
+			switch {
+			case i+2 < len(attrs) && attrs[i+1] == 5:
+				value = cssColor(xterm256RGB(int(attrs[i+2])))
+				i += 2
+			case i+4 < len(attrs) && attrs[i+1] == 2:
+				value = cssColor([3]int{int(attrs[i+2]), int(attrs[i+3]), int(attrs[i+4])})
+				i += 4
+			default:
+				continue
+			}
+			if a == 38 {
+				fg = value
+			} else {
+				bg = value
+			}
+		}
+	}
+	if reverse {
+		if fg == "" {
+			fg = teeHTMLForeground
+		}
+		if bg == "" {
+			bg = teeHTMLBackground
+		}
+		fg, bg = bg, fg
+	}
+
+	var css []string
+	if fg != "" {
+		css = append(css, "color:"+fg)
+	}
+	if bg != "" {
+		css = append(css, "background-color:"+bg)
+	}
+	if bold {
+		css = append(css, "font-weight:bold")
+	}
+	if faint {
+		css = append(css, "opacity:0.6")
+	}
+	if italic {
+		css = append(css, "font-style:italic")
+	}
+	switch {
+	case underline && strike:
+		css = append(css, "text-decoration:underline line-through")
+	case underline:
+		css = append(css, "text-decoration:underline")
+	case strike:
+		css = append(css, "text-decoration:line-through")
+	}
+	if hidden {
+		css = append(css, "visibility:hidden")
+	}
+	return strings.Join(css, ";")
+}
+
+func cssColor(rgb [3]int) string {
+	return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2])
+}
+
+func (t *sessionTee) flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.plain != nil {
+		t.plain.Flush()
+	}
+	if t.html != nil {
+		t.html.Flush()
+	}
+}
+
+// HTML を閉じてファイルを閉じる
+func (t *sessionTee) close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.plain != nil {
+		t.plain.Flush()
+	}
+	if t.html != nil {
+		t.html.WriteString(teeHTMLFooter)
+		t.html.Flush()
+	}
+	for _, f := range t.files {
+		f.Close()
+	}
+	t.plain, t.html, t.files = nil, nil, nil
+}
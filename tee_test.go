@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func openTestTee(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "out.log")
+	htmlPath := filepath.Join(dir, "out.html")
+	var err error
+	tee, err = openTee(plainPath, htmlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tee.close(); tee = nil })
+	return plainPath, htmlPath
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTeeWritesWhatIsPrinted(t *testing.T) {
+	withReset(t)
+	h := userHighlighter(colorAlways, "yellow+line:^WARN.*", "red:ERROR", "bold+bg:yellow:id=(\\d+)")
+	plainPath, htmlPath := openTestTee(t)
+
+	lines := []string{"ERROR id=42 <tag> & more", "WARN disk ERROR", "plain line"}
+	stdout := captureOutput(t, func() {
+		for _, line := range lines {
+			writeLine(h, "", line)
+		}
+		output.flush()
+	})
+	tee.close()
+
+	if plain := readTestFile(t, plainPath); plain != stdout {
+		t.Errorf("--tee wrote %q, want what was printed %q", plain, stdout)
+	}
+	if !strings.Contains(stdout, "\x1b[") {
+		t.Errorf("expected ANSI colors in output: %q", stdout)
+	}
+
+	doc := readTestFile(t, htmlPath)
+	for _, want := range []string{
+		teeHTMLHeader,
+		`<span style="color:#cd0000">ERROR</span> ` +
+			`<span style="background-color:#cdcd00;font-weight:bold">id=42</span> &lt;tag&gt; &amp; more` + "\n",
+		`<span style="color:#cdcd00">WARN disk </span><span style="color:#cd0000">ERROR</span>` + "\n",
+		"plain line\n",
+		teeHTMLFooter,
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("--tee-html output missing %q:\n%s", want, doc)
+		}
+	}
+	if strings.Contains(doc, "\x1b") {
+		t.Errorf("--tee-html output contains escape sequences:\n%s", doc)
+	}
+}
+
+// HTML の範囲は端末の色付けと同じ計算から作られる
+func TestTeeHTMLSpansMatchTerminalColors(t *testing.T) {
+	withReset(t)
+	h := buildHighlighter(globalOptions{colorMode: colorAlways, autoHighlight: true}, []string{"hash:user=\\w+", "magenta:timeout"})
+	_, htmlPath := openTestTee(t)
+
+	for _, line := range benchmarkLines {
+		colored, spans := h.highlightSpans(line)
+		if want := h.Highlight(line); colored != want {
+			t.Errorf("highlightSpans(%q) = %q, want %q", line, colored, want)
+		}
+		var rebuilt strings.Builder
+		last := 0
+		for _, span := range spans {
+			rebuilt.WriteString(line[last:span.start])
+			rebuilt.WriteString(span.color.Sprint(line[span.start:span.end]))
+			last = span.end
+		}
+		rebuilt.WriteString(line[last:])
+		if rebuilt.String() != colored {
+			t.Errorf("spans of %q render %q, want %q", line, rebuilt.String(), colored)
+		}
+		writeLine(h, "", line)
+	}
+	tee.close()
+	doc := readTestFile(t, htmlPath)
+	if !strings.Contains(doc, `<span style="color:`) {
+		t.Errorf("expected colored spans in HTML:\n%s", doc)
+	}
+}
+
+func TestTeeHTMLHashIsStable(t *testing.T) {
+	withReset(t)
+	h := userHighlighter(colorNever, "hash:user=\\w+")
+	_, htmlPath := openTestTee(t)
+
+	captureOutput(t, func() {
+		writeLine(h, "", "user=alice")
+		writeLine(h, "", "user=bob")
+		writeLine(h, "", "again user=alice")
+		output.flush()
+	})
+	tee.close()
+	lines := strings.Split(readTestFile(t, htmlPath), "\n")
+	var alice []string
+	for _, line := range lines {
+		if strings.Contains(line, "user=alice") {
+			start := strings.Index(line, "<span")
+			alice = append(alice, line[start:strings.Index(line, ">")+1])
+		}
+	}
+	if len(alice) != 2 || alice[0] != alice[1] || !strings.Contains(alice[0], "color:#") {
+		t.Errorf("hash colors for the same value differ or are missing: %q", alice)
+	}
+}
+
+func TestAttributesCSS(t *testing.T) {
+	tests := []struct {
+		attrs []color.Attribute
+		want  string
+	}{
+		{nil, ""},
+		{[]color.Attribute{color.FgRed}, "color:#cd0000"},
+		{[]color.Attribute{color.FgHiBlue, color.Underline}, "color:#5c5cff;text-decoration:underline"},
+		{[]color.Attribute{38, 5, 208, 48, 2, 1, 2, 3}, "color:#ff8700;background-color:#010203"},
+		{[]color.Attribute{color.ReverseVideo}, "color:#1e1e1e;background-color:#d0d0d0"},
+		{[]color.Attribute{color.Faint, color.Italic, color.CrossedOut}, "opacity:0.6;font-style:italic;text-decoration:line-through"},
+	}
+	for _, tt := range tests {
+		if got := attributesCSS(tt.attrs); got != tt.want {
+			t.Errorf("attributesCSS(%v) = %q, want %q", tt.attrs, got, tt.want)
+		}
+	}
+}
+
+func TestTeeHTMLIsClosedOnFatalErrors(t *testing.T) {
+	dir := t.TempDir()
+	htmlPath := filepath.Join(dir, "out.html")
+	result := runTrailHelper(t, "--no-logo", "--tee-html", htmlPath, "file", filepath.Join(dir, "missing.log"))
+	if result.code != 1 {
+		t.Fatalf("exit code = %d, want 1; stderr=%q", result.code, result.stderr)
+	}
+	if doc := readTestFile(t, htmlPath); !strings.HasSuffix(doc, teeHTMLFooter) {
+		t.Errorf("--tee-html output is not closed:\n%s", doc)
+	}
+
+	// オプションの誤りではファイルを作らない
+	htmlPath = filepath.Join(dir, "invalid.html")
+	result = runTrailHelper(t, "--no-logo", "--tee-html", htmlPath, "file", "-min-level", "loud", filepath.Join(dir, "missing.log"))
+	if result.code != 1 {
+		t.Fatalf("exit code = %d, want 1; stderr=%q", result.code, result.stderr)
+	}
+	if _, err := os.Stat(htmlPath); !os.IsNotExist(err) {
+		t.Errorf("--tee-html file created for invalid options: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- 行頭のタイムスタンプの書き換え ----------
+
+type timeFlags struct {
+	format *string
+	zone   *string
+}
+
+func registerTimeFlags(fs *flag.FlagSet) timeFlags {
+	return timeFlags{
+		format: fs.String("time-format", "", "rewrite the leading timestamp: iso, rfc3339, delta, or a Go time layout"),
+		zone:   fs.String("time-zone", "local", "time zone for -time-format: local, UTC, or a name such as Asia/Tokyo"),
+	}
+}
+
+// 書き換え後の書式の別名
+var timeFormatAliases = map[string]string{
+	"iso":     "2006-01-02T15:04:05.000Z07:00",
+	"rfc3339": time.RFC3339,
+}
+
+// 認識するタイムスタンプの形式。正規表現の最初のグループがタイムスタンプ部分。
+type timestampForm struct {
+	re     *regexp.Regexp
+	iso    bool   // 日付・時刻・小数部・時差のグループから組み立てる
+	layout string // iso でも layout でもなければ UNIX 時刻
+	noYear bool
+}
+
+var timestampForms = []timestampForm{
+	// 2024-05-01T12:00:00.123Z, 2024-05-01 12:00:00,123 +0900 など
+	{iso: true, re: regexp.MustCompile(`^((\d{4}-\d{2}-\d{2})[T ](\d{2}:\d{2}:\d{2})(?:[.,](\d{1,9}))?(?: ?(Z|[+-]\d{2}:?\d{2}))?)`)},
+	// 2024/05/01 12:00:00.123 (Go の log パッケージ)
+	{iso: true, re: regexp.MustCompile(`^((\d{4}/\d{2}/\d{2}) (\d{2}:\d{2}:\d{2})(?:[.,](\d{1,9}))?)`)},
+	// [01/May/2024:12:00:00 +0900] (Apache / nginx)
+	{re: regexp.MustCompile(`^\[(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`), layout: "02/Jan/2006:15:04:05 -0700"},
+	// May  1 12:00:00 (syslog、年なし)
+	{re: regexp.MustCompile(`^([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2})`), layout: time.Stamp, noYear: true},
+	// 1714564800.123 または 1714564800123 (UNIX 時刻)
+	{re: regexp.MustCompile(`^(\d{10}(?:\.\d{1,9})?|\d{13})\b`)},
+}
+
+// 行頭のタイムスタンプを探し、時刻と行内の位置を返す
+func parseLeadingTimestamp(text string, loc *time.Location, now time.Time) (time.Time, int, int, bool) {
+	for _, form := range timestampForms {
+		m := form.re.FindStringSubmatchIndex(text)
+		if m == nil {
+			continue
+		}
+		start, end := m[2], m[3]
+		value := text[start:end]
+		var t time.Time
+		var err error
+		switch {
+		case form.iso:
+			t, err = parseISOLike(text, m, loc)
+		case form.layout != "":
+			t, err = time.ParseInLocation(form.layout, value, loc)
+			if err == nil && form.noYear {
+				t = time.Date(now.In(loc).Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+				if t.Sub(now) > 24*time.Hour {
+					// 年をまたいだ直後に前年の行を読んだ場合
+					t = t.AddDate(-1, 0, 0)
+				}
+			}
+		default:
+			t, err = parseUnixTimestamp(value)
+		}
+		if err != nil {
+			return time.Time{}, 0, 0, false
+		}
+		return t, start, end, true
+	}
+	return time.Time{}, 0, 0, false
+}
+
+// 日付・時刻・小数部・時差を組み立てて解析する
+func parseISOLike(text string, m []int, loc *time.Location) (time.Time, error) {
+	group := func(n int) string {
+		if m[2*n] < 0 {
+			return ""
+		}
+		return text[m[2*n]:m[2*n+1]]
+	}
+	date := strings.ReplaceAll(group(2), "/", "-")
+	value := date + "T" + group(3)
+	if frac := group(4); frac != "" {
+		value += "." + frac
+	}
+	zone := ""
+	if len(m) > 10 {
+		zone = group(5)
+	}
+	if zone == "" {
+		return time.ParseInLocation("2006-01-02T15:04:05.999999999", value, loc)
+	}
+	if zone != "Z" && !strings.Contains(zone, ":") {
+		zone = zone[:3] + ":" + zone[3:]
+	}
+	return time.Parse(time.RFC3339Nano, value+zone)
+}
+
+func parseUnixTimestamp(value string) (time.Time, error) {
+	if len(value) == 13 && !strings.Contains(value, ".") {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(ms), nil
+	}
+	sec, frac, _ := strings.Cut(value, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var ns int64
+	if frac != "" {
+		ns, err = strconv.ParseInt((frac + "000000000")[:9], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(s, ns), nil
+}
+
+// タイムスタンプの書き換え
+type timeRewriter struct {
+	layout string         // 空なら前の行からの差分を表示する
+	loc    *time.Location // 書き換え後のタイムゾーン
+	input  *time.Location // タイムゾーンの無い時刻の解釈に使う
+	now    func() time.Time
+
+	mu   sync.Mutex
+	prev time.Time
+}
+
+var timeRules *timeRewriter
+
+func (f timeFlags) build() (*timeRewriter, error) {
+	if *f.format == "" {
+		return nil, nil
+	}
+	var loc *time.Location
+	switch strings.ToLower(*f.zone) {
+	case "", "local":
+		loc = time.Local
+	case "utc":
+		loc = time.UTC
+	default:
+		l, err := time.LoadLocation(*f.zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -time-zone %q: %v", *f.zone, err)
+		}
+		loc = l
+	}
+
+	r := &timeRewriter{loc: loc, input: time.Local, now: time.Now}
+	switch format := *f.format; {
+	case strings.EqualFold(format, "delta"):
+	case timeFormatAliases[strings.ToLower(format)] != "":
+		r.layout = timeFormatAliases[strings.ToLower(format)]
+	default:
+		r.layout = format
+	}
+	return r, nil
+}
+
+// 行頭のタイムスタンプを書き換える。解析できない行はそのまま返す。
+func (r *timeRewriter) rewrite(text string) string {
+	if r == nil {
+		return text
+	}
+	// タイムゾーンの無い時刻は選んだタイムゾーンではなく、このマシンの時刻として解釈する
+	t, start, end, ok := parseLeadingTimestamp(text, r.input, r.now())
+	if !ok {
+		return text
+	}
+	if r.layout != "" {
+		return text[:start] + t.In(r.loc).Format(r.layout) + text[end:]
+	}
+
+	r.mu.Lock()
+	var delta time.Duration
+	if !r.prev.IsZero() {
+		delta = t.Sub(r.prev)
+	}
+	r.prev = t
+	r.mu.Unlock()
+	return text[:start] + formatDelta(delta) + text[end:]
+}
+
+// +0.032s のように秒単位で表す
+func formatDelta(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	return fmt.Sprintf("%s%.3fs", sign, d.Seconds())
+}
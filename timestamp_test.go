@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newTestTimeRewriter(t *testing.T, format, zone string) *timeRewriter {
+	t.Helper()
+	r, err := timeFlags{format: &format, zone: &zone}.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.input = time.UTC
+	r.now = func() time.Time { return time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC) }
+	return r
+}
+
+func TestTimeRewriterFormats(t *testing.T) {
+	r := newTestTimeRewriter(t, "iso", "Asia/Tokyo")
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"2024-05-01T12:00:00.123Z INFO a", "2024-05-01T21:00:00.123+09:00 INFO a"},
+		{"2024-05-01 12:00:00,5 +0200 b", "2024-05-01T19:00:00.500+09:00 b"},
+		{"2024-05-01 12:00:00 c", "2024-05-01T21:00:00.000+09:00 c"},
+		{"2024/05/01 12:00:00 d", "2024-05-01T21:00:00.000+09:00 d"},
+		{"[01/May/2024:12:00:00 +0000] e", "[2024-05-01T21:00:00.000+09:00] e"},
+		{"May  1 12:00:00 host f", "2024-05-01T21:00:00.000+09:00 host f"},
+		{"1714564800.25 g", "2024-05-01T21:00:00.250+09:00 g"},
+		{"1714564800250 h", "2024-05-01T21:00:00.250+09:00 h"},
+		{"no timestamp here", "no timestamp here"},
+		{"  2024-05-01T12:00:00Z indented", "  2024-05-01T12:00:00Z indented"},
+		{"2024-13-45 99:99:99 invalid", "2024-13-45 99:99:99 invalid"},
+	}
+	for _, tt := range tests {
+		if got := r.rewrite(tt.in); got != tt.want {
+			t.Errorf("rewrite(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	layout := newTestTimeRewriter(t, "15:04:05.000", "UTC")
+	if got := layout.rewrite("2024-05-01T12:00:00.123+09:00 x"); got != "03:00:00.123 x" {
+		t.Fatalf("rewrite with layout = %q", got)
+	}
+}
+
+func TestTimeRewriterDelta(t *testing.T) {
+	r := newTestTimeRewriter(t, "delta", "local")
+	lines := []struct{ in, want string }{
+		{"2024-05-01T12:00:00.000Z start", "+0.000s start"},
+		{"2024-05-01T12:00:00.032Z next", "+0.032s next"},
+		{"    at stack frame", "    at stack frame"},
+		{"2024-05-01T12:00:02.032Z stalled", "+2.000s stalled"},
+		{"2024-05-01T12:00:01.532Z reordered", "-0.500s reordered"},
+	}
+	for _, line := range lines {
+		if got := r.rewrite(line.in); got != line.want {
+			t.Errorf("rewrite(%q) = %q, want %q", line.in, got, line.want)
+		}
+	}
+}
+
+func TestTimeFlagsValidation(t *testing.T) {
+	format, zone := "iso", "Mars/Olympus"
+	if _, err := (timeFlags{format: &format, zone: &zone}).build(); err == nil {
+		t.Fatal("expected error for unknown time zone")
+	}
+	empty := ""
+	if r, err := (timeFlags{format: &empty, zone: &zone}).build(); r != nil || err != nil {
+		t.Fatalf("build without -time-format = %v, %v", r, err)
+	}
+}
+
+func TestWriteLineRewritesTimestampForDisplayOnly(t *testing.T) {
+	withReset(t)
+	timeRules = newTestTimeRewriter(t, "delta", "UTC")
+	exitRules = &exitConditions{untilMatch: regexp.MustCompile(`^2024-05-01T12:00:01Z two$`), done: make(chan int, 1)}
+
+	out := captureOutput(t, func() {
+		printLine(nil, "2024-05-01T12:00:00Z one")
+		printLine(nil, "2024-05-01T12:00:01Z two")
+	})
+	if out != "+0.000s one\n+1.000s two\n" {
+		t.Fatalf("output = %q", out)
+	}
+	// 終了条件などは元の行で評価する
+	select {
+	case code := <-exitRules.doneCh():
+		if code != exitMatched {
+			t.Fatalf("exit code = %d", code)
+		}
+	default:
+		t.Fatal("-until-match did not see the original timestamp")
+	}
+}
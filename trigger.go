@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- マッチした行でコマンド / Webhook を起動 ----------
+
+// 1 回のアクション実行の上限時間
+const triggerActionTimeout = 30 * time.Second
+
+type triggerFlags struct {
+	specs       *repeatedStrings
+	cooldown    *time.Duration
+	concurrency *int
+}
+
+func registerTriggerFlags(fs *flag.FlagSet) triggerFlags {
+	var specs repeatedStrings
+	fs.Var(&specs, "on", "run 'regex=command' (or 'regex=http://...') when a line matches (can be used multiple times)")
+	return triggerFlags{
+		specs:       &specs,
+		cooldown:    fs.Duration("on-cooldown", time.Second, "minimum time between two runs of the same -on trigger"),
+		concurrency: fs.Int("on-concurrency", 4, "maximum number of -on actions running at once"),
+	}
+}
+
+// マッチ時に渡す値
+type triggerVars struct {
+	line       string
+	file       string
+	groups     []string
+	names      []string
+	suppressed int
+}
+
+type trigger struct {
+	pattern *regexp.Regexp
+	action  string
+	spec    string
+
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+type triggerRunner func(ctx context.Context, action string, vars triggerVars) error
+
+type triggerSet struct {
+	triggers []*trigger
+	cooldown time.Duration
+	sem      chan struct{}
+	wg       sync.WaitGroup
+	run      triggerRunner
+	now      func() time.Time
+}
+
+var triggers *triggerSet
+
+func (f triggerFlags) build() (*triggerSet, error) {
+	if len(*f.specs) == 0 {
+		return nil, nil
+	}
+	if *f.cooldown < 0 {
+		return nil, fmt.Errorf("-on-cooldown must be >= 0")
+	}
+	if *f.concurrency <= 0 {
+		return nil, fmt.Errorf("-on-concurrency must be > 0")
+	}
+
+	set := &triggerSet{
+		cooldown: *f.cooldown,
+		sem:      make(chan struct{}, *f.concurrency),
+		run:      runTriggerAction,
+		now:      time.Now,
+	}
+	for _, spec := range *f.specs {
+		t, err := parseTrigger(spec)
+		if err != nil {
+			return nil, err
+		}
+		set.triggers = append(set.triggers, t)
+	}
+	return set, nil
+}
+
+// 'regex=action' を解析する。regex 側で '=' を使う場合は \x3d と書く。
+func parseTrigger(spec string) (*trigger, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return nil, fmt.Errorf("invalid -on format: %s (expected 'regex=command')", spec)
+	}
+	regexStr := strings.TrimSpace(parts[0])
+	regex, err := regexp.Compile(regexStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -on regex '%s': %v", regexStr, err)
+	}
+	return &trigger{pattern: regex, action: strings.TrimSpace(parts[1]), spec: spec}, nil
+}
+
+// 行をすべてのトリガーに照合し、条件を満たしたものを非同期に実行する
+func (s *triggerSet) fire(file, text string) {
+	if s == nil {
+		return
+	}
+	for _, t := range s.triggers {
+		groups := t.pattern.FindStringSubmatch(text)
+		if groups == nil {
+			continue
+		}
+		suppressed, ok, dropped := t.admit(s.now(), s.cooldown, s.sem)
+		if dropped {
+			// 同時実行数の上限に達しているときは tail を止めずに捨てる
+			log.Printf("trigger %q dropped: %d actions already running", t.spec, cap(s.sem))
+		}
+		if !ok {
+			continue
+		}
+
+		vars := triggerVars{
+			line:       text,
+			file:       file,
+			groups:     groups,
+			names:      t.pattern.SubexpNames(),
+			suppressed: suppressed,
+		}
+		s.wg.Add(1)
+		go func(t *trigger) {
+			defer s.wg.Done()
+			defer func() { <-s.sem }()
+			ctx, cancel := context.WithTimeout(context.Background(), triggerActionTimeout)
+			defer cancel()
+			if err := s.run(ctx, t.action, vars); err != nil {
+				log.Printf("trigger %q failed: %v", t.spec, err)
+			}
+		}(t)
+	}
+}
+
+// クールダウン中なら抑制数を数えて false を返す。
+// 実行できるときは sem を 1 つ確保する。上限に達していれば dropped を返し、クールダウンも抑制数もそのままにする
+func (t *trigger) admit(now time.Time, cooldown time.Duration, sem chan struct{}) (suppressed int, ok, dropped bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.last.IsZero() && now.Sub(t.last) < cooldown {
+		t.suppressed++
+		return 0, false, false
+	}
+	select {
+	case sem <- struct{}{}:
+	default:
+		return 0, false, true
+	}
+	suppressed = t.suppressed
+	t.last = now
+	t.suppressed = 0
+	return suppressed, true, false
+}
+
+// 実行中のアクションが終わるのを待つ
+func (s *triggerSet) wait() {
+	if s == nil {
+		return
+	}
+	s.wg.Wait()
+}
+
+var placeholderRE = regexp.MustCompile(`\{(\w+)\}`)
+
+// テンプレート変数名と値の一覧
+func (v triggerVars) values() map[string]string {
+	values := map[string]string{
+		"line":       v.line,
+		"file":       v.file,
+		"suppressed": strconv.Itoa(v.suppressed),
+	}
+	for i, group := range v.groups {
+		values[strconv.Itoa(i)] = group
+		if i < len(v.names) && v.names[i] != "" {
+			values[v.names[i]] = group
+		}
+	}
+	return values
+}
+
+// 既知のプレースホルダーだけを置き換える。JSON などの波括弧はそのまま残す。
+func expandTemplate(template string, values map[string]string, quote func(name, value string) string) string {
+	return placeholderRE.ReplaceAllStringFunc(template, func(m string) string {
+		name := m[1 : len(m)-1]
+		value, ok := values[name]
+		if !ok {
+			return m
+		}
+		return quote(name, value)
+	})
+}
+
+func triggerEnvName(name string) string {
+	if _, err := strconv.Atoi(name); err == nil {
+		return "TRAIL_GROUP_" + name
+	}
+	switch name {
+	case "line", "file", "suppressed":
+		return "TRAIL_" + strings.ToUpper(name)
+	}
+	return "TRAIL_GROUP_" + strings.ToUpper(name)
+}
+
+func runTriggerAction(ctx context.Context, action string, vars triggerVars) error {
+	if strings.HasPrefix(action, "http://") || strings.HasPrefix(action, "https://") {
+		return postTriggerWebhook(ctx, action, vars)
+	}
+	return runTriggerCommand(ctx, action, vars)
+}
+
+// コマンドはシェルで実行する。行の内容はシェルに再解釈されないよう環境変数経由で渡す。
+func runTriggerCommand(ctx context.Context, command string, vars triggerVars) error {
+	values := vars.values()
+	env := os.Environ()
+	for name, value := range values {
+		env = append(env, triggerEnvName(name)+"="+value)
+	}
+
+	args := triggerShellArgs(runtime.GOOS, command, values)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	setShellCmdLine(cmd, args)
+	cmd.Env = env
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// プレースホルダーを環境変数の参照に置き換えたシェルのコマンドライン。
+// cmd は %VAR% をコマンドの解釈より前に展開するため、値に & や | があるとコマンドとして実行されてしまう。
+// 遅延展開 (/V:ON の !VAR!) は解釈の後に展開されるので、値はそのまま文字列として渡る。
+// /S と外側の引用符で、cmd は引用符を 1 組外しただけのコマンドを実行する。
+func triggerShellArgs(goos, command string, values map[string]string) []string {
+	if goos == "windows" {
+		expanded := expandTemplate(command, values, func(name, _ string) string {
+			return "!" + triggerEnvName(name) + "!"
+		})
+		return []string{"cmd", "/V:ON", "/S", "/C", `"` + expanded + `"`}
+	}
+	expanded := expandTemplate(command, values, func(name, _ string) string {
+		return "${" + triggerEnvName(name) + "}"
+	})
+	return []string{"sh", "-c", expanded}
+}
+
+// Windows で cmd に渡すコマンドライン。cmd は引数を自分で解釈するので、
+// Go の引数のエスケープ (" を \" にする) を通さずにそのまま並べる
+func shellCmdLine(args []string) string {
+	return strings.Join(args, " ")
+}
+
+func postTriggerWebhook(ctx context.Context, rawURL string, vars triggerVars) error {
+	values := vars.values()
+	target := expandTemplate(rawURL, values, func(_, value string) string {
+		return url.QueryEscape(value)
+	})
+
+	groups := make(map[string]string)
+	for name, value := range values {
+		switch name {
+		case "line", "file", "suppressed":
+			continue
+		}
+		groups[name] = value
+	}
+	body, err := json.Marshal(map[string]any{
+		"line":       vars.line,
+		"file":       vars.file,
+		"groups":     groups,
+		"suppressed": vars.suppressed,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+import "os/exec"
+
+// sh には引数をそのまま渡せる
+func setShellCmdLine(*exec.Cmd, []string) {}
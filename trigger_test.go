@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func buildTriggers(t *testing.T, args ...string) *triggerSet {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts := registerTriggerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	set, err := opts.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+type recordedAction struct {
+	action string
+	vars   triggerVars
+}
+
+func recordTriggerRuns(set *triggerSet) func() []recordedAction {
+	var mu sync.Mutex
+	var runs []recordedAction
+	set.run = func(_ context.Context, action string, vars triggerVars) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs = append(runs, recordedAction{action: action, vars: vars})
+		return nil
+	}
+	return func() []recordedAction {
+		set.wait()
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]recordedAction(nil), runs...)
+	}
+}
+
+func TestParseTrigger(t *testing.T) {
+	trig, err := parseTrigger(`ERROR (?P<code>\d+)=notify-send "{line}"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := trig.pattern.String(), `ERROR (?P<code>\d+)`; got != want {
+		t.Fatalf("pattern = %q, want %q", got, want)
+	}
+	if got, want := trig.action, `notify-send "{line}"`; got != want {
+		t.Fatalf("action = %q, want %q", got, want)
+	}
+
+	for _, spec := range []string{"no-separator", "=cmd", "ERROR=", "[=cmd"} {
+		if _, err := parseTrigger(spec); err == nil {
+			t.Fatalf("parseTrigger(%q) error = nil", spec)
+		}
+	}
+}
+
+func TestTriggerFlagsValidation(t *testing.T) {
+	if set := buildTriggers(t); set != nil {
+		t.Fatalf("set = %+v, want nil without -on", set)
+	}
+	for _, args := range [][]string{
+		{"-on", "x=y", "-on-concurrency", "0"},
+		{"-on", "x=y", "-on-cooldown", "-1s"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		opts := registerTriggerFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := opts.build(); err == nil {
+			t.Fatalf("build(%q) error = nil", args)
+		}
+	}
+}
+
+func TestTriggerSetFiresWithCaptureGroups(t *testing.T) {
+	set := buildTriggers(t, "-on", `ERROR (?P<code>\d+)=echo {code}`)
+	runs := recordTriggerRuns(set)
+
+	set.fire("app.log", "INFO ok")
+	set.fire("app.log", "ERROR 503 upstream")
+
+	got := runs()
+	if len(got) != 1 {
+		t.Fatalf("runs = %d, want 1", len(got))
+	}
+	values := got[0].vars.values()
+	for name, want := range map[string]string{
+		"line": "ERROR 503 upstream",
+		"file": "app.log",
+		"0":    "ERROR 503",
+		"1":    "503",
+		"code": "503",
+	} {
+		if values[name] != want {
+			t.Fatalf("values[%q] = %q, want %q", name, values[name], want)
+		}
+	}
+}
+
+func TestTriggerSetCooldownSuppressesBursts(t *testing.T) {
+	set := buildTriggers(t, "-on", "ERROR=true", "-on-cooldown", "1m")
+	runs := recordTriggerRuns(set)
+	now := time.Date(2026, 6, 21, 10, 0, 0, 0, time.UTC)
+	set.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		set.fire("", "ERROR")
+	}
+	now = now.Add(time.Minute)
+	set.fire("", "ERROR again")
+
+	got := runs()
+	if len(got) != 2 {
+		t.Fatalf("runs = %d, want 2", len(got))
+	}
+	if got[0].vars.suppressed+got[1].vars.suppressed != 99 {
+		t.Fatalf("suppressed = %d + %d, want 99 in total", got[0].vars.suppressed, got[1].vars.suppressed)
+	}
+}
+
+func TestTriggerSetDropsWhenConcurrencyCapReached(t *testing.T) {
+	withReset(t)
+	set := buildTriggers(t, "-on", "ERROR=true", "-on-cooldown", "0", "-on-concurrency", "1")
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	set.run = func(context.Context, string, triggerVars) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+
+	logs := captureLogOutput(t, func() {
+		set.fire("", "ERROR 1")
+		<-started
+		set.fire("", "ERROR 2")
+	})
+	close(release)
+	set.wait()
+
+	if len(started) != 0 {
+		t.Fatalf("extra actions started: %d", len(started))
+	}
+	requireContains(t, logs, "dropped: 1 actions already running")
+}
+
+func TestTriggerDroppedForConcurrencyKeepsCooldownAndSuppressed(t *testing.T) {
+	withReset(t)
+	set := buildTriggers(t, "-on", "ERROR=true", "-on-cooldown", "1m", "-on-concurrency", "1")
+	now := time.Date(2026, 6, 21, 10, 0, 0, 0, time.UTC)
+	set.now = func() time.Time { return now }
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var suppressed []int
+	set.run = func(_ context.Context, _ string, vars triggerVars) error {
+		mu.Lock()
+		suppressed = append(suppressed, vars.suppressed)
+		first := len(suppressed) == 1
+		mu.Unlock()
+		if first {
+			<-release
+		}
+		return nil
+	}
+
+	captureLogOutput(t, func() {
+		set.fire("", "ERROR 1")
+		set.fire("", "ERROR in cooldown")
+		set.fire("", "ERROR in cooldown")
+		// クールダウンは明けたが、最初のアクションが動いているので捨てられる
+		now = now.Add(time.Minute)
+		set.fire("", "ERROR dropped")
+		close(release)
+		set.wait()
+		// 捨てられたアクションはクールダウンを始めず、抑制数も引き継ぐ
+		set.fire("", "ERROR 2")
+		set.wait()
+	})
+
+	if fmt.Sprint(suppressed) != "[0 2]" {
+		t.Fatalf("suppressed per run = %v, want [0 2]", suppressed)
+	}
+}
+
+func TestExpandTemplateLeavesUnknownBraces(t *testing.T) {
+	got := expandTemplate(`{"text":"{line}","x":{unknown}}`, map[string]string{"line": "hi"}, func(_, v string) string {
+		return v
+	})
+	if want := `{"text":"hi","x":{unknown}}`; got != want {
+		t.Fatalf("expandTemplate = %q, want %q", got, want)
+	}
+}
+
+func TestRunTriggerCommandPassesLineWithoutShellInjection(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	out := filepath.Join(t.TempDir(), "out.txt")
+	trig, err := parseTrigger(`ERROR=printf '%s|%s' "{line}" "{file}" > ` + out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `ERROR $(touch pwned) "quoted"; echo nope & echo bg | cat`
+	vars := triggerVars{line: line, file: "app.log", groups: trig.pattern.FindStringSubmatch(line), names: trig.pattern.SubexpNames()}
+
+	if err := runTriggerCommand(context.Background(), trig.action, vars); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), line+"|app.log"; got != want {
+		t.Fatalf("command output = %q, want %q", got, want)
+	}
+}
+
+func TestTriggerShellArgsKeepLineOutOfCommand(t *testing.T) {
+	line := `x & del /q C:\* | echo %PATH% ^& !TRAIL_FILE!`
+	values := map[string]string{"line": line, "file": "app.log", "code": "E1"}
+	command := `notify.bat "{line}" {file} {code}`
+	tests := []struct {
+		goos string
+		want []string
+	}{
+		{"windows", []string{"cmd", "/V:ON", "/S", "/C", `"notify.bat "!TRAIL_LINE!" !TRAIL_FILE! !TRAIL_GROUP_CODE!"`}},
+		{"linux", []string{"sh", "-c", `notify.bat "${TRAIL_LINE}" ${TRAIL_FILE} ${TRAIL_GROUP_CODE}`}},
+	}
+	for _, tt := range tests {
+		if got := triggerShellArgs(tt.goos, command, values); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: args = %q, want %q", tt.goos, got, tt.want)
+		}
+	}
+}
+
+func TestShellCmdLineKeepsQuotesVerbatim(t *testing.T) {
+	args := triggerShellArgs("windows", `msg * "ERROR in {file}"`, map[string]string{"file": "app.log"})
+	if got, want := shellCmdLine(args), `cmd /V:ON /S /C "msg * "ERROR in !TRAIL_FILE!""`; got != want {
+		t.Fatalf("command line = %s, want %s", got, want)
+	}
+}
+
+func TestPostTriggerWebhook(t *testing.T) {
+	var gotBody map[string]any
+	var gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		gotQuery = r.URL.Query().Get("code")
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &gotBody)
+	}))
+	defer srv.Close()
+
+	trig, err := parseTrigger(`ERROR (?P<code>\d+)=` + srv.URL + `/hook?code={code}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := "ERROR 503 upstream"
+	vars := triggerVars{line: line, file: "app.log", groups: trig.pattern.FindStringSubmatch(line), names: trig.pattern.SubexpNames()}
+
+	if err := runTriggerAction(context.Background(), trig.action, vars); err != nil {
+		t.Fatal(err)
+	}
+	if gotQuery != "503" {
+		t.Fatalf("query code = %q, want 503", gotQuery)
+	}
+	if gotBody["line"] != line || gotBody["file"] != "app.log" {
+		t.Fatalf("body = %v", gotBody)
+	}
+	groups, _ := gotBody["groups"].(map[string]any)
+	if groups["code"] != "503" {
+		t.Fatalf("body groups = %v", gotBody["groups"])
+	}
+}
+
+func TestPostTriggerWebhookReportsHTTPErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	err := postTriggerWebhook(context.Background(), srv.URL, triggerVars{line: "x"})
+	if err == nil {
+		t.Fatal("postTriggerWebhook error = nil")
+	}
+	requireContains(t, err.Error(), "500")
+}
+
+func TestWriteLineFiresTriggersWithSourceFile(t *testing.T) {
+	withReset(t)
+	triggers = buildTriggers(t, "-on", "ERROR=true")
+	runs := recordTriggerRuns(triggers)
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("INFO\nERROR boom\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wait := memoryOutput(t)
+	startTestFollower(t, nil, path, 10)
+	wait("ERROR boom\n")
+
+	got := runs()
+	if len(got) != 1 || got[0].vars.file != path || got[0].vars.line != "ERROR boom" {
+		t.Fatalf("runs = %+v", got)
+	}
+}
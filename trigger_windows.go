@@ -0,0 +1,12 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setShellCmdLine(cmd *exec.Cmd, args []string) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: shellCmdLine(args)}
+}
@@ -0,0 +1,970 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+// ---------- 対話モード (TUI) ----------
+
+// スクロールバックに保持する最大行数
+const tuiMaxLines = 10000
+
+// 再描画の最短間隔
+const tuiRedrawInterval = 50 * time.Millisecond
+
+type tuiLine struct {
+	source string
+	text   string
+}
+
+type tuiInputMode int
+
+const (
+	tuiNormal tuiInputMode = iota
+	tuiSearchInput
+	tuiFilterInput
+	tuiFilePicker
+)
+
+type tuiKeyCode int
+
+const (
+	keyRune tuiKeyCode = iota
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyEsc
+	keyBackspace
+	keyCtrlC
+)
+
+type tuiKey struct {
+	code tuiKeyCode
+	r    rune
+}
+
+// キー入力の結果として呼び出し側が行うこと
+type tuiAction struct {
+	quit      bool
+	switchTo  *string // dir モードで追従するファイル ("" は最新を追う)
+	listFiles bool
+}
+
+// 画面の状態。端末 I/O から切り離してテストできるようにしている。
+type tuiModel struct {
+	mu       sync.Mutex
+	lines    []tuiLine
+	maxLines int
+	width    int
+	height   int
+
+	visibleCount int // フィルタ適用後の行数。行ごとに view() を作り直さないよう数えておく
+
+	follow bool // 新しい行に追従中 (false なら一時停止)
+	offset int  // 表示中の最終行が、表示対象の末尾から何行上か
+
+	mode  tuiInputMode
+	input string
+
+	search       *regexp.Regexp
+	searchErr    bool
+	searchOrigin int // 検索を始めたときの offset
+	filter       *regexp.Regexp
+	filterOn     bool
+	colorsOn     bool
+	disabled     map[int]bool
+	highlight    *color.Color
+	currentHit   int // 現在の検索ヒット (表示対象内のインデックス、-1 なら無し)
+
+	file       string
+	files      []string
+	pickIndex  int
+	canPick    bool
+	message    string
+	statusLine func() string
+
+	highlighter *Highlighter
+}
+
+func newTUIModel(maxLines int, h *Highlighter) *tuiModel {
+	return &tuiModel{
+		highlighter: h,
+		maxLines:    maxLines,
+		width:       80,
+		height:      24,
+		follow:      true,
+		colorsOn:    true,
+		disabled:    make(map[int]bool),
+		highlight:   h.newColor(color.ReverseVideo),
+		currentHit:  -1,
+	}
+}
+
+func (m *tuiModel) append(source, text string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lines = append(m.lines, tuiLine{source: source, text: text})
+	if m.visible(text) {
+		m.visibleCount++
+	}
+	if len(m.lines) > m.maxLines {
+		// 1 行ごとに全体を詰め直さないよう、上限を超えたら 1 割まとめて捨てる
+		drop := len(m.lines) - m.maxLines + m.maxLines/10
+		dropped := m.countVisible(m.lines[:drop])
+		m.visibleCount -= dropped
+		if m.currentHit >= 0 {
+			m.currentHit -= dropped
+			if m.currentHit < 0 {
+				m.currentHit = -1
+			}
+		}
+		m.lines = append(m.lines[:0:0], m.lines[drop:]...)
+	}
+	if !m.follow && m.visible(text) {
+		// 一時停止中は表示位置を固定する
+		m.offset++
+	}
+	m.clampOffset()
+}
+
+func (m *tuiModel) visible(text string) bool {
+	return !m.filterOn || m.filter == nil || m.filter.MatchString(text)
+}
+
+// フィルタを変えたときに表示対象の行数を数え直す
+func (m *tuiModel) recountVisible() {
+	m.visibleCount = m.countVisible(m.lines)
+}
+
+func (m *tuiModel) countVisible(lines []tuiLine) int {
+	n := 0
+	for _, line := range lines {
+		if m.visible(line.text) {
+			n++
+		}
+	}
+	return n
+}
+
+// フィルタ適用後の表示対象
+func (m *tuiModel) view() []tuiLine {
+	if !m.filterOn || m.filter == nil {
+		return m.lines
+	}
+	view := make([]tuiLine, 0, len(m.lines))
+	for _, line := range m.lines {
+		if m.filter.MatchString(line.text) {
+			view = append(view, line)
+		}
+	}
+	return view
+}
+
+func (m *tuiModel) bodyHeight() int {
+	if m.height < 2 {
+		return 1
+	}
+	return m.height - 1
+}
+
+func (m *tuiModel) clampOffset() {
+	maxOffset := m.visibleCount - m.bodyHeight()
+	if maxOffset < 0 {
+		maxOffset = 0
+	}
+	if m.offset > maxOffset {
+		m.offset = maxOffset
+	}
+	if m.offset < 0 {
+		m.offset = 0
+	}
+}
+
+func (m *tuiModel) resize(width, height int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.width, m.height = width, height
+	m.clampOffset()
+}
+
+func (m *tuiModel) setMessage(msg string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.message = msg
+}
+
+// delta > 0 で古い方向へスクロールし、追従を止める
+func (m *tuiModel) scroll(delta int) {
+	m.offset += delta
+	m.clampOffset()
+	if delta > 0 {
+		m.follow = false
+	}
+}
+
+func (m *tuiModel) resume() {
+	m.follow = true
+	m.offset = 0
+}
+
+// 検索にヒットする行へ移動する。older が true なら古い方向へ探す。
+func (m *tuiModel) jumpToMatch(older bool) {
+	if m.search == nil {
+		return
+	}
+	view := m.view()
+	start := m.currentHit
+	if start < 0 {
+		// 画面の最下行から探し始める
+		start = len(view) - m.offset
+		if !older {
+			start = len(view) - m.offset - m.bodyHeight() - 1
+		}
+	}
+	step := 1
+	if older {
+		step = -1
+	}
+	for i := start + step; i >= 0 && i < len(view); i += step {
+		if m.search.MatchString(view[i].text) {
+			m.currentHit = i
+			m.follow = false
+			// ヒット行が画面の中央付近に来るようにする
+			m.offset = len(view) - 1 - i - m.bodyHeight()/2
+			m.clampOffset()
+			m.message = ""
+			return
+		}
+	}
+	m.message = "no more matches: " + m.search.String()
+}
+
+func (m *tuiModel) handleKey(k tuiKey) tuiAction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.mode {
+	case tuiSearchInput, tuiFilterInput:
+		return m.handleInputKey(k)
+	case tuiFilePicker:
+		return m.handlePickerKey(k)
+	}
+
+	page := m.bodyHeight()
+	switch k.code {
+	case keyCtrlC:
+		return tuiAction{quit: true}
+	case keyUp:
+		m.scroll(1)
+	case keyDown:
+		m.scroll(-1)
+	case keyPageUp:
+		m.scroll(page)
+	case keyPageDown:
+		m.scroll(-page)
+	case keyHome:
+		m.scroll(len(m.lines))
+	case keyEnd:
+		m.resume()
+	case keyEsc:
+		m.search = nil
+		m.currentHit = -1
+		m.message = ""
+	case keyRune:
+		switch k.r {
+		case 'q':
+			return tuiAction{quit: true}
+		case ' ', 'p':
+			if m.follow {
+				m.follow = false
+			} else {
+				m.resume()
+			}
+		case 'k':
+			m.scroll(1)
+		case 'j':
+			m.scroll(-1)
+		case 'b':
+			m.scroll(page)
+		case 'f':
+			m.scroll(-page)
+		case 'g':
+			m.scroll(len(m.lines))
+		case 'G':
+			m.resume()
+		case '/':
+			m.mode = tuiSearchInput
+			m.input = ""
+			m.currentHit = -1
+			m.searchOrigin = m.offset
+		case '&':
+			m.mode = tuiFilterInput
+			m.input = ""
+		case 'n':
+			m.jumpToMatch(true)
+		case 'N':
+			m.jumpToMatch(false)
+		case 't':
+			if m.filter == nil {
+				m.message = "no filter set (press & to set one)"
+				break
+			}
+			m.filterOn = !m.filterOn
+			m.recountVisible()
+			m.currentHit = -1
+			m.clampOffset()
+		case 'c':
+			m.colorsOn = !m.colorsOn
+		case 'o':
+			if !m.canPick {
+				m.message = "file switcher is only available in dir mode"
+				break
+			}
+			return tuiAction{listFiles: true}
+		default:
+			if k.r >= '1' && k.r <= '9' {
+				order := int(k.r - '1')
+				m.disabled[order] = !m.disabled[order]
+			}
+		}
+	}
+	return tuiAction{}
+}
+
+func (m *tuiModel) handleInputKey(k tuiKey) tuiAction {
+	switch k.code {
+	case keyCtrlC:
+		return tuiAction{quit: true}
+	case keyEsc:
+		if m.mode == tuiSearchInput {
+			m.search = nil
+			m.currentHit = -1
+		}
+		m.mode = tuiNormal
+		m.input = ""
+		m.searchErr = false
+		return tuiAction{}
+	case keyEnter:
+		if m.mode == tuiFilterInput {
+			m.applyFilterInput()
+		}
+		m.mode = tuiNormal
+		m.input = ""
+		m.searchErr = false
+		return tuiAction{}
+	case keyBackspace:
+		if m.input != "" {
+			runes := []rune(m.input)
+			m.input = string(runes[:len(runes)-1])
+		}
+	case keyRune:
+		m.input += string(k.r)
+	default:
+		return tuiAction{}
+	}
+
+	if m.mode == tuiSearchInput {
+		// インクリメンタル検索: 入力のたびに検索開始時の位置から探し直す
+		m.currentHit = -1
+		m.search = nil
+		m.offset = m.searchOrigin
+		m.clampOffset()
+		if m.input == "" {
+			m.searchErr = false
+			return tuiAction{}
+		}
+		re, err := regexp.Compile(m.input)
+		m.searchErr = err != nil
+		if err != nil {
+			return tuiAction{}
+		}
+		m.search = re
+		m.jumpToMatch(true)
+	}
+	return tuiAction{}
+}
+
+func (m *tuiModel) applyFilterInput() {
+	if m.input == "" {
+		m.filter = nil
+		m.filterOn = false
+		m.message = "filter cleared"
+	} else {
+		re, err := regexp.Compile(m.input)
+		if err != nil {
+			m.message = fmt.Sprintf("invalid filter regex '%s': %v", m.input, err)
+			return
+		}
+		m.filter = re
+		m.filterOn = true
+		m.message = ""
+	}
+	m.recountVisible()
+	m.currentHit = -1
+	m.offset = 0
+	m.clampOffset()
+}
+
+func (m *tuiModel) openPicker(files []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files = files
+	m.pickIndex = 0
+	m.mode = tuiFilePicker
+}
+
+func (m *tuiModel) handlePickerKey(k tuiKey) tuiAction {
+	entries := len(m.files) + 1 // 先頭は「最新を追う」
+	switch k.code {
+	case keyCtrlC:
+		return tuiAction{quit: true}
+	case keyEsc:
+		m.mode = tuiNormal
+	case keyUp:
+		if m.pickIndex > 0 {
+			m.pickIndex--
+		}
+	case keyDown:
+		if m.pickIndex < entries-1 {
+			m.pickIndex++
+		}
+	case keyEnter:
+		m.mode = tuiNormal
+		path := ""
+		if m.pickIndex > 0 {
+			path = m.files[m.pickIndex-1]
+		}
+		return tuiAction{switchTo: &path}
+	case keyRune:
+		switch k.r {
+		case 'q':
+			m.mode = tuiNormal
+		case 'k':
+			return m.handlePickerKey(tuiKey{code: keyUp})
+		case 'j':
+			return m.handlePickerKey(tuiKey{code: keyDown})
+		}
+	}
+	return tuiAction{}
+}
+
+// 表示用のパターン一覧。無効化されたものを除き、検索語の強調を最優先で重ねる。
+func (m *tuiModel) patterns() []ColorPattern {
+	var patterns []ColorPattern
+	if m.colorsOn {
+		// 無効にできるのは -c のパターンだけ
+		for _, pattern := range m.highlighter.patterns() {
+			if !m.disabled[pattern.Order] {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	if m.search != nil {
+		patterns = append(patterns, ColorPattern{
+			Pattern: m.search,
+			Color:   m.highlight,
+			Order:   len(m.highlighter.userPatterns()) + 1,
+			Spec:    "search",
+		})
+	}
+	return patterns
+}
+
+func (m *tuiModel) render(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprint(w, "\x1b[H")
+	body := m.bodyHeight()
+	if m.mode == tuiFilePicker {
+		m.renderPicker(w, body)
+	} else {
+		m.renderLines(w, body)
+	}
+	fmt.Fprint(w, "\x1b[2K", m.highlight.Sprint(truncateWidth(m.statusText(), m.width)))
+}
+
+func (m *tuiModel) renderLines(w io.Writer, body int) {
+	view := m.view()
+	end := len(view) - m.offset
+	start := end - body
+	patterns := m.patterns()
+	for row := 0; row < body; row++ {
+		fmt.Fprint(w, "\x1b[2K")
+		if i := start + row; i >= 0 && i < end {
+			fmt.Fprint(w, colorize(truncateWidth(view[i].text, m.width), patterns, nil))
+		}
+		fmt.Fprint(w, "\r\n")
+	}
+}
+
+func (m *tuiModel) renderPicker(w io.Writer, body int) {
+	entries := append([]string{"(follow latest)"}, m.files...)
+	for row := 0; row < body; row++ {
+		fmt.Fprint(w, "\x1b[2K")
+		if row < len(entries) {
+			label := entries[row]
+			if row > 0 {
+				label = filepath.Base(label)
+			}
+			if row > 0 && entries[row] == m.file {
+				label += " *"
+			}
+			label = truncateWidth("  "+label, m.width)
+			if row == m.pickIndex {
+				label = m.highlight.Sprint(label)
+			}
+			fmt.Fprint(w, label)
+		}
+		fmt.Fprint(w, "\r\n")
+	}
+}
+
+func (m *tuiModel) statusText() string {
+	switch m.mode {
+	case tuiSearchInput:
+		if m.searchErr {
+			return "/" + m.input + "  (invalid regex)"
+		}
+		return "/" + m.input
+	case tuiFilterInput:
+		return "&" + m.input
+	case tuiFilePicker:
+		return "select file: Enter to follow, Esc to cancel"
+	}
+
+	state := "LIVE"
+	if !m.follow {
+		state = "PAUSED"
+	}
+	parts := []string{"trail", state}
+	if m.file != "" {
+		parts = append(parts, filepath.Base(m.file))
+	}
+	parts = append(parts, fmt.Sprintf("%d lines", len(m.lines)))
+	if m.search != nil {
+		parts = append(parts, "/"+m.search.String())
+	}
+	if m.filter != nil {
+		state := "off"
+		if m.filterOn {
+			state = "on"
+		}
+		parts = append(parts, fmt.Sprintf("filter %s: %s", state, m.filter.String()))
+	}
+	if !m.colorsOn {
+		parts = append(parts, "colors off")
+	} else if off := m.disabledSpecs(); off != "" {
+		parts = append(parts, "off: "+off)
+	}
+	if m.statusLine != nil {
+		parts = append(parts, m.statusLine())
+	}
+	if m.message != "" {
+		parts = append(parts, m.message)
+	}
+	return " " + strings.Join(parts, " | ")
+}
+
+func (m *tuiModel) disabledSpecs() string {
+	var specs []string
+	for _, pattern := range m.highlighter.userPatterns() {
+		if m.disabled[pattern.Order] {
+			specs = append(specs, pattern.Spec)
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+// 表示幅で切り詰めた上で、右側を空白で埋めずに返す。
+// 制御文字は端末で幅が変わるので、先に expandControls で見える形にする。
+func truncateWidth(s string, width int) string {
+	s = expandControls(s)
+	total := 0
+	for i, r := range s {
+		w := runeWidth(r)
+		if total+w > width {
+			return s[:i]
+		}
+		total += w
+	}
+	return s
+}
+
+// タブを次のタブ位置 (8 桁ごと) までの空白にし、それ以外の C0 制御文字と DEL は ^X の形で表す。
+// C1 制御文字は取り除く。
+func expandControls(s string) string {
+	if !strings.ContainsFunc(s, func(r rune) bool { return r < 0x20 || (r >= 0x7f && r < 0xa0) }) {
+		return s
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			n := 8 - col%8
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		case r < 0x20 || r == 0x7f:
+			b.WriteByte('^')
+			b.WriteByte(byte(r) ^ 0x40)
+			col += 2
+		case r > 0x7f && r < 0xa0:
+		default:
+			b.WriteRune(r)
+			col += runeWidth(r)
+		}
+	}
+	return b.String()
+}
+
+// 東アジアの全角文字は 2 桁として数える
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20 || (r >= 0x7f && r < 0xa0):
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0x303e,
+		r >= 0x3041 && r <= 0x33ff,
+		r >= 0x3400 && r <= 0x4dbf,
+		r >= 0x4e00 && r <= 0x9fff,
+		r >= 0xa000 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+// 入力バイト列をキーに変換する
+func parseKeys(b []byte) []tuiKey {
+	var keys []tuiKey
+	for len(b) > 0 {
+		switch {
+		case b[0] == 0x1b && len(b) >= 3 && (b[1] == '[' || b[1] == 'O'):
+			seq, n := parseEscape(b)
+			if n == 0 {
+				keys = append(keys, tuiKey{code: keyEsc})
+				b = b[1:]
+				continue
+			}
+			if seq != nil {
+				keys = append(keys, *seq)
+			}
+			b = b[n:]
+		case b[0] == 0x1b:
+			keys = append(keys, tuiKey{code: keyEsc})
+			b = b[1:]
+		case b[0] == '\r' || b[0] == '\n':
+			keys = append(keys, tuiKey{code: keyEnter})
+			b = b[1:]
+		case b[0] == 0x7f || b[0] == 0x08:
+			keys = append(keys, tuiKey{code: keyBackspace})
+			b = b[1:]
+		case b[0] == 0x03:
+			keys = append(keys, tuiKey{code: keyCtrlC})
+			b = b[1:]
+		case b[0] == 0x02:
+			keys = append(keys, tuiKey{code: keyPageUp})
+			b = b[1:]
+		case b[0] == 0x06:
+			keys = append(keys, tuiKey{code: keyPageDown})
+			b = b[1:]
+		case b[0] < 0x20:
+			b = b[1:]
+		default:
+			s := string(b)
+			r := []rune(s)[0]
+			keys = append(keys, tuiKey{code: keyRune, r: r})
+			b = b[len(string(r)):]
+		}
+	}
+	return keys
+}
+
+// ESC [ ... のシーケンスを解析する。未対応のものは読み飛ばす。
+func parseEscape(b []byte) (*tuiKey, int) {
+	for i := 2; i < len(b); i++ {
+		c := b[i]
+		if (c >= 'A' && c <= 'Z') || c == '~' || (c >= 'a' && c <= 'z') {
+			body := string(b[2:i])
+			var code tuiKeyCode
+			switch {
+			case c == 'A':
+				code = keyUp
+			case c == 'B':
+				code = keyDown
+			case c == 'H':
+				code = keyHome
+			case c == 'F':
+				code = keyEnd
+			case c == '~' && body == "5":
+				code = keyPageUp
+			case c == '~' && body == "6":
+				code = keyPageDown
+			case c == '~' && (body == "1" || body == "7"):
+				code = keyHome
+			case c == '~' && (body == "4" || body == "8"):
+				code = keyEnd
+			default:
+				return nil, i + 1
+			}
+			return &tuiKey{code: code}, i + 1
+		}
+	}
+	return nil, 0
+}
+
+// 端末との入出力を受け持つ
+type tuiApp struct {
+	model    *tuiModel
+	in       *os.File
+	out      *bufio.Writer
+	oldState *term.State
+
+	dirty     chan struct{}
+	quit      chan struct{}
+	switchReq chan string
+	listFiles func() ([]string, error)
+	closeOnce sync.Once
+	quitOnce  sync.Once
+	done      chan struct{}
+	stopped   chan struct{} // redrawLoop が終わると閉じる
+}
+
+var tuiMode bool
+
+var tuiView *tuiApp
+
+// --tui 指定時に TUI を開始し、ログ出力をステータス行へ向ける
+func startTUIMode(h *Highlighter) {
+	if !tuiMode {
+		return
+	}
+	app, err := startTUI(h)
+	if err != nil {
+		exitFatal(err)
+	}
+	tuiView = app
+	log.SetOutput(app)
+}
+
+// 出力を書き終え、TUI を閉じてから log.Fatal する。
+// --tee-html のファイルもここで閉じないと、末尾の </html> が書かれない。
+func exitFatal(v ...any) {
+	output.flush()
+	output.close()
+	tee.close()
+	if tuiView != nil {
+		tuiView.close()
+		log.SetOutput(os.Stderr)
+	}
+	log.Fatal(v...)
+}
+
+// 端末を raw モード・代替画面に切り替えて TUI を開始する
+func startTUI(h *Highlighter) (*tuiApp, error) {
+	in := os.Stdin
+	if !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, fmt.Errorf("--tui requires an interactive terminal")
+	}
+	oldState, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, err
+	}
+
+	app := &tuiApp{
+		model:     newTUIModel(tuiMaxLines, h),
+		in:        in,
+		out:       bufio.NewWriter(os.Stdout),
+		oldState:  oldState,
+		dirty:     make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		switchReq: make(chan string, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	if stats != nil {
+		app.model.statusLine = func() string {
+			return stats.line(h.userPatterns())
+		}
+	}
+	app.resize()
+	fmt.Fprint(app.out, "\x1b[?1049h\x1b[?25l\x1b[2J")
+	app.out.Flush()
+
+	go app.readInput()
+	go app.redrawLoop()
+	return app, nil
+}
+
+func (a *tuiApp) markDirty() {
+	select {
+	case a.dirty <- struct{}{}:
+	default:
+	}
+}
+
+func (a *tuiApp) resize() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return
+	}
+	if width != a.model.width || height != a.model.height {
+		a.model.resize(width, height)
+		a.markDirty()
+	}
+}
+
+func (a *tuiApp) redrawLoop() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(tuiRedrawInterval)
+	defer ticker.Stop()
+	refresh := time.NewTicker(time.Second) // 統計表示の更新用
+	defer refresh.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-refresh.C:
+			a.markDirty()
+		case <-ticker.C:
+			a.resize()
+			select {
+			case <-a.dirty:
+				a.model.render(a.out)
+				a.out.Flush()
+			default:
+			}
+		}
+	}
+}
+
+func (a *tuiApp) readInput() {
+	buf := make([]byte, 256)
+	for {
+		n, err := a.in.Read(buf)
+		if err != nil {
+			a.requestQuit()
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			action := a.model.handleKey(key)
+			switch {
+			case action.quit:
+				a.requestQuit()
+				return
+			case action.listFiles:
+				a.openPicker()
+			case action.switchTo != nil:
+				select {
+				case a.switchReq <- *action.switchTo:
+				default:
+				}
+			}
+		}
+		a.markDirty()
+	}
+}
+
+func (a *tuiApp) openPicker() {
+	if a.listFiles == nil {
+		return
+	}
+	files, err := a.listFiles()
+	if err != nil {
+		a.model.setMessage(err.Error())
+		return
+	}
+	a.model.openPicker(files)
+}
+
+func (a *tuiApp) requestQuit() {
+	a.quitOnce.Do(func() {
+		close(a.quit)
+	})
+}
+
+// TUI にログ行を追加する
+func (a *tuiApp) append(source, text string) {
+	a.model.append(source, text)
+	a.markDirty()
+}
+
+func (a *tuiApp) setFile(path string) {
+	if a == nil {
+		return
+	}
+	a.model.mu.Lock()
+	a.model.file = path
+	a.model.mu.Unlock()
+	a.markDirty()
+}
+
+// dir モードでファイル一覧を取得する関数を設定し、ファイル切り替えを有効にする
+func (a *tuiApp) setFileLister(list func() ([]string, error)) {
+	if a == nil {
+		return
+	}
+	a.model.mu.Lock()
+	a.model.canPick = true
+	a.model.mu.Unlock()
+	a.listFiles = list
+}
+
+func (a *tuiApp) quitCh() <-chan struct{} {
+	if a == nil {
+		return nil
+	}
+	return a.quit
+}
+
+func (a *tuiApp) switchCh() <-chan string {
+	if a == nil {
+		return nil
+	}
+	return a.switchReq
+}
+
+// log パッケージの出力をステータス行に表示する
+func (a *tuiApp) Write(p []byte) (int, error) {
+	a.model.setMessage(strings.TrimSpace(string(p)))
+	a.markDirty()
+	return len(p), nil
+}
+
+// 端末を元の状態に戻す
+func (a *tuiApp) close() {
+	if a == nil {
+		return
+	}
+	a.closeOnce.Do(func() {
+		close(a.done)
+		// 描画中に端末を戻すと a.out への書き込みが重なるので、redrawLoop が終わるのを待つ
+		<-a.stopped
+		fmt.Fprint(a.out, "\x1b[0m\x1b[?25h\x1b[?1049l")
+		a.out.Flush()
+		term.Restore(int(a.in.Fd()), a.oldState)
+	})
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/term"
+)
+
+func newTestTUIModel(t *testing.T, h *Highlighter, lines ...string) *tuiModel {
+	t.Helper()
+	m := newTUIModel(100, h)
+	m.resize(40, 4) // 本文 3 行 + ステータス行
+	for _, line := range lines {
+		m.append("app.log", line)
+	}
+	return m
+}
+
+func renderBody(m *tuiModel) []string {
+	var buf bytes.Buffer
+	m.render(&buf)
+	rows := strings.Split(stripANSI(strings.ReplaceAll(buf.String(), "\x1b[2K", "")), "\r\n")
+	body := rows[:len(rows)-1]
+	for i := range body {
+		body[i] = strings.TrimPrefix(body[i], "\x1b[H")
+	}
+	return body
+}
+
+func pressKeys(m *tuiModel, input string) tuiAction {
+	var action tuiAction
+	for _, key := range parseKeys([]byte(input)) {
+		action = m.handleKey(key)
+	}
+	return action
+}
+
+func numberedLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	return lines
+}
+
+func TestTUIModelFollowsAndPauses(t *testing.T) {
+	withReset(t)
+	m := newTestTUIModel(t, nil, numberedLines(5)...)
+
+	if got, want := renderBody(m), []string{"line 3", "line 4", "line 5"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("body = %q, want %q", got, want)
+	}
+
+	pressKeys(m, " ")
+	m.append("app.log", "line 6")
+	if got := renderBody(m); got[2] != "line 5" {
+		t.Fatalf("paused body = %q, want view frozen at line 5", got)
+	}
+
+	pressKeys(m, " ")
+	if got := renderBody(m); got[2] != "line 6" {
+		t.Fatalf("resumed body = %q, want line 6 at bottom", got)
+	}
+}
+
+func TestTUIModelScrollsBackAndReturnsToLive(t *testing.T) {
+	withReset(t)
+	m := newTestTUIModel(t, nil, numberedLines(10)...)
+
+	pressKeys(m, "kk")
+	if got := renderBody(m); got[2] != "line 8" || m.follow {
+		t.Fatalf("body = %q follow=%v, want line 8 at bottom and paused", got, m.follow)
+	}
+	pressKeys(m, "g")
+	if got := renderBody(m); got[0] != "line 1" {
+		t.Fatalf("body = %q, want line 1 at top", got)
+	}
+	pressKeys(m, "G")
+	if got := renderBody(m); got[2] != "line 10" || !m.follow {
+		t.Fatalf("body = %q follow=%v, want live view", got, m.follow)
+	}
+}
+
+func TestTUIModelEvictsOldLines(t *testing.T) {
+	withReset(t)
+	m := newTUIModel(3, nil)
+	for _, line := range numberedLines(5) {
+		m.append("", line)
+	}
+	if len(m.lines) != 3 || m.lines[0].text != "line 3" {
+		t.Fatalf("lines = %+v, want last 3 lines", m.lines)
+	}
+}
+
+func TestTUIModelEvictsInChunksAndCountsVisibleLines(t *testing.T) {
+	withReset(t)
+	m := newTUIModel(100, nil)
+	m.filter = regexp.MustCompile(`ERROR`)
+	m.filterOn = true
+	for i := range 250 {
+		if i%5 == 0 {
+			m.append("", fmt.Sprintf("ERROR %d", i))
+		} else {
+			m.append("", fmt.Sprintf("INFO %d", i))
+		}
+	}
+	// 上限を超えるたびに 1 割まとめて捨てる
+	if len(m.lines) > 100 || len(m.lines) < 90 {
+		t.Fatalf("len(lines) = %d, want between 90 and 100", len(m.lines))
+	}
+	if want := len(m.view()); m.visibleCount != want {
+		t.Fatalf("visibleCount = %d, want %d", m.visibleCount, want)
+	}
+	pressKeys(m, "t")
+	if m.visibleCount != len(m.lines) {
+		t.Fatalf("visibleCount without filter = %d, want %d", m.visibleCount, len(m.lines))
+	}
+}
+
+func TestTUIModelIncrementalSearchAndNavigation(t *testing.T) {
+	withReset(t)
+	m := newTestTUIModel(t, nil,
+		"INFO a", "ERROR first", "INFO b", "INFO c", "INFO d", "ERROR second", "INFO e", "INFO f", "INFO g")
+
+	pressKeys(m, "/ERR")
+	if m.currentHit != 5 {
+		t.Fatalf("currentHit = %d, want 5 (nearest older match)", m.currentHit)
+	}
+	pressKeys(m, "\r")
+	if m.mode != tuiNormal || m.search == nil {
+		t.Fatalf("mode = %v search = %v, want normal mode with search kept", m.mode, m.search)
+	}
+
+	pressKeys(m, "n")
+	if m.currentHit != 1 {
+		t.Fatalf("after n currentHit = %d, want 1", m.currentHit)
+	}
+	requireContains(t, strings.Join(renderBody(m), "|"), "ERROR first")
+
+	pressKeys(m, "n")
+	requireContains(t, m.message, "no more matches")
+
+	pressKeys(m, "N")
+	if m.currentHit != 5 {
+		t.Fatalf("after N currentHit = %d, want 5", m.currentHit)
+	}
+}
+
+func TestTUIModelSearchHighlightsMatches(t *testing.T) {
+	withReset(t)
+	m := newTestTUIModel(t, newHighlighter(colorAlways), "INFO ok", "ERROR boom")
+
+	pressKeys(m, "/boom\r")
+
+	var buf bytes.Buffer
+	m.render(&buf)
+	requireContains(t, buf.String(), "\x1b[7mboom\x1b[27m")
+}
+
+func TestTUIModelInvalidSearchRegex(t *testing.T) {
+	withReset(t)
+	m := newTestTUIModel(t, nil, "x")
+
+	pressKeys(m, "/[")
+	if !m.searchErr || m.search != nil {
+		t.Fatalf("searchErr=%v search=%v, want invalid state", m.searchErr, m.search)
+	}
+	requireContains(t, m.statusText(), "invalid regex")
+	pressKeys(m, "\x1b")
+	if m.mode != tuiNormal {
+		t.Fatalf("mode = %v, want normal after Esc", m.mode)
+	}
+}
+
+func TestTUIModelFilterToggle(t *testing.T) {
+	withReset(t)
+	m := newTestTUIModel(t, nil, "INFO a", "ERROR b", "INFO c", "ERROR d", "INFO e")
+
+	pressKeys(m, "&ERROR\r")
+	if got := renderBody(m); strings.Join(got, "|") != "|ERROR b|ERROR d" {
+		t.Fatalf("filtered body = %q", got)
+	}
+
+	pressKeys(m, "t")
+	if got := renderBody(m); strings.Join(got, "|") != "INFO c|ERROR d|INFO e" {
+		t.Fatalf("unfiltered body = %q", got)
+	}
+	requireContains(t, m.statusText(), "filter off: ERROR")
+
+	pressKeys(m, "&\r")
+	if m.filter != nil {
+		t.Fatal("empty filter input should clear the filter")
+	}
+}
+
+func TestTUIModelTogglesColorPatterns(t *testing.T) {
+	withReset(t)
+	h := userHighlighter(colorAlways, "red:ERROR", "yellow:WARN")
+	m := newTestTUIModel(t, h, "ERROR WARN")
+
+	pressKeys(m, "1")
+	var buf bytes.Buffer
+	m.render(&buf)
+	requireNotContains(t, buf.String(), ansi("31", "ERROR"))
+	requireContains(t, buf.String(), ansi("33", "WARN"))
+	requireContains(t, m.statusText(), "off: red:ERROR")
+
+	pressKeys(m, "c")
+	buf.Reset()
+	m.render(&buf)
+	requireNotContains(t, buf.String(), ansi("33", "WARN"))
+}
+
+func TestTUIModelFilePicker(t *testing.T) {
+	withReset(t)
+	m := newTestTUIModel(t, nil)
+
+	pressKeys(m, "o")
+	requireContains(t, m.message, "only available in dir mode")
+
+	m.canPick = true
+	if action := pressKeys(m, "o"); !action.listFiles {
+		t.Fatal("o should request the file list")
+	}
+	m.openPicker([]string{"/logs/new.log", "/logs/old.log"})
+	body := renderBody(m)
+	if body[0] != "  (follow latest)" || body[1] != "  new.log" || body[2] != "  old.log" {
+		t.Fatalf("picker body = %q", body)
+	}
+
+	action := pressKeys(m, "\x1b[B\x1b[B\r")
+	if action.switchTo == nil || *action.switchTo != "/logs/old.log" {
+		t.Fatalf("switchTo = %v, want /logs/old.log", action.switchTo)
+	}
+
+	m.openPicker([]string{"/logs/new.log"})
+	action = pressKeys(m, "\r")
+	if action.switchTo == nil || *action.switchTo != "" {
+		t.Fatalf("switchTo = %v, want follow latest", action.switchTo)
+	}
+}
+
+func TestTUIModelQuitKeys(t *testing.T) {
+	withReset(t)
+	for _, input := range []string{"q", "\x03"} {
+		m := newTestTUIModel(t, nil)
+		if action := pressKeys(m, input); !action.quit {
+			t.Fatalf("key %q did not quit", input)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("a\x1b[A\x1b[5~\x1b[6~\x1b\r\x7f日"))
+	want := []tuiKey{
+		{code: keyRune, r: 'a'},
+		{code: keyUp},
+		{code: keyPageUp},
+		{code: keyPageDown},
+		{code: keyEsc},
+		{code: keyEnter},
+		{code: keyBackspace},
+		{code: keyRune, r: '日'},
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("parseKeys = %v, want %v", keys, want)
+	}
+}
+
+func TestTruncateWidthCountsWideRunes(t *testing.T) {
+	if got := truncateWidth("日本語のログ", 7); got != "日本語" {
+		t.Fatalf("truncateWidth = %q, want %q", got, "日本語")
+	}
+	if got := truncateWidth("abc", 10); got != "abc" {
+		t.Fatalf("truncateWidth = %q, want abc", got)
+	}
+}
+
+func TestTruncateWidthExpandsControls(t *testing.T) {
+	if got := truncateWidth("日本\tERROR", 12); got != "日本    ERRO" {
+		t.Fatalf("truncateWidth = %q, want tab expanded to column 8", got)
+	}
+	if got := truncateWidth("a\x1b[2Jb\x7f\u0085c", 20); got != "a^[[2Jb^?c" {
+		t.Fatalf("truncateWidth = %q, want escaped controls", got)
+	}
+}
+
+func TestTUIAppCloseWaitsForRedraw(t *testing.T) {
+	withReset(t)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	var out bytes.Buffer
+	app := &tuiApp{
+		model:    newTUIModel(100, nil),
+		in:       r,
+		out:      bufio.NewWriter(&out),
+		oldState: &term.State{},
+		dirty:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go app.redrawLoop()
+	for i := range 3 {
+		app.append("", fmt.Sprintf("line %d", i))
+		time.Sleep(tuiRedrawInterval)
+	}
+	app.markDirty()
+	// -race で、描画と端末の復元が同じ bufio.Writer に同時に書かないことを確かめる
+	app.close()
+	if got := out.String(); !strings.HasSuffix(got, "\x1b[0m\x1b[?25h\x1b[?1049l") || !strings.Contains(got, "line 2") {
+		t.Fatalf("output = %q", got)
+	}
+}
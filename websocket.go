@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- WebSocket (RFC 6455) ----------
+
+// trail serve で使う範囲だけを実装する: サーバー側のテキストの送信と、
+// クライアントからの短いテキスト・ping・close の受信。
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// クライアントから受け取るメッセージの上限
+const websocketMaxMessage = 64 << 10
+
+// 1 フレームの書き込みを待つ上限。読まないクライアントに送信側を止められないようにする。
+const websocketWriteTimeout = 10 * time.Second
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+var errWebsocketClosed = errors.New("websocket closed")
+
+type websocketConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu           sync.Mutex // 書き込みの排他
+	w            *bufio.Writer
+	writeTimeout time.Duration
+}
+
+// ハンドシェイクの要求かどうか
+func isWebsocketRequest(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WebSocket には CORS が効かないので、他のサイトのページから開かれた接続を Origin で断る。
+// 同じホストのページと allowed の Origin ("*" はすべて) を受け付ける。Origin を送らないスクリプトなどはそのまま通す。
+func websocketOriginAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// ハンドシェイクに応じて接続を乗っ取る。失敗した場合は応答を書いてエラーを返す。
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request, allowedOrigins []string) (*websocketConn, error) {
+	if r.Method != http.MethodGet || !isWebsocketRequest(r) {
+		http.Error(w, "websocket handshake expected", http.StatusBadRequest)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if !websocketOriginAllowed(r, allowedOrigins) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, errors.New("websocket origin not allowed")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{conn: conn, r: rw.Reader, w: rw.Writer, writeTimeout: websocketWriteTimeout}, nil
+}
+
+// テキストメッセージを 1 フレームで送る
+func (c *websocketConn) writeText(text string) error {
+	return c.writeFrame(wsOpText, []byte(text))
+}
+
+// close フレームを送って接続を閉じる
+func (c *websocketConn) close(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	payload = append(payload, reason...)
+	c.writeFrame(wsOpClose, payload)
+	return c.conn.Close()
+}
+
+// サーバーからのフレームはマスクしない
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	c.w.Write(header)
+	c.w.Write(payload)
+	return c.w.Flush()
+}
+
+// テキストメッセージを 1 つ読む。ping には応答し、close を受け取ると errWebsocketClosed を返す。
+func (c *websocketConn) readText() (string, error) {
+	var message []byte
+	var opcode byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return "", err
+		}
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return "", err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return "", errWebsocketClosed
+		case wsOpContinuation:
+			if opcode == 0 {
+				return "", errors.New("unexpected continuation frame")
+			}
+		case wsOpText, wsOpBinary:
+			if opcode != 0 {
+				return "", errors.New("unfinished fragmented message")
+			}
+			opcode = op
+		default:
+			return "", fmt.Errorf("unknown opcode %#x", op)
+		}
+		if len(message)+len(payload) > websocketMaxMessage {
+			return "", errors.New("message too large")
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+		if opcode != wsOpText {
+			// 使わないので読み捨てる
+			message, opcode = nil, 0
+			continue
+		}
+		return string(message), nil
+	}
+}
+
+// クライアントからのフレームはマスクされている
+func (c *websocketConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.r, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		err = errors.New("client frame is not masked")
+		return
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > websocketMaxMessage {
+		err = errors.New("frame too large")
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}